@@ -9,6 +9,8 @@ import (
 	"github.com/maksimru/event-scheduler/version"
 	log "github.com/sirupsen/logrus"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
@@ -34,7 +36,22 @@ func app(ctx context.Context) int {
 
 	setupLogger(cfg)
 
-	if err := scheduler.NewScheduler(ctx, cfg).Run(ctx); err != nil {
+	// termination signal starts the graceful shutdown, scheduler components are stopped once it's finished
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Warn("received ", sig.String(), " signal, shutting down")
+			cancel()
+		case <-runCtx.Done():
+		}
+	}()
+
+	if err := scheduler.NewScheduler(ctx, cfg).Run(runCtx); err != nil {
 		log.Error("scheduler failure: ", err.Error())
 		return 1
 	}
@@ -131,8 +131,8 @@ func TestMainFunc(t *testing.T) {
 			expectedExitCode: 0,
 			expectedPanic:    false,
 			env: map[string]string{
-				"LISTENER_DRIVER":           "amqp",
-				"PUBLISHER_DRIVER":          "amqp",
+				"LISTENER_DRIVER":           "sqs",
+				"PUBLISHER_DRIVER":          "sqs",
 				"PUBSUB_LISTENER_KEY_FILE":  dir + "/tests/pubsub_cred_mock.json",
 				"PUBSUB_PUBLISHER_KEY_FILE": dir + "/tests/pubsub_cred_mock.json",
 				"STORAGE_PATH":              dir + "/tests/tempStorage2",
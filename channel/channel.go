@@ -1,19 +1,172 @@
 package channel
 
+import (
+	"math"
+	"sync"
+	"time"
+)
+
 type Channel struct {
 	ID          string      `json:"id" xml:"id"`
 	Source      Source      `json:"source" xml:"source"`
 	Destination Destination `json:"destination" xml:"destination"`
+	// DeadLetter receives messages which exhausted delivery attempts or have unreadable schedule, the cluster keeps them either way
+	DeadLetter *Destination `json:"dead_letter,omitempty" xml:"dead_letter,omitempty"`
+	// RateLimit spreads deliveries of the channel, messages over the rate wait in the scheduled queue
+	RateLimit *RateLimit `json:"rate_limit,omitempty" xml:"rate_limit,omitempty"`
+}
+
+// RateLimit is the token bucket of the channel deliveries
+type RateLimit struct {
+	// Rate is the number of messages delivered per second
+	Rate float64 `json:"rate" xml:"rate"`
+	// Burst is the number of messages delivered at once after the idle time, the rate rounded up by default
+	Burst int `json:"burst,omitempty" xml:"burst,omitempty"`
+}
+
+// GetBurst returns the capacity of the token bucket, at least one message
+func (r RateLimit) GetBurst() int {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return int(math.Max(1, math.Ceil(r.Rate)))
 }
 
+const DuplicateModeDuplicate = "duplicate"
+const DuplicateModeReplace = "replace"
+
+const InvalidSchedulePolicyDeliver = "deliver"
+const InvalidSchedulePolicyDrop = "drop"
+const InvalidSchedulePolicyDeadLetter = "dead_letter"
+
 type Source struct {
 	Driver string       `json:"driver" xml:"driver"`
 	Config SourceConfig `json:"config" xml:"config"`
+	// DuplicateMode selects how the message with already scheduled ID is handled, it's scheduled once again by default
+	DuplicateMode string `json:"duplicate_mode,omitempty" xml:"duplicate_mode,omitempty"`
+	// DeduplicationWindow drops messages with the key seen within the window (seconds), zero disables deduplication
+	DeduplicationWindow int `json:"deduplication_window,omitempty" xml:"deduplication_window,omitempty"`
+	// DeduplicationAttribute holds the deduplication key, the message ID is used by default
+	DeduplicationAttribute string `json:"deduplication_attribute,omitempty" xml:"deduplication_attribute,omitempty"`
+	// InvalidSchedulePolicy handles messages with unreadable scheduling attributes, every source driver moves them to dead letters by default
+	InvalidSchedulePolicy string `json:"invalid_schedule_policy,omitempty" xml:"invalid_schedule_policy,omitempty"`
+}
+
+// GetInvalidSchedulePolicy returns the policy of messages with unreadable scheduling attributes, dead letters by default
+func (s Source) GetInvalidSchedulePolicy() string {
+	if s.InvalidSchedulePolicy == "" {
+		return InvalidSchedulePolicyDeadLetter
+	}
+	return s.InvalidSchedulePolicy
 }
 
 type Destination struct {
 	Driver string            `json:"driver" xml:"driver"`
 	Config DestinationConfig `json:"config" xml:"config"`
+	// PreserveAvailableAt re-emits the scheduling time as the available_at attribute
+	PreserveAvailableAt bool `json:"preserve_available_at" xml:"preserve_available_at"`
+	// Retry configures redelivery of failed messages, the default policy is used when it's omitted
+	Retry *RetryPolicy `json:"retry,omitempty" xml:"retry,omitempty"`
+	// Concurrency is the number of messages delivered to the destination at once
+	Concurrency int `json:"concurrency,omitempty" xml:"concurrency,omitempty"`
+	// CircuitBreaker stops deliveries to the failing destination, the default policy is used when it's omitted
+	CircuitBreaker *CircuitBreakerPolicy `json:"circuit_breaker,omitempty" xml:"circuit_breaker,omitempty"`
+}
+
+// DefaultConcurrency is used by destinations without the concurrency
+const DefaultConcurrency = 5
+
+// GetConcurrency returns the number of concurrent deliveries to the destination
+func (d Destination) GetConcurrency() int {
+	if d.Concurrency <= 0 {
+		return DefaultConcurrency
+	}
+	return d.Concurrency
+}
+
+const DefaultRetryInitialBackoff = 1.0
+const DefaultRetryMultiplier = 2.0
+const DefaultRetryMaxBackoff = 60.0
+const DefaultRetryJitter = 0.2
+
+// RetryPolicy delays redelivery exponentially, zero fields fall back to the defaults (seconds)
+type RetryPolicy struct {
+	InitialBackoff float64 `json:"initial_backoff,omitempty" xml:"initial_backoff,omitempty"`
+	Multiplier     float64 `json:"multiplier,omitempty" xml:"multiplier,omitempty"`
+	MaxBackoff     float64 `json:"max_backoff,omitempty" xml:"max_backoff,omitempty"`
+	// Jitter spreads the backoff by the fraction of it, so redeliveries of many messages don't hit the destination at once
+	Jitter float64 `json:"jitter,omitempty" xml:"jitter,omitempty"`
+	// MaxAttempts limits deliveries of the message, zero retries forever
+	MaxAttempts int `json:"max_attempts,omitempty" xml:"max_attempts,omitempty"`
+}
+
+// GetRetryPolicy returns the destination retry policy with defaults applied
+func (d Destination) GetRetryPolicy() RetryPolicy {
+	policy := RetryPolicy{}
+	if d.Retry != nil {
+		policy = *d.Retry
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = DefaultRetryInitialBackoff
+	}
+	if policy.Multiplier < 1 {
+		policy.Multiplier = DefaultRetryMultiplier
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	if policy.Jitter <= 0 || policy.Jitter > 1 {
+		policy.Jitter = DefaultRetryJitter
+	}
+	return policy
+}
+
+// Backoff returns the delay before the next delivery after the failed attempt (counted from 1),
+// random in [0, 1) picks the delay within the jitter
+func (r RetryPolicy) Backoff(attempt int, random float64) time.Duration {
+	backoff := r.InitialBackoff * math.Pow(r.Multiplier, float64(attempt-1))
+	if backoff > r.MaxBackoff {
+		backoff = r.MaxBackoff
+	}
+	backoff *= 1 - r.Jitter + 2*r.Jitter*random
+	return time.Duration(backoff * float64(time.Second))
+}
+
+// Exhausted reports whether the message shouldn't be delivered after the attempt
+func (r RetryPolicy) Exhausted(attempt int) bool {
+	return r.MaxAttempts > 0 && attempt >= r.MaxAttempts
+}
+
+const DefaultBreakerFailureThreshold = 5
+const DefaultBreakerOpenTimeout = 30.0
+const DefaultBreakerHalfOpenRequests = 1
+
+// CircuitBreakerPolicy opens the breaker after consecutive failed deliveries, zero fields fall back to the defaults
+type CircuitBreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failed deliveries which opens the breaker
+	FailureThreshold int `json:"failure_threshold,omitempty" xml:"failure_threshold,omitempty"`
+	// OpenTimeout is the time (seconds) the open breaker holds deliveries back before trying the destination again
+	OpenTimeout float64 `json:"open_timeout,omitempty" xml:"open_timeout,omitempty"`
+	// HalfOpenRequests is the number of successful trial deliveries which close the breaker
+	HalfOpenRequests int `json:"half_open_requests,omitempty" xml:"half_open_requests,omitempty"`
+}
+
+// GetCircuitBreakerPolicy returns the destination circuit breaker policy with defaults applied
+func (d Destination) GetCircuitBreakerPolicy() CircuitBreakerPolicy {
+	policy := CircuitBreakerPolicy{}
+	if d.CircuitBreaker != nil {
+		policy = *d.CircuitBreaker
+	}
+	if policy.FailureThreshold <= 0 {
+		policy.FailureThreshold = DefaultBreakerFailureThreshold
+	}
+	if policy.OpenTimeout <= 0 {
+		policy.OpenTimeout = DefaultBreakerOpenTimeout
+	}
+	if policy.HalfOpenRequests <= 0 {
+		policy.HalfOpenRequests = DefaultBreakerHalfOpenRequests
+	}
+	return policy
 }
 
 type SourceConfig interface{}
@@ -27,26 +180,66 @@ type EventHandler struct {
 	channelUpdatedCallback Updated
 	channelDeletedCallback Deleted
 	channelAddedCallback   Added
+	// subscribers are notified along with the callbacks, every one in its own goroutine
+	subscribersMutex *sync.Mutex
+	subscribers      []EventHandler
 }
 
 func NewEventHandler(channelUpdatedCallback Updated, channelDeletedCallback Deleted, channelAddedCallback Added) *EventHandler {
-	return &EventHandler{channelUpdatedCallback: channelUpdatedCallback, channelDeletedCallback: channelDeletedCallback, channelAddedCallback: channelAddedCallback}
+	return &EventHandler{
+		channelUpdatedCallback: channelUpdatedCallback,
+		channelDeletedCallback: channelDeletedCallback,
+		channelAddedCallback:   channelAddedCallback,
+		subscribersMutex:       &sync.Mutex{},
+	}
+}
+
+// Subscribe adds callbacks of the channel events, nil callbacks are skipped
+func (h *EventHandler) Subscribe(channelUpdatedCallback Updated, channelDeletedCallback Deleted, channelAddedCallback Added) {
+	h.subscribersMutex.Lock()
+	defer h.subscribersMutex.Unlock()
+	h.subscribers = append(h.subscribers, EventHandler{
+		channelUpdatedCallback: channelUpdatedCallback,
+		channelDeletedCallback: channelDeletedCallback,
+		channelAddedCallback:   channelAddedCallback,
+	})
+}
+
+func (h *EventHandler) getSubscribers() []EventHandler {
+	h.subscribersMutex.Lock()
+	defer h.subscribersMutex.Unlock()
+	return append([]EventHandler{}, h.subscribers...)
 }
 
 func (h *EventHandler) OnAdded(c Channel) {
 	go func() {
 		h.channelAddedCallback(c)
 	}()
+	for _, subscriber := range h.getSubscribers() {
+		if callback := subscriber.channelAddedCallback; callback != nil {
+			go callback(c)
+		}
+	}
 }
 
 func (h *EventHandler) OnUpdated(c Channel) {
 	go func() {
 		h.channelUpdatedCallback(c)
 	}()
+	for _, subscriber := range h.getSubscribers() {
+		if callback := subscriber.channelUpdatedCallback; callback != nil {
+			go callback(c)
+		}
+	}
 }
 
 func (h *EventHandler) OnDeleted(c Channel) {
 	go func() {
 		h.channelDeletedCallback(c)
 	}()
+	for _, subscriber := range h.getSubscribers() {
+		if callback := subscriber.channelDeletedCallback; callback != nil {
+			go callback(c)
+		}
+	}
 }
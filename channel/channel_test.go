@@ -0,0 +1,138 @@
+package channel
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDestination_GetRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination Destination
+		want        RetryPolicy
+	}{
+		{
+			name:        "Check default retry policy",
+			destination: Destination{},
+			want:        RetryPolicy{InitialBackoff: 1, Multiplier: 2, MaxBackoff: 60, Jitter: 0.2},
+		},
+		{
+			name:        "Check omitted fields keep defaults",
+			destination: Destination{Retry: &RetryPolicy{InitialBackoff: 0.5, MaxAttempts: 5}},
+			want:        RetryPolicy{InitialBackoff: 0.5, Multiplier: 2, MaxBackoff: 60, Jitter: 0.2, MaxAttempts: 5},
+		},
+		{
+			name:        "Check configured retry policy",
+			destination: Destination{Retry: &RetryPolicy{InitialBackoff: 2, Multiplier: 1, MaxBackoff: 10, Jitter: 0.5, MaxAttempts: 3}},
+			want:        RetryPolicy{InitialBackoff: 2, Multiplier: 1, MaxBackoff: 10, Jitter: 0.5, MaxAttempts: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.destination.GetRetryPolicy())
+		})
+	}
+}
+
+func TestDestination_GetConcurrency(t *testing.T) {
+	assert.Equal(t, DefaultConcurrency, Destination{}.GetConcurrency())
+	assert.Equal(t, DefaultConcurrency, Destination{Concurrency: -1}.GetConcurrency())
+	assert.Equal(t, 2, Destination{Concurrency: 2}.GetConcurrency())
+}
+
+func TestDestination_GetCircuitBreakerPolicy(t *testing.T) {
+	assert.Equal(t, CircuitBreakerPolicy{FailureThreshold: 5, OpenTimeout: 30, HalfOpenRequests: 1}, Destination{}.GetCircuitBreakerPolicy())
+	assert.Equal(t,
+		CircuitBreakerPolicy{FailureThreshold: 3, OpenTimeout: 30, HalfOpenRequests: 2},
+		Destination{CircuitBreaker: &CircuitBreakerPolicy{FailureThreshold: 3, HalfOpenRequests: 2}}.GetCircuitBreakerPolicy(),
+	)
+}
+
+func TestSource_GetInvalidSchedulePolicy(t *testing.T) {
+	assert.Equal(t, InvalidSchedulePolicyDeadLetter, Source{}.GetInvalidSchedulePolicy())
+	assert.Equal(t, InvalidSchedulePolicyDrop, Source{InvalidSchedulePolicy: InvalidSchedulePolicyDrop}.GetInvalidSchedulePolicy())
+}
+
+func TestRateLimit_GetBurst(t *testing.T) {
+	assert.Equal(t, 1, RateLimit{Rate: 0.5}.GetBurst())
+	assert.Equal(t, 3, RateLimit{Rate: 2.5}.GetBurst())
+	assert.Equal(t, 10, RateLimit{Rate: 2.5, Burst: 10}.GetBurst())
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 1, Multiplier: 2, MaxBackoff: 10, Jitter: 0.5}
+	tests := []struct {
+		name    string
+		attempt int
+		random  float64
+		want    time.Duration
+	}{
+		{
+			name:    "Check first retry without jitter",
+			attempt: 1,
+			random:  0.5,
+			want:    time.Second,
+		},
+		{
+			name:    "Check backoff grows exponentially",
+			attempt: 3,
+			random:  0.5,
+			want:    4 * time.Second,
+		},
+		{
+			name:    "Check backoff is limited",
+			attempt: 100,
+			random:  0.5,
+			want:    10 * time.Second,
+		},
+		{
+			name:    "Check lower jitter bound",
+			attempt: 2,
+			random:  0,
+			want:    time.Second,
+		},
+		{
+			name:    "Check upper jitter bound",
+			attempt: 2,
+			random:  1,
+			want:    3 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, policy.Backoff(tt.attempt, tt.random))
+		})
+	}
+}
+
+func TestRetryPolicy_Exhausted(t *testing.T) {
+	assert.False(t, RetryPolicy{}.Exhausted(1000))
+	assert.False(t, RetryPolicy{MaxAttempts: 3}.Exhausted(2))
+	assert.True(t, RetryPolicy{MaxAttempts: 3}.Exhausted(3))
+}
+
+func TestEventHandler_Subscribe(t *testing.T) {
+	events := make(chan string, 10)
+	record := func(event string) func(c Channel) {
+		return func(c Channel) {
+			events <- event + " " + c.ID
+		}
+	}
+	h := NewEventHandler(record("updated"), record("deleted"), record("added"))
+	h.Subscribe(record("subscriber updated"), record("subscriber deleted"), nil)
+
+	h.OnUpdated(Channel{ID: "ch1"})
+	assert.ElementsMatch(t, []string{"updated ch1", "subscriber updated ch1"}, []string{<-events, <-events})
+	h.OnDeleted(Channel{ID: "ch1"})
+	assert.ElementsMatch(t, []string{"deleted ch1", "subscriber deleted ch1"}, []string{<-events, <-events})
+
+	// nil callback isn't called
+	h.OnAdded(Channel{ID: "ch2"})
+	assert.Equal(t, "added ch2", <-events)
+	select {
+	case event := <-events:
+		t.Fatal("unexpected event: " + event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
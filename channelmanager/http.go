@@ -1,13 +1,27 @@
 package channelmanager
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/labstack/echo/v4"
 	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/dispatcher"
+	amqplistenerconfig "github.com/maksimru/event-scheduler/listener/amqp/config"
+	httplistenerconfig "github.com/maksimru/event-scheduler/listener/http/config"
+	kafkalistenerconfig "github.com/maksimru/event-scheduler/listener/kafka/config"
+	natslistenerconfig "github.com/maksimru/event-scheduler/listener/nats/config"
 	pubsublistenerconfig "github.com/maksimru/event-scheduler/listener/pubsub/config"
+	redislistenerconfig "github.com/maksimru/event-scheduler/listener/redis/config"
+	"github.com/maksimru/event-scheduler/message"
+	amqppublisherconfig "github.com/maksimru/event-scheduler/publisher/amqp/config"
+	kafkapublisherconfig "github.com/maksimru/event-scheduler/publisher/kafka/config"
+	natspublisherconfig "github.com/maksimru/event-scheduler/publisher/nats/config"
 	pubsubpublisherconfig "github.com/maksimru/event-scheduler/publisher/pubsub/config"
+	redispublisherconfig "github.com/maksimru/event-scheduler/publisher/redis/config"
+	webhookpublisherconfig "github.com/maksimru/event-scheduler/publisher/webhook/config"
 	"github.com/maksimru/event-scheduler/storage"
 	"net/http"
+	"net/url"
 )
 
 type ChannelManagerServer interface {
@@ -15,6 +29,7 @@ type ChannelManagerServer interface {
 	AddChannel(ctx echo.Context) error
 	DeleteChannel(ctx echo.Context) error
 	UpdateChannel(ctx echo.Context) error
+	GetChannelStats(ctx echo.Context) error
 }
 
 type SchedulerChannelManagerServer struct {
@@ -34,21 +49,244 @@ func (m *SchedulerChannelManagerServer) initHttpRoutes() {
 	m.httpServer.DELETE("/channels/:id", m.DeleteChannel)
 	m.httpServer.POST("/channels", m.AddChannel)
 	m.httpServer.PATCH("/channels/:id", m.UpdateChannel)
+	m.httpServer.GET("/channels/:id/stats", m.GetChannelStats)
 }
 
 type ChannelInput struct {
 	Source      SourceInput `json:"source" form:"source" query:"source" validate:"required,dive"`
 	Destination TargetInput `json:"destination" form:"destination" query:"destination" validate:"required,dive"`
+	// DeadLetter receives messages which couldn't be scheduled or delivered, they are kept in the cluster either way
+	DeadLetter *TargetInput `json:"dead_letter" form:"dead_letter" query:"dead_letter" validate:"omitempty"`
+	// RateLimit caps deliveries of the channel, messages over the limit stay scheduled
+	RateLimit *RateLimitInput `json:"rate_limit" form:"rate_limit" query:"rate_limit" validate:"omitempty"`
 }
 
 type SourceInput struct {
-	Driver             string                            `json:"driver" form:"driver" query:"driver" validate:"required,oneof=pubsub"`
-	PubsubSourceConfig pubsublistenerconfig.SourceConfig `json:"config" form:"config" query:"config" validate:"required_if=Driver pubsub"`
+	Driver string               `json:"driver" form:"driver" query:"driver" validate:"required,oneof=pubsub kafka amqp http nats redis"`
+	Config channel.SourceConfig `json:"config" form:"config" query:"config" validate:"required"`
+	// DuplicateMode replaces the scheduled message by the one with the same ID or schedules both
+	DuplicateMode string `json:"duplicate_mode" form:"duplicate_mode" query:"duplicate_mode" validate:"omitempty,oneof=duplicate replace"`
+	// DeduplicationWindow in seconds, messages with the same key are dropped within the window
+	DeduplicationWindow    int    `json:"deduplication_window" form:"deduplication_window" query:"deduplication_window" validate:"min=0"`
+	DeduplicationAttribute string `json:"deduplication_attribute" form:"deduplication_attribute" query:"deduplication_attribute"`
+	// InvalidSchedulePolicy delivers now, drops or moves to dead letters messages with unreadable scheduling attributes
+	InvalidSchedulePolicy string `json:"invalid_schedule_policy" form:"invalid_schedule_policy" query:"invalid_schedule_policy" validate:"omitempty,oneof=deliver drop dead_letter"`
 }
 
 type TargetInput struct {
-	Driver             string                                  `json:"driver" form:"driver" query:"driver" validate:"required,oneof=pubsub"`
-	PubsubTargetConfig pubsubpublisherconfig.DestinationConfig `json:"config" form:"config" query:"config" validate:"required_if=Driver pubsub"`
+	Driver string                    `json:"driver" form:"driver" query:"driver" validate:"required,oneof=pubsub kafka amqp webhook nats redis"`
+	Config channel.DestinationConfig `json:"config" form:"config" query:"config" validate:"required"`
+	// PreserveAvailableAt keeps the available_at attribute in the dispatched messages
+	PreserveAvailableAt bool `json:"preserve_available_at" form:"preserve_available_at" query:"preserve_available_at"`
+	// Retry configures redelivery of failed messages, omitted fields keep the defaults
+	Retry *RetryInput `json:"retry" form:"retry" query:"retry"`
+	// Concurrency is the number of messages delivered to the destination at once, 5 by default
+	Concurrency int `json:"concurrency" form:"concurrency" query:"concurrency" validate:"min=0"`
+	// CircuitBreaker configures pausing of deliveries to the failing destination, omitted fields keep the defaults
+	CircuitBreaker *CircuitBreakerInput `json:"circuit_breaker" form:"circuit_breaker" query:"circuit_breaker"`
+}
+
+// RetryInput backoffs are in seconds, jitter is the fraction of the backoff
+type RetryInput struct {
+	InitialBackoff float64 `json:"initial_backoff" validate:"min=0"`
+	Multiplier     float64 `json:"multiplier" validate:"omitempty,min=1"`
+	MaxBackoff     float64 `json:"max_backoff" validate:"min=0"`
+	Jitter         float64 `json:"jitter" validate:"min=0,max=1"`
+	MaxAttempts    int     `json:"max_attempts" validate:"min=0"`
+}
+
+// CircuitBreakerInput open timeout is in seconds
+type CircuitBreakerInput struct {
+	FailureThreshold int     `json:"failure_threshold" validate:"min=0"`
+	OpenTimeout      float64 `json:"open_timeout" validate:"min=0"`
+	HalfOpenRequests int     `json:"half_open_requests" validate:"min=0"`
+}
+
+func (i *TargetInput) destination() *channel.Destination {
+	if i == nil {
+		return nil
+	}
+	return &channel.Destination{
+		Driver:              i.Driver,
+		Config:              i.Config,
+		PreserveAvailableAt: i.PreserveAvailableAt,
+		Retry:               i.Retry.policy(),
+		Concurrency:         i.Concurrency,
+		CircuitBreaker:      i.CircuitBreaker.policy(),
+	}
+}
+
+func (i *RetryInput) policy() *channel.RetryPolicy {
+	if i == nil {
+		return nil
+	}
+	return &channel.RetryPolicy{
+		InitialBackoff: i.InitialBackoff,
+		Multiplier:     i.Multiplier,
+		MaxBackoff:     i.MaxBackoff,
+		Jitter:         i.Jitter,
+		MaxAttempts:    i.MaxAttempts,
+	}
+}
+
+// RateLimitInput rate is messages per second, burst is the number of messages delivered at once
+type RateLimitInput struct {
+	Rate  float64 `json:"rate" validate:"gt=0"`
+	Burst int     `json:"burst" validate:"min=0"`
+}
+
+func (i *RateLimitInput) rateLimit() *channel.RateLimit {
+	if i == nil {
+		return nil
+	}
+	return &channel.RateLimit{
+		Rate:  i.Rate,
+		Burst: i.Burst,
+	}
+}
+
+func (i *CircuitBreakerInput) policy() *channel.CircuitBreakerPolicy {
+	if i == nil {
+		return nil
+	}
+	return &channel.CircuitBreakerPolicy{
+		FailureThreshold: i.FailureThreshold,
+		OpenTimeout:      i.OpenTimeout,
+		HalfOpenRequests: i.HalfOpenRequests,
+	}
+}
+
+type driverInput struct {
+	Driver string          `json:"driver"`
+	Config json.RawMessage `json:"config"`
+}
+
+type sourceOptionsInput struct {
+	DuplicateMode          string `json:"duplicate_mode"`
+	DeduplicationWindow    int    `json:"deduplication_window"`
+	DeduplicationAttribute string `json:"deduplication_attribute"`
+	InvalidSchedulePolicy  string `json:"invalid_schedule_policy"`
+}
+
+type targetOptionsInput struct {
+	PreserveAvailableAt bool                 `json:"preserve_available_at"`
+	Retry               *RetryInput          `json:"retry"`
+	Concurrency         int                  `json:"concurrency"`
+	CircuitBreaker      *CircuitBreakerInput `json:"circuit_breaker"`
+}
+
+// UnmarshalJSON decodes source config into the structure of the selected driver
+func (i *SourceInput) UnmarshalJSON(data []byte) error {
+	var input driverInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		return err
+	}
+	var options sourceOptionsInput
+	if err := json.Unmarshal(data, &options); err != nil {
+		return err
+	}
+	i.Driver, i.DuplicateMode = input.Driver, options.DuplicateMode
+	i.DeduplicationWindow, i.DeduplicationAttribute = options.DeduplicationWindow, options.DeduplicationAttribute
+	i.InvalidSchedulePolicy = options.InvalidSchedulePolicy
+	switch input.Driver {
+	case "pubsub":
+		var cfg pubsublistenerconfig.SourceConfig
+		if err := decodeDriverConfig(input.Config, &cfg); err != nil {
+			return err
+		}
+		i.Config = cfg
+	case "kafka":
+		var cfg kafkalistenerconfig.SourceConfig
+		if err := decodeDriverConfig(input.Config, &cfg); err != nil {
+			return err
+		}
+		i.Config = cfg
+	case "amqp":
+		var cfg amqplistenerconfig.SourceConfig
+		if err := decodeDriverConfig(input.Config, &cfg); err != nil {
+			return err
+		}
+		i.Config = cfg
+	case "http":
+		var cfg httplistenerconfig.SourceConfig
+		if err := decodeDriverConfig(input.Config, &cfg); err != nil {
+			return err
+		}
+		i.Config = cfg
+	case "nats":
+		var cfg natslistenerconfig.SourceConfig
+		if err := decodeDriverConfig(input.Config, &cfg); err != nil {
+			return err
+		}
+		i.Config = cfg
+	case "redis":
+		var cfg redislistenerconfig.SourceConfig
+		if err := decodeDriverConfig(input.Config, &cfg); err != nil {
+			return err
+		}
+		i.Config = cfg
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes destination config into the structure of the selected driver
+func (i *TargetInput) UnmarshalJSON(data []byte) error {
+	var input driverInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		return err
+	}
+	var options targetOptionsInput
+	if err := json.Unmarshal(data, &options); err != nil {
+		return err
+	}
+	i.Driver, i.PreserveAvailableAt, i.Retry, i.Concurrency = input.Driver, options.PreserveAvailableAt, options.Retry, options.Concurrency
+	i.CircuitBreaker = options.CircuitBreaker
+	switch input.Driver {
+	case "pubsub":
+		var cfg pubsubpublisherconfig.DestinationConfig
+		if err := decodeDriverConfig(input.Config, &cfg); err != nil {
+			return err
+		}
+		i.Config = cfg
+	case "kafka":
+		var cfg kafkapublisherconfig.DestinationConfig
+		if err := decodeDriverConfig(input.Config, &cfg); err != nil {
+			return err
+		}
+		i.Config = cfg
+	case "amqp":
+		var cfg amqppublisherconfig.DestinationConfig
+		if err := decodeDriverConfig(input.Config, &cfg); err != nil {
+			return err
+		}
+		i.Config = cfg
+	case "webhook":
+		var cfg webhookpublisherconfig.DestinationConfig
+		if err := decodeDriverConfig(input.Config, &cfg); err != nil {
+			return err
+		}
+		i.Config = cfg
+	case "nats":
+		var cfg natspublisherconfig.DestinationConfig
+		if err := decodeDriverConfig(input.Config, &cfg); err != nil {
+			return err
+		}
+		i.Config = cfg
+	case "redis":
+		var cfg redispublisherconfig.DestinationConfig
+		if err := decodeDriverConfig(input.Config, &cfg); err != nil {
+			return err
+		}
+		i.Config = cfg
+	}
+	return nil
+}
+
+func decodeDriverConfig(data json.RawMessage, cfg interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, cfg)
 }
 
 func (m *SchedulerChannelManagerServer) AddChannel(ctx echo.Context) error {
@@ -64,21 +302,23 @@ func (m *SchedulerChannelManagerServer) AddChannel(ctx echo.Context) error {
 	}
 	channelID, err := m.manager.AddChannel(channel.Channel{
 		Source: channel.Source{
-			Driver: c.Source.Driver,
-			Config: pubsublistenerconfig.SourceConfig{
-				ProjectID:      c.Source.PubsubSourceConfig.ProjectID,
-				SubscriptionID: c.Source.PubsubSourceConfig.SubscriptionID,
-				KeyFile:        c.Source.PubsubSourceConfig.KeyFile,
-			},
+			Driver:                 c.Source.Driver,
+			Config:                 c.Source.Config,
+			DuplicateMode:          c.Source.DuplicateMode,
+			DeduplicationWindow:    c.Source.DeduplicationWindow,
+			DeduplicationAttribute: c.Source.DeduplicationAttribute,
+			InvalidSchedulePolicy:  c.Source.InvalidSchedulePolicy,
 		},
 		Destination: channel.Destination{
-			Driver: c.Destination.Driver,
-			Config: pubsubpublisherconfig.DestinationConfig{
-				ProjectID: c.Destination.PubsubTargetConfig.ProjectID,
-				TopicID:   c.Destination.PubsubTargetConfig.TopicID,
-				KeyFile:   c.Destination.PubsubTargetConfig.KeyFile,
-			},
+			Driver:              c.Destination.Driver,
+			Config:              c.Destination.Config,
+			PreserveAvailableAt: c.Destination.PreserveAvailableAt,
+			Retry:               c.Destination.Retry.policy(),
+			Concurrency:         c.Destination.Concurrency,
+			CircuitBreaker:      c.Destination.CircuitBreaker.policy(),
 		},
+		DeadLetter: c.DeadLetter.destination(),
+		RateLimit:  c.RateLimit.rateLimit(),
 	})
 	if err != nil {
 		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
@@ -104,24 +344,30 @@ func (m *SchedulerChannelManagerServer) UpdateChannel(ctx echo.Context) error {
 	if err := ctx.Validate(c); err != nil {
 		return echo.NewHTTPError(http.StatusNotAcceptable, err.Error())
 	}
-	_, err := m.manager.UpdateChannel(channelID, channel.Channel{
+	update := channel.Channel{
 		Source: channel.Source{
-			Driver: c.Source.Driver,
-			Config: pubsublistenerconfig.SourceConfig{
-				ProjectID:      c.Source.PubsubSourceConfig.ProjectID,
-				SubscriptionID: c.Source.PubsubSourceConfig.SubscriptionID,
-				KeyFile:        c.Source.PubsubSourceConfig.KeyFile,
-			},
+			Driver:                 c.Source.Driver,
+			Config:                 c.Source.Config,
+			DuplicateMode:          c.Source.DuplicateMode,
+			DeduplicationWindow:    c.Source.DeduplicationWindow,
+			DeduplicationAttribute: c.Source.DeduplicationAttribute,
+			InvalidSchedulePolicy:  c.Source.InvalidSchedulePolicy,
 		},
 		Destination: channel.Destination{
-			Driver: c.Destination.Driver,
-			Config: pubsubpublisherconfig.DestinationConfig{
-				ProjectID: c.Destination.PubsubTargetConfig.ProjectID,
-				TopicID:   c.Destination.PubsubTargetConfig.TopicID,
-				KeyFile:   c.Destination.PubsubTargetConfig.KeyFile,
-			},
+			Driver:              c.Destination.Driver,
+			Config:              c.Destination.Config,
+			PreserveAvailableAt: c.Destination.PreserveAvailableAt,
+			Retry:               c.Destination.Retry.policy(),
+			Concurrency:         c.Destination.Concurrency,
+			CircuitBreaker:      c.Destination.CircuitBreaker.policy(),
 		},
-	})
+		DeadLetter: c.DeadLetter.destination(),
+		RateLimit:  c.RateLimit.rateLimit(),
+	}
+	if stored, err := m.manager.GetChannel(channelID); err == nil {
+		update = restoreChannelSecrets(update, stored)
+	}
+	_, err := m.manager.UpdateChannel(channelID, update)
 	if err == storage.ErrChannelNotFound {
 		return ctx.JSON(http.StatusNotFound, map[string]interface{}{
 			"status": false,
@@ -171,8 +417,225 @@ func (m *SchedulerChannelManagerServer) ListChannels(ctx echo.Context) error {
 			"error":  fmt.Sprintf("error removing channel %s: %s", ctx, err.Error()),
 		})
 	}
+	redacted := make([]channel.Channel, 0, len(channels))
+	for _, c := range channels {
+		redacted = append(redacted, redactChannel(c))
+	}
 	return ctx.JSON(http.StatusOK, map[string]interface{}{
 		"status": true,
-		"data":   channels,
+		"data":   redacted,
+	})
+}
+
+// redactedSecret replaces secrets of the channel drivers in API responses, empty secrets are kept to show they are not set.
+// Passwords of the URLs are replaced by url.URL.Redacted, the submitted redacted secrets keep the stored ones on update
+const redactedSecret = "***"
+
+// redactChannel hides secrets of the channel source, destination and dead letter
+func redactChannel(c channel.Channel) channel.Channel {
+	c.Source.Config = redactConfig(c.Source.Config)
+	c.Destination.Config = redactConfig(c.Destination.Config)
+	if c.DeadLetter != nil {
+		deadLetter := *c.DeadLetter
+		deadLetter.Config = redactConfig(deadLetter.Config)
+		c.DeadLetter = &deadLetter
+	}
+	return c
+}
+
+// restoreChannelSecrets replaces redacted secrets of the submitted channel by the stored ones, so the listed channel can be submitted back
+func restoreChannelSecrets(c channel.Channel, stored channel.Channel) channel.Channel {
+	if c.Source.Driver == stored.Source.Driver {
+		c.Source.Config = restoreSecrets(c.Source.Config, stored.Source.Config)
+	}
+	if c.Destination.Driver == stored.Destination.Driver {
+		c.Destination.Config = restoreSecrets(c.Destination.Config, stored.Destination.Config)
+	}
+	if c.DeadLetter != nil && stored.DeadLetter != nil && c.DeadLetter.Driver == stored.DeadLetter.Driver {
+		deadLetter := *c.DeadLetter
+		deadLetter.Config = restoreSecrets(deadLetter.Config, stored.DeadLetter.Config)
+		c.DeadLetter = &deadLetter
+	}
+	return c
+}
+
+// redactConfig hides credentials of the driver config: passwords, signing secrets, header values and URL passwords
+func redactConfig(config interface{}) interface{} {
+	switch cfg := config.(type) {
+	case amqplistenerconfig.SourceConfig:
+		cfg.URL = redactURL(cfg.URL)
+		return cfg
+	case natslistenerconfig.SourceConfig:
+		cfg.URL = redactURL(cfg.URL)
+		return cfg
+	case redislistenerconfig.SourceConfig:
+		cfg.Password = redactSecret(cfg.Password)
+		return cfg
+	case amqppublisherconfig.DestinationConfig:
+		cfg.URL = redactURL(cfg.URL)
+		return cfg
+	case natspublisherconfig.DestinationConfig:
+		cfg.URL = redactURL(cfg.URL)
+		cfg.Headers = redactHeaders(cfg.Headers)
+		return cfg
+	case redispublisherconfig.DestinationConfig:
+		cfg.Password = redactSecret(cfg.Password)
+		return cfg
+	case webhookpublisherconfig.DestinationConfig:
+		cfg.URL = redactURL(cfg.URL)
+		cfg.Headers = redactHeaders(cfg.Headers)
+		cfg.SigningSecret = redactSecret(cfg.SigningSecret)
+		return cfg
+	}
+	return config
+}
+
+// restoreSecrets keeps the stored credentials which are submitted redacted, the config of another driver is kept as is
+func restoreSecrets(config interface{}, stored interface{}) interface{} {
+	switch cfg := config.(type) {
+	case amqplistenerconfig.SourceConfig:
+		if storedCfg, ok := stored.(amqplistenerconfig.SourceConfig); ok {
+			cfg.URL = restoreURL(cfg.URL, storedCfg.URL)
+		}
+		return cfg
+	case natslistenerconfig.SourceConfig:
+		if storedCfg, ok := stored.(natslistenerconfig.SourceConfig); ok {
+			cfg.URL = restoreURL(cfg.URL, storedCfg.URL)
+		}
+		return cfg
+	case redislistenerconfig.SourceConfig:
+		if storedCfg, ok := stored.(redislistenerconfig.SourceConfig); ok {
+			cfg.Password = restoreSecret(cfg.Password, storedCfg.Password)
+		}
+		return cfg
+	case amqppublisherconfig.DestinationConfig:
+		if storedCfg, ok := stored.(amqppublisherconfig.DestinationConfig); ok {
+			cfg.URL = restoreURL(cfg.URL, storedCfg.URL)
+		}
+		return cfg
+	case natspublisherconfig.DestinationConfig:
+		if storedCfg, ok := stored.(natspublisherconfig.DestinationConfig); ok {
+			cfg.URL = restoreURL(cfg.URL, storedCfg.URL)
+			cfg.Headers = restoreHeaders(cfg.Headers, storedCfg.Headers)
+		}
+		return cfg
+	case redispublisherconfig.DestinationConfig:
+		if storedCfg, ok := stored.(redispublisherconfig.DestinationConfig); ok {
+			cfg.Password = restoreSecret(cfg.Password, storedCfg.Password)
+		}
+		return cfg
+	case webhookpublisherconfig.DestinationConfig:
+		if storedCfg, ok := stored.(webhookpublisherconfig.DestinationConfig); ok {
+			cfg.URL = restoreURL(cfg.URL, storedCfg.URL)
+			cfg.Headers = restoreHeaders(cfg.Headers, storedCfg.Headers)
+			cfg.SigningSecret = restoreSecret(cfg.SigningSecret, storedCfg.SigningSecret)
+		}
+		return cfg
+	}
+	return config
+}
+
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redactedSecret
+}
+
+func restoreSecret(secret string, stored string) string {
+	if secret == redactSecret(stored) {
+		return stored
+	}
+	return secret
+}
+
+// redactURL hides the password of the URL user info, unreadable URLs are hidden completely
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redactSecret(rawURL)
+	}
+	return u.Redacted()
+}
+
+func restoreURL(rawURL string, stored string) string {
+	if rawURL == redactURL(stored) {
+		return stored
+	}
+	return rawURL
+}
+
+// redactHeaders hides values of all headers, they often carry credentials
+func redactHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(headers))
+	for name, value := range headers {
+		redacted[name] = redactSecret(value)
+	}
+	return redacted
+}
+
+func restoreHeaders(headers map[string]string, stored map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	restored := make(map[string]string, len(headers))
+	for name, value := range headers {
+		restored[name] = restoreSecret(value, stored[name])
+	}
+	return restored
+}
+
+type ChannelStatsOutput struct {
+	Scheduled   int `json:"scheduled"`
+	InFlight    int `json:"in_flight"`
+	DeadLetters int `json:"dead_letters"`
+	// Lag is seconds, the fraction keeps milliseconds
+	Lag     float64       `json:"lag"`
+	Breaker BreakerOutput `json:"breaker"`
+}
+
+type BreakerOutput struct {
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+	// OpenUntil is seconds, it's omitted unless the breaker was opened
+	OpenUntil float64 `json:"open_until,omitempty"`
+}
+
+func (m *SchedulerChannelManagerServer) GetChannelStats(ctx echo.Context) error {
+	channelID := ctx.Param("id")
+	stats, err := m.manager.GetChannelStats(channelID)
+	var breaker dispatcher.BreakerState
+	if err == nil {
+		breaker, err = m.manager.GetBreakerState(channelID)
+	}
+	if err == storage.ErrChannelNotFound {
+		return ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error getting channel stats %s: %s", ctx, err.Error()),
+		})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error getting channel stats %s: %s", ctx, err.Error()),
+		})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"status":    true,
+		"channelID": channelID,
+		"stats": ChannelStatsOutput{
+			Scheduled:   stats.Scheduled,
+			InFlight:    stats.InFlight,
+			DeadLetters: stats.DeadLetters,
+			Lag:         message.Seconds(stats.Lag),
+			Breaker: BreakerOutput{
+				State:     breaker.State,
+				Failures:  breaker.Failures,
+				OpenUntil: message.Seconds(breaker.OpenUntil),
+			},
+		},
 	})
 }
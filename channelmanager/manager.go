@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"github.com/hashicorp/raft"
 	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/dispatcher"
 	"github.com/maksimru/event-scheduler/errormessages"
 	"github.com/maksimru/event-scheduler/fsm"
+	"github.com/maksimru/event-scheduler/message"
 	"github.com/maksimru/event-scheduler/storage"
 	log "github.com/sirupsen/logrus"
 	"time"
@@ -13,15 +15,20 @@ import (
 
 type ChannelManager interface {
 	GetChannels() ([]channel.Channel, error)
+	GetChannel(id string) (channel.Channel, error)
 	AddChannel(channel channel.Channel) (*channel.Channel, error)
 	DeleteChannel(id string) error
 	UpdateChannel(id string, channel channel.Channel) (*channel.Channel, error)
+	GetChannelStats(id string) (storage.ChannelStats, error)
+	GetBreakerState(id string) (dispatcher.BreakerState, error)
 }
 
 type SchedulerChannelManager struct {
 	cluster *raft.Raft
 	storage *storage.PqStorage
 	handler *channel.EventHandler
+	// dispatcher reports circuit breakers of the channel destinations
+	dispatcher dispatcher.Dispatcher
 }
 
 func NewSchedulerChannelManager(cluster *raft.Raft, storage *storage.PqStorage, handler *channel.EventHandler) *SchedulerChannelManager {
@@ -42,6 +49,10 @@ func (m *SchedulerChannelManager) BootChannelManager(cluster *raft.Raft, storage
 	return nil
 }
 
+func (m *SchedulerChannelManager) SetDispatcher(dispatcher dispatcher.Dispatcher) {
+	m.dispatcher = dispatcher
+}
+
 func (m *SchedulerChannelManager) GetChannels() ([]channel.Channel, error) {
 	if m.cluster.State() != raft.Leader {
 		return nil, errormessages.ErrOperationIsRestrictedOnNonLeader
@@ -49,6 +60,14 @@ func (m *SchedulerChannelManager) GetChannels() ([]channel.Channel, error) {
 	return m.storage.GetChannels(), nil
 }
 
+// GetChannel returns the stored channel, its secrets are not redacted
+func (m *SchedulerChannelManager) GetChannel(ID string) (channel.Channel, error) {
+	if m.cluster.State() != raft.Leader {
+		return channel.Channel{}, errormessages.ErrOperationIsRestrictedOnNonLeader
+	}
+	return m.storage.GetChannel(ID)
+}
+
 func (m *SchedulerChannelManager) AddChannel(channelInput channel.Channel) (*channel.Channel, error) {
 	if m.cluster.State() != raft.Leader {
 		return nil, errormessages.ErrOperationIsRestrictedOnNonLeader
@@ -130,3 +149,25 @@ func (m *SchedulerChannelManager) UpdateChannel(ID string, channelInput channel.
 	m.handler.OnUpdated(c)
 	return &c, nil
 }
+
+// GetChannelStats returns the backlog of the channel, the lag is measured against the leader time
+func (m *SchedulerChannelManager) GetChannelStats(ID string) (storage.ChannelStats, error) {
+	if m.cluster.State() != raft.Leader {
+		return storage.ChannelStats{}, errormessages.ErrOperationIsRestrictedOnNonLeader
+	}
+	return m.storage.GetChannelStats(ID, message.UnixMilli(time.Now()))
+}
+
+// GetBreakerState returns the circuit breaker of the channel destination, the leader delivers messages
+func (m *SchedulerChannelManager) GetBreakerState(ID string) (dispatcher.BreakerState, error) {
+	if m.cluster.State() != raft.Leader {
+		return dispatcher.BreakerState{}, errormessages.ErrOperationIsRestrictedOnNonLeader
+	}
+	if _, err := m.storage.GetChannel(ID); err != nil {
+		return dispatcher.BreakerState{}, err
+	}
+	if m.dispatcher == nil {
+		return dispatcher.BreakerState{State: dispatcher.BreakerClosed}, nil
+	}
+	return m.dispatcher.GetBreakerState(ID), nil
+}
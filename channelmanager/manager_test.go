@@ -1,10 +1,14 @@
 package channelmanager
 
 import (
+	"context"
 	"github.com/hashicorp/raft"
 	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/dispatcher"
+	"github.com/maksimru/event-scheduler/errormessages"
 	"github.com/maksimru/event-scheduler/fsm"
 	pubsublistenerconfig "github.com/maksimru/event-scheduler/listener/pubsub/config"
+	"github.com/maksimru/event-scheduler/message"
 	"github.com/maksimru/event-scheduler/nodenameresolver"
 	pubsubpublisherconfig "github.com/maksimru/event-scheduler/publisher/pubsub/config"
 	"github.com/maksimru/event-scheduler/storage"
@@ -196,6 +200,20 @@ func TestSchedulerChannelManager_AddChannel(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Check add channel with rate limit",
+			args: args{
+				channelInput: channel.Channel{
+					ID:        "1",
+					RateLimit: &channel.RateLimit{Rate: 10, Burst: 20},
+				},
+			},
+			want: channel.Channel{
+				ID:        "1",
+				RateLimit: &channel.RateLimit{Rate: 10, Burst: 20},
+			},
+			wantErr: false,
+		},
 		{
 			name: "Check add channel with all options",
 			args: args{
@@ -283,6 +301,110 @@ func TestSchedulerChannelManager_AddChannel(t *testing.T) {
 	}
 }
 
+func TestSchedulerChannelManager_GetChannelStats(t *testing.T) {
+	cluster, clusterTransport, pqStorage := bootStagingCluster()
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+	m := NewSchedulerChannelManager(cluster, pqStorage, nil)
+	_, _ = pqStorage.AddChannel(channel.Channel{ID: "1"})
+
+	// follower doesn't report stats
+	_, err := m.GetChannelStats("1")
+	assert.Equal(t, errormessages.ErrOperationIsRestrictedOnNonLeader, err)
+
+	// boot required cluster
+	cluster.BootstrapCluster(raft.Configuration{Servers: []raft.Server{
+		{
+			Suffrage: raft.Voter,
+			ID:       nodenameresolver.Resolve(string(clusterTransport.LocalAddr())),
+			Address:  clusterTransport.LocalAddr(),
+		},
+	}})
+
+	// wait for election
+	time.Sleep(time.Second * 1)
+
+	chStorage, _ := pqStorage.GetChannelStorage("1")
+	chStorage.Enqueue(message.NewMessage([]byte("msg1"), 1000))
+	chStorage.Enqueue(message.NewMessage([]byte("msg2"), message.UnixMilli(time.Now())+60000))
+	stats, err := m.GetChannelStats("1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stats.Scheduled)
+	assert.Greater(t, stats.Lag, 0)
+
+	_, err = m.GetChannelStats("2")
+	assert.Equal(t, storage.ErrChannelNotFound, err)
+}
+
+func TestSchedulerChannelManager_GetBreakerState(t *testing.T) {
+	cluster, clusterTransport, pqStorage := bootStagingCluster()
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+	m := NewSchedulerChannelManager(cluster, pqStorage, nil)
+	_, _ = pqStorage.AddChannel(channel.Channel{ID: "1"})
+
+	// follower doesn't deliver messages
+	_, err := m.GetBreakerState("1")
+	assert.Equal(t, errormessages.ErrOperationIsRestrictedOnNonLeader, err)
+
+	// boot required cluster
+	cluster.BootstrapCluster(raft.Configuration{Servers: []raft.Server{
+		{
+			Suffrage: raft.Voter,
+			ID:       nodenameresolver.Resolve(string(clusterTransport.LocalAddr())),
+			Address:  clusterTransport.LocalAddr(),
+		},
+	}})
+
+	// wait for election
+	time.Sleep(time.Second * 1)
+
+	state, err := m.GetBreakerState("1")
+	assert.NoError(t, err)
+	assert.Equal(t, dispatcher.BreakerState{State: dispatcher.BreakerClosed}, state)
+	m.SetDispatcher(dispatcher.NewDispatcher(context.Background(), pqStorage))
+	state, err = m.GetBreakerState("1")
+	assert.NoError(t, err)
+	assert.Equal(t, dispatcher.BreakerState{State: dispatcher.BreakerClosed}, state)
+
+	_, err = m.GetBreakerState("2")
+	assert.Equal(t, storage.ErrChannelNotFound, err)
+}
+
+func TestSchedulerChannelManager_GetChannel(t *testing.T) {
+	cluster, clusterTransport, pqStorage := bootStagingCluster()
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+	m := NewSchedulerChannelManager(cluster, pqStorage, nil)
+	_, _ = pqStorage.AddChannel(channel.Channel{ID: "1"})
+
+	// follower doesn't serve channels
+	_, err := m.GetChannel("1")
+	assert.Equal(t, errormessages.ErrOperationIsRestrictedOnNonLeader, err)
+
+	// boot required cluster
+	cluster.BootstrapCluster(raft.Configuration{Servers: []raft.Server{
+		{
+			Suffrage: raft.Voter,
+			ID:       nodenameresolver.Resolve(string(clusterTransport.LocalAddr())),
+			Address:  clusterTransport.LocalAddr(),
+		},
+	}})
+
+	// wait for election
+	time.Sleep(time.Second * 1)
+
+	c, err := m.GetChannel("1")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", c.ID)
+
+	_, err = m.GetChannel("2")
+	assert.Equal(t, storage.ErrChannelNotFound, err)
+}
+
 func TestSchedulerChannelManager_DeleteChannel(t *testing.T) {
 	type args struct {
 		ID string
@@ -16,4 +16,9 @@ type Config struct {
 	ClusterNodePort              string `env:"CLUSTER_NODE_PORT" envDefault:"5559"`
 	ClusterInitialNodes          string `env:"CLUSTER_INITIAL_NODES" envDefault:"localhost:5559"`
 	APIPort                      string `env:"API_PORT" envDefault:"5569"`
+	ProcessorBatchSize           int    `env:"PROCESSOR_BATCH_SIZE" envDefault:"100"`
+	PrioritizerBatchSize         int    `env:"PRIORITIZER_BATCH_SIZE" envDefault:"100"`
+	ProcessorLeaseTimeoutMs      int    `env:"PROCESSOR_LEASE_TIMEOUT_MS" envDefault:"30000"`
+	DispatcherQueueSize          int    `env:"DISPATCHER_QUEUE_SIZE" envDefault:"1000"`
+	ShutdownTimeoutMs            int    `env:"SHUTDOWN_TIMEOUT_MS" envDefault:"30000"`
 }
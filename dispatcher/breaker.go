@@ -0,0 +1,107 @@
+package dispatcher
+
+import (
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/message"
+	"sync"
+)
+
+const BreakerClosed = "closed"
+const BreakerOpen = "open"
+const BreakerHalfOpen = "half_open"
+
+// BreakerTrialWait is the time (milliseconds) deliveries wait for the trial deliveries of the half-open breaker
+const BreakerTrialWait = 100
+
+// BreakerState describes the circuit breaker of the channel destination
+type BreakerState struct {
+	State string
+	// Failures counts consecutive failed deliveries
+	Failures int
+	// OpenUntil is the time (Unix milliseconds) the open breaker lets trial deliveries through
+	OpenUntil int `json:"OpenUntilMs"`
+}
+
+// circuitBreaker holds deliveries to the failing destination back, once the open timeout passes
+// trial deliveries decide whether the destination has recovered
+type circuitBreaker struct {
+	mutex     *sync.Mutex
+	policy    channel.CircuitBreakerPolicy
+	state     string
+	failures  int
+	openUntil int
+	// trials counts deliveries let through by the half-open breaker, successes counts the delivered ones
+	trials    int
+	successes int
+}
+
+func newCircuitBreaker(policy channel.CircuitBreakerPolicy) *circuitBreaker {
+	return &circuitBreaker{
+		mutex:  &sync.Mutex{},
+		policy: policy,
+		state:  BreakerClosed,
+	}
+}
+
+// allow reports whether the delivery can be attempted at the time, otherwise it returns the time to ask again
+func (b *circuitBreaker) allow(now int) (int, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if now < b.openUntil {
+			return b.openUntil, false
+		}
+		b.state, b.trials, b.successes = BreakerHalfOpen, 0, 0
+		fallthrough
+	case BreakerHalfOpen:
+		if b.trials >= b.policy.HalfOpenRequests {
+			return now + BreakerTrialWait, false
+		}
+		b.trials++
+	}
+	return now, true
+}
+
+// record counts the delivery result, it returns true when the breaker is opened by the failure
+func (b *circuitBreaker) record(delivered bool, now int) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if delivered {
+		b.failures = 0
+		if b.state == BreakerHalfOpen {
+			b.successes++
+			if b.successes >= b.policy.HalfOpenRequests {
+				b.state = BreakerClosed
+			}
+		}
+		return false
+	}
+	b.failures++
+	switch b.state {
+	case BreakerClosed:
+		if b.failures < b.policy.FailureThreshold {
+			return false
+		}
+	case BreakerOpen:
+		// deliveries started before the breaker was opened
+		return false
+	}
+	b.state = BreakerOpen
+	b.openUntil = now + message.FromSeconds(b.policy.OpenTimeout)
+	return true
+}
+
+// getState returns the breaker state at the time, the expired open breaker is reported as half-open
+func (b *circuitBreaker) getState(now int) BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	state := BreakerState{State: b.state, Failures: b.failures}
+	if b.state == BreakerOpen {
+		state.OpenUntil = b.openUntil
+		if now >= b.openUntil {
+			state.State = BreakerHalfOpen
+		}
+	}
+	return state
+}
@@ -0,0 +1,50 @@
+package dispatcher
+
+import (
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_circuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(channel.CircuitBreakerPolicy{FailureThreshold: 2, OpenTimeout: 1, HalfOpenRequests: 2})
+	_, allowed := b.allow(1000)
+	assert.True(t, allowed)
+
+	// success resets consecutive failures
+	assert.False(t, b.record(false, 1000))
+	assert.False(t, b.record(true, 1000))
+	assert.False(t, b.record(false, 1000))
+	assert.Equal(t, BreakerState{State: BreakerClosed, Failures: 1}, b.getState(1000))
+
+	// failure threshold opens the breaker
+	assert.True(t, b.record(false, 1500))
+	assert.Equal(t, BreakerState{State: BreakerOpen, Failures: 2, OpenUntil: 2500}, b.getState(1500))
+	retryAt, allowed := b.allow(2000)
+	assert.False(t, allowed)
+	assert.Equal(t, 2500, retryAt)
+	// deliveries started before the breaker was opened don't prolong it
+	assert.False(t, b.record(false, 2000))
+	assert.Equal(t, BreakerState{State: BreakerHalfOpen, Failures: 3, OpenUntil: 2500}, b.getState(2500))
+
+	// half-open breaker lets the trial deliveries through, the failed one opens it once again
+	_, allowed = b.allow(2500)
+	assert.True(t, allowed)
+	_, allowed = b.allow(2500)
+	assert.True(t, allowed)
+	retryAt, allowed = b.allow(2500)
+	assert.False(t, allowed)
+	assert.Equal(t, 2500+BreakerTrialWait, retryAt)
+	assert.True(t, b.record(false, 2600))
+	assert.Equal(t, BreakerState{State: BreakerOpen, Failures: 4, OpenUntil: 3600}, b.getState(2600))
+
+	// successful trial deliveries close it
+	_, allowed = b.allow(3600)
+	assert.True(t, allowed)
+	assert.False(t, b.record(true, 3700))
+	assert.Equal(t, BreakerState{State: BreakerHalfOpen}, b.getState(3700))
+	_, allowed = b.allow(3700)
+	assert.True(t, allowed)
+	assert.False(t, b.record(true, 3800))
+	assert.Equal(t, BreakerState{State: BreakerClosed}, b.getState(3800))
+}
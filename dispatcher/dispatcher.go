@@ -2,54 +2,272 @@ package dispatcher
 
 import (
 	"context"
-	"github.com/enriquebris/goconcurrentqueue"
+	"encoding/json"
+	"errors"
+	"github.com/hashicorp/raft"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/fsm"
 	"github.com/maksimru/event-scheduler/message"
 	"github.com/maksimru/event-scheduler/publisher"
+	publisheramqp "github.com/maksimru/event-scheduler/publisher/amqp"
+	publisherkafka "github.com/maksimru/event-scheduler/publisher/kafka"
+	publishernats "github.com/maksimru/event-scheduler/publisher/nats"
 	publisherpubsub "github.com/maksimru/event-scheduler/publisher/pubsub"
+	publisherredis "github.com/maksimru/event-scheduler/publisher/redis"
 	publishertest "github.com/maksimru/event-scheduler/publisher/test"
+	publisherwebhook "github.com/maksimru/event-scheduler/publisher/webhook"
 	"github.com/maksimru/event-scheduler/storage"
 	log "github.com/sirupsen/logrus"
+	"math/rand"
 	"sync"
 	"time"
 )
 
-const DispatcherThreads = 5
+// DefaultQueueSize is the number of messages waiting for the delivery to one destination,
+// pushing into the full queue blocks until the destination catches up
+const DefaultQueueSize = 1000
+
+// AckBatchSize is the maximum number of deliveries acknowledged by one cluster command
+const AckBatchSize = 100
+
+var errPublisherNotFound = errors.New("publisher is not found")
 
 type Dispatcher interface {
 	Push(msg message.Message, channelID string) error
+	// PushLeased delivers the message in flight, the lease is acknowledged through the cluster once the message is published
+	PushLeased(msg message.Message, channelID string, leaseID string) error
+	// PushDeadLetter delivers the message to the channel dead letter destination
+	PushDeadLetter(msg message.Message, channelID string) error
+	// PausedUntil reports whether the channel destination breaker is open at the time and when it lets deliveries through
+	PausedUntil(channelID string, now int) (int, bool)
+	// GetBreakerState returns the circuit breaker of the channel destination
+	GetBreakerState(channelID string) BreakerState
+	// Drain waits for the queued and in-flight deliveries, leases of the undelivered messages are released once the context is done
+	Drain(ctx context.Context) error
 	Dispatch() error
 }
 
 type MessageDispatcher struct {
-	outboundPool *goconcurrentqueue.FIFO
-	context      context.Context
-	publishers   map[string]publisher.Publisher
-	dataStorage  *storage.PqStorage
+	context         context.Context
+	publishersMutex *sync.Mutex
+	publishers      map[string]publisher.Publisher
+	// deadLetterPublishers deliver to the channel dead letter destinations
+	deadLetterPublishers map[string]publisher.Publisher
+	dataStorage          *storage.PqStorage
+	cluster              *raft.Raft
+	acks                 chan MessageForDelivery
+	// queues keep messages of every destination apart, so a slow destination holds back only its own workers
+	queuesMutex *sync.Mutex
+	queues      map[queueKey]*outboundQueue
+	queueSize   int
+	// running dispatcher starts workers of the new queues right away
+	running bool
+	workers *sync.WaitGroup
+	// inflight keeps the leases waiting in the outbound queues, so renewed leases aren't delivered twice by the node
+	inflightMutex *sync.Mutex
+	inflight      map[string]MessageForDelivery
+	// pending counts messages queued, delivered or waiting for the redelivery, it's guarded by inflightMutex
+	pending int
+	// breakers hold deliveries to the failing destinations back
+	breakersMutex *sync.Mutex
+	breakers      map[queueKey]*circuitBreaker
+	// deliveries are read locked while the publisher is in use, so it's closed only once they are completed
+	deliveriesMutex *sync.Mutex
+	deliveries      map[queueKey]*sync.RWMutex
+}
+
+// queueKey identifies the destination of the channel
+type queueKey struct {
+	channelID  string
+	deadLetter bool
+}
+
+// outboundQueue keeps messages of the destination, it's served by as many workers as the destination concurrency
+type outboundQueue struct {
+	messages chan MessageForDelivery
+	// pushMutex is read locked while the message is pushed, so the removed queue is drained once pushes are completed
+	pushMutex *sync.RWMutex
+	removed   chan struct{}
+	// stopWorkers stops the current workers, they complete their deliveries first
+	stopWorkers context.CancelFunc
+	concurrency int
 }
 
 type MessageForDelivery struct {
 	msg       message.Message
 	channelID string
+	leaseID   string
+	// attempts counts failed deliveries of the message
+	attempts int
+	// deadLetter is delivered to the channel dead letter destination
+	deadLetter bool
 }
 
 func (m MessageForDelivery) GetMessage() message.Message {
 	return m.msg
 }
 
-func NewDispatcher(ctx context.Context, outboundPool *goconcurrentqueue.FIFO, dataStorage *storage.PqStorage) *MessageDispatcher {
+func NewDispatcher(ctx context.Context, dataStorage *storage.PqStorage) *MessageDispatcher {
 	return &MessageDispatcher{
-		context:      ctx,
-		outboundPool: outboundPool,
-		publishers:   make(map[string]publisher.Publisher),
-		dataStorage:  dataStorage,
+		context:              ctx,
+		publishersMutex:      &sync.Mutex{},
+		publishers:           make(map[string]publisher.Publisher),
+		deadLetterPublishers: make(map[string]publisher.Publisher),
+		dataStorage:          dataStorage,
+		queuesMutex:          &sync.Mutex{},
+		queues:               make(map[queueKey]*outboundQueue),
+		queueSize:            DefaultQueueSize,
+		workers:              &sync.WaitGroup{},
+		acks:                 make(chan MessageForDelivery, AckBatchSize),
+		inflightMutex:        &sync.Mutex{},
+		inflight:             make(map[string]MessageForDelivery),
+		breakersMutex:        &sync.Mutex{},
+		breakers:             make(map[queueKey]*circuitBreaker),
+		deliveriesMutex:      &sync.Mutex{},
+		deliveries:           make(map[queueKey]*sync.RWMutex),
+	}
+}
+
+// SetCluster enables acknowledgements of the leased messages
+func (d *MessageDispatcher) SetCluster(cluster *raft.Raft) {
+	d.cluster = cluster
+}
+
+// SetQueueSize limits messages waiting for the delivery to every destination, it applies to the queues created later
+func (d *MessageDispatcher) SetQueueSize(size int) {
+	d.queuesMutex.Lock()
+	defer d.queuesMutex.Unlock()
+	d.queueSize = size
+}
+
+// Subscribe rebuilds publishers of the updated channels and closes publishers of the deleted ones
+func (d *MessageDispatcher) Subscribe(handler *channel.EventHandler) {
+	handler.Subscribe(d.channelUpdated, d.channelDeleted, nil)
+}
+
+func (d *MessageDispatcher) channelUpdated(c channel.Channel) {
+	log.Info("dispatcher resets publishers of the updated channel, id ", c.ID)
+	d.resetPublishers(c.ID)
+	d.resizeWorkers(c.ID)
+}
+
+func (d *MessageDispatcher) channelDeleted(c channel.Channel) {
+	log.Info("dispatcher closes publishers of the removed channel, id ", c.ID)
+	d.removeQueues(c.ID)
+	d.resetPublishers(c.ID)
+	d.deliveriesMutex.Lock()
+	delete(d.deliveries, queueKey{channelID: c.ID})
+	delete(d.deliveries, queueKey{channelID: c.ID, deadLetter: true})
+	d.deliveriesMutex.Unlock()
+}
+
+// resizeWorkers replaces workers of the channel queues when the destination concurrency is changed,
+// queued messages are kept for the new workers
+func (d *MessageDispatcher) resizeWorkers(channelID string) {
+	d.queuesMutex.Lock()
+	defer d.queuesMutex.Unlock()
+	if !d.running {
+		return
+	}
+	for _, deadLetter := range []bool{false, true} {
+		key := queueKey{channelID: channelID, deadLetter: deadLetter}
+		queue, has := d.queues[key]
+		if !has || queue.concurrency == d.concurrency(key) {
+			continue
+		}
+		queue.stopWorkers()
+		d.startWorkers(key, queue)
 	}
 }
 
+// removeQueues stops workers of the removed channel and drops its queued messages, their leases are dropped with the channel
+func (d *MessageDispatcher) removeQueues(channelID string) {
+	for _, deadLetter := range []bool{false, true} {
+		key := queueKey{channelID: channelID, deadLetter: deadLetter}
+		d.queuesMutex.Lock()
+		queue, has := d.queues[key]
+		delete(d.queues, key)
+		d.queuesMutex.Unlock()
+		if !has {
+			continue
+		}
+		close(queue.removed)
+		if queue.stopWorkers != nil {
+			queue.stopWorkers()
+		}
+		queue.pushMutex.Lock()
+	drain:
+		for {
+			select {
+			case m := <-queue.messages:
+				d.inflightMutex.Lock()
+				if m.leaseID != "" {
+					delete(d.inflight, d.leaseKey(m.channelID, m.leaseID))
+				}
+				d.pending--
+				d.inflightMutex.Unlock()
+			default:
+				break drain
+			}
+		}
+		queue.pushMutex.Unlock()
+	}
+}
+
+// resetPublishers closes publishers of the channel once their in-flight deliveries are completed,
+// the next delivery builds them and the circuit breakers from the stored channel
+func (d *MessageDispatcher) resetPublishers(channelID string) {
+	for _, deadLetter := range []bool{false, true} {
+		key := queueKey{channelID: channelID, deadLetter: deadLetter}
+		deliveries := d.getDeliveries(key)
+		deliveries.Lock()
+		d.publishersMutex.Lock()
+		publishers := d.publishers
+		if deadLetter {
+			publishers = d.deadLetterPublishers
+		}
+		p, has := publishers[channelID]
+		delete(publishers, channelID)
+		d.publishersMutex.Unlock()
+		if has {
+			if err := p.Close(); err != nil {
+				log.Warn("dispatcher publisher close exception: ", err.Error())
+			}
+		}
+		d.breakersMutex.Lock()
+		delete(d.breakers, key)
+		d.breakersMutex.Unlock()
+		deliveries.Unlock()
+	}
+}
+
+// getDeliveries returns the lock of the destination deliveries
+func (d *MessageDispatcher) getDeliveries(key queueKey) *sync.RWMutex {
+	d.deliveriesMutex.Lock()
+	defer d.deliveriesMutex.Unlock()
+	deliveries, has := d.deliveries[key]
+	if !has {
+		deliveries = &sync.RWMutex{}
+		d.deliveries[key] = deliveries
+	}
+	return deliveries
+}
+
 func (d *MessageDispatcher) SetPublisher(channelID string, p publisher.Publisher) {
+	d.publishersMutex.Lock()
+	defer d.publishersMutex.Unlock()
 	d.publishers[channelID] = p
 }
 
+func (d *MessageDispatcher) SetDeadLetterPublisher(channelID string, p publisher.Publisher) {
+	d.publishersMutex.Lock()
+	defer d.publishersMutex.Unlock()
+	d.deadLetterPublishers[channelID] = p
+}
+
 func (d *MessageDispatcher) getChannelPublisher(channelID string) (publisher.Publisher, error) {
+	d.publishersMutex.Lock()
+	defer d.publishersMutex.Unlock()
 	p, has := d.publishers[channelID]
 	if has {
 		return p, nil
@@ -59,11 +277,58 @@ func (d *MessageDispatcher) getChannelPublisher(channelID string) (publisher.Pub
 	if err != nil {
 		return nil, err
 	}
-	cfg := c.Destination
+	p = d.newPublisher(c.Destination)
+	d.publishers[channelID] = p
+	return p, nil
+}
+
+func (d *MessageDispatcher) getDeadLetterPublisher(channelID string) (publisher.Publisher, error) {
+	d.publishersMutex.Lock()
+	defer d.publishersMutex.Unlock()
+	p, has := d.deadLetterPublishers[channelID]
+	if has {
+		return p, nil
+	}
+
+	c, err := d.dataStorage.GetChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	if c.DeadLetter == nil {
+		return nil, errors.New("channel has no dead letter destination")
+	}
+	p = d.newPublisher(*c.DeadLetter)
+	d.deadLetterPublishers[channelID] = p
+	return p, nil
+}
 
+// destination returns the channel destination of the message, the default one is used for dropped channels
+func (d *MessageDispatcher) destination(m MessageForDelivery) channel.Destination {
+	c, err := d.dataStorage.GetChannel(m.channelID)
+	if err != nil {
+		return channel.Destination{}
+	}
+	if m.deadLetter && c.DeadLetter != nil {
+		return *c.DeadLetter
+	}
+	return c.Destination
+}
+
+func (d *MessageDispatcher) newPublisher(cfg channel.Destination) publisher.Publisher {
+	var p publisher.Publisher
 	switch cfg.Driver {
 	case "pubsub":
 		p = publisherpubsub.NewPubSubPublisher(d.context, cfg.Config)
+	case "kafka":
+		p = publisherkafka.NewKafkaPublisher(d.context, cfg.Config)
+	case "amqp":
+		p = publisheramqp.NewAmqpPublisher(d.context, cfg.Config)
+	case "webhook":
+		p = publisherwebhook.NewWebhookPublisher(d.context, cfg.Config)
+	case "nats":
+		p = publishernats.NewNatsPublisher(d.context, cfg.Config)
+	case "redis":
+		p = publisherredis.NewRedisPublisher(d.context, cfg.Config)
 	case "test":
 		p = publishertest.NewTestPublisher()
 	case "test_w":
@@ -72,78 +337,477 @@ func (d *MessageDispatcher) getChannelPublisher(channelID string) (publisher.Pub
 		log.Warn("selected publisher driver is not yet supported")
 		panic("selected publisher driver is not yet supported")
 	}
-	d.publishers[channelID] = p
-	return p, nil
+	return p
+}
+
+// prepareMessage adds the available_at attribute when the channel destination requires it
+func (d *MessageDispatcher) prepareMessage(m MessageForDelivery) message.Message {
+	msg := m.GetMessage()
+	if !d.destination(m).PreserveAvailableAt {
+		return msg
+	}
+	return msg.WithAttribute(message.AttributeAvailableAt, message.FormatAvailableAt(msg.GetAvailableAt()))
 }
 
 func (d *MessageDispatcher) Push(msg message.Message, channelID string) error {
-	err := d.outboundPool.Enqueue(MessageForDelivery{
+	return d.PushLeased(msg, channelID, "")
+}
+
+func (d *MessageDispatcher) PushLeased(msg message.Message, channelID string, leaseID string) error {
+	m := MessageForDelivery{
 		msg:       msg,
 		channelID: channelID,
+		leaseID:   leaseID,
+	}
+	if leaseID != "" {
+		key := d.leaseKey(channelID, leaseID)
+		d.inflightMutex.Lock()
+		_, has := d.inflight[key]
+		d.inflight[key] = m
+		d.inflightMutex.Unlock()
+		if has {
+			// renewed lease is still waiting for the delivery
+			return nil
+		}
+	}
+
+	err := d.enqueue(m)
+
+	if err != nil {
+		log.Error("dispatcher outbound queue push exception: ", err.Error())
+		d.forget(m)
+		return err
+	}
+
+	return nil
+}
+
+func (d *MessageDispatcher) PushDeadLetter(msg message.Message, channelID string) error {
+	err := d.enqueue(MessageForDelivery{
+		msg:        msg,
+		channelID:  channelID,
+		deadLetter: true,
 	})
 
 	if err != nil {
-		log.Error("dispatcher outbound pool push exception: ", err.Error())
+		log.Error("dispatcher outbound queue push exception: ", err.Error())
 		return err
 	}
 
 	return nil
 }
 
+// enqueue waits for the room in the destination queue, so the full queue holds back the channel processor
+func (d *MessageDispatcher) enqueue(m MessageForDelivery) error {
+	queue, err := d.getQueue(queueKey{channelID: m.channelID, deadLetter: m.deadLetter})
+	if err != nil {
+		return err
+	}
+	queue.pushMutex.RLock()
+	defer queue.pushMutex.RUnlock()
+	select {
+	case <-queue.removed:
+		return storage.ErrChannelNotFound
+	default:
+	}
+	d.addPending(1)
+	select {
+	case queue.messages <- m:
+		return nil
+	default:
+	}
+	log.Tracef("dispatcher queue is full, channel %v", m.channelID)
+	select {
+	case queue.messages <- m:
+		return nil
+	case <-queue.removed:
+		d.addPending(-1)
+		return storage.ErrChannelNotFound
+	case <-d.context.Done():
+		d.addPending(-1)
+		return d.context.Err()
+	}
+}
+
+func (d *MessageDispatcher) addPending(delta int) {
+	d.inflightMutex.Lock()
+	defer d.inflightMutex.Unlock()
+	d.pending += delta
+}
+
+// getQueue returns the destination queue, new queues are served by their own workers, removed channels have no queues
+func (d *MessageDispatcher) getQueue(key queueKey) (*outboundQueue, error) {
+	d.queuesMutex.Lock()
+	defer d.queuesMutex.Unlock()
+	queue, has := d.queues[key]
+	if has {
+		return queue, nil
+	}
+	if _, err := d.dataStorage.GetChannel(key.channelID); err != nil {
+		return nil, err
+	}
+	queue = &outboundQueue{
+		messages:  make(chan MessageForDelivery, d.queueSize),
+		pushMutex: &sync.RWMutex{},
+		removed:   make(chan struct{}),
+	}
+	d.queues[key] = queue
+	if d.running {
+		d.startWorkers(key, queue)
+	}
+	return queue, nil
+}
+
+// concurrency returns the number of workers of the destination
+func (d *MessageDispatcher) concurrency(key queueKey) int {
+	return d.destination(MessageForDelivery{channelID: key.channelID, deadLetter: key.deadLetter}).GetConcurrency()
+}
+
+// startWorkers runs as many workers as the destination concurrency, every destination gets its share of deliveries
+func (d *MessageDispatcher) startWorkers(key queueKey, queue *outboundQueue) {
+	ctx, stopWorkers := context.WithCancel(d.context)
+	queue.stopWorkers = stopWorkers
+	queue.concurrency = d.concurrency(key)
+	for workerID := 0; workerID < queue.concurrency; workerID++ {
+		d.workers.Add(1)
+		go func(workerID int) {
+			defer d.workers.Done()
+			d.work(ctx, key, queue.messages, workerID)
+		}(workerID)
+	}
+}
+
+// work delivers messages of the queue until its workers or the dispatcher are stopped
+func (d *MessageDispatcher) work(ctx context.Context, key queueKey, queue chan MessageForDelivery, workerID int) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m := <-queue:
+			log.Tracef("dispatcher dequeued element, channel %v worker (%v): %v", key.channelID, workerID, string(m.msg.GetBody()))
+			d.deliver(m)
+			d.addPending(-1)
+		}
+	}
+}
+
+// deliver publishes the message once the destination breaker lets it through, failed deliveries are retried
+func (d *MessageDispatcher) deliver(m MessageForDelivery) {
+	key := queueKey{channelID: m.channelID, deadLetter: m.deadLetter}
+	breaker := d.getBreaker(key)
+	for {
+		now := message.UnixMilli(time.Now())
+		retryAt, allowed := breaker.allow(now)
+		if allowed {
+			break
+		}
+		// the lease of the held message is renewed by the cluster if it expires meanwhile
+		select {
+		case <-d.context.Done():
+			return
+		case <-time.After(time.Duration(retryAt-now) * time.Millisecond):
+		}
+	}
+
+	result := d.publish(key, m)
+	// missing publisher fails the delivery, so the trial of the half open breaker is resolved as well
+	if breaker.record(result == nil, message.UnixMilli(time.Now())) {
+		log.Warnf("dispatcher circuit breaker is open, channel %v: %v", m.channelID, result.Error())
+	}
+	if result == errPublisherNotFound {
+		log.Warn("dispatcher can't init publisher for channel: ", m.channelID)
+		if _, err := d.dataStorage.GetChannel(m.channelID); err != nil {
+			// lease of the removed channel is dropped with the channel
+			d.forget(m)
+			return
+		}
+	}
+	if result == nil && !m.deadLetter {
+		d.ack(m)
+	}
+
+	// delivery failed, redeliver
+	if result != nil {
+		d.retry(m, result)
+	}
+}
+
+// publish dispatches the message by the destination publisher, the publisher isn't closed meanwhile
+func (d *MessageDispatcher) publish(key queueKey, m MessageForDelivery) error {
+	deliveries := d.getDeliveries(key)
+	deliveries.RLock()
+	defer deliveries.RUnlock()
+	getPublisher := d.getChannelPublisher
+	if key.deadLetter {
+		getPublisher = d.getDeadLetterPublisher
+	}
+	p, err := getPublisher(key.channelID)
+	if err != nil {
+		return errPublisherNotFound
+	}
+	return p.Dispatch(d.prepareMessage(m))
+}
+
+// getBreaker returns the circuit breaker of the destination, it's configured by the destination policy
+func (d *MessageDispatcher) getBreaker(key queueKey) *circuitBreaker {
+	d.breakersMutex.Lock()
+	defer d.breakersMutex.Unlock()
+	breaker, has := d.breakers[key]
+	if has {
+		return breaker
+	}
+	policy := d.destination(MessageForDelivery{channelID: key.channelID, deadLetter: key.deadLetter}).GetCircuitBreakerPolicy()
+	breaker = newCircuitBreaker(policy)
+	d.breakers[key] = breaker
+	return breaker
+}
+
+func (d *MessageDispatcher) PausedUntil(channelID string, now int) (int, bool) {
+	d.breakersMutex.Lock()
+	breaker, has := d.breakers[queueKey{channelID: channelID}]
+	d.breakersMutex.Unlock()
+	if !has {
+		return 0, false
+	}
+	state := breaker.getState(now)
+	return state.OpenUntil, state.State == BreakerOpen
+}
+
+func (d *MessageDispatcher) GetBreakerState(channelID string) BreakerState {
+	d.breakersMutex.Lock()
+	breaker, has := d.breakers[queueKey{channelID: channelID}]
+	d.breakersMutex.Unlock()
+	if !has {
+		return BreakerState{State: BreakerClosed}
+	}
+	return breaker.getState(message.UnixMilli(time.Now()))
+}
+
+func (d *MessageDispatcher) leaseKey(channelID string, leaseID string) string {
+	return channelID + "/" + leaseID
+}
+
+// ack queues the acknowledgement of the delivered message
+func (d *MessageDispatcher) ack(m MessageForDelivery) {
+	if m.leaseID == "" {
+		return
+	}
+	select {
+	case d.acks <- m:
+	case <-d.context.Done():
+	}
+}
+
+// acknowledge sends queued acknowledgements through the cluster, failed ones expire and the messages are delivered once again
+func (d *MessageDispatcher) acknowledge() {
+	for {
+		var batch []MessageForDelivery
+		select {
+		case <-d.context.Done():
+			return
+		case m := <-d.acks:
+			batch = append(batch, m)
+		}
+	drain:
+		for len(batch) < AckBatchSize {
+			select {
+			case m := <-d.acks:
+				batch = append(batch, m)
+			default:
+				break drain
+			}
+		}
+
+		opPayload := fsm.CommandPayload{
+			Operation: fsm.OperationMessageAck,
+			Leases:    make([]fsm.ChannelLease, len(batch)),
+		}
+		for i, m := range batch {
+			opPayload.Leases[i] = fsm.ChannelLease{ChannelID: m.channelID, Lease: storage.Lease{ID: m.leaseID}}
+		}
+		d.applyAcks(opPayload)
+		// leases are kept in flight until the acknowledgement is applied, so the drained node doesn't stop before it
+		d.inflightMutex.Lock()
+		for _, m := range batch {
+			delete(d.inflight, d.leaseKey(m.channelID, m.leaseID))
+		}
+		d.inflightMutex.Unlock()
+	}
+}
+
+func (d *MessageDispatcher) applyAcks(opPayload fsm.CommandPayload) {
+	if d.cluster == nil {
+		return
+	}
+	opPayloadData, err := json.Marshal(opPayload)
+	if err != nil {
+		log.Error("dispatcher error preparing ack payload: ", err.Error())
+		return
+	}
+	if err := d.cluster.Apply(opPayloadData, 500*time.Millisecond).Error(); err != nil {
+		log.Warn("dispatcher is unable to acknowledge delivered messages: ", err.Error())
+	}
+}
+
+// retry redelivers the failed message after the channel backoff, the delayed message doesn't hold dispatcher workers
+func (d *MessageDispatcher) retry(m MessageForDelivery, cause error) {
+	m.attempts++
+	policy := d.destination(m).GetRetryPolicy()
+	if policy.Exhausted(m.attempts) {
+		if m.deadLetter {
+			log.Errorf("dispatcher dropped dead letter after %v attempts, channel %v: %v", m.attempts, m.channelID, cause.Error())
+			return
+		}
+		log.Warnf("dispatcher moves message to dead letters after %v attempts, channel %v: %v", m.attempts, m.channelID, cause.Error())
+		d.moveToDeadLetters(m, cause)
+		return
+	}
+	backoff := policy.Backoff(m.attempts, rand.Float64())
+	log.Tracef("dispatcher message redelivery in %v, channel %v: %v", backoff, m.channelID, cause.Error())
+	d.addPending(1)
+	time.AfterFunc(backoff, func() {
+		defer d.addPending(-1)
+		if d.context.Err() != nil {
+			return
+		}
+		if err := d.enqueue(m); err != nil {
+			log.Error("dispatcher message redeliver exception: ", err.Error())
+			if err == storage.ErrChannelNotFound {
+				d.forget(m)
+			}
+		}
+	})
+}
+
+// forget drops the lease of the message which isn't delivered by the node anymore
+func (d *MessageDispatcher) forget(m MessageForDelivery) {
+	if m.leaseID == "" {
+		return
+	}
+	d.inflightMutex.Lock()
+	delete(d.inflight, d.leaseKey(m.channelID, m.leaseID))
+	d.inflightMutex.Unlock()
+}
+
+// moveToDeadLetters keeps the undelivered message in the cluster and acknowledges its lease by the same command,
+// the message is forwarded to the dead letter destination when the channel has one
+func (d *MessageDispatcher) moveToDeadLetters(m MessageForDelivery, cause error) {
+	d.inflightMutex.Lock()
+	delete(d.inflight, d.leaseKey(m.channelID, m.leaseID))
+	d.inflightMutex.Unlock()
+	if d.cluster != nil {
+		opPayload := fsm.CommandPayload{
+			Operation: fsm.OperationDeadLetterAdd,
+			ChannelID: m.channelID,
+			Message:   m.msg,
+			Reason:    cause.Error(),
+			Timestamp: message.UnixMilli(time.Now()),
+		}
+		if m.leaseID != "" {
+			opPayload.Leases = []fsm.ChannelLease{{ChannelID: m.channelID, Lease: storage.Lease{ID: m.leaseID}}}
+		}
+		opPayloadData, err := json.Marshal(opPayload)
+		if err != nil {
+			log.Error("dispatcher error preparing dead letter payload: ", err.Error())
+			return
+		}
+		// the message is delivered once again when its lease expires
+		if err := d.cluster.Apply(opPayloadData, 500*time.Millisecond).Error(); err != nil {
+			log.Warn("dispatcher is unable to move message to dead letters: ", err.Error())
+			return
+		}
+	}
+	if c, err := d.dataStorage.GetChannel(m.channelID); err == nil && c.DeadLetter != nil {
+		_ = d.PushDeadLetter(m.msg.WithAttribute(message.AttributeFailureReason, cause.Error()), m.channelID)
+	}
+}
+
+// DrainCheckInterval is the time between checks of the drained dispatcher
+const DrainCheckInterval = 10 * time.Millisecond
+
+func (d *MessageDispatcher) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(DrainCheckInterval)
+	defer ticker.Stop()
+	for {
+		d.inflightMutex.Lock()
+		drained := d.pending == 0 && len(d.inflight) == 0
+		d.inflightMutex.Unlock()
+		if drained {
+			log.Info("dispatcher is drained")
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			d.release()
+			return ctx.Err()
+		case <-d.context.Done():
+			d.release()
+			return d.context.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// release expires leases of the undelivered messages through the cluster, so the next leader delivers them right away
+func (d *MessageDispatcher) release() {
+	d.inflightMutex.Lock()
+	opPayload := fsm.CommandPayload{
+		Operation: fsm.OperationLeaseRelease,
+		Timestamp: message.UnixMilli(time.Now()),
+		Leases:    make([]fsm.ChannelLease, 0, len(d.inflight)),
+	}
+	for _, m := range d.inflight {
+		opPayload.Leases = append(opPayload.Leases, fsm.ChannelLease{ChannelID: m.channelID, Lease: storage.Lease{ID: m.leaseID}})
+	}
+	d.inflightMutex.Unlock()
+	if len(opPayload.Leases) == 0 || d.cluster == nil {
+		return
+	}
+	log.Warnf("dispatcher releases %v undelivered messages", len(opPayload.Leases))
+	opPayloadData, err := json.Marshal(opPayload)
+	if err != nil {
+		log.Error("dispatcher error preparing release payload: ", err.Error())
+		return
+	}
+	// unreleased messages are delivered once their leases expire
+	if err := d.cluster.Apply(opPayloadData, 500*time.Millisecond).Error(); err != nil {
+		log.Warn("dispatcher is unable to release undelivered messages: ", err.Error())
+	}
+}
+
 func (d *MessageDispatcher) Dispatch() error {
 	defer func() {
 		// close opened publisher connections
+		d.publishersMutex.Lock()
+		defer d.publishersMutex.Unlock()
 		for _, p := range d.publishers {
 			_ = p.Close()
 		}
+		for _, p := range d.deadLetterPublishers {
+			_ = p.Close()
+		}
 	}()
 	var dispatcherWg sync.WaitGroup
-	for threadID := 0; threadID < DispatcherThreads; threadID++ {
-		dispatcherWg.Add(1)
-
-		go func(threadId int) {
-			defer dispatcherWg.Done()
-
-			for {
-				select {
-				case <-d.context.Done():
-					log.Warn("dispatcher is stopped")
-					return
-				default:
-				}
-
-				if d.outboundPool.GetLen() == 0 {
-					log.Tracef("dispatcher queue is empty, thread (%v)", threadId)
-					time.Sleep(time.Second)
-					continue
-				}
-
-				v, _ := d.outboundPool.DequeueOrWaitForNextElement()
-				m := v.(MessageForDelivery)
-				log.Tracef("dispatcher dequeued element, thread (%v): %v", threadId, m.msg.GetBody())
-
-				p, err := d.getChannelPublisher(m.channelID)
-				if err != nil {
-					log.Warn("dispatcher can't init publisher for channel: ", m.channelID)
-					continue
-				}
-
-				result := p.Dispatch(m.GetMessage())
-
-				// delivery failed, redeliver
-				if result != nil {
-					// Attempt to redeliver the message
-					err := d.outboundPool.Enqueue(m)
-					if err != nil {
-						log.Errorf("dispatcher message redeliver exception, thread (%v): %v", threadId, err.Error())
-					} else {
-						log.Tracef("dispatcher message redelivered, thread (%v)", threadId)
-					}
-				}
+	dispatcherWg.Add(1)
+	go func() {
+		defer dispatcherWg.Done()
+		d.acknowledge()
+	}()
 
-			}
-		}(threadID)
+	d.queuesMutex.Lock()
+	d.running = true
+	for key, queue := range d.queues {
+		d.startWorkers(key, queue)
 	}
+	d.queuesMutex.Unlock()
+
+	<-d.context.Done()
+	log.Warn("dispatcher is stopped")
+	// workers aren't started once the dispatcher is stopped
+	d.queuesMutex.Lock()
+	d.running = false
+	d.queuesMutex.Unlock()
+	d.workers.Wait()
 	dispatcherWg.Wait()
 	return nil
 }
@@ -5,4 +5,5 @@ import "errors"
 var (
 	ErrOperationIsRestrictedOnNonLeader = errors.New("channel operation is denied on non-leader node")
 	ErrSourceDriverNotSupported         = errors.New("selected source driver is not yet supported")
+	ErrChannelSourceIsNotHttp           = errors.New("channel source driver doesn't accept messages over http")
 )
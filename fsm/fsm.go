@@ -5,13 +5,25 @@ import (
 	"encoding/json"
 	"github.com/hashicorp/raft"
 	"github.com/maksimru/event-scheduler/channel"
+	amqplistenerconfig "github.com/maksimru/event-scheduler/listener/amqp/config"
+	httplistenerconfig "github.com/maksimru/event-scheduler/listener/http/config"
+	kafkalistenerconfig "github.com/maksimru/event-scheduler/listener/kafka/config"
+	natslistenerconfig "github.com/maksimru/event-scheduler/listener/nats/config"
 	pubsublistenerconfig "github.com/maksimru/event-scheduler/listener/pubsub/config"
+	redislistenerconfig "github.com/maksimru/event-scheduler/listener/redis/config"
 	"github.com/maksimru/event-scheduler/message"
+	amqppublisherconfig "github.com/maksimru/event-scheduler/publisher/amqp/config"
+	kafkapublisherconfig "github.com/maksimru/event-scheduler/publisher/kafka/config"
+	natspublisherconfig "github.com/maksimru/event-scheduler/publisher/nats/config"
 	pubsubpublisherconfig "github.com/maksimru/event-scheduler/publisher/pubsub/config"
+	redispublisherconfig "github.com/maksimru/event-scheduler/publisher/redis/config"
+	webhookpublisherconfig "github.com/maksimru/event-scheduler/publisher/webhook/config"
+	"github.com/maksimru/event-scheduler/schedule"
 	"github.com/maksimru/event-scheduler/storage"
 	"github.com/mitchellh/mapstructure"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"strconv"
 )
 
 type prioritizedFSM struct {
@@ -29,12 +41,60 @@ const OperationMessagePop int = 1
 const OperationChannelCreate int = 2
 const OperationChannelDelete int = 3
 const OperationChannelUpdate int = 4
+const OperationMessageCancel int = 5
+const OperationMessageReschedule int = 6
+const OperationScheduleSave int = 7
+const OperationScheduleDelete int = 8
+const OperationMessagePushBatch int = 9
+const OperationMessagePopBatch int = 10
+const OperationMessageAck int = 11
+const OperationLeaseRenew int = 12
+const OperationDeadLetterAdd int = 13
+const OperationDeadLetterReplay int = 14
+const OperationLeaseRelease int = 15
 
 type CommandPayload struct {
 	Operation int
 	ChannelID string
 	Message   message.Message
 	Channel   channel.Channel
+	// MessageID refers to the scheduled message
+	MessageID string
+	// Timestamp is the leader time of push in Unix milliseconds, it's used to expire deduplication keys, so all nodes agree on them.
+	// It restricts pop to the messages scheduled up to it, messages could be cancelled after the processor check.
+	// Rescheduled messages are moved to it, saved schedules fire after it
+	Timestamp int `json:"TimestampMs,omitempty"`
+	// Schedule is the saved recurring schedule, ID refers to the deleted one
+	Schedule *schedule.Schedule `json:",omitempty"`
+	// Messages are pushed by the batch in one command, they may belong to different channels
+	Messages []ChannelMessage `json:",omitempty"`
+	// Limit is the maximum number of messages popped by the batch
+	Limit int `json:",omitempty"`
+	// LeaseTimeout keeps popped messages in flight until they are acknowledged, expired leases are renewed by it (milliseconds)
+	LeaseTimeout int `json:"LeaseTimeoutMs,omitempty"`
+	// Leases are acknowledged by the batch, only their IDs are sent
+	Leases []ChannelLease `json:",omitempty"`
+	// Reason describes why the message is moved to the dead letters
+	Reason string `json:",omitempty"`
+	// DeadLetterIDs refer to the replayed dead letters, their messages are scheduled to the timestamp
+	DeadLetterIDs []string `json:",omitempty"`
+}
+
+// UnmarshalJSON converts the timestamp of commands logged before millisecond precision
+func (p *CommandPayload) UnmarshalJSON(data []byte) error {
+	type payload CommandPayload
+	var decoded struct {
+		payload
+		LegacyTimestamp int `json:"Timestamp"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*p = CommandPayload(decoded.payload)
+	if p.Timestamp == 0 {
+		p.Timestamp = decoded.LegacyTimestamp * 1000
+	}
+	return nil
 }
 
 type ApplyResponse struct {
@@ -43,8 +103,12 @@ type ApplyResponse struct {
 }
 
 type fsmSnapshot struct {
-	channelsDump []channel.Channel
-	messagesDump map[string][]message.Message
+	channelsDump      []channel.Channel
+	messagesDump      map[string][]message.Message
+	deduplicationDump map[string][]storage.DeduplicationEntry
+	schedulesDump     map[string][]schedule.Schedule
+	leasesDump        map[string][]storage.Lease
+	deadLettersDump   map[string][]storage.DeadLetter
 }
 
 type ChannelMessage struct {
@@ -52,10 +116,34 @@ type ChannelMessage struct {
 	Message   message.Message
 }
 
+type ChannelDeduplicationEntry struct {
+	ChannelID string
+	Entry     storage.DeduplicationEntry
+}
+
+type ChannelSchedule struct {
+	ChannelID string
+	Schedule  schedule.Schedule
+}
+
+type ChannelLease struct {
+	ChannelID string
+	Lease     storage.Lease
+}
+
+type ChannelDeadLetter struct {
+	ChannelID  string
+	DeadLetter storage.DeadLetter
+}
+
 type Structs byte
 
 const MessageStruct Structs = 1
 const ChannelsStruct Structs = 2
+const DeduplicationStruct Structs = 3
+const ScheduleStruct Structs = 4
+const LeaseStruct Structs = 5
+const DeadLetterStruct Structs = 6
 
 func (f fsmSnapshot) persistChannels(sink raft.SnapshotSink, encoder *json.Encoder) error {
 	for _, c := range f.channelsDump {
@@ -84,6 +172,54 @@ func (f fsmSnapshot) persistMessages(sink raft.SnapshotSink, encoder *json.Encod
 	return nil
 }
 
+func (f fsmSnapshot) persistDeduplication(sink raft.SnapshotSink, encoder *json.Encoder) error {
+	for _, c := range f.channelsDump {
+		for _, entry := range f.deduplicationDump[c.ID] {
+			sink.Write([]byte{byte(DeduplicationStruct)})
+			err := encoder.Encode(&ChannelDeduplicationEntry{
+				ChannelID: c.ID,
+				Entry:     entry,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f fsmSnapshot) persistSchedules(sink raft.SnapshotSink, encoder *json.Encoder) error {
+	for _, c := range f.channelsDump {
+		for _, sch := range f.schedulesDump[c.ID] {
+			sink.Write([]byte{byte(ScheduleStruct)})
+			err := encoder.Encode(&ChannelSchedule{
+				ChannelID: c.ID,
+				Schedule:  sch,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f fsmSnapshot) persistLeases(sink raft.SnapshotSink, encoder *json.Encoder) error {
+	for _, c := range f.channelsDump {
+		for _, lease := range f.leasesDump[c.ID] {
+			sink.Write([]byte{byte(LeaseStruct)})
+			err := encoder.Encode(&ChannelLease{
+				ChannelID: c.ID,
+				Lease:     lease,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // Persist should dump all necessary state to the WriteCloser 'sink',
 // and call sink.Close() when finished or call sink.Cancel() on error.
 func (f fsmSnapshot) Persist(sink raft.SnapshotSink) error {
@@ -96,6 +232,18 @@ func (f fsmSnapshot) Persist(sink raft.SnapshotSink) error {
 		if err := f.persistMessages(sink, encoder); err != nil {
 			return err
 		}
+		if err := f.persistDeduplication(sink, encoder); err != nil {
+			return err
+		}
+		if err := f.persistSchedules(sink, encoder); err != nil {
+			return err
+		}
+		if err := f.persistLeases(sink, encoder); err != nil {
+			return err
+		}
+		if err := f.persistDeadLetters(sink, encoder); err != nil {
+			return err
+		}
 
 		return nil
 	}()
@@ -114,6 +262,22 @@ func (f fsmSnapshot) Persist(sink raft.SnapshotSink) error {
 	return nil
 }
 
+func (f fsmSnapshot) persistDeadLetters(sink raft.SnapshotSink, encoder *json.Encoder) error {
+	for _, c := range f.channelsDump {
+		for _, deadLetter := range f.deadLettersDump[c.ID] {
+			sink.Write([]byte{byte(DeadLetterStruct)})
+			err := encoder.Encode(&ChannelDeadLetter{
+				ChannelID:  c.ID,
+				DeadLetter: deadLetter,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // Release is invoked when we are finished with the snapshot.
 func (f fsmSnapshot) Release() {
 }
@@ -132,22 +296,159 @@ func (b prioritizedFSM) Apply(raftLog *raft.Log) interface{} {
 		}
 		switch payload.Operation {
 		case OperationMessagePush:
-			s, has := b.storage.GetChannelStorage(payload.ChannelID)
-			if has {
-				s.Enqueue(payload.Message)
+			return b.push(payload.ChannelID, payload.Message, payload.Timestamp)
+		case OperationMessagePushBatch:
+			// every message is pushed on its own, so duplicates don't fail the batch
+			responses := make([]ApplyResponse, len(payload.Messages))
+			for i, m := range payload.Messages {
+				responses[i] = *b.push(m.ChannelID, m.Message, payload.Timestamp)
 			}
 			return &ApplyResponse{
-				Data: payload.Message,
+				Data: responses,
 			}
 		case OperationMessagePop:
 			data := message.Message{}
 			s, has := b.storage.GetChannelStorage(payload.ChannelID)
 			if has {
-				data = s.Dequeue()
+				if !b.scheduled(s, payload.Timestamp) {
+					return &ApplyResponse{
+						Data: data,
+						Err:  storage.ErrMessageNotFound,
+					}
+				}
+				data = b.pop(s, payload.Timestamp)
+				if payload.LeaseTimeout > 0 {
+					return &ApplyResponse{
+						Data: b.lease(s, raftLog.Index, 0, data, payload),
+					}
+				}
 			}
 			return &ApplyResponse{
 				Data: data,
 			}
+		case OperationMessagePopBatch:
+			data := []message.Message{}
+			s, has := b.storage.GetChannelStorage(payload.ChannelID)
+			if has {
+				for len(data) < payload.Limit && b.scheduled(s, payload.Timestamp) {
+					data = append(data, b.pop(s, payload.Timestamp))
+				}
+			}
+			if len(data) == 0 {
+				return &ApplyResponse{
+					Data: data,
+					Err:  storage.ErrMessageNotFound,
+				}
+			}
+			if payload.LeaseTimeout > 0 {
+				leases := make([]storage.Lease, len(data))
+				for i, msg := range data {
+					leases[i] = b.lease(s, raftLog.Index, i, msg, payload)
+				}
+				return &ApplyResponse{
+					Data: leases,
+				}
+			}
+			return &ApplyResponse{
+				Data: data,
+			}
+		case OperationLeaseRenew:
+			leases := []storage.Lease{}
+			s, has := b.storage.GetChannelStorage(payload.ChannelID)
+			if has {
+				leases = s.RenewExpired(payload.Timestamp, payload.Timestamp+payload.LeaseTimeout, payload.Limit)
+			}
+			if len(leases) == 0 {
+				return &ApplyResponse{
+					Data: leases,
+					Err:  storage.ErrMessageNotFound,
+				}
+			}
+			return &ApplyResponse{
+				Data: leases,
+			}
+		case OperationMessageAck:
+			acked := 0
+			for _, l := range payload.Leases {
+				if s, has := b.storage.GetChannelStorage(l.ChannelID); has && s.Ack(l.Lease.ID) {
+					acked++
+				}
+			}
+			return &ApplyResponse{
+				Data: acked,
+			}
+		case OperationLeaseRelease:
+			// leases of the undelivered messages expire at the timestamp, the leader delivers them once again
+			released := 0
+			for _, l := range payload.Leases {
+				if s, has := b.storage.GetChannelStorage(l.ChannelID); has && s.Release(l.Lease.ID, payload.Timestamp) {
+					released++
+				}
+			}
+			return &ApplyResponse{
+				Data: released,
+			}
+		case OperationDeadLetterAdd:
+			s, has := b.storage.GetChannelStorage(payload.ChannelID)
+			if !has {
+				return &ApplyResponse{
+					Err: storage.ErrChannelNotFound,
+				}
+			}
+			// failed delivery is acknowledged by the same command, so the message isn't dispatched again
+			for _, l := range payload.Leases {
+				s.Ack(l.Lease.ID)
+			}
+			deadLetter := storage.DeadLetter{
+				ID:       strconv.FormatUint(raftLog.Index, 10),
+				Message:  payload.Message.WithAttribute(message.AttributeFailureReason, payload.Reason),
+				Reason:   payload.Reason,
+				FailedAt: payload.Timestamp,
+			}
+			s.AddDeadLetter(deadLetter)
+			return &ApplyResponse{
+				Data: deadLetter,
+			}
+		case OperationDeadLetterReplay:
+			messages, err := b.storage.ReplayDeadLetters(payload.ChannelID, payload.DeadLetterIDs, payload.Timestamp)
+			return &ApplyResponse{
+				Data: messages,
+				Err:  err,
+			}
+		case OperationMessageReschedule:
+			msg, err := b.storage.RescheduleMessage(payload.ChannelID, payload.MessageID, payload.Timestamp)
+			return &ApplyResponse{
+				Data: msg,
+				Err:  err,
+			}
+		case OperationScheduleSave:
+			if payload.Schedule == nil {
+				return &ApplyResponse{
+					Err: storage.ErrScheduleNotFound,
+				}
+			}
+			sch, err := b.storage.SaveSchedule(payload.ChannelID, *payload.Schedule, payload.Timestamp)
+			return &ApplyResponse{
+				Data: sch,
+				Err:  err,
+			}
+		case OperationScheduleDelete:
+			if payload.Schedule == nil {
+				return &ApplyResponse{
+					Err: storage.ErrScheduleNotFound,
+				}
+			}
+			sch, err := b.storage.DeleteSchedule(payload.ChannelID, payload.Schedule.ID)
+			return &ApplyResponse{
+				Data: sch,
+				Err:  err,
+			}
+		case OperationMessageCancel:
+			err := b.storage.RemoveMessage(payload.ChannelID, payload.MessageID)
+			return &ApplyResponse{
+				Data: payload.MessageID,
+				Err:  err,
+			}
 		case OperationChannelCreate:
 			c, err := b.storage.AddChannel(remapChannelConfig(payload.Channel))
 			return &ApplyResponse{
@@ -171,6 +472,62 @@ func (b prioritizedFSM) Apply(raftLog *raft.Log) interface{} {
 	return nil
 }
 
+func (b prioritizedFSM) push(channelID string, msg message.Message, timestamp int) *ApplyResponse {
+	s, has := b.storage.GetChannelStorage(channelID)
+	if has {
+		c, _ := b.storage.GetChannel(channelID)
+		// commands without timestamp were written before deduplication was introduced
+		if c.Source.DeduplicationWindow > 0 && timestamp > 0 &&
+			s.Deduplicate(deduplicationKey(c, msg), timestamp, c.Source.DeduplicationWindow*1000) {
+			return &ApplyResponse{
+				Data: msg,
+				Err:  storage.ErrMessageDuplicated,
+			}
+		}
+		if c.Source.DuplicateMode == channel.DuplicateModeReplace {
+			s.Replace(msg)
+		} else {
+			s.Enqueue(msg)
+		}
+	}
+	return &ApplyResponse{
+		Data: msg,
+	}
+}
+
+// scheduled checks whether the head of the queue is scheduled up to the timestamp, commands without timestamp pop any message
+func (b prioritizedFSM) scheduled(s storage.PqChannelStorage, timestamp int) bool {
+	return !s.IsEmpty() && (timestamp == 0 || s.CheckScheduled(timestamp))
+}
+
+func (b prioritizedFSM) pop(s storage.PqChannelStorage, timestamp int) message.Message {
+	msg := s.Dequeue()
+	// next occurrence is scheduled in the same command, so the schedule is never lost
+	if msg.GetScheduleID() != "" {
+		s.ScheduleNext(msg, timestamp)
+	}
+	return msg
+}
+
+// lease keeps the popped message in flight, the ID is derived from the log entry, so all nodes agree on it
+func (b prioritizedFSM) lease(s storage.PqChannelStorage, index uint64, i int, msg message.Message, payload CommandPayload) storage.Lease {
+	lease := storage.Lease{
+		ID:        strconv.FormatUint(index, 10) + "-" + strconv.Itoa(i),
+		Message:   msg,
+		ExpiresAt: payload.Timestamp + payload.LeaseTimeout,
+	}
+	s.AddLease(lease)
+	return lease
+}
+
+// deduplicationKey takes the key from the configured attribute or uses the message ID
+func deduplicationKey(c channel.Channel, msg message.Message) string {
+	if c.Source.DeduplicationAttribute != "" {
+		return msg.GetAttributes()[c.Source.DeduplicationAttribute]
+	}
+	return msg.GetID()
+}
+
 // transform hash map config to real objects
 func remapChannelConfig(c channel.Channel) channel.Channel {
 	switch c.Source.Driver {
@@ -181,23 +538,103 @@ func remapChannelConfig(c channel.Channel) channel.Channel {
 			panic(err)
 		}
 		c.Source.Config = cfg
+	case "kafka":
+		var cfg kafkalistenerconfig.SourceConfig
+		err := mapstructure.Decode(c.Source.Config, &cfg)
+		if err != nil {
+			panic(err)
+		}
+		c.Source.Config = cfg
+	case "amqp":
+		var cfg amqplistenerconfig.SourceConfig
+		err := mapstructure.Decode(c.Source.Config, &cfg)
+		if err != nil {
+			panic(err)
+		}
+		c.Source.Config = cfg
+	case "http":
+		var cfg httplistenerconfig.SourceConfig
+		err := mapstructure.Decode(c.Source.Config, &cfg)
+		if err != nil {
+			panic(err)
+		}
+		c.Source.Config = cfg
+	case "nats":
+		var cfg natslistenerconfig.SourceConfig
+		err := mapstructure.Decode(c.Source.Config, &cfg)
+		if err != nil {
+			panic(err)
+		}
+		c.Source.Config = cfg
+	case "redis":
+		var cfg redislistenerconfig.SourceConfig
+		err := mapstructure.Decode(c.Source.Config, &cfg)
+		if err != nil {
+			panic(err)
+		}
+		c.Source.Config = cfg
 	default:
 		// truncate configs of unsupported drivers
 		c.Source.Config = nil
 	}
-	switch c.Destination.Driver {
+	c.Destination = remapDestinationConfig(c.Destination)
+	if c.DeadLetter != nil {
+		deadLetter := remapDestinationConfig(*c.DeadLetter)
+		c.DeadLetter = &deadLetter
+	}
+	return c
+}
+
+// transform hash map config of the destination driver to real object
+func remapDestinationConfig(d channel.Destination) channel.Destination {
+	switch d.Driver {
 	case "pubsub":
 		var cfg pubsubpublisherconfig.DestinationConfig
-		err := mapstructure.Decode(c.Destination.Config, &cfg)
+		err := mapstructure.Decode(d.Config, &cfg)
+		if err != nil {
+			panic(err)
+		}
+		d.Config = cfg
+	case "kafka":
+		var cfg kafkapublisherconfig.DestinationConfig
+		err := mapstructure.Decode(d.Config, &cfg)
+		if err != nil {
+			panic(err)
+		}
+		d.Config = cfg
+	case "amqp":
+		var cfg amqppublisherconfig.DestinationConfig
+		err := mapstructure.Decode(d.Config, &cfg)
+		if err != nil {
+			panic(err)
+		}
+		d.Config = cfg
+	case "webhook":
+		var cfg webhookpublisherconfig.DestinationConfig
+		err := mapstructure.Decode(d.Config, &cfg)
 		if err != nil {
 			panic(err)
 		}
-		c.Destination.Config = cfg
+		d.Config = cfg
+	case "nats":
+		var cfg natspublisherconfig.DestinationConfig
+		err := mapstructure.Decode(d.Config, &cfg)
+		if err != nil {
+			panic(err)
+		}
+		d.Config = cfg
+	case "redis":
+		var cfg redispublisherconfig.DestinationConfig
+		err := mapstructure.Decode(d.Config, &cfg)
+		if err != nil {
+			panic(err)
+		}
+		d.Config = cfg
 	default:
 		// truncate configs of unsupported drivers
-		c.Destination.Config = nil
+		d.Config = nil
 	}
-	return c
+	return d
 }
 
 // Snapshot is used to support log compaction. This call should
@@ -209,8 +646,12 @@ func remapChannelConfig(c channel.Channel) channel.Channel {
 func (b prioritizedFSM) Snapshot() (raft.FSMSnapshot, error) {
 	channelsDump, messagesDump := b.storage.Dump()
 	return &fsmSnapshot{
-		channelsDump: channelsDump,
-		messagesDump: messagesDump,
+		channelsDump:      channelsDump,
+		messagesDump:      messagesDump,
+		deduplicationDump: b.storage.DumpDeduplication(),
+		schedulesDump:     b.storage.DumpSchedules(),
+		leasesDump:        b.storage.DumpLeases(),
+		deadLettersDump:   b.storage.DumpDeadLetters(),
 	}, nil
 }
 
@@ -245,8 +686,12 @@ func (b prioritizedFSM) Restore(rClose io.ReadCloser) error {
 		switch structType {
 		case ChannelsStruct:
 			var c channel.Channel
-			data, _, _ := reader.ReadLine()
-			err := json.Unmarshal(data, &c)
+			data, err := readSnapshotLine(reader)
+			if err != nil {
+				log.Errorf("Snapshot restore failed: error read channel data %s\n", err.Error())
+				return err
+			}
+			err = json.Unmarshal(data, &c)
 			if err != nil {
 				log.Errorf("Snapshot restore failed: error decode channel data %s\n", err.Error())
 				return err
@@ -255,8 +700,12 @@ func (b prioritizedFSM) Restore(rClose io.ReadCloser) error {
 			totalChannelsRestored++
 		case MessageStruct:
 			var m ChannelMessage
-			data, _, _ := reader.ReadLine()
-			err := json.Unmarshal(data, &m)
+			data, err := readSnapshotLine(reader)
+			if err != nil {
+				log.Errorf("Snapshot restore failed: error read message data %s\n", err.Error())
+				return err
+			}
+			err = json.Unmarshal(data, &m)
 			if err != nil {
 				log.Errorf("Snapshot restore failed: error decode message data %s\n", err.Error())
 				return err
@@ -268,9 +717,90 @@ func (b prioritizedFSM) Restore(rClose io.ReadCloser) error {
 			} else {
 				log.Error("Unable to find channel information inside the snapshot")
 			}
+		case DeduplicationStruct:
+			var e ChannelDeduplicationEntry
+			data, err := readSnapshotLine(reader)
+			if err != nil {
+				log.Errorf("Snapshot restore failed: error read deduplication data %s\n", err.Error())
+				return err
+			}
+			err = json.Unmarshal(data, &e)
+			if err != nil {
+				log.Errorf("Snapshot restore failed: error decode deduplication data %s\n", err.Error())
+				return err
+			}
+			s, has := b.storage.GetChannelStorage(e.ChannelID)
+			if has {
+				s.RestoreDeduplication(e.Entry)
+			} else {
+				log.Error("Unable to find channel information inside the snapshot")
+			}
+		case ScheduleStruct:
+			var cs ChannelSchedule
+			data, err := readSnapshotLine(reader)
+			if err != nil {
+				log.Errorf("Snapshot restore failed: error read schedule data %s\n", err.Error())
+				return err
+			}
+			err = json.Unmarshal(data, &cs)
+			if err != nil {
+				log.Errorf("Snapshot restore failed: error decode schedule data %s\n", err.Error())
+				return err
+			}
+			s, has := b.storage.GetChannelStorage(cs.ChannelID)
+			if has {
+				s.RestoreSchedule(cs.Schedule)
+			} else {
+				log.Error("Unable to find channel information inside the snapshot")
+			}
+		case LeaseStruct:
+			var cl ChannelLease
+			data, err := readSnapshotLine(reader)
+			if err != nil {
+				log.Errorf("Snapshot restore failed: error read lease data %s\n", err.Error())
+				return err
+			}
+			err = json.Unmarshal(data, &cl)
+			if err != nil {
+				log.Errorf("Snapshot restore failed: error decode lease data %s\n", err.Error())
+				return err
+			}
+			s, has := b.storage.GetChannelStorage(cl.ChannelID)
+			if has {
+				s.AddLease(cl.Lease)
+			} else {
+				log.Error("Unable to find channel information inside the snapshot")
+			}
+		case DeadLetterStruct:
+			var cd ChannelDeadLetter
+			data, err := readSnapshotLine(reader)
+			if err != nil {
+				log.Errorf("Snapshot restore failed: error read dead letter data %s\n", err.Error())
+				return err
+			}
+			err = json.Unmarshal(data, &cd)
+			if err != nil {
+				log.Errorf("Snapshot restore failed: error decode dead letter data %s\n", err.Error())
+				return err
+			}
+			s, has := b.storage.GetChannelStorage(cd.ChannelID)
+			if has {
+				s.AddDeadLetter(cd.DeadLetter)
+			} else {
+				log.Error("Unable to find channel information inside the snapshot")
+			}
 		}
 	}
 
 	log.Infof("Snapshot restore finished: restored %d messages in %d channels in snapshot\n", totalMessagesRestored, totalChannelsRestored)
 	return nil
 }
+
+// readSnapshotLine reads the whole encoded struct, unlike ReadLine it isn't limited by the reader buffer size
+func readSnapshotLine(reader *bufio.Reader) ([]byte, error) {
+	data, err := reader.ReadBytes('\n')
+	if err == io.EOF && len(data) > 0 {
+		return data, nil
+	}
+	return data, err
+}
@@ -1,16 +1,22 @@
 package fsm
 
 import (
+	"bytes"
+	"crypto/rand"
 	"encoding/json"
 	"github.com/hashicorp/raft"
 	"github.com/maksimru/event-scheduler/channel"
 	pubsublistenerconfig "github.com/maksimru/event-scheduler/listener/pubsub/config"
 	"github.com/maksimru/event-scheduler/message"
 	"github.com/maksimru/event-scheduler/nodenameresolver"
+	kafkapublisherconfig "github.com/maksimru/event-scheduler/publisher/kafka/config"
 	pubsubpublisherconfig "github.com/maksimru/event-scheduler/publisher/pubsub/config"
+	webhookpublisherconfig "github.com/maksimru/event-scheduler/publisher/webhook/config"
+	"github.com/maksimru/event-scheduler/schedule"
 	"github.com/maksimru/event-scheduler/storage"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"io/ioutil"
 	"reflect"
 	"testing"
 	"time"
@@ -36,9 +42,9 @@ func Test_prioritizedFSM_Snapshot(t *testing.T) {
 			want: raft.FSMSnapshot(&fsmSnapshot{
 				messagesDump: map[string][]message.Message{
 					"id1": {
-						message.NewMessage("msg1", 1000),
-						message.NewMessage("msg5", 1200),
-						message.NewMessage("msg4", 2000),
+						message.NewMessage([]byte("msg1"), 1000),
+						message.NewMessage([]byte("msg5"), 1200),
+						message.NewMessage([]byte("msg4"), 2000),
 					},
 				},
 				channelsDump: []channel.Channel{
@@ -48,12 +54,24 @@ func Test_prioritizedFSM_Snapshot(t *testing.T) {
 						Destination: channel.Destination{},
 					},
 				},
+				deduplicationDump: map[string][]storage.DeduplicationEntry{
+					"id1": {},
+				},
+				schedulesDump: map[string][]schedule.Schedule{
+					"id1": {},
+				},
+				leasesDump: map[string][]storage.Lease{
+					"id1": {},
+				},
+				deadLettersDump: map[string][]storage.DeadLetter{
+					"id1": {},
+				},
 			}),
 			wantErr: false,
 			messages: []message.Message{
-				message.NewMessage("msg1", 1000),
-				message.NewMessage("msg5", 1200),
-				message.NewMessage("msg4", 2000),
+				message.NewMessage([]byte("msg1"), 1000),
+				message.NewMessage([]byte("msg5"), 1200),
+				message.NewMessage([]byte("msg4"), 2000),
 			},
 			channel: channel.Channel{
 				ID:          "id1",
@@ -130,9 +148,9 @@ func Test_prioritizedFSM_Restore(t *testing.T) {
 			},
 			messages: map[string][]message.Message{
 				"id1": {
-					message.NewMessage("msg1", 1000),
-					message.NewMessage("msg5", 1200),
-					message.NewMessage("msg4", 2000),
+					message.NewMessage([]byte("msg1"), 1000),
+					message.NewMessage([]byte("msg5"), 1200),
+					message.NewMessage([]byte("msg4"), 2000),
 				},
 			},
 			channels: []channel.Channel{
@@ -151,30 +169,30 @@ func Test_prioritizedFSM_Restore(t *testing.T) {
 			},
 			messages: map[string][]message.Message{
 				"id1": {
-					message.NewMessage("XXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXX", 1000),
-					message.NewMessage("YYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYY", 1200),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
-					message.NewMessage("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ", 2000),
+					message.NewMessage([]byte("XXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXX"), 1000),
+					message.NewMessage([]byte("YYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYYY"), 1200),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
+					message.NewMessage([]byte("ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), 2000),
 				},
 			},
 			channels: []channel.Channel{
@@ -200,6 +218,48 @@ func Test_prioritizedFSM_Restore(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Checks fsm snapshot restoration in single channel with message attributes",
+			fields: fields{
+				storage: storage.NewPqStorage(),
+			},
+			messages: map[string][]message.Message{
+				"id1": {
+					message.NewMessageWithAttributes([]byte("msg1"), 1000, map[string]string{"type": "foo"}),
+					message.NewMessageWithAttributes([]byte("msg2"), 1200, map[string]string{"type": "bar", "trace": "1"}),
+				},
+			},
+			channels: []channel.Channel{
+				{
+					ID:          "id1",
+					Source:      channel.Source{},
+					Destination: channel.Destination{PreserveAvailableAt: true},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Checks fsm snapshot restoration in single channel with binary messages",
+			fields: fields{
+				storage: storage.NewPqStorage(),
+			},
+			messages: map[string][]message.Message{
+				"id1": {
+					message.NewMessage([]byte{}, 1000),
+					message.NewMessage([]byte{0, '\n', 0xff, '"', '\\'}, 1100),
+					message.NewMessage(randomBody(256), 1200),
+					message.NewMessage(randomBody(64*1024), 1300),
+				},
+			},
+			channels: []channel.Channel{
+				{
+					ID:          "id1",
+					Source:      channel.Source{},
+					Destination: channel.Destination{},
+				},
+			},
+			wantErr: false,
+		},
 		{
 			name: "Checks fsm snapshot restoration in single channel, empty channel",
 			fields: fields{
@@ -224,14 +284,14 @@ func Test_prioritizedFSM_Restore(t *testing.T) {
 			},
 			messages: map[string][]message.Message{
 				"id1": {
-					message.NewMessage("msg1", 1000),
-					message.NewMessage("msg5", 1200),
-					message.NewMessage("msg4", 2000),
+					message.NewMessage([]byte("msg1"), 1000),
+					message.NewMessage([]byte("msg5"), 1200),
+					message.NewMessage([]byte("msg4"), 2000),
 				},
 				"id2": {
-					message.NewMessage("msg7", 3000),
-					message.NewMessage("msg8", 3200),
-					message.NewMessage("msg6", 1000),
+					message.NewMessage([]byte("msg7"), 3000),
+					message.NewMessage([]byte("msg8"), 3200),
+					message.NewMessage([]byte("msg6"), 1000),
 				},
 			},
 			channels: []channel.Channel{
@@ -378,3 +438,625 @@ func Test_prioritizedFSM_Restore(t *testing.T) {
 		})
 	}
 }
+
+func randomBody(size int) []byte {
+	body := make([]byte, size)
+	_, _ = rand.Read(body)
+	return body
+}
+
+func Test_prioritizedFSM_ApplyBinaryMessage(t *testing.T) {
+	s := storage.NewPqStorage()
+	_, _ = s.AddChannel(channel.Channel{ID: "id1"})
+	f := prioritizedFSM{storage: s}
+
+	want := message.NewMessage(randomBody(1024), 1000)
+	opPayloadData, err := json.Marshal(CommandPayload{
+		Operation: OperationMessagePush,
+		ChannelID: "id1",
+		Message:   want,
+	})
+	assert.NoError(t, err)
+	f.Apply(&raft.Log{Type: raft.LogCommand, Data: opPayloadData})
+
+	opPayloadData, err = json.Marshal(CommandPayload{
+		Operation: OperationMessagePop,
+		ChannelID: "id1",
+	})
+	assert.NoError(t, err)
+	r := f.Apply(&raft.Log{Type: raft.LogCommand, Data: opPayloadData}).(*ApplyResponse)
+	assert.Equal(t, want, r.Data)
+}
+
+func Test_prioritizedFSM_ApplyLegacyMessage(t *testing.T) {
+	s := storage.NewPqStorage()
+	_, _ = s.AddChannel(channel.Channel{ID: "id1"})
+	f := prioritizedFSM{storage: s}
+
+	// command written before message bodies became binary
+	f.Apply(&raft.Log{Type: raft.LogCommand, Data: []byte(`{"Operation":0,"ChannelID":"id1","Message":{"AvailableAt":1000,"Body":"foo"}}`)})
+
+	chStorage, _ := s.GetChannelStorage("id1")
+	assert.Equal(t, message.NewMessage([]byte("foo"), 1000000), chStorage.Dequeue())
+}
+
+func Test_prioritizedFSM_ApplyLegacyTimestamp(t *testing.T) {
+	s := storage.NewPqStorage()
+	_, _ = s.AddChannel(channel.Channel{ID: "id1"})
+	f := prioritizedFSM{storage: s}
+	chStorage, _ := s.GetChannelStorage("id1")
+	chStorage.Enqueue(message.NewMessage([]byte("foo"), 1000500))
+
+	// command written before millisecond precision restricts pop to the second
+	r := f.Apply(&raft.Log{Type: raft.LogCommand, Data: []byte(`{"Operation":1,"ChannelID":"id1","Timestamp":1000}`)}).(*ApplyResponse)
+	assert.Equal(t, storage.ErrMessageNotFound, r.Err)
+	r = f.Apply(&raft.Log{Type: raft.LogCommand, Data: []byte(`{"Operation":1,"ChannelID":"id1","Timestamp":1001}`)}).(*ApplyResponse)
+	assert.NoError(t, r.Err)
+	assert.Equal(t, message.NewMessage([]byte("foo"), 1000500), r.Data)
+}
+
+func TestCommandPayload_JSON(t *testing.T) {
+	payload := CommandPayload{Operation: OperationMessagePop, ChannelID: "id1", Timestamp: 1000500}
+	data, err := json.Marshal(payload)
+	assert.NoError(t, err)
+	var got CommandPayload
+	assert.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, payload, got)
+}
+
+func Test_prioritizedFSM_RestoreLegacySnapshot(t *testing.T) {
+	f := prioritizedFSM{storage: storage.NewPqStorage()}
+
+	// snapshot written before message bodies became binary
+	var snapshot bytes.Buffer
+	snapshot.WriteByte(byte(ChannelsStruct))
+	snapshot.WriteString(`{"id":"id1","source":{"driver":"","config":null},"destination":{"driver":"","config":null}}` + "\n")
+	snapshot.WriteByte(byte(MessageStruct))
+	snapshot.WriteString(`{"ChannelID":"id1","Message":{"AvailableAt":1000,"Body":"foo"}}` + "\n")
+	snapshot.WriteByte(byte(MessageStruct))
+	snapshot.WriteString(`{"ChannelID":"id1","Message":{"AvailableAt":1200,"Body":"bar"}}` + "\n")
+
+	assert.NoError(t, f.Restore(ioutil.NopCloser(&snapshot)))
+	_, gotMessages := f.storage.Dump()
+	assert.Equal(t, []message.Message{
+		message.NewMessage([]byte("foo"), 1000000),
+		message.NewMessage([]byte("bar"), 1200000),
+	}, gotMessages["id1"])
+}
+
+func applyCommand(f prioritizedFSM, payload CommandPayload) *ApplyResponse {
+	opPayloadData, _ := json.Marshal(payload)
+	return f.Apply(&raft.Log{Type: raft.LogCommand, Data: opPayloadData}).(*ApplyResponse)
+}
+
+func Test_prioritizedFSM_ApplyCancel(t *testing.T) {
+	s := storage.NewPqStorage()
+	_, _ = s.AddChannel(channel.Channel{ID: "id1"})
+	f := prioritizedFSM{storage: s}
+
+	applyCommand(f, CommandPayload{Operation: OperationMessagePush, ChannelID: "id1", Message: message.NewMessage([]byte("foo"), 1000).WithID("msg1")})
+	applyCommand(f, CommandPayload{Operation: OperationMessagePush, ChannelID: "id1", Message: message.NewMessage([]byte("bar"), 1200).WithID("msg2")})
+
+	r := applyCommand(f, CommandPayload{Operation: OperationMessageCancel, ChannelID: "id1", MessageID: "msg1"})
+	assert.NoError(t, r.Err)
+	r = applyCommand(f, CommandPayload{Operation: OperationMessageCancel, ChannelID: "id1", MessageID: "msg1"})
+	assert.Equal(t, storage.ErrMessageNotFound, r.Err)
+	r = applyCommand(f, CommandPayload{Operation: OperationMessageCancel, ChannelID: "id2", MessageID: "msg2"})
+	assert.Equal(t, storage.ErrChannelNotFound, r.Err)
+
+	// message cancelled after the processor check isn't replaced by the one scheduled later
+	r = applyCommand(f, CommandPayload{Operation: OperationMessagePop, ChannelID: "id1", Timestamp: 1000})
+	assert.Equal(t, storage.ErrMessageNotFound, r.Err)
+	r = applyCommand(f, CommandPayload{Operation: OperationMessagePop, ChannelID: "id1", Timestamp: 1200})
+	assert.NoError(t, r.Err)
+	assert.Equal(t, message.NewMessage([]byte("bar"), 1200).WithID("msg2"), r.Data)
+	r = applyCommand(f, CommandPayload{Operation: OperationMessagePop, ChannelID: "id1"})
+	assert.Equal(t, storage.ErrMessageNotFound, r.Err)
+}
+
+func Test_prioritizedFSM_RestoreCancelled(t *testing.T) {
+	s := storage.NewPqStorage()
+	_, _ = s.AddChannel(channel.Channel{ID: "id1"})
+	f := prioritizedFSM{storage: s}
+
+	applyCommand(f, CommandPayload{Operation: OperationMessagePush, ChannelID: "id1", Message: message.NewMessage([]byte("foo"), 1000).WithID("msg1")})
+	applyCommand(f, CommandPayload{Operation: OperationMessagePush, ChannelID: "id1", Message: message.NewMessage([]byte("bar"), 1200).WithID("msg2")})
+	applyCommand(f, CommandPayload{Operation: OperationMessageCancel, ChannelID: "id1", MessageID: "msg1"})
+
+	snapshotStore := raft.NewInmemSnapshotStore()
+	_, transport := raft.NewInmemTransport("")
+	sink, err := snapshotStore.Create(raft.SnapshotVersionMax, 1, 1, raft.Configuration{}, 1, transport)
+	assert.NoError(t, err)
+	snapshot, err := f.Snapshot()
+	assert.NoError(t, err)
+	assert.NoError(t, snapshot.Persist(sink))
+
+	_, source, err := snapshotStore.Open(sink.ID())
+	assert.NoError(t, err)
+	assert.NoError(t, f.Restore(source))
+
+	_, gotMessages := f.storage.Dump()
+	assert.Equal(t, []message.Message{message.NewMessage([]byte("bar"), 1200).WithID("msg2")}, gotMessages["id1"])
+	_, err = f.storage.GetMessage("id1", "msg1")
+	assert.Equal(t, storage.ErrMessageNotFound, err)
+}
+
+func Test_prioritizedFSM_ApplyReschedule(t *testing.T) {
+	s := storage.NewPqStorage()
+	_, _ = s.AddChannel(channel.Channel{ID: "id1"})
+	f := prioritizedFSM{storage: s}
+
+	applyCommand(f, CommandPayload{Operation: OperationMessagePush, ChannelID: "id1", Message: message.NewMessage([]byte("foo"), 1000).WithID("msg1")})
+	applyCommand(f, CommandPayload{Operation: OperationMessagePush, ChannelID: "id1", Message: message.NewMessage([]byte("bar"), 1200).WithID("msg2")})
+
+	r := applyCommand(f, CommandPayload{Operation: OperationMessageReschedule, ChannelID: "id1", MessageID: "msg1", Timestamp: 1300})
+	assert.NoError(t, r.Err)
+	assert.Equal(t, message.NewMessage([]byte("foo"), 1300).WithID("msg1"), r.Data)
+	r = applyCommand(f, CommandPayload{Operation: OperationMessageReschedule, ChannelID: "id1", MessageID: "msg3", Timestamp: 1300})
+	assert.Equal(t, storage.ErrMessageNotFound, r.Err)
+
+	_, gotMessages := f.storage.Dump()
+	assert.Equal(t, []message.Message{
+		message.NewMessage([]byte("bar"), 1200).WithID("msg2"),
+		message.NewMessage([]byte("foo"), 1300).WithID("msg1"),
+	}, gotMessages["id1"])
+}
+
+func Test_prioritizedFSM_ApplyDuplicate(t *testing.T) {
+	tests := []struct {
+		name          string
+		duplicateMode string
+		want          []message.Message
+	}{
+		{
+			name:          "Check message with the same ID is scheduled once again",
+			duplicateMode: "",
+			want: []message.Message{
+				message.NewMessage([]byte("foo"), 1000).WithID("msg1"),
+				message.NewMessage([]byte("bar"), 1200).WithID("msg1"),
+			},
+		},
+		{
+			name:          "Check message with the same ID replaces the scheduled one",
+			duplicateMode: channel.DuplicateModeReplace,
+			want: []message.Message{
+				message.NewMessage([]byte("bar"), 1200).WithID("msg1"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := storage.NewPqStorage()
+			_, _ = s.AddChannel(channel.Channel{ID: "id1", Source: channel.Source{DuplicateMode: tt.duplicateMode}})
+			f := prioritizedFSM{storage: s}
+
+			applyCommand(f, CommandPayload{Operation: OperationMessagePush, ChannelID: "id1", Message: message.NewMessage([]byte("foo"), 1000).WithID("msg1")})
+			applyCommand(f, CommandPayload{Operation: OperationMessagePush, ChannelID: "id1", Message: message.NewMessage([]byte("bar"), 1200).WithID("msg1")})
+
+			_, gotMessages := f.storage.Dump()
+			assert.Equal(t, tt.want, gotMessages["id1"])
+		})
+	}
+}
+
+func Test_prioritizedFSM_ApplyDeduplication(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  channel.Source
+		push    []CommandPayload
+		want    []message.Message
+		wantErr []error
+	}{
+		{
+			name:   "Check messages aren't deduplicated by default",
+			source: channel.Source{},
+			push: []CommandPayload{
+				{Message: message.NewMessage([]byte("foo"), 1000).WithID("msg1"), Timestamp: 100000},
+				{Message: message.NewMessage([]byte("foo"), 1000).WithID("msg1"), Timestamp: 110000},
+			},
+			want: []message.Message{
+				message.NewMessage([]byte("foo"), 1000).WithID("msg1"),
+				message.NewMessage([]byte("foo"), 1000).WithID("msg1"),
+			},
+			wantErr: []error{nil, nil},
+		},
+		{
+			name:   "Check messages are deduplicated by ID within the window",
+			source: channel.Source{DeduplicationWindow: 60},
+			push: []CommandPayload{
+				{Message: message.NewMessage([]byte("foo"), 1000).WithID("msg1"), Timestamp: 100000},
+				{Message: message.NewMessage([]byte("foo"), 1000).WithID("msg1"), Timestamp: 159000},
+				{Message: message.NewMessage([]byte("bar"), 1100).WithID("msg2"), Timestamp: 159000},
+				{Message: message.NewMessage([]byte("foo"), 1200).WithID("msg1"), Timestamp: 160000},
+			},
+			want: []message.Message{
+				message.NewMessage([]byte("foo"), 1000).WithID("msg1"),
+				message.NewMessage([]byte("bar"), 1100).WithID("msg2"),
+				message.NewMessage([]byte("foo"), 1200).WithID("msg1"),
+			},
+			wantErr: []error{nil, storage.ErrMessageDuplicated, nil, nil},
+		},
+		{
+			name:   "Check messages are deduplicated by attribute",
+			source: channel.Source{DeduplicationWindow: 60, DeduplicationAttribute: "key"},
+			push: []CommandPayload{
+				{Message: message.NewMessageWithAttributes([]byte("foo"), 1000, map[string]string{"key": "k1"}).WithID("msg1"), Timestamp: 100000},
+				{Message: message.NewMessageWithAttributes([]byte("foo"), 1000, map[string]string{"key": "k1"}).WithID("msg2"), Timestamp: 110000},
+				{Message: message.NewMessage([]byte("bar"), 1100).WithID("msg3"), Timestamp: 110000},
+				{Message: message.NewMessage([]byte("bar"), 1100).WithID("msg3"), Timestamp: 120000},
+			},
+			want: []message.Message{
+				message.NewMessageWithAttributes([]byte("foo"), 1000, map[string]string{"key": "k1"}).WithID("msg1"),
+				message.NewMessage([]byte("bar"), 1100).WithID("msg3"),
+				message.NewMessage([]byte("bar"), 1100).WithID("msg3"),
+			},
+			wantErr: []error{nil, storage.ErrMessageDuplicated, nil, nil},
+		},
+		{
+			name:   "Check legacy commands without timestamp aren't deduplicated",
+			source: channel.Source{DeduplicationWindow: 60},
+			push: []CommandPayload{
+				{Message: message.NewMessage([]byte("foo"), 1000).WithID("msg1")},
+				{Message: message.NewMessage([]byte("foo"), 1000).WithID("msg1")},
+			},
+			want: []message.Message{
+				message.NewMessage([]byte("foo"), 1000).WithID("msg1"),
+				message.NewMessage([]byte("foo"), 1000).WithID("msg1"),
+			},
+			wantErr: []error{nil, nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := storage.NewPqStorage()
+			_, _ = s.AddChannel(channel.Channel{ID: "id1", Source: tt.source})
+			f := prioritizedFSM{storage: s}
+
+			for i, payload := range tt.push {
+				payload.Operation, payload.ChannelID = OperationMessagePush, "id1"
+				assert.Equal(t, tt.wantErr[i], applyCommand(f, payload).Err)
+			}
+
+			_, gotMessages := f.storage.Dump()
+			assert.Equal(t, tt.want, gotMessages["id1"])
+		})
+	}
+}
+
+func Test_prioritizedFSM_RestoreDeduplication(t *testing.T) {
+	s := storage.NewPqStorage()
+	_, _ = s.AddChannel(channel.Channel{ID: "id1", Source: channel.Source{DeduplicationWindow: 60}})
+	f := prioritizedFSM{storage: s}
+
+	applyCommand(f, CommandPayload{Operation: OperationMessagePush, ChannelID: "id1", Message: message.NewMessage([]byte("foo"), 1000).WithID("msg1"), Timestamp: 100000})
+	applyCommand(f, CommandPayload{Operation: OperationMessagePop, ChannelID: "id1"})
+
+	snapshotStore := raft.NewInmemSnapshotStore()
+	_, transport := raft.NewInmemTransport("")
+	sink, err := snapshotStore.Create(raft.SnapshotVersionMax, 1, 1, raft.Configuration{}, 1, transport)
+	assert.NoError(t, err)
+	snapshot, err := f.Snapshot()
+	assert.NoError(t, err)
+	assert.NoError(t, snapshot.Persist(sink))
+
+	_, source, err := snapshotStore.Open(sink.ID())
+	assert.NoError(t, err)
+	assert.NoError(t, f.Restore(source))
+
+	assert.Equal(t, map[string][]storage.DeduplicationEntry{"id1": {{Key: "msg1", SeenAt: 100000}}}, f.storage.DumpDeduplication())
+	// released message is still deduplicated after the restore
+	r := applyCommand(f, CommandPayload{Operation: OperationMessagePush, ChannelID: "id1", Message: message.NewMessage([]byte("foo"), 1000).WithID("msg1"), Timestamp: 150000})
+	assert.Equal(t, storage.ErrMessageDuplicated, r.Err)
+}
+
+func Test_prioritizedFSM_ApplySchedule(t *testing.T) {
+	s := storage.NewPqStorage()
+	_, _ = s.AddChannel(channel.Channel{ID: "id1"})
+	f := prioritizedFSM{storage: s}
+	sch := schedule.Schedule{ID: "sch1", Cron: "*/5 * * * *", Body: []byte("foo")}
+
+	r := applyCommand(f, CommandPayload{Operation: OperationScheduleSave, ChannelID: "id1", Schedule: &sch, Timestamp: 1000000})
+	assert.NoError(t, r.Err)
+	assert.Equal(t, 1200000, r.Data.(schedule.Schedule).NextAt)
+
+	// occurrence is released and the next one is scheduled by the same command
+	r = applyCommand(f, CommandPayload{Operation: OperationMessagePop, ChannelID: "id1", Timestamp: 1200000})
+	assert.Equal(t, sch.Occurrence(1200000), r.Data)
+	_, gotMessages := f.storage.Dump()
+	assert.Equal(t, []message.Message{sch.Occurrence(1500000)}, gotMessages["id1"])
+
+	r = applyCommand(f, CommandPayload{Operation: OperationScheduleDelete, ChannelID: "id1", Schedule: &schedule.Schedule{ID: "sch1"}})
+	assert.NoError(t, r.Err)
+	_, gotMessages = f.storage.Dump()
+	assert.Empty(t, gotMessages["id1"])
+	r = applyCommand(f, CommandPayload{Operation: OperationScheduleDelete, ChannelID: "id1", Schedule: &schedule.Schedule{ID: "sch1"}})
+	assert.Equal(t, storage.ErrScheduleNotFound, r.Err)
+}
+
+func Test_prioritizedFSM_RestoreSchedules(t *testing.T) {
+	s := storage.NewPqStorage()
+	_, _ = s.AddChannel(channel.Channel{ID: "id1"})
+	f := prioritizedFSM{storage: s}
+	sch := schedule.Schedule{ID: "sch1", Cron: "*/5 * * * *", Body: []byte("foo")}
+	applyCommand(f, CommandPayload{Operation: OperationScheduleSave, ChannelID: "id1", Schedule: &sch, Timestamp: 1000000})
+
+	snapshotStore := raft.NewInmemSnapshotStore()
+	_, transport := raft.NewInmemTransport("")
+	sink, err := snapshotStore.Create(raft.SnapshotVersionMax, 1, 1, raft.Configuration{}, 1, transport)
+	assert.NoError(t, err)
+	snapshot, err := f.Snapshot()
+	assert.NoError(t, err)
+	assert.NoError(t, snapshot.Persist(sink))
+
+	_, source, err := snapshotStore.Open(sink.ID())
+	assert.NoError(t, err)
+	assert.NoError(t, f.Restore(source))
+
+	sch.NextAt = 1200000
+	assert.Equal(t, map[string][]schedule.Schedule{"id1": {sch}}, f.storage.DumpSchedules())
+	// restored occurrence keeps the schedule going
+	r := applyCommand(f, CommandPayload{Operation: OperationMessagePop, ChannelID: "id1", Timestamp: 1200000})
+	assert.Equal(t, sch.Occurrence(1200000), r.Data)
+	_, gotMessages := f.storage.Dump()
+	assert.Equal(t, []message.Message{sch.Occurrence(1500000)}, gotMessages["id1"])
+}
+
+func Test_prioritizedFSM_ApplyPushBatch(t *testing.T) {
+	s := storage.NewPqStorage()
+	_, _ = s.AddChannel(channel.Channel{ID: "id1", Source: channel.Source{DeduplicationWindow: 60}})
+	_, _ = s.AddChannel(channel.Channel{ID: "id2"})
+	f := prioritizedFSM{storage: s}
+
+	r := applyCommand(f, CommandPayload{Operation: OperationMessagePushBatch, Timestamp: 1000000, Messages: []ChannelMessage{
+		{ChannelID: "id1", Message: message.NewMessage([]byte("foo"), 1000).WithID("msg1")},
+		{ChannelID: "id2", Message: message.NewMessage([]byte("bar"), 1200).WithID("msg2")},
+		// duplicate fails on its own
+		{ChannelID: "id1", Message: message.NewMessage([]byte("foo"), 1000).WithID("msg1")},
+	}})
+	assert.NoError(t, r.Err)
+	assert.Equal(t, []ApplyResponse{
+		{Data: message.NewMessage([]byte("foo"), 1000).WithID("msg1")},
+		{Data: message.NewMessage([]byte("bar"), 1200).WithID("msg2")},
+		{Data: message.NewMessage([]byte("foo"), 1000).WithID("msg1"), Err: storage.ErrMessageDuplicated},
+	}, r.Data)
+
+	_, gotMessages := s.Dump()
+	assert.Equal(t, []message.Message{message.NewMessage([]byte("foo"), 1000).WithID("msg1")}, gotMessages["id1"])
+	assert.Equal(t, []message.Message{message.NewMessage([]byte("bar"), 1200).WithID("msg2")}, gotMessages["id2"])
+}
+
+func Test_prioritizedFSM_ApplyPopBatch(t *testing.T) {
+	s := storage.NewPqStorage()
+	_, _ = s.AddChannel(channel.Channel{ID: "id1"})
+	f := prioritizedFSM{storage: s}
+	chStorage, _ := s.GetChannelStorage("id1")
+	for _, availableAt := range []int{1000, 1100, 1200, 1300, 2000} {
+		chStorage.Enqueue(message.NewMessage([]byte("foo"), availableAt))
+	}
+
+	// pop is restricted by the limit
+	r := applyCommand(f, CommandPayload{Operation: OperationMessagePopBatch, ChannelID: "id1", Timestamp: 1500, Limit: 2})
+	assert.NoError(t, r.Err)
+	assert.Equal(t, []message.Message{
+		message.NewMessage([]byte("foo"), 1000),
+		message.NewMessage([]byte("foo"), 1100),
+	}, r.Data)
+
+	// pop is restricted by the timestamp
+	r = applyCommand(f, CommandPayload{Operation: OperationMessagePopBatch, ChannelID: "id1", Timestamp: 1500, Limit: 10})
+	assert.NoError(t, r.Err)
+	assert.Equal(t, []message.Message{
+		message.NewMessage([]byte("foo"), 1200),
+		message.NewMessage([]byte("foo"), 1300),
+	}, r.Data)
+
+	r = applyCommand(f, CommandPayload{Operation: OperationMessagePopBatch, ChannelID: "id1", Timestamp: 1500, Limit: 10})
+	assert.Equal(t, storage.ErrMessageNotFound, r.Err)
+	assert.Equal(t, []message.Message{message.NewMessage([]byte("foo"), 2000)}, chStorage.Dump())
+}
+
+func Test_prioritizedFSM_ApplyPopBatchSchedule(t *testing.T) {
+	s := storage.NewPqStorage()
+	_, _ = s.AddChannel(channel.Channel{ID: "id1"})
+	f := prioritizedFSM{storage: s}
+	sch := schedule.Schedule{ID: "sch1", Cron: "@hourly", Body: []byte("foo")}
+	assert.NoError(t, applyCommand(f, CommandPayload{Operation: OperationScheduleSave, ChannelID: "id1", Schedule: &sch, Timestamp: 0}).Err)
+
+	// next occurrence is due within the batch as well
+	r := applyCommand(f, CommandPayload{Operation: OperationMessagePopBatch, ChannelID: "id1", Timestamp: 3600000, Limit: 10})
+	assert.NoError(t, r.Err)
+	assert.Len(t, r.Data, 1)
+	saved, _ := s.GetSchedule("id1", "sch1")
+	assert.Equal(t, 7200000, saved.NextAt)
+}
+
+func Test_prioritizedFSM_ApplyLease(t *testing.T) {
+	s := storage.NewPqStorage()
+	_, _ = s.AddChannel(channel.Channel{ID: "id1"})
+	f := prioritizedFSM{storage: s}
+	chStorage, _ := s.GetChannelStorage("id1")
+	for _, availableAt := range []int{1000, 1100, 1200} {
+		chStorage.Enqueue(message.NewMessage([]byte("foo"), availableAt))
+	}
+	apply := func(index uint64, payload CommandPayload) *ApplyResponse {
+		data, _ := json.Marshal(payload)
+		return f.Apply(&raft.Log{Index: index, Type: raft.LogCommand, Data: data}).(*ApplyResponse)
+	}
+
+	// popped messages are kept in flight
+	r := apply(10, CommandPayload{Operation: OperationMessagePop, ChannelID: "id1", Timestamp: 1500, LeaseTimeout: 1000})
+	assert.NoError(t, r.Err)
+	assert.Equal(t, storage.Lease{ID: "10-0", Message: message.NewMessage([]byte("foo"), 1000), ExpiresAt: 2500}, r.Data)
+	r = apply(11, CommandPayload{Operation: OperationMessagePopBatch, ChannelID: "id1", Timestamp: 1600, LeaseTimeout: 1000, Limit: 10})
+	assert.NoError(t, r.Err)
+	assert.Equal(t, []storage.Lease{
+		{ID: "11-0", Message: message.NewMessage([]byte("foo"), 1100), ExpiresAt: 2600},
+		{ID: "11-1", Message: message.NewMessage([]byte("foo"), 1200), ExpiresAt: 2600},
+	}, r.Data)
+	assert.True(t, chStorage.IsEmpty())
+
+	// acknowledged messages are dropped
+	r = apply(12, CommandPayload{Operation: OperationMessageAck, Leases: []ChannelLease{
+		{ChannelID: "id1", Lease: storage.Lease{ID: "11-0"}},
+		{ChannelID: "id1", Lease: storage.Lease{ID: "unknown"}},
+		{ChannelID: "id2", Lease: storage.Lease{ID: "11-1"}},
+	}})
+	assert.Equal(t, 1, r.Data)
+
+	// expired leases are renewed
+	r = apply(13, CommandPayload{Operation: OperationLeaseRenew, ChannelID: "id1", Timestamp: 2500, LeaseTimeout: 1000, Limit: 10})
+	assert.NoError(t, r.Err)
+	assert.Equal(t, []storage.Lease{{ID: "10-0", Message: message.NewMessage([]byte("foo"), 1000), ExpiresAt: 3500}}, r.Data)
+	r = apply(14, CommandPayload{Operation: OperationLeaseRenew, ChannelID: "id1", Timestamp: 2500, LeaseTimeout: 1000, Limit: 10})
+	assert.Equal(t, storage.ErrMessageNotFound, r.Err)
+
+	// released lease is renewed right away
+	r = apply(15, CommandPayload{Operation: OperationLeaseRelease, Timestamp: 2550, Leases: []ChannelLease{
+		{ChannelID: "id1", Lease: storage.Lease{ID: "10-0"}},
+		{ChannelID: "id1", Lease: storage.Lease{ID: "unknown"}},
+	}})
+	assert.Equal(t, 1, r.Data)
+	r = apply(16, CommandPayload{Operation: OperationLeaseRenew, ChannelID: "id1", Timestamp: 2550, LeaseTimeout: 950, Limit: 1})
+	assert.NoError(t, r.Err)
+	assert.Equal(t, []storage.Lease{{ID: "10-0", Message: message.NewMessage([]byte("foo"), 1000), ExpiresAt: 3500}}, r.Data)
+
+	assert.Equal(t, []storage.Lease{
+		{ID: "11-1", Message: message.NewMessage([]byte("foo"), 1200), ExpiresAt: 2600},
+		{ID: "10-0", Message: message.NewMessage([]byte("foo"), 1000), ExpiresAt: 3500},
+	}, chStorage.DumpLeases())
+}
+
+func Test_prioritizedFSM_RestoreLeases(t *testing.T) {
+	s := storage.NewPqStorage()
+	_, _ = s.AddChannel(channel.Channel{ID: "id1"})
+	f := prioritizedFSM{storage: s}
+	chStorage, _ := s.GetChannelStorage("id1")
+	lease := storage.Lease{ID: "10-0", Message: message.NewMessage([]byte("foo"), 1000).WithID("msg1"), ExpiresAt: 2500}
+	chStorage.AddLease(lease)
+
+	snapshotStore := raft.NewInmemSnapshotStore()
+	_, transport := raft.NewInmemTransport("")
+	sink, err := snapshotStore.Create(raft.SnapshotVersionMax, 1, 1, raft.Configuration{}, 1, transport)
+	assert.NoError(t, err)
+	snapshot, err := f.Snapshot()
+	assert.NoError(t, err)
+	assert.NoError(t, snapshot.Persist(sink))
+
+	_, source, err := snapshotStore.Open(sink.ID())
+	assert.NoError(t, err)
+	assert.NoError(t, f.Restore(source))
+
+	// message in flight is still dispatched by the new leader
+	assert.Equal(t, map[string][]storage.Lease{"id1": {lease}}, f.storage.DumpLeases())
+}
+
+func Test_prioritizedFSM_ApplyDeadLetter(t *testing.T) {
+	s := storage.NewPqStorage()
+	_, _ = s.AddChannel(channel.Channel{ID: "id1"})
+	f := prioritizedFSM{storage: s}
+	chStorage, _ := s.GetChannelStorage("id1")
+	msg := message.NewMessage([]byte("foo"), 1000).WithID("msg1")
+	chStorage.AddLease(storage.Lease{ID: "10-0", Message: msg, ExpiresAt: 2500})
+	apply := func(index uint64, payload CommandPayload) *ApplyResponse {
+		data, _ := json.Marshal(payload)
+		return f.Apply(&raft.Log{Index: index, Type: raft.LogCommand, Data: data}).(*ApplyResponse)
+	}
+
+	// failed delivery is moved to the dead letters and acknowledged
+	r := apply(11, CommandPayload{
+		Operation: OperationDeadLetterAdd,
+		ChannelID: "id1",
+		Message:   msg,
+		Reason:    "destination is unavailable",
+		Timestamp: 2000,
+		Leases:    []ChannelLease{{ChannelID: "id1", Lease: storage.Lease{ID: "10-0"}}},
+	})
+	assert.NoError(t, r.Err)
+	deadLetter := storage.DeadLetter{
+		ID:       "11",
+		Message:  msg.WithAttribute(message.AttributeFailureReason, "destination is unavailable"),
+		Reason:   "destination is unavailable",
+		FailedAt: 2000,
+	}
+	assert.Equal(t, deadLetter, r.Data)
+	assert.Empty(t, chStorage.DumpLeases())
+	assert.Equal(t, []storage.DeadLetter{deadLetter}, chStorage.GetDeadLetters())
+	r = apply(12, CommandPayload{Operation: OperationDeadLetterAdd, ChannelID: "id2", Message: msg})
+	assert.Equal(t, storage.ErrChannelNotFound, r.Err)
+
+	// replayed message is scheduled once again
+	r = apply(13, CommandPayload{Operation: OperationDeadLetterReplay, ChannelID: "id1", DeadLetterIDs: []string{"12"}, Timestamp: 3000})
+	assert.Equal(t, storage.ErrDeadLetterNotFound, r.Err)
+	r = apply(14, CommandPayload{Operation: OperationDeadLetterReplay, ChannelID: "id1", DeadLetterIDs: []string{"11"}, Timestamp: 3000})
+	assert.NoError(t, r.Err)
+	replayed := message.NewMessage([]byte("foo"), 3000).WithID("msg1")
+	assert.Equal(t, []message.Message{replayed}, r.Data)
+	assert.Equal(t, []message.Message{replayed}, chStorage.Dump())
+	assert.Empty(t, chStorage.GetDeadLetters())
+}
+
+func Test_prioritizedFSM_RestoreDeadLetters(t *testing.T) {
+	s := storage.NewPqStorage()
+	_, _ = s.AddChannel(channel.Channel{ID: "id1"})
+	f := prioritizedFSM{storage: s}
+	chStorage, _ := s.GetChannelStorage("id1")
+	deadLetter := storage.DeadLetter{ID: "11", Message: message.NewMessage([]byte("foo"), 0), Reason: "invalid available_at", FailedAt: 2000}
+	chStorage.AddDeadLetter(deadLetter)
+
+	snapshotStore := raft.NewInmemSnapshotStore()
+	_, transport := raft.NewInmemTransport("")
+	sink, err := snapshotStore.Create(raft.SnapshotVersionMax, 1, 1, raft.Configuration{}, 1, transport)
+	assert.NoError(t, err)
+	snapshot, err := f.Snapshot()
+	assert.NoError(t, err)
+	assert.NoError(t, snapshot.Persist(sink))
+
+	_, source, err := snapshotStore.Open(sink.ID())
+	assert.NoError(t, err)
+	assert.NoError(t, f.Restore(source))
+
+	assert.Equal(t, map[string][]storage.DeadLetter{"id1": {deadLetter}}, f.storage.DumpDeadLetters())
+}
+
+func Test_prioritizedFSM_ApplyDeadLetterDestination(t *testing.T) {
+	f := prioritizedFSM{storage: storage.NewPqStorage()}
+	webhookCfg := webhookpublisherconfig.DestinationConfig{URL: "http://localhost/failed", Method: "PUT", TimeoutMs: 500}
+	kafkaCfg := kafkapublisherconfig.DestinationConfig{Brokers: []string{"localhost:9092"}, Topic: "dead"}
+	data, _ := json.Marshal(CommandPayload{
+		Operation: OperationChannelCreate,
+		Channel: channel.Channel{
+			ID:          "id1",
+			Destination: channel.Destination{Driver: "webhook", Config: webhookCfg},
+			DeadLetter:  &channel.Destination{Driver: "kafka", Config: kafkaCfg},
+		},
+	})
+	r := f.Apply(&raft.Log{Index: 1, Type: raft.LogCommand, Data: data}).(*ApplyResponse)
+	assert.NoError(t, r.Err)
+
+	// dead letter destination config is decoded as the destination one
+	c, err := f.storage.GetChannel("id1")
+	assert.NoError(t, err)
+	assert.Equal(t, webhookCfg, c.Destination.Config)
+	if assert.NotNil(t, c.DeadLetter) {
+		assert.Equal(t, kafkaCfg, c.DeadLetter.Config)
+	}
+
+	// restored channel keeps decoded configs
+	snapshotStore := raft.NewInmemSnapshotStore()
+	_, transport := raft.NewInmemTransport("")
+	sink, err := snapshotStore.Create(raft.SnapshotVersionMax, 1, 1, raft.Configuration{}, 1, transport)
+	assert.NoError(t, err)
+	snapshot, err := f.Snapshot()
+	assert.NoError(t, err)
+	assert.NoError(t, snapshot.Persist(sink))
+	_, source, err := snapshotStore.Open(sink.ID())
+	assert.NoError(t, err)
+	assert.NoError(t, f.Restore(source))
+
+	c, err = f.storage.GetChannel("id1")
+	assert.NoError(t, err)
+	assert.Equal(t, webhookCfg, c.Destination.Config)
+	if assert.NotNil(t, c.DeadLetter) {
+		assert.Equal(t, kafkaCfg, c.DeadLetter.Config)
+	}
+}
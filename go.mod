@@ -7,12 +7,13 @@ require (
 	cloud.google.com/go/pubsub v1.10.0
 	github.com/BBVA/raft-badger v1.1.0
 	github.com/DataDog/zstd v1.4.8 // indirect
+	github.com/alicebob/miniredis/v2 v2.16.0
 	github.com/armon/go-metrics v0.3.6 // indirect
 	github.com/caarlos0/env/v6 v6.5.0
 	github.com/dgryski/go-farm v0.0.0-20200201041132-a6ae2369ad13 // indirect
-	github.com/enriquebris/goconcurrentqueue v0.6.0
 	github.com/fatih/color v1.10.0 // indirect
 	github.com/go-playground/validator/v10 v10.4.1
+	github.com/go-redis/redis/v8 v8.8.0
 	github.com/golang/snappy v0.0.2 // indirect
 	github.com/hashicorp/go-hclog v0.15.0 // indirect
 	github.com/hashicorp/go-immutable-radix v1.3.0 // indirect
@@ -24,13 +25,15 @@ require (
 	github.com/magefile/mage v1.11.0 // indirect
 	github.com/maksimru/go-hpds v1.10.0
 	github.com/mitchellh/mapstructure v1.4.1
-	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
-	github.com/modern-go/reflect2 v1.0.1 // indirect
+	github.com/nats-io/nats-server/v2 v2.2.0
+	github.com/nats-io/nats.go v1.11.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/satori/go.uuid v1.2.0
+	github.com/segmentio/kafka-go v0.4.10
 	github.com/sirupsen/logrus v1.8.0
+	github.com/streadway/amqp v1.0.0
 	github.com/stretchr/testify v1.7.0
 	go.opencensus.io v0.22.6 // indirect
-	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad // indirect
 	golang.org/x/oauth2 v0.0.0-20210216194517-16ff1888fd2e // indirect
 	golang.org/x/sync v0.0.0-20201207232520-09787c993a3a
 	golang.org/x/sys v0.0.0-20210218085108-9555bcde0c6a // indirect
@@ -0,0 +1,212 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/listener"
+	amqpconfig "github.com/maksimru/event-scheduler/listener/amqp/config"
+	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/prioritizer"
+	"github.com/maksimru/event-scheduler/storage"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"io"
+	"strconv"
+	"time"
+)
+
+const defaultPrefetchCount = 10
+
+var ErrDeliveriesClosed = errors.New("amqp deliveries channel is closed")
+
+// Channel is the subset of amqp.Channel used by the listener
+type Channel interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Close() error
+}
+
+type Listener struct {
+	config      amqpconfig.SourceConfig
+	connection  io.Closer
+	amqpChannel Channel
+	context     context.Context
+	stopFunc    context.CancelFunc
+	prioritizer *prioritizer.Prioritizer
+	channel     channel.Channel
+}
+
+func (l *Listener) Boot(ctx context.Context, channel channel.Channel, prioritizer *prioritizer.Prioritizer) error {
+	l.context, l.config = ctx, channel.Source.Config.(amqpconfig.SourceConfig)
+	l.prioritizer, l.channel = prioritizer, channel
+	if l.config.URL == "" || l.config.Queue == "" {
+		err := errors.New("url and queue are required")
+		log.Error("listener client boot failure: ", err.Error())
+		return err
+	}
+	return nil
+}
+
+func (l *Listener) SetAmqpChannel(amqpChannel Channel) {
+	l.amqpChannel = amqpChannel
+}
+
+// connect opens the broker connection lazily, so a broker outage doesn't break the channel boot
+func (l *Listener) connect() error {
+	if l.amqpChannel != nil {
+		return nil
+	}
+	connection, err := amqp.Dial(l.config.URL)
+	if err != nil {
+		log.Error("listener client connection failure: ", err.Error())
+		return err
+	}
+	amqpChannel, err := connection.Channel()
+	if err != nil {
+		_ = connection.Close()
+		log.Error("listener client channel failure: ", err.Error())
+		return err
+	}
+	l.connection, l.amqpChannel = connection, amqpChannel
+	return nil
+}
+
+func (l *Listener) Stop() error {
+	if l.stopFunc != nil {
+		log.Info("listener stop called")
+		l.stopFunc()
+	}
+	return nil
+}
+
+func (l *Listener) Listen() error {
+	if err := l.connect(); err != nil {
+		return err
+	}
+	defer func() {
+		err := l.amqpChannel.Close()
+		if err != nil {
+			log.Error("listener client termination failure: ", err.Error())
+		}
+		if l.connection != nil {
+			_ = l.connection.Close()
+		}
+	}()
+
+	// Dedicated context
+	amqpContext, cancelListener := context.WithCancel(l.context)
+	defer cancelListener()
+	l.stopFunc = cancelListener
+
+	prefetchCount := l.config.PrefetchCount
+	if prefetchCount == 0 {
+		prefetchCount = defaultPrefetchCount
+	}
+	if err := l.amqpChannel.Qos(prefetchCount, 0, false); err != nil {
+		log.Error("listener qos setup exception: ", err.Error())
+		return err
+	}
+
+	deliveries, err := l.amqpChannel.Consume(l.config.Queue, "", false, false, false, false, nil)
+	if err != nil {
+		log.Error("listener message receive exception: ", err.Error())
+		return err
+	}
+
+listenerloop:
+	for {
+		select {
+		case <-amqpContext.Done():
+			break listenerloop
+		case d, ok := <-deliveries:
+			if !ok {
+				log.Error("listener message receive exception: ", ErrDeliveriesClosed.Error())
+				return ErrDeliveriesClosed
+			}
+			l.handle(d)
+		}
+	}
+
+	log.Info("listener stopped")
+
+	return nil
+}
+
+func (l *Listener) handle(d amqp.Delivery) {
+	log.Trace("listener message received: ", string(d.Body))
+	if cancelID, has := d.Headers[message.AttributeCancelID]; has {
+		err := l.prioritizer.Cancel(fmt.Sprint(cancelID), l.channel)
+		if err != nil && err != storage.ErrMessageNotFound {
+			log.Warn("listener is unable to cancel scheduled message")
+			// return the message to the queue for strong consistency
+			if err := d.Nack(false, true); err != nil {
+				log.Error("listener message nack exception: ", err.Error())
+			}
+			return
+		}
+	} else if availableAt, has := d.Headers["available_at"]; has {
+		priority, err := parseAvailableAt(availableAt)
+		if err != nil {
+			log.Error("listener unable to read available_at header: ", err.Error())
+			err = listener.PersistInvalidSchedule(l.prioritizer, l.channel, message.NewMessageWithAttributes(d.Body, 0, getAttributes(d.Headers)).WithID(d.MessageId), err)
+		} else {
+			err = l.prioritizer.Persist(message.NewMessageWithAttributes(d.Body, priority, getAttributes(d.Headers)).WithID(d.MessageId), l.channel)
+		}
+		if err != nil {
+			log.Warn("listener is unable to persist received message")
+			// return the message to the queue for strong consistency
+			if err := d.Nack(false, true); err != nil {
+				log.Error("listener message nack exception: ", err.Error())
+			}
+			return
+		}
+	}
+	if err := d.Ack(false); err != nil {
+		log.Error("listener message ack exception: ", err.Error())
+	}
+}
+
+// getAttributes converts header values of basic types to strings, nested tables and arrays are skipped
+func getAttributes(headers amqp.Table) map[string]string {
+	attributes := make(map[string]string, len(headers))
+	for k, v := range headers {
+		switch value := v.(type) {
+		case string:
+			attributes[k] = value
+		case []byte:
+			attributes[k] = string(value)
+		case bool, int, int8, int16, int32, int64, float32, float64, amqp.Decimal, time.Time:
+			attributes[k] = fmt.Sprint(value)
+		}
+	}
+	return attributes
+}
+
+// headers keep their AMQP field types, publishers may send the timestamp either as a number or as a string,
+// numbers are epoch seconds and the result is in milliseconds
+func parseAvailableAt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case string:
+		return message.ParseAvailableAt(v)
+	case []byte:
+		return message.ParseAvailableAt(string(v))
+	case int:
+		return v * 1000, nil
+	case int8:
+		return int(v) * 1000, nil
+	case int16:
+		return int(v) * 1000, nil
+	case int32:
+		return int(v) * 1000, nil
+	case int64:
+		return int(v) * 1000, nil
+	case float32:
+		return message.ParseAvailableAt(strconv.FormatFloat(float64(v), 'f', -1, 32))
+	case float64:
+		return message.ParseAvailableAt(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return 0, fmt.Errorf("unsupported available_at header type %T", value)
+	}
+}
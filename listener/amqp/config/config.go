@@ -0,0 +1,7 @@
+package amqpconfig
+
+type SourceConfig struct {
+	URL           string `json:"url" xml:"url" mapstructure:"url" validate:"required"`
+	Queue         string `json:"queue" xml:"queue" mapstructure:"queue" validate:"required"`
+	PrefetchCount int    `json:"prefetch_count" xml:"prefetch_count" mapstructure:"prefetch_count" validate:"min=0"`
+}
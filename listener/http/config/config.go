@@ -0,0 +1,4 @@
+package httpconfig
+
+type SourceConfig struct {
+}
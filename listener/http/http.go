@@ -0,0 +1,36 @@
+package http
+
+import (
+	"context"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/prioritizer"
+	log "github.com/sirupsen/logrus"
+)
+
+// Listener of the http driver is passive, messages are pushed through the message manager API
+type Listener struct {
+	context  context.Context
+	stopFunc context.CancelFunc
+}
+
+func (l *Listener) Boot(ctx context.Context, _ channel.Channel, _ *prioritizer.Prioritizer) error {
+	l.context = ctx
+	return nil
+}
+
+func (l *Listener) Listen() error {
+	ctx, cancelListener := context.WithCancel(l.context)
+	defer cancelListener()
+	l.stopFunc = cancelListener
+	<-ctx.Done()
+	log.Info("listener stopped")
+	return nil
+}
+
+func (l *Listener) Stop() error {
+	if l.stopFunc != nil {
+		log.Info("listener stop called")
+		l.stopFunc()
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package http
+
+import (
+	"context"
+	"github.com/go-playground/validator/v10"
+	"github.com/hashicorp/raft"
+	"github.com/labstack/echo/v4"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/fsm"
+	"github.com/maksimru/event-scheduler/httpvalidator"
+	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/messagemanager"
+	"github.com/maksimru/event-scheduler/prioritizer"
+	"github.com/maksimru/event-scheduler/storage"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListenerHttp_Boot(t *testing.T) {
+	l := &Listener{}
+	err := l.Boot(context.Background(), channel.Channel{Source: channel.Source{Driver: "http"}}, new(prioritizer.Prioritizer))
+	assert.NoError(t, err)
+}
+
+func TestListenerHttp_StopBeforeListen(t *testing.T) {
+	l := &Listener{}
+	assert.NoError(t, l.Stop())
+}
+
+func inmemConfig() *raft.Config {
+	conf := raft.DefaultConfig()
+	conf.HeartbeatTimeout = 50 * time.Millisecond
+	conf.ElectionTimeout = 50 * time.Millisecond
+	conf.LeaderLeaseTimeout = 50 * time.Millisecond
+	conf.CommitTimeout = 5 * time.Millisecond
+	return conf
+}
+
+func bootStagingCluster(nodeId string, pqStorage *storage.PqStorage) (*raft.Raft, raft.ServerAddress) {
+	store := raft.NewInmemStore()
+	cacheStore, _ := raft.NewLogCache(128, store)
+	snapshotStore := raft.NewInmemSnapshotStore()
+	raftTransportTcpAddr := raft.NewInmemAddr()
+	_, transport := raft.NewInmemTransport(raftTransportTcpAddr)
+	raftconfig := inmemConfig()
+	raftconfig.LogLevel = "info"
+	raftconfig.LocalID = raft.ServerID(nodeId)
+	raftconfig.SnapshotThreshold = 512
+	raftServer, err := raft.NewRaft(raftconfig, fsm.NewPrioritizedFSM(pqStorage), cacheStore, store, snapshotStore, transport)
+	if err != nil {
+		panic("exception during staging cluster boot: " + err.Error())
+	}
+	return raftServer, raftTransportTcpAddr
+}
+
+func TestListenerHttp_Listen(t *testing.T) {
+	tests := []struct {
+		name           string
+		jsonInput      string
+		wantStatusCode int
+		want           []message.Message
+	}{
+		{
+			name:           "Check http listener channel receives pushed message",
+			jsonInput:      "{\"body\":\"foo\",\"available_at\":1000}",
+			wantStatusCode: http.StatusOK,
+			want:           []message.Message{message.NewMessage([]byte("foo"), 1000000)},
+		},
+		{
+			name:           "Check http listener channel keeps message attributes",
+			jsonInput:      "{\"body\":\"foo\",\"available_at\":1000,\"attributes\":{\"type\":\"bar\"}}",
+			wantStatusCode: http.StatusOK,
+			want:           []message.Message{message.NewMessageWithAttributes([]byte("foo"), 1000000, map[string]string{"type": "bar"})},
+		},
+		{
+			name:           "Check http listener channel receives base64 encoded message",
+			jsonInput:      "{\"body\":\"AAEC\",\"encoding\":\"base64\",\"available_at\":1000}",
+			wantStatusCode: http.StatusOK,
+			want:           []message.Message{message.NewMessage([]byte{0, 1, 2}, 1000000)},
+		},
+		{
+			name:           "Check http listener channel rejects message without schedule",
+			jsonInput:      "{\"body\":\"foo\"}",
+			wantStatusCode: http.StatusNotAcceptable,
+			want:           []message.Message{},
+		},
+	}
+	for testID, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+
+			pqStorage := storage.NewPqStorage()
+			nodeId := string(rune(testID))
+			cluster, clusterAddr := bootStagingCluster(nodeId, pqStorage)
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+
+			p := new(prioritizer.Prioritizer)
+			_ = p.Boot(cluster)
+
+			f := cluster.BootstrapCluster(raft.Configuration{Servers: []raft.Server{
+				{
+					Suffrage: raft.Voter,
+					ID:       raft.ServerID(nodeId),
+					Address:  clusterAddr,
+				},
+			}})
+			if err := f.Error(); err != nil {
+				t.Fatal("Cluster bootstrap failed: ", err)
+			}
+			// wait for election
+			time.Sleep(time.Second * 1)
+
+			c := channel.Channel{ID: "ch1", Source: channel.Source{Driver: "http"}}
+			_, _ = pqStorage.AddChannel(c)
+
+			l := &Listener{}
+			assert.NoError(t, l.Boot(ctx, c, p))
+			stopped := make(chan error, 1)
+			go func() {
+				stopped <- l.Listen()
+			}()
+
+			httpServer := echo.New()
+			httpServer.Validator = &httpvalidator.HttpValidator{Validator: validator.New()}
+			ms := &messagemanager.SchedulerMessageManagerServer{}
+			_ = ms.BootMessageManagerServer(messagemanager.NewSchedulerMessageManager(cluster, pqStorage, p), httpServer)
+			server := httptest.NewServer(httpServer)
+			defer server.Close()
+
+			resp, err := http.Post(server.URL+"/channels/"+c.ID+"/messages", echo.MIMEApplicationJSON, strings.NewReader(tt.jsonInput))
+			if assert.NoError(t, err) {
+				body, _ := ioutil.ReadAll(resp.Body)
+				_ = resp.Body.Close()
+				assert.Equal(t, tt.wantStatusCode, resp.StatusCode, string(body))
+			}
+
+			// the listener is passive, it only waits for the channel context
+			cancel()
+			select {
+			case err := <-stopped:
+				assert.NoError(t, err)
+			case <-time.After(time.Second * 3):
+				t.Fatal("listener wasn't stopped")
+			}
+
+			chStorage, _ := pqStorage.GetChannelStorage(c.ID)
+			got := []message.Message{}
+			for !chStorage.IsEmpty() {
+				msg := chStorage.Dequeue()
+				// message IDs are checked separately
+				assert.NotEmpty(t, msg.GetID())
+				got = append(got, msg.WithID(""))
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,7 @@
+package kafkaconfig
+
+type SourceConfig struct {
+	Brokers []string `json:"brokers" xml:"brokers" mapstructure:"brokers" validate:"required,min=1"`
+	Topic   string   `json:"topic" xml:"topic" mapstructure:"topic" validate:"required"`
+	GroupID string   `json:"group_id" xml:"group_id" mapstructure:"group_id" validate:"required"`
+}
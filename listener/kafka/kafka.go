@@ -0,0 +1,174 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/listener"
+	kafkaconfig "github.com/maksimru/event-scheduler/listener/kafka/config"
+	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/prioritizer"
+	"github.com/maksimru/event-scheduler/storage"
+	"github.com/segmentio/kafka-go"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const persistRetryInterval = 500 * time.Millisecond
+
+// Reader is the subset of kafka.Reader used by the listener
+type Reader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+type Listener struct {
+	config      kafkaconfig.SourceConfig
+	reader      Reader
+	context     context.Context
+	stopFunc    context.CancelFunc
+	prioritizer *prioritizer.Prioritizer
+	channel     channel.Channel
+}
+
+func (l *Listener) Boot(ctx context.Context, channel channel.Channel, prioritizer *prioritizer.Prioritizer) error {
+	l.context, l.config = ctx, channel.Source.Config.(kafkaconfig.SourceConfig)
+	reader, err := makeKafkaReader(l.config)
+	l.reader, l.prioritizer, l.channel = reader, prioritizer, channel
+	return err
+}
+
+func (l *Listener) SetKafkaReader(reader Reader) {
+	l.reader = reader
+}
+
+func makeKafkaReader(config kafkaconfig.SourceConfig) (Reader, error) {
+	if len(config.Brokers) == 0 || config.Topic == "" || config.GroupID == "" {
+		err := errors.New("brokers, topic and group_id are required")
+		log.Error("listener client boot failure: ", err.Error())
+		return nil, err
+	}
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers: config.Brokers,
+		Topic:   config.Topic,
+		GroupID: config.GroupID,
+	}), nil
+}
+
+func (l *Listener) Stop() error {
+	if l.stopFunc != nil {
+		log.Info("listener stop called")
+		l.stopFunc()
+	}
+	return nil
+}
+
+func (l *Listener) Listen() error {
+	defer func() {
+		err := l.reader.Close()
+		if err != nil {
+			log.Error("listener client termination failure: ", err.Error())
+		}
+	}()
+
+	// Dedicated context
+	kafkaContext, cancelListener := context.WithCancel(l.context)
+	defer cancelListener()
+	l.stopFunc = cancelListener
+
+	for {
+		msg, err := l.reader.FetchMessage(kafkaContext)
+		if err != nil {
+			if kafkaContext.Err() != nil {
+				break
+			}
+			log.Error("listener message receive exception: ", err.Error())
+			return err
+		}
+		log.Trace("listener message received: ", string(msg.Value))
+		if !l.persist(kafkaContext, msg) {
+			// listener is stopped before the message was persisted, keep the offset uncommitted
+			break
+		}
+		if err := l.reader.CommitMessages(kafkaContext, msg); err != nil {
+			if kafkaContext.Err() != nil {
+				break
+			}
+			log.Error("listener offset commit exception: ", err.Error())
+			return err
+		}
+	}
+
+	log.Info("listener stopped")
+
+	return nil
+}
+
+// persist stores the message in the cluster, retrying until it succeeds or the listener is stopped.
+// Kafka commits offsets, not individual messages, so skipping a failed message would lose it
+func (l *Listener) persist(ctx context.Context, msg kafka.Message) bool {
+	if cancelID, has := getHeader(msg, message.AttributeCancelID); has {
+		return l.retry(ctx, func() error {
+			err := l.prioritizer.Cancel(cancelID, l.channel)
+			if err == storage.ErrMessageNotFound {
+				return nil
+			}
+			return err
+		})
+	}
+	availableAt, has := getHeader(msg, "available_at")
+	if !has {
+		return true
+	}
+	priority, err := message.ParseAvailableAt(availableAt)
+	if err != nil {
+		log.Error("listener unable to read available_at header: ", err.Error())
+		cause := err
+		return l.retry(ctx, func() error {
+			return listener.PersistInvalidSchedule(l.prioritizer, l.channel, message.NewMessageWithAttributes(msg.Value, 0, getAttributes(msg)).WithID(getMessageID(msg)), cause)
+		})
+	}
+	return l.retry(ctx, func() error {
+		return l.prioritizer.Persist(message.NewMessageWithAttributes(msg.Value, priority, getAttributes(msg)).WithID(getMessageID(msg)), l.channel)
+	})
+}
+
+// retry runs the cluster operation until it succeeds, it returns false if the listener is stopped
+func (l *Listener) retry(ctx context.Context, op func() error) bool {
+	for {
+		err := op()
+		if err == nil {
+			return true
+		}
+		log.Warn("listener is unable to persist received message")
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(persistRetryInterval):
+		}
+	}
+}
+
+// getMessageID identifies the record by its position in the topic
+func getMessageID(msg kafka.Message) string {
+	return fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)
+}
+
+func getAttributes(msg kafka.Message) map[string]string {
+	attributes := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		attributes[h.Key] = string(h.Value)
+	}
+	return attributes
+}
+
+func getHeader(msg kafka.Message, key string) (string, bool) {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,325 @@
+package kafka
+
+import (
+	"context"
+	"crypto/rand"
+	"github.com/hashicorp/raft"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/fsm"
+	kafkaconfig "github.com/maksimru/event-scheduler/listener/kafka/config"
+	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/prioritizer"
+	"github.com/maksimru/event-scheduler/storage"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockReader struct {
+	mutex     sync.Mutex
+	messages  []kafka.Message
+	committed []kafka.Message
+	closed    bool
+}
+
+func (r *mockReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		r.mutex.Lock()
+		if len(r.messages) > 0 {
+			msg := r.messages[0]
+			r.messages = r.messages[1:]
+			r.mutex.Unlock()
+			return msg, nil
+		}
+		r.mutex.Unlock()
+		select {
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (r *mockReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.committed = append(r.committed, msgs...)
+	return nil
+}
+
+func (r *mockReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestListenerKafka_Boot(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel channel.Channel
+		wantErr bool
+	}{
+		{
+			name: "Check kafka listener boot",
+			channel: channel.Channel{
+				Source: channel.Source{
+					Driver: "kafka",
+					Config: kafkaconfig.SourceConfig{
+						Brokers: []string{"localhost:9092"},
+						Topic:   "topic",
+						GroupID: "group",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Check kafka listener boot without brokers",
+			channel: channel.Channel{
+				Source: channel.Source{
+					Driver: "kafka",
+					Config: kafkaconfig.SourceConfig{
+						Topic:   "topic",
+						GroupID: "group",
+					},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Listener{}
+			err := l.Boot(context.Background(), tt.channel, new(prioritizer.Prioritizer))
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				assert.NoError(t, l.reader.Close())
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func inmemConfig() *raft.Config {
+	conf := raft.DefaultConfig()
+	conf.HeartbeatTimeout = 50 * time.Millisecond
+	conf.ElectionTimeout = 50 * time.Millisecond
+	conf.LeaderLeaseTimeout = 50 * time.Millisecond
+	conf.CommitTimeout = 5 * time.Millisecond
+	return conf
+}
+
+func bootStagingCluster(nodeId string, pqStorage *storage.PqStorage) (*raft.Raft, raft.ServerAddress) {
+	store := raft.NewInmemStore()
+	cacheStore, _ := raft.NewLogCache(128, store)
+	snapshotStore := raft.NewInmemSnapshotStore()
+	raftTransportTcpAddr := raft.NewInmemAddr()
+	_, transport := raft.NewInmemTransport(raftTransportTcpAddr)
+	raftconfig := inmemConfig()
+	raftconfig.LogLevel = "info"
+	raftconfig.LocalID = raft.ServerID(nodeId)
+	raftconfig.SnapshotThreshold = 512
+	raftServer, err := raft.NewRaft(raftconfig, fsm.NewPrioritizedFSM(pqStorage), cacheStore, store, snapshotStore, transport)
+	if err != nil {
+		panic("exception during staging cluster boot: " + err.Error())
+	}
+	return raftServer, raftTransportTcpAddr
+}
+
+func TestListenerKafka_Listen(t *testing.T) {
+	binaryBody := randomBody(1024)
+	tests := []struct {
+		name          string
+		bootstrap     bool
+		publish       []kafka.Message
+		want          []message.Message
+		wantCommitted int
+		// wantDeadLetters are compared by the body
+		wantDeadLetters []string
+		// invalidSchedulePolicy of the channel source, dead letters by default
+		invalidSchedulePolicy string
+	}{
+		{
+			name:      "Check kafka listener can receive single message with available_at header",
+			bootstrap: true,
+			publish: []kafka.Message{{
+				Value:   []byte("foo"),
+				Headers: []kafka.Header{{Key: "available_at", Value: []byte("1000")}},
+			}},
+			want:          []message.Message{message.NewMessage([]byte("foo"), 1000000)},
+			wantCommitted: 1,
+		},
+		{
+			name:      "Check kafka listener keeps headers as attributes",
+			bootstrap: true,
+			publish: []kafka.Message{{
+				Value:   []byte("foo"),
+				Headers: []kafka.Header{{Key: "available_at", Value: []byte("1000")}, {Key: "type", Value: []byte("bar")}},
+			}},
+			want:          []message.Message{message.NewMessageWithAttributes([]byte("foo"), 1000000, map[string]string{"type": "bar"})},
+			wantCommitted: 1,
+		},
+		{
+			name:      "Check kafka listener can receive binary message",
+			bootstrap: true,
+			publish: []kafka.Message{{
+				Value:   binaryBody,
+				Headers: []kafka.Header{{Key: "available_at", Value: []byte("1000")}},
+			}},
+			want:          []message.Message{message.NewMessage(binaryBody, 1000000)},
+			wantCommitted: 1,
+		},
+		{
+			name:      "Check kafka listener skips messages without available_at header",
+			bootstrap: true,
+			publish: []kafka.Message{{
+				Value: []byte("foo"),
+			}},
+			want:          []message.Message{},
+			wantCommitted: 1,
+		},
+		{
+			name:      "Check kafka listener dead-letters messages with wrong available_at header",
+			bootstrap: true,
+			publish: []kafka.Message{{
+				Value:   []byte("foo"),
+				Headers: []kafka.Header{{Key: "available_at", Value: []byte("foo")}},
+			}},
+			want:            []message.Message{},
+			wantCommitted:   1,
+			wantDeadLetters: []string{"foo"},
+		},
+		{
+			name:      "Check kafka listener delivers messages with wrong available_at header now",
+			bootstrap: true,
+			publish: []kafka.Message{{
+				Value:   []byte("foo"),
+				Headers: []kafka.Header{{Key: "available_at", Value: []byte("foo")}},
+			}},
+			want:                  []message.Message{message.NewMessageWithAttributes([]byte("foo"), 0, map[string]string{"available_at": "foo"})},
+			wantCommitted:         1,
+			invalidSchedulePolicy: channel.InvalidSchedulePolicyDeliver,
+		},
+		{
+			name:      "Check kafka listener drops messages with wrong available_at header",
+			bootstrap: true,
+			publish: []kafka.Message{{
+				Value:   []byte("foo"),
+				Headers: []kafka.Header{{Key: "available_at", Value: []byte("foo")}},
+			}},
+			want:                  []message.Message{},
+			wantCommitted:         1,
+			invalidSchedulePolicy: channel.InvalidSchedulePolicyDrop,
+		},
+		{
+			name:      "Check kafka listener can receive multiple messages",
+			bootstrap: true,
+			publish: []kafka.Message{{
+				Value:   []byte("msg1"),
+				Headers: []kafka.Header{{Key: "available_at", Value: []byte("1000")}},
+			}, {
+				Value:   []byte("msg2"),
+				Headers: []kafka.Header{{Key: "available_at", Value: []byte("1100")}},
+			}, {
+				Value:   []byte("msg3"),
+				Headers: []kafka.Header{{Key: "available_at", Value: []byte("1200")}},
+			}},
+			want: []message.Message{
+				message.NewMessage([]byte("msg1"), 1000000),
+				message.NewMessage([]byte("msg2"), 1100000),
+				message.NewMessage([]byte("msg3"), 1200000),
+			},
+			wantCommitted: 3,
+		},
+		{
+			name:      "Check kafka listener doesn't commit offsets of messages which weren't persisted",
+			bootstrap: false,
+			publish: []kafka.Message{{
+				Value:   []byte("foo"),
+				Headers: []kafka.Header{{Key: "available_at", Value: []byte("1000")}},
+			}},
+			want:          []message.Message{},
+			wantCommitted: 0,
+		},
+	}
+	for testID, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+
+			pqStorage := storage.NewPqStorage()
+			nodeId := string(rune(testID))
+			cluster, clusterAddr := bootStagingCluster(nodeId, pqStorage)
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+
+			p := new(prioritizer.Prioritizer)
+			_ = p.Boot(cluster)
+
+			if tt.bootstrap {
+				f := cluster.BootstrapCluster(raft.Configuration{Servers: []raft.Server{
+					{
+						Suffrage: raft.Voter,
+						ID:       raft.ServerID(nodeId),
+						Address:  clusterAddr,
+					},
+				}})
+				if err := f.Error(); err != nil {
+					t.Fatal("Cluster bootstrap failed: ", err)
+				}
+				// wait for election
+				time.Sleep(time.Second * 1)
+			}
+
+			c := channel.Channel{ID: "ch1", Source: channel.Source{Driver: "kafka", InvalidSchedulePolicy: tt.invalidSchedulePolicy}}
+			_, _ = pqStorage.AddChannel(c)
+
+			reader := &mockReader{messages: tt.publish}
+			l := &Listener{
+				context:     ctx,
+				prioritizer: p,
+				channel:     c,
+			}
+			l.SetKafkaReader(reader)
+
+			startedAt := message.UnixMilli(time.Now())
+			assert.NoError(t, l.Listen())
+
+			chStorage, _ := pqStorage.GetChannelStorage(c.ID)
+			got := []message.Message{}
+			for !chStorage.IsEmpty() {
+				msg := chStorage.Dequeue()
+				// message IDs are checked separately
+				assert.NotEmpty(t, msg.GetID())
+				// messages delivered now are compared without the receive time
+				if msg.GetAvailableAt() >= startedAt {
+					msg.AvailableAt = 0
+				}
+				got = append(got, msg.WithID(""))
+			}
+			assert.ElementsMatch(t, tt.want, got)
+			var gotDeadLetters []string
+			for _, deadLetter := range chStorage.GetDeadLetters() {
+				assert.NotEmpty(t, deadLetter.Message.GetAttributes()[message.AttributeFailureReason])
+				gotDeadLetters = append(gotDeadLetters, string(deadLetter.Message.GetBody()))
+			}
+			assert.Equal(t, tt.wantDeadLetters, gotDeadLetters)
+			assert.Equal(t, tt.wantCommitted, len(reader.committed))
+			assert.True(t, reader.closed)
+		})
+	}
+}
+
+func randomBody(size int) []byte {
+	body := make([]byte, size)
+	_, _ = rand.Read(body)
+	return body
+}
+
+func TestGetMessageID(t *testing.T) {
+	msg := kafka.Message{Topic: "source", Partition: 2, Offset: 15}
+	assert.Equal(t, "source/2/15", getMessageID(msg))
+}
@@ -3,7 +3,10 @@ package listener
 import (
 	"context"
 	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/message"
 	"github.com/maksimru/event-scheduler/prioritizer"
+	log "github.com/sirupsen/logrus"
+	"time"
 )
 
 type Listener interface {
@@ -11,3 +14,20 @@ type Listener interface {
 	Listen() error
 	Stop() error
 }
+
+// PersistInvalidSchedule handles the message with unreadable scheduling attributes by the invalid schedule policy of the channel source,
+// the message is scheduled now, dropped or moved to the channel dead letters. The source acknowledges the message unless an error is returned
+func PersistInvalidSchedule(p *prioritizer.Prioritizer, c channel.Channel, msg message.Message, cause error) error {
+	switch c.Source.GetInvalidSchedulePolicy() {
+	case channel.InvalidSchedulePolicyDeliver:
+		log.Warn("listener delivers message with invalid scheduling attribute now: ", cause.Error())
+		return p.Persist(message.NewMessageWithAttributes(msg.GetBody(), message.UnixMilli(time.Now()), msg.GetAttributes()).WithID(msg.GetID()), c)
+	case channel.InvalidSchedulePolicyDrop:
+		log.Warn("listener drops message with invalid scheduling attribute: ", cause.Error())
+		return nil
+	default:
+		log.Warn("listener moves message with invalid scheduling attribute to dead letters: ", cause.Error())
+		_, err := p.DeadLetter(msg, cause.Error(), c)
+		return err
+	}
+}
@@ -0,0 +1,8 @@
+package natsconfig
+
+type SourceConfig struct {
+	URL       string `json:"url" xml:"url" mapstructure:"url" validate:"required"`
+	Subject   string `json:"subject" xml:"subject" mapstructure:"subject" validate:"required"`
+	Durable   string `json:"durable" xml:"durable" mapstructure:"durable" validate:"required"`
+	BatchSize int    `json:"batch_size" xml:"batch_size" mapstructure:"batch_size" validate:"min=0"`
+}
@@ -0,0 +1,168 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/listener"
+	natsconfig "github.com/maksimru/event-scheduler/listener/nats/config"
+	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/prioritizer"
+	"github.com/maksimru/event-scheduler/storage"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	defaultBatchSize = 10
+	fetchWait        = time.Second
+)
+
+type Listener struct {
+	config       natsconfig.SourceConfig
+	connection   *nats.Conn
+	subscription *nats.Subscription
+	context      context.Context
+	stopFunc     context.CancelFunc
+	prioritizer  *prioritizer.Prioritizer
+	channel      channel.Channel
+}
+
+func (l *Listener) Boot(ctx context.Context, channel channel.Channel, prioritizer *prioritizer.Prioritizer) error {
+	l.context, l.config = ctx, channel.Source.Config.(natsconfig.SourceConfig)
+	l.prioritizer, l.channel = prioritizer, channel
+	if l.config.URL == "" || l.config.Subject == "" || l.config.Durable == "" {
+		err := errors.New("url, subject and durable are required")
+		log.Error("listener client boot failure: ", err.Error())
+		return err
+	}
+	return nil
+}
+
+// connect opens the server connection lazily, so a server outage doesn't break the channel boot
+func (l *Listener) connect() error {
+	connection, err := nats.Connect(l.config.URL)
+	if err != nil {
+		log.Error("listener client connection failure: ", err.Error())
+		return err
+	}
+	js, err := connection.JetStream()
+	if err != nil {
+		connection.Close()
+		log.Error("listener client jetstream failure: ", err.Error())
+		return err
+	}
+	subscription, err := js.PullSubscribe(l.config.Subject, l.config.Durable, nats.ManualAck(), nats.AckExplicit())
+	if err != nil {
+		connection.Close()
+		log.Error("listener client subscription failure: ", err.Error())
+		return err
+	}
+	l.connection, l.subscription = connection, subscription
+	return nil
+}
+
+func (l *Listener) Stop() error {
+	if l.stopFunc != nil {
+		log.Info("listener stop called")
+		l.stopFunc()
+	}
+	return nil
+}
+
+func (l *Listener) Listen() error {
+	if err := l.connect(); err != nil {
+		return err
+	}
+	// the subscription isn't unsubscribed, it would remove the durable consumer with its position
+	defer l.connection.Close()
+
+	// Dedicated context
+	natsContext, cancelListener := context.WithCancel(l.context)
+	defer cancelListener()
+	l.stopFunc = cancelListener
+
+	batchSize := l.config.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultBatchSize
+	}
+
+	for natsContext.Err() == nil {
+		msgs, err := l.subscription.Fetch(batchSize, nats.MaxWait(fetchWait))
+		if err != nil {
+			if err == nats.ErrTimeout {
+				continue
+			}
+			if natsContext.Err() != nil {
+				break
+			}
+			log.Error("listener message receive exception: ", err.Error())
+			return err
+		}
+		for _, msg := range msgs {
+			l.handle(msg)
+		}
+	}
+
+	log.Info("listener stopped")
+
+	return nil
+}
+
+func (l *Listener) handle(msg *nats.Msg) {
+	log.Trace("listener message received: ", string(msg.Data))
+	if cancelID := msg.Header.Get(message.AttributeCancelID); cancelID != "" {
+		err := l.prioritizer.Cancel(cancelID, l.channel)
+		if err != nil && err != storage.ErrMessageNotFound {
+			log.Warn("listener is unable to cancel scheduled message")
+			// request redelivery for strong consistency
+			if err := msg.Nak(); err != nil {
+				log.Error("listener message nak exception: ", err.Error())
+			}
+			return
+		}
+	} else if availableAt := msg.Header.Get("available_at"); availableAt != "" {
+		priority, err := message.ParseAvailableAt(availableAt)
+		if err != nil {
+			log.Error("listener unable to read available_at header: ", err.Error())
+			err = listener.PersistInvalidSchedule(l.prioritizer, l.channel, message.NewMessageWithAttributes(msg.Data, 0, getAttributes(msg.Header)).WithID(getMessageID(msg)), err)
+		} else {
+			err = l.prioritizer.Persist(message.NewMessageWithAttributes(msg.Data, priority, getAttributes(msg.Header)).WithID(getMessageID(msg)), l.channel)
+		}
+		if err != nil {
+			log.Warn("listener is unable to persist received message")
+			// request redelivery for strong consistency
+			if err := msg.Nak(); err != nil {
+				log.Error("listener message nak exception: ", err.Error())
+			}
+			return
+		}
+	}
+	if err := msg.Ack(); err != nil {
+		log.Error("listener message ack exception: ", err.Error())
+	}
+}
+
+// getAttributes keeps the first value of every header
+func getAttributes(header nats.Header) map[string]string {
+	attributes := make(map[string]string, len(header))
+	for k, v := range header {
+		if len(v) > 0 {
+			attributes[k] = v[0]
+		}
+	}
+	return attributes
+}
+
+// getMessageID prefers the publisher deduplication ID, otherwise the message is identified by its stream sequence
+func getMessageID(msg *nats.Msg) string {
+	if id := msg.Header.Get(nats.MsgIdHdr); id != "" {
+		return id
+	}
+	if meta, err := msg.Metadata(); err == nil {
+		return fmt.Sprintf("%s/%d", meta.Stream, meta.Sequence.Stream)
+	}
+	return ""
+}
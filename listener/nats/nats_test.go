@@ -0,0 +1,349 @@
+package nats
+
+import (
+	"context"
+	"crypto/rand"
+	"github.com/hashicorp/raft"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/fsm"
+	natsconfig "github.com/maksimru/event-scheduler/listener/nats/config"
+	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/prioritizer"
+	"github.com/maksimru/event-scheduler/storage"
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// runJetStreamServer starts embedded nats server with the stream capturing the subject
+func runJetStreamServer(t *testing.T, stream string, subject string) (*server.Server, func()) {
+	dir, err := ioutil.TempDir("", "nats-listener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = dir
+	s := natsserver.RunServer(&opts)
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	js, _ := nc.JetStream()
+	if _, err := js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{subject}}); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, func() {
+		s.Shutdown()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestListenerNats_Boot(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel channel.Channel
+		wantErr bool
+	}{
+		{
+			name: "Check nats listener boot",
+			channel: channel.Channel{
+				Source: channel.Source{
+					Driver: "nats",
+					Config: natsconfig.SourceConfig{
+						URL:     "nats://localhost:4222",
+						Subject: "scheduled",
+						Durable: "event-scheduler",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Check nats listener boot without durable",
+			channel: channel.Channel{
+				Source: channel.Source{
+					Driver: "nats",
+					Config: natsconfig.SourceConfig{
+						URL:     "nats://localhost:4222",
+						Subject: "scheduled",
+					},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Listener{}
+			err := l.Boot(context.Background(), tt.channel, new(prioritizer.Prioritizer))
+			if !tt.wantErr {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func inmemConfig() *raft.Config {
+	conf := raft.DefaultConfig()
+	conf.HeartbeatTimeout = 50 * time.Millisecond
+	conf.ElectionTimeout = 50 * time.Millisecond
+	conf.LeaderLeaseTimeout = 50 * time.Millisecond
+	conf.CommitTimeout = 5 * time.Millisecond
+	return conf
+}
+
+func bootStagingCluster(nodeId string, pqStorage *storage.PqStorage) (*raft.Raft, raft.ServerAddress) {
+	store := raft.NewInmemStore()
+	cacheStore, _ := raft.NewLogCache(128, store)
+	snapshotStore := raft.NewInmemSnapshotStore()
+	raftTransportTcpAddr := raft.NewInmemAddr()
+	_, transport := raft.NewInmemTransport(raftTransportTcpAddr)
+	raftconfig := inmemConfig()
+	raftconfig.LogLevel = "info"
+	raftconfig.LocalID = raft.ServerID(nodeId)
+	raftconfig.SnapshotThreshold = 512
+	raftServer, err := raft.NewRaft(raftconfig, fsm.NewPrioritizedFSM(pqStorage), cacheStore, store, snapshotStore, transport)
+	if err != nil {
+		panic("exception during staging cluster boot: " + err.Error())
+	}
+	return raftServer, raftTransportTcpAddr
+}
+
+func TestListenerNats_Listen(t *testing.T) {
+	binaryBody := randomBody(1024)
+	tests := []struct {
+		name           string
+		bootstrap      bool
+		publish        []*nats.Msg
+		want           []message.Message
+		wantAckPending int
+		// wantDeadLetters are compared by the body
+		wantDeadLetters []string
+		// invalidSchedulePolicy of the channel source, dead letters by default
+		invalidSchedulePolicy string
+	}{
+		{
+			name:      "Check nats listener can receive single message",
+			bootstrap: true,
+			publish: []*nats.Msg{
+				{Data: []byte("foo"), Header: nats.Header{"available_at": []string{"1000"}}},
+			},
+			want:           []message.Message{message.NewMessage([]byte("foo"), 1000000)},
+			wantAckPending: 0,
+		},
+		{
+			name:      "Check nats listener keeps headers as attributes",
+			bootstrap: true,
+			publish: []*nats.Msg{
+				{Data: []byte("foo"), Header: nats.Header{"available_at": []string{"1000"}, "type": []string{"bar", "baz"}}},
+			},
+			want:           []message.Message{message.NewMessageWithAttributes([]byte("foo"), 1000000, map[string]string{"type": "bar"})},
+			wantAckPending: 0,
+		},
+		{
+			name:      "Check nats listener can receive binary message",
+			bootstrap: true,
+			publish: []*nats.Msg{
+				{Data: binaryBody, Header: nats.Header{"available_at": []string{"1000"}}},
+			},
+			want:           []message.Message{message.NewMessage(binaryBody, 1000000)},
+			wantAckPending: 0,
+		},
+		{
+			name:      "Check nats listener acks messages without available_at header",
+			bootstrap: true,
+			publish: []*nats.Msg{
+				{Data: []byte("foo")},
+				{Data: []byte("bar"), Header: nats.Header{"available_at": []string{"bar"}}},
+			},
+			want:            []message.Message{},
+			wantAckPending:  0,
+			wantDeadLetters: []string{"bar"},
+		},
+		{
+			name:      "Check nats listener drops messages with wrong available_at header",
+			bootstrap: true,
+			publish: []*nats.Msg{
+				{Data: []byte("bar"), Header: nats.Header{"available_at": []string{"bar"}}},
+			},
+			want:                  []message.Message{},
+			wantAckPending:        0,
+			invalidSchedulePolicy: channel.InvalidSchedulePolicyDrop,
+		},
+		{
+			name:      "Check nats listener can receive multiple messages",
+			bootstrap: true,
+			publish: []*nats.Msg{
+				{Data: []byte("msg1"), Header: nats.Header{"available_at": []string{"1000"}}},
+				{Data: []byte("msg2"), Header: nats.Header{"available_at": []string{"1100"}}},
+				{Data: []byte("msg3"), Header: nats.Header{"available_at": []string{"1200"}}},
+			},
+			want: []message.Message{
+				message.NewMessage([]byte("msg1"), 1000000),
+				message.NewMessage([]byte("msg2"), 1100000),
+				message.NewMessage([]byte("msg3"), 1200000),
+			},
+			wantAckPending: 0,
+		},
+		{
+			name:      "Check nats listener cancels scheduled messages",
+			bootstrap: true,
+			publish: []*nats.Msg{
+				{Data: []byte("msg1"), Header: nats.Header{"available_at": []string{"1000"}, nats.MsgIdHdr: []string{"id1"}}},
+				{Data: []byte("msg2"), Header: nats.Header{"available_at": []string{"1100"}, nats.MsgIdHdr: []string{"id2"}}},
+				{Data: []byte("cancel1"), Header: nats.Header{"cancel_id": []string{"id1"}}},
+				{Data: []byte("cancel3"), Header: nats.Header{"cancel_id": []string{"id3"}}},
+			},
+			want: []message.Message{
+				message.NewMessageWithAttributes([]byte("msg2"), 1100000, map[string]string{nats.MsgIdHdr: "id2"}),
+			},
+			wantAckPending: 0,
+		},
+		{
+			name:      "Check nats listener doesn't ack messages which weren't persisted",
+			bootstrap: false,
+			publish: []*nats.Msg{
+				{Data: []byte("foo"), Header: nats.Header{"available_at": []string{"1000"}}},
+			},
+			want:           []message.Message{},
+			wantAckPending: 1,
+		},
+	}
+	for testID, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+
+			natsServer, shutdown := runJetStreamServer(t, "SCHEDULED", "scheduled")
+			defer shutdown()
+
+			pqStorage := storage.NewPqStorage()
+			nodeId := string(rune(testID))
+			cluster, clusterAddr := bootStagingCluster(nodeId, pqStorage)
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+
+			p := new(prioritizer.Prioritizer)
+			_ = p.Boot(cluster)
+
+			if tt.bootstrap {
+				f := cluster.BootstrapCluster(raft.Configuration{Servers: []raft.Server{
+					{
+						Suffrage: raft.Voter,
+						ID:       raft.ServerID(nodeId),
+						Address:  clusterAddr,
+					},
+				}})
+				if err := f.Error(); err != nil {
+					t.Fatal("Cluster bootstrap failed: ", err)
+				}
+				// wait for election
+				time.Sleep(time.Second * 1)
+			}
+
+			nc, err := nats.Connect(natsServer.ClientURL())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer nc.Close()
+			js, _ := nc.JetStream()
+			for _, msg := range tt.publish {
+				msg.Subject = "scheduled"
+				_, err := js.PublishMsg(msg)
+				assert.NoError(t, err)
+			}
+
+			c := channel.Channel{ID: "ch1", Source: channel.Source{
+				Driver:                "nats",
+				InvalidSchedulePolicy: tt.invalidSchedulePolicy,
+				Config: natsconfig.SourceConfig{
+					URL:     natsServer.ClientURL(),
+					Subject: "scheduled",
+					Durable: "event-scheduler",
+				},
+			}}
+			_, _ = pqStorage.AddChannel(c)
+
+			l := &Listener{}
+			assert.NoError(t, l.Boot(ctx, c, p))
+			assert.NoError(t, l.Listen())
+
+			chStorage, _ := pqStorage.GetChannelStorage(c.ID)
+			got := []message.Message{}
+			for !chStorage.IsEmpty() {
+				msg := chStorage.Dequeue()
+				// message IDs are checked separately
+				assert.NotEmpty(t, msg.GetID())
+				got = append(got, msg.WithID(""))
+			}
+			assert.ElementsMatch(t, tt.want, got)
+			var gotDeadLetters []string
+			for _, deadLetter := range chStorage.GetDeadLetters() {
+				assert.NotEmpty(t, deadLetter.Message.GetAttributes()[message.AttributeFailureReason])
+				gotDeadLetters = append(gotDeadLetters, string(deadLetter.Message.GetBody()))
+			}
+			assert.Equal(t, tt.wantDeadLetters, gotDeadLetters)
+
+			info, err := js.ConsumerInfo("SCHEDULED", "event-scheduler")
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.wantAckPending, info.NumAckPending)
+			}
+		})
+	}
+}
+
+func TestListenerNats_ListenWithoutServer(t *testing.T) {
+	l := &Listener{}
+	_ = l.Boot(context.Background(), channel.Channel{Source: channel.Source{
+		Driver: "nats",
+		Config: natsconfig.SourceConfig{
+			URL:     "nats://127.0.0.1:1",
+			Subject: "scheduled",
+			Durable: "event-scheduler",
+		},
+	}}, new(prioritizer.Prioritizer))
+	assert.Error(t, l.Listen())
+}
+
+func randomBody(size int) []byte {
+	body := make([]byte, size)
+	_, _ = rand.Read(body)
+	return body
+}
+
+func TestGetMessageID(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *nats.Msg
+		want string
+	}{
+		{
+			name: "Check message is identified by deduplication header",
+			msg:  &nats.Msg{Header: nats.Header{nats.MsgIdHdr: []string{"id1"}}},
+			want: "id1",
+		},
+		{
+			name: "Check message without deduplication header and metadata has no ID",
+			msg:  &nats.Msg{Header: nats.Header{}},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, getMessageID(tt.msg))
+		})
+	}
+}
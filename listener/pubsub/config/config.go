@@ -4,4 +4,8 @@ type SourceConfig struct {
 	ProjectID      string `json:"project_id" xml:"project_id" mapstructure:"project_id"`
 	SubscriptionID string `json:"subscription_id" xml:"subscription_id" mapstructure:"subscription_id"`
 	KeyFile        string `json:"key_file" xml:"key_file" mapstructure:"key_file"`
+	// AvailableAtAttribute holds the scheduling time (epoch seconds or RFC 3339), available_at by default
+	AvailableAtAttribute string `json:"available_at_attribute,omitempty" xml:"available_at_attribute,omitempty" mapstructure:"available_at_attribute"`
+	// DelayAttribute holds the delay relative to the publish time, delay_seconds or delay by default
+	DelayAttribute string `json:"delay_attribute,omitempty" xml:"delay_attribute,omitempty" mapstructure:"delay_attribute"`
 }
@@ -4,13 +4,14 @@ import (
 	"cloud.google.com/go/pubsub"
 	"context"
 	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/listener"
 	pubsubconfig "github.com/maksimru/event-scheduler/listener/pubsub/config"
 	"github.com/maksimru/event-scheduler/message"
 	"github.com/maksimru/event-scheduler/prioritizer"
+	"github.com/maksimru/event-scheduler/storage"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/api/option"
 	"runtime"
-	"strconv"
 )
 
 type Listener struct {
@@ -79,21 +80,7 @@ func (l *Listener) Listen() error {
 				return
 			case msg := <-cm:
 				log.Trace("listener message received: ", string(msg.Data))
-				if availableAt, has := msg.Attributes["available_at"]; has {
-					priority, err := strconv.Atoi(availableAt)
-					if err != nil {
-						log.Error("listener unable to read available_at attribute: ", err.Error())
-					} else {
-						err := l.prioritizer.Persist(message.NewMessage(string(msg.Data), priority), l.channel)
-						if err != nil {
-							log.Warn("listener is unable to persist received message")
-							// do not ack the message for strong consistency
-							msg.Nack()
-							break
-						}
-					}
-				}
-				msg.Ack()
+				l.handle(msg)
 			}
 		}
 	}()
@@ -111,3 +98,58 @@ func (l *Listener) Listen() error {
 
 	return nil
 }
+
+// handle cancels or schedules the received message, it's acked once the cluster has accepted it
+func (l *Listener) handle(msg *pubsub.Message) {
+	if cancelID, has := msg.Attributes[message.AttributeCancelID]; has {
+		err := l.prioritizer.Cancel(cancelID, l.channel)
+		if err != nil && err != storage.ErrMessageNotFound {
+			log.Warn("listener is unable to cancel scheduled message")
+			// do not ack the message for strong consistency
+			msg.Nack()
+			return
+		}
+		msg.Ack()
+		return
+	}
+	availableAt, has, err := l.availableAt(msg)
+	if !has {
+		msg.Ack()
+		return
+	}
+	if err != nil {
+		err = listener.PersistInvalidSchedule(l.prioritizer, l.channel, message.NewMessageWithAttributes(msg.Data, 0, msg.Attributes).WithID(msg.ID), err)
+	} else {
+		err = l.prioritizer.Persist(message.NewMessageWithAttributes(msg.Data, availableAt, msg.Attributes).WithID(msg.ID), l.channel)
+	}
+	if err != nil {
+		log.Warn("listener is unable to persist received message")
+		// do not ack the message for strong consistency
+		msg.Nack()
+		return
+	}
+	msg.Ack()
+}
+
+// availableAt reads the scheduling time, the absolute time takes precedence over the delay relative to the publish time
+func (l *Listener) availableAt(msg *pubsub.Message) (int, bool, error) {
+	availableAtAttribute := message.AttributeAvailableAt
+	if l.config.AvailableAtAttribute != "" {
+		availableAtAttribute = l.config.AvailableAtAttribute
+	}
+	if value, has := msg.Attributes[availableAtAttribute]; has {
+		availableAt, err := message.ParseAvailableAt(value)
+		return availableAt, true, err
+	}
+	delayAttributes := []string{message.AttributeDelaySeconds, message.AttributeDelay}
+	if l.config.DelayAttribute != "" {
+		delayAttributes = []string{l.config.DelayAttribute}
+	}
+	for _, delayAttribute := range delayAttributes {
+		if value, has := msg.Attributes[delayAttribute]; has {
+			availableAt, err := message.ParseDelay(value, msg.PublishTime)
+			return availableAt, true, err
+		}
+	}
+	return 0, false, nil
+}
@@ -4,6 +4,7 @@ import (
 	"cloud.google.com/go/pubsub"
 	"cloud.google.com/go/pubsub/pstest"
 	"context"
+	"crypto/rand"
 	"github.com/hashicorp/raft"
 	"github.com/maksimru/event-scheduler/channel"
 	"github.com/maksimru/event-scheduler/config"
@@ -175,9 +176,11 @@ func bootStagingCluster(nodeId string, pqStorage *storage.PqStorage) (*raft.Raft
 }
 
 func TestListenerPubsub_Listen(t *testing.T) {
+	binaryBody := randomBody(1024)
 	type fields struct {
 		channel           channel.Channel
 		availableChannels []channel.Channel
+		config            pubsubconfig.SourceConfig
 	}
 	pubsubServer := pstest.NewServer()
 	defer func() {
@@ -191,6 +194,8 @@ func TestListenerPubsub_Listen(t *testing.T) {
 		publish      []*pubsub.Message
 		publishDelay []*pubsub.Message
 		want         []message.Message
+		// wantDeadLetters are compared by the body
+		wantDeadLetters []string
 	}{
 		{
 			name: "Check pubsub listener can receive single message with available_at attribute",
@@ -213,7 +218,53 @@ func TestListenerPubsub_Listen(t *testing.T) {
 			}},
 			publishDelay: nil,
 			wantErr:      false,
-			want:         []message.Message{message.NewMessage("foo", 1000)},
+			want:         []message.Message{message.NewMessage([]byte("foo"), 1000000)},
+		},
+		{
+			name: "Check pubsub listener keeps message attributes",
+			fields: fields{
+				channel: channel.Channel{
+					ID: "ch1",
+					Source: channel.Source{
+						Driver: "pubsub",
+					},
+				},
+				availableChannels: []channel.Channel{
+					{
+						ID: "ch1",
+					},
+				},
+			},
+			publish: []*pubsub.Message{{
+				Data:       []byte("foo"),
+				Attributes: map[string]string{"available_at": "1000", "type": "bar"},
+			}},
+			publishDelay: nil,
+			wantErr:      false,
+			want:         []message.Message{message.NewMessageWithAttributes([]byte("foo"), 1000000, map[string]string{"type": "bar"})},
+		},
+		{
+			name: "Check pubsub listener can receive binary message",
+			fields: fields{
+				channel: channel.Channel{
+					ID: "ch1",
+					Source: channel.Source{
+						Driver: "pubsub",
+					},
+				},
+				availableChannels: []channel.Channel{
+					{
+						ID: "ch1",
+					},
+				},
+			},
+			publish: []*pubsub.Message{{
+				Data:       binaryBody,
+				Attributes: map[string]string{"available_at": "1000"},
+			}},
+			publishDelay: nil,
+			wantErr:      false,
+			want:         []message.Message{message.NewMessage(binaryBody, 1000000)},
 		},
 		{
 			name: "Check pubsub listener can receive single message without available_at attribute",
@@ -239,7 +290,7 @@ func TestListenerPubsub_Listen(t *testing.T) {
 			want:         []message.Message{},
 		},
 		{
-			name: "Check pubsub listener can receive single message with wrong available_at attribute",
+			name: "Check pubsub listener moves message with wrong available_at attribute to dead letters by default",
 			fields: fields{
 				channel: channel.Channel{
 					ID: "ch1",
@@ -257,6 +308,102 @@ func TestListenerPubsub_Listen(t *testing.T) {
 				Data:       []byte("foo"),
 				Attributes: map[string]string{"available_at": "foo"},
 			}},
+			publishDelay:    nil,
+			wantErr:         false,
+			want:            []message.Message{},
+			wantDeadLetters: []string{"foo"},
+		},
+		{
+			name: "Check pubsub listener can receive message with RFC 3339 available_at attribute",
+			fields: fields{
+				channel: channel.Channel{
+					ID: "ch1",
+					Source: channel.Source{
+						Driver: "pubsub",
+					},
+				},
+				availableChannels: []channel.Channel{
+					{
+						ID: "ch1",
+					},
+				},
+			},
+			publish: []*pubsub.Message{{
+				Data:       []byte("foo"),
+				Attributes: map[string]string{"available_at": "2021-03-01T00:00:00Z"},
+			}},
+			publishDelay: nil,
+			wantErr:      false,
+			want:         []message.Message{message.NewMessage([]byte("foo"), 1614556800000)},
+		},
+		{
+			name: "Check pubsub listener can receive message with custom available_at attribute",
+			fields: fields{
+				channel: channel.Channel{
+					ID: "ch1",
+					Source: channel.Source{
+						Driver: "pubsub",
+					},
+				},
+				availableChannels: []channel.Channel{
+					{
+						ID: "ch1",
+					},
+				},
+				config: pubsubconfig.SourceConfig{AvailableAtAttribute: "send_at"},
+			},
+			publish: []*pubsub.Message{{
+				Data:       []byte("foo"),
+				Attributes: map[string]string{"send_at": "1000", "available_at": "foo"},
+			}},
+			publishDelay: nil,
+			wantErr:      false,
+			want:         []message.Message{message.NewMessageWithAttributes([]byte("foo"), 1000000, map[string]string{"send_at": "1000"})},
+		},
+		{
+			name: "Check pubsub listener delivers message with wrong available_at attribute now",
+			fields: fields{
+				channel: channel.Channel{
+					ID: "ch1",
+					Source: channel.Source{
+						Driver:                "pubsub",
+						InvalidSchedulePolicy: channel.InvalidSchedulePolicyDeliver,
+					},
+				},
+				availableChannels: []channel.Channel{
+					{
+						ID: "ch1",
+					},
+				},
+			},
+			publish: []*pubsub.Message{{
+				Data:       []byte("foo"),
+				Attributes: map[string]string{"delay": "soon"},
+			}},
+			publishDelay: nil,
+			wantErr:      false,
+			want:         []message.Message{message.NewMessageWithAttributes([]byte("foo"), 0, map[string]string{"delay": "soon"})},
+		},
+		{
+			name: "Check pubsub listener drops message with wrong available_at attribute",
+			fields: fields{
+				channel: channel.Channel{
+					ID: "ch1",
+					Source: channel.Source{
+						Driver:                "pubsub",
+						InvalidSchedulePolicy: channel.InvalidSchedulePolicyDrop,
+					},
+				},
+				availableChannels: []channel.Channel{
+					{
+						ID: "ch1",
+					},
+				},
+			},
+			publish: []*pubsub.Message{{
+				Data:       []byte("foo"),
+				Attributes: map[string]string{"available_at": "foo"},
+			}},
 			publishDelay: nil,
 			wantErr:      false,
 			want:         []message.Message{},
@@ -289,9 +436,9 @@ func TestListenerPubsub_Listen(t *testing.T) {
 			publishDelay: nil,
 			wantErr:      false,
 			want: []message.Message{
-				message.NewMessage("msg1", 1000),
-				message.NewMessage("msg2", 1100),
-				message.NewMessage("msg3", 1200),
+				message.NewMessage([]byte("msg1"), 1000000),
+				message.NewMessage([]byte("msg2"), 1100000),
+				message.NewMessage([]byte("msg3"), 1200000),
 			},
 		},
 		{
@@ -331,12 +478,12 @@ func TestListenerPubsub_Listen(t *testing.T) {
 			}},
 			wantErr: false,
 			want: []message.Message{
-				message.NewMessage("msg1", 1000),
-				message.NewMessage("msg2", 1100),
-				message.NewMessage("msg3", 1200),
-				message.NewMessage("msg4", 1300),
-				message.NewMessage("msg5", 1400),
-				message.NewMessage("msg6", 1500),
+				message.NewMessage([]byte("msg1"), 1000000),
+				message.NewMessage([]byte("msg2"), 1100000),
+				message.NewMessage([]byte("msg3"), 1200000),
+				message.NewMessage([]byte("msg4"), 1300000),
+				message.NewMessage([]byte("msg5"), 1400000),
+				message.NewMessage([]byte("msg6"), 1500000),
 			},
 		},
 	}
@@ -378,9 +525,8 @@ func TestListenerPubsub_Listen(t *testing.T) {
 				_, _ = pqStorage.AddChannel(c)
 			}
 
-			cfg := pubsubconfig.SourceConfig{
-				SubscriptionID: "mocksubscription" + strconv.Itoa(testID),
-			}
+			cfg := tt.fields.config
+			cfg.SubscriptionID = "mocksubscription" + strconv.Itoa(testID)
 
 			// make pubsub client-server connection
 			pubsubServerConn, _ := grpc.Dial(pubsubServer.Addr, grpc.WithInsecure())
@@ -398,6 +544,7 @@ func TestListenerPubsub_Listen(t *testing.T) {
 			}
 
 			// publish test messages
+			startedAt := message.UnixMilli(time.Now())
 			for _, msg := range tt.publish {
 				pubsubServer.Publish(topic.String(), msg.Data, msg.Attributes)
 			}
@@ -432,12 +579,103 @@ func TestListenerPubsub_Listen(t *testing.T) {
 			var got []message.Message
 			for !chStorage.IsEmpty() {
 				item := chStorage.Dequeue()
-				got = append(got, item)
+				// message IDs are checked separately
+				assert.NotEmpty(t, item.GetID())
+				// messages delivered now are compared without the receive time
+				if item.GetAvailableAt() >= startedAt {
+					item.AvailableAt = 0
+				}
+				got = append(got, item.WithID(""))
 			}
 			// compare received messages
 			if !reflect.DeepEqual(got, tt.want) {
 				assert.ElementsMatch(t, tt.want, got)
 			}
+			var gotDeadLetters []string
+			for _, deadLetter := range chStorage.GetDeadLetters() {
+				assert.NotEmpty(t, deadLetter.Message.GetAttributes()[message.AttributeFailureReason])
+				gotDeadLetters = append(gotDeadLetters, string(deadLetter.Message.GetBody()))
+			}
+			assert.Equal(t, tt.wantDeadLetters, gotDeadLetters)
 		})
 	}
 }
+
+func TestListener_availableAt(t *testing.T) {
+	publishTime := time.Unix(1000, 0)
+	tests := []struct {
+		name       string
+		config     pubsubconfig.SourceConfig
+		attributes map[string]string
+		want       int
+		wantHas    bool
+		wantErr    bool
+	}{
+		{
+			name:       "Check epoch available_at",
+			attributes: map[string]string{"available_at": "2000"},
+			want:       2000000,
+			wantHas:    true,
+		},
+		{
+			name:       "Check available_at takes precedence over delay",
+			attributes: map[string]string{"available_at": "2000", "delay": "60"},
+			want:       2000000,
+			wantHas:    true,
+		},
+		{
+			name:       "Check delay_seconds",
+			attributes: map[string]string{"delay_seconds": "60"},
+			want:       1060000,
+			wantHas:    true,
+		},
+		{
+			name:       "Check Go duration delay",
+			attributes: map[string]string{"delay": "1m30s"},
+			want:       1090000,
+			wantHas:    true,
+		},
+		{
+			name:       "Check ISO-8601 delay",
+			attributes: map[string]string{"delay": "PT2M"},
+			want:       1120000,
+			wantHas:    true,
+		},
+		{
+			name:       "Check custom delay attribute",
+			config:     pubsubconfig.SourceConfig{DelayAttribute: "wait"},
+			attributes: map[string]string{"wait": "PT1M", "delay": "PT2M"},
+			want:       1060000,
+			wantHas:    true,
+		},
+		{
+			name:       "Check invalid delay",
+			attributes: map[string]string{"delay_seconds": "-60"},
+			wantHas:    true,
+			wantErr:    true,
+		},
+		{
+			name:       "Check message without scheduling attributes",
+			attributes: map[string]string{"type": "foo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Listener{config: tt.config}
+			got, has, err := l.availableAt(&pubsub.Message{Attributes: tt.attributes, PublishTime: publishTime})
+			assert.Equal(t, tt.wantHas, has)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func randomBody(size int) []byte {
+	body := make([]byte, size)
+	_, _ = rand.Read(body)
+	return body
+}
@@ -0,0 +1,11 @@
+package redisconfig
+
+type SourceConfig struct {
+	Addr      string `json:"addr" xml:"addr" mapstructure:"addr" validate:"required"`
+	Password  string `json:"password" xml:"password" mapstructure:"password"`
+	DB        int    `json:"db" xml:"db" mapstructure:"db" validate:"min=0"`
+	Stream    string `json:"stream" xml:"stream" mapstructure:"stream" validate:"required"`
+	Group     string `json:"group" xml:"group" mapstructure:"group" validate:"required"`
+	Consumer  string `json:"consumer" xml:"consumer" mapstructure:"consumer"`
+	BatchSize int    `json:"batch_size" xml:"batch_size" mapstructure:"batch_size" validate:"min=0"`
+}
@@ -0,0 +1,184 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/listener"
+	redisconfig "github.com/maksimru/event-scheduler/listener/redis/config"
+	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/prioritizer"
+	"github.com/maksimru/event-scheduler/storage"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+const (
+	defaultBatchSize     = 10
+	defaultConsumer      = "event-scheduler"
+	readBlock            = time.Second
+	persistRetryInterval = 500 * time.Millisecond
+)
+
+const (
+	FieldBody        = "body"
+	FieldAvailableAt = "available_at"
+)
+
+// pending entries are read with "0" id, new entries with ">" id
+const (
+	pendingEntries = "0"
+	newEntries     = ">"
+)
+
+type Listener struct {
+	config      redisconfig.SourceConfig
+	client      *redis.Client
+	context     context.Context
+	stopFunc    context.CancelFunc
+	prioritizer *prioritizer.Prioritizer
+	channel     channel.Channel
+}
+
+func (l *Listener) Boot(ctx context.Context, channel channel.Channel, prioritizer *prioritizer.Prioritizer) error {
+	l.context, l.config = ctx, channel.Source.Config.(redisconfig.SourceConfig)
+	l.prioritizer, l.channel = prioritizer, channel
+	if l.config.Addr == "" || l.config.Stream == "" || l.config.Group == "" {
+		err := errors.New("addr, stream and group are required")
+		log.Error("listener client boot failure: ", err.Error())
+		return err
+	}
+	// client connects lazily, so a server outage doesn't break the channel boot
+	l.client = redis.NewClient(&redis.Options{
+		Addr:     l.config.Addr,
+		Password: l.config.Password,
+		DB:       l.config.DB,
+	})
+	return nil
+}
+
+func (l *Listener) Stop() error {
+	if l.stopFunc != nil {
+		log.Info("listener stop called")
+		l.stopFunc()
+	}
+	return nil
+}
+
+func (l *Listener) Listen() error {
+	defer func() {
+		err := l.client.Close()
+		if err != nil {
+			log.Error("listener client termination failure: ", err.Error())
+		}
+	}()
+
+	// Dedicated context
+	redisContext, cancelListener := context.WithCancel(l.context)
+	defer cancelListener()
+	l.stopFunc = cancelListener
+
+	// group created on the first start consumes the whole stream
+	err := l.client.XGroupCreateMkStream(redisContext, l.config.Stream, l.config.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		log.Error("listener consumer group setup exception: ", err.Error())
+		return err
+	}
+
+	// the consumer name is shared by all nodes, so a new leader picks up entries left pending by the previous one
+	consumer := l.config.Consumer
+	if consumer == "" {
+		consumer = defaultConsumer
+	}
+	batchSize := l.config.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultBatchSize
+	}
+
+	id := pendingEntries
+	for redisContext.Err() == nil {
+		streams, err := l.client.XReadGroup(redisContext, &redis.XReadGroupArgs{
+			Group:    l.config.Group,
+			Consumer: consumer,
+			Streams:  []string{l.config.Stream, id},
+			Count:    int64(batchSize),
+			Block:    readBlock,
+		}).Result()
+		if err == redis.Nil {
+			id = newEntries
+			continue
+		}
+		if err != nil {
+			if redisContext.Err() != nil {
+				break
+			}
+			log.Error("listener message receive exception: ", err.Error())
+			return err
+		}
+		entries := 0
+		for _, stream := range streams {
+			entries += len(stream.Messages)
+			for _, entry := range stream.Messages {
+				if !l.handle(redisContext, entry) {
+					// unacknowledged entries stay pending and are read again
+					id = pendingEntries
+					select {
+					case <-redisContext.Done():
+					case <-time.After(persistRetryInterval):
+					}
+					break
+				}
+			}
+		}
+		if entries == 0 {
+			// all pending entries are processed
+			id = newEntries
+		}
+	}
+
+	log.Info("listener stopped")
+
+	return nil
+}
+
+// handle persists the entry and acknowledges it, it returns false if the entry is left pending
+func (l *Listener) handle(ctx context.Context, entry redis.XMessage) bool {
+	body, _ := entry.Values[FieldBody].(string)
+	log.Trace("listener message received: ", body)
+	if cancelID, has := entry.Values[message.AttributeCancelID]; has {
+		err := l.prioritizer.Cancel(cancelID.(string), l.channel)
+		if err != nil && err != storage.ErrMessageNotFound {
+			log.Warn("listener is unable to cancel scheduled message")
+			return false
+		}
+	} else if availableAt, has := entry.Values[FieldAvailableAt]; has {
+		priority, err := message.ParseAvailableAt(availableAt.(string))
+		if err != nil {
+			log.Error("listener unable to read available_at field: ", err.Error())
+			err = listener.PersistInvalidSchedule(l.prioritizer, l.channel, message.NewMessageWithAttributes([]byte(body), 0, getAttributes(entry)).WithID(entry.ID), err)
+		} else {
+			err = l.prioritizer.Persist(message.NewMessageWithAttributes([]byte(body), priority, getAttributes(entry)).WithID(entry.ID), l.channel)
+		}
+		if err != nil {
+			log.Warn("listener is unable to persist received message")
+			return false
+		}
+	}
+	if err := l.client.XAck(ctx, l.config.Stream, l.config.Group, entry.ID).Err(); err != nil {
+		log.Error("listener message ack exception: ", err.Error())
+	}
+	return true
+}
+
+// getAttributes keeps all entry fields except the body
+func getAttributes(entry redis.XMessage) map[string]string {
+	attributes := make(map[string]string, len(entry.Values))
+	for k, v := range entry.Values {
+		if value, ok := v.(string); ok && k != FieldBody {
+			attributes[k] = value
+		}
+	}
+	return attributes
+}
@@ -0,0 +1,280 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/hashicorp/raft"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/fsm"
+	redisconfig "github.com/maksimru/event-scheduler/listener/redis/config"
+	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/prioritizer"
+	"github.com/maksimru/event-scheduler/storage"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestListenerRedis_Boot(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel channel.Channel
+		wantErr bool
+	}{
+		{
+			name: "Check redis listener boot",
+			channel: channel.Channel{
+				Source: channel.Source{
+					Driver: "redis",
+					Config: redisconfig.SourceConfig{
+						Addr:   "localhost:6379",
+						Stream: "scheduled",
+						Group:  "event-scheduler",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Check redis listener boot without group",
+			channel: channel.Channel{
+				Source: channel.Source{
+					Driver: "redis",
+					Config: redisconfig.SourceConfig{
+						Addr:   "localhost:6379",
+						Stream: "scheduled",
+					},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Listener{}
+			err := l.Boot(context.Background(), tt.channel, new(prioritizer.Prioritizer))
+			if !tt.wantErr {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func inmemConfig() *raft.Config {
+	conf := raft.DefaultConfig()
+	conf.HeartbeatTimeout = 50 * time.Millisecond
+	conf.ElectionTimeout = 50 * time.Millisecond
+	conf.LeaderLeaseTimeout = 50 * time.Millisecond
+	conf.CommitTimeout = 5 * time.Millisecond
+	return conf
+}
+
+func bootStagingCluster(nodeId string, pqStorage *storage.PqStorage) (*raft.Raft, raft.ServerAddress) {
+	store := raft.NewInmemStore()
+	cacheStore, _ := raft.NewLogCache(128, store)
+	snapshotStore := raft.NewInmemSnapshotStore()
+	raftTransportTcpAddr := raft.NewInmemAddr()
+	_, transport := raft.NewInmemTransport(raftTransportTcpAddr)
+	raftconfig := inmemConfig()
+	raftconfig.LogLevel = "info"
+	raftconfig.LocalID = raft.ServerID(nodeId)
+	raftconfig.SnapshotThreshold = 512
+	raftServer, err := raft.NewRaft(raftconfig, fsm.NewPrioritizedFSM(pqStorage), cacheStore, store, snapshotStore, transport)
+	if err != nil {
+		panic("exception during staging cluster boot: " + err.Error())
+	}
+	return raftServer, raftTransportTcpAddr
+}
+
+func TestListenerRedis_Listen(t *testing.T) {
+	binaryBody := randomBody(1024)
+	tests := []struct {
+		name        string
+		bootstrap   bool
+		publish     [][]string
+		want        []message.Message
+		wantPending int64
+		// wantDeadLetters are compared by the body
+		wantDeadLetters []string
+		// invalidSchedulePolicy of the channel source, dead letters by default
+		invalidSchedulePolicy string
+	}{
+		{
+			name:      "Check redis listener can receive single message",
+			bootstrap: true,
+			publish: [][]string{
+				{FieldBody, "foo", FieldAvailableAt, "1000"},
+			},
+			want:        []message.Message{message.NewMessage([]byte("foo"), 1000000)},
+			wantPending: 0,
+		},
+		{
+			name:      "Check redis listener keeps entry fields as attributes",
+			bootstrap: true,
+			publish: [][]string{
+				{FieldBody, "foo", FieldAvailableAt, "1000", "type", "bar"},
+			},
+			want:        []message.Message{message.NewMessageWithAttributes([]byte("foo"), 1000000, map[string]string{"type": "bar"})},
+			wantPending: 0,
+		},
+		{
+			name:      "Check redis listener can receive binary message",
+			bootstrap: true,
+			publish: [][]string{
+				{FieldBody, string(binaryBody), FieldAvailableAt, "1000"},
+			},
+			want:        []message.Message{message.NewMessage(binaryBody, 1000000)},
+			wantPending: 0,
+		},
+		{
+			name:      "Check redis listener acks messages without available_at field",
+			bootstrap: true,
+			publish: [][]string{
+				{FieldBody, "foo"},
+				{FieldBody, "bar", FieldAvailableAt, "bar"},
+			},
+			want:            []message.Message{},
+			wantPending:     0,
+			wantDeadLetters: []string{"bar"},
+		},
+		{
+			name:      "Check redis listener drops messages with wrong available_at field",
+			bootstrap: true,
+			publish: [][]string{
+				{FieldBody, "bar", FieldAvailableAt, "bar"},
+			},
+			want:                  []message.Message{},
+			wantPending:           0,
+			invalidSchedulePolicy: channel.InvalidSchedulePolicyDrop,
+		},
+		{
+			name:      "Check redis listener can receive multiple messages",
+			bootstrap: true,
+			publish: [][]string{
+				{FieldBody, "msg1", FieldAvailableAt, "1000"},
+				{FieldBody, "msg2", FieldAvailableAt, "1100"},
+				{FieldBody, "msg3", FieldAvailableAt, "1200"},
+			},
+			want: []message.Message{
+				message.NewMessage([]byte("msg1"), 1000000),
+				message.NewMessage([]byte("msg2"), 1100000),
+				message.NewMessage([]byte("msg3"), 1200000),
+			},
+			wantPending: 0,
+		},
+		{
+			name:      "Check redis listener doesn't ack messages which weren't persisted",
+			bootstrap: false,
+			publish: [][]string{
+				{FieldBody, "foo", FieldAvailableAt, "1000"},
+			},
+			want:        []message.Message{},
+			wantPending: 1,
+		},
+	}
+	for testID, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+			defer cancel()
+
+			redisServer, err := miniredis.Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer redisServer.Close()
+
+			pqStorage := storage.NewPqStorage()
+			nodeId := string(rune(testID))
+			cluster, clusterAddr := bootStagingCluster(nodeId, pqStorage)
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+
+			p := new(prioritizer.Prioritizer)
+			_ = p.Boot(cluster)
+
+			if tt.bootstrap {
+				f := cluster.BootstrapCluster(raft.Configuration{Servers: []raft.Server{
+					{
+						Suffrage: raft.Voter,
+						ID:       raft.ServerID(nodeId),
+						Address:  clusterAddr,
+					},
+				}})
+				if err := f.Error(); err != nil {
+					t.Fatal("Cluster bootstrap failed: ", err)
+				}
+				// wait for election
+				time.Sleep(time.Second * 1)
+			}
+
+			for _, values := range tt.publish {
+				_, err := redisServer.XAdd("scheduled", "*", values)
+				assert.NoError(t, err)
+			}
+
+			c := channel.Channel{ID: "ch1", Source: channel.Source{
+				Driver:                "redis",
+				InvalidSchedulePolicy: tt.invalidSchedulePolicy,
+				Config: redisconfig.SourceConfig{
+					Addr:   redisServer.Addr(),
+					Stream: "scheduled",
+					Group:  "event-scheduler",
+				},
+			}}
+			_, _ = pqStorage.AddChannel(c)
+
+			l := &Listener{}
+			assert.NoError(t, l.Boot(ctx, c, p))
+			assert.NoError(t, l.Listen())
+
+			chStorage, _ := pqStorage.GetChannelStorage(c.ID)
+			got := []message.Message{}
+			for !chStorage.IsEmpty() {
+				msg := chStorage.Dequeue()
+				// message IDs are checked separately
+				assert.NotEmpty(t, msg.GetID())
+				got = append(got, msg.WithID(""))
+			}
+			assert.ElementsMatch(t, tt.want, got)
+			var gotDeadLetters []string
+			for _, deadLetter := range chStorage.GetDeadLetters() {
+				assert.NotEmpty(t, deadLetter.Message.GetAttributes()[message.AttributeFailureReason])
+				gotDeadLetters = append(gotDeadLetters, string(deadLetter.Message.GetBody()))
+			}
+			assert.Equal(t, tt.wantDeadLetters, gotDeadLetters)
+
+			client := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+			defer func() {
+				_ = client.Close()
+			}()
+			pending, err := client.XPending(context.Background(), "scheduled", "event-scheduler").Result()
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.wantPending, pending.Count)
+			}
+		})
+	}
+}
+
+func TestListenerRedis_ListenWithoutServer(t *testing.T) {
+	l := &Listener{}
+	_ = l.Boot(context.Background(), channel.Channel{Source: channel.Source{
+		Driver: "redis",
+		Config: redisconfig.SourceConfig{
+			Addr:   "127.0.0.1:1",
+			Stream: "scheduled",
+			Group:  "event-scheduler",
+		},
+	}}, new(prioritizer.Prioritizer))
+	assert.Error(t, l.Listen())
+}
+
+func randomBody(size int) []byte {
+	body := make([]byte, size)
+	_, _ = rand.Read(body)
+	return body
+}
@@ -1,11 +1,94 @@
 package message
 
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+)
+
+// AttributeAvailableAt is the scheduling attribute, it's kept in AvailableAt rather than in Attributes
+const AttributeAvailableAt = "available_at"
+
+// AttributeCancelID makes the source message cancel the scheduled message with the ID instead of being scheduled
+const AttributeCancelID = "cancel_id"
+
+// AttributeFailureReason describes why the message was moved to the dead letters
+const AttributeFailureReason = "failure_reason"
+
 type Message struct {
+	// ID is the source message ID or the generated one, it identifies the message within the channel
+	ID string
+	// AvailableAt is the scheduling time in Unix milliseconds
 	AvailableAt int
-	Body        interface{}
+	Body        []byte
+	Attributes  map[string]string
+	// ScheduleID refers to the recurring schedule which emitted the message
+	ScheduleID string
+}
+
+// encodedMessage is the message representation in raft log and snapshots
+type encodedMessage struct {
+	ID string `json:",omitempty"`
+	// AvailableAt is the scheduling time in seconds of messages encoded before millisecond precision
+	AvailableAt   int `json:",omitempty"`
+	AvailableAtMs int `json:",omitempty"`
+	// Data is the base64 encoded body
+	Data []byte
+	// Body is the text body of messages encoded before bodies became binary
+	Body       *string `json:",omitempty"`
+	Attributes map[string]string
+	ScheduleID string `json:",omitempty"`
+}
+
+func (msg Message) MarshalJSON() ([]byte, error) {
+	return json.Marshal(encodedMessage{
+		ID:            msg.ID,
+		AvailableAtMs: msg.AvailableAt,
+		Data:          msg.Body,
+		Attributes:    msg.Attributes,
+		ScheduleID:    msg.ScheduleID,
+	})
+}
+
+// UnmarshalJSON decodes both binary and legacy text bodies, legacy scheduling time in seconds is converted to milliseconds
+func (msg *Message) UnmarshalJSON(data []byte) error {
+	var encoded encodedMessage
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	msg.ID, msg.AvailableAt, msg.Body, msg.Attributes = encoded.ID, encoded.AvailableAtMs, encoded.Data, encoded.Attributes
+	msg.ScheduleID = encoded.ScheduleID
+	if encoded.AvailableAtMs == 0 {
+		msg.AvailableAt = encoded.AvailableAt * 1000
+	}
+	if encoded.Body != nil {
+		msg.Body = []byte(*encoded.Body)
+	}
+	return nil
+}
+
+func (msg Message) GetID() string {
+	return msg.ID
+}
+
+// WithID returns a copy of the message with the ID set
+func (msg Message) WithID(id string) Message {
+	msg.ID = id
+	return msg
+}
+
+func (msg Message) GetScheduleID() string {
+	return msg.ScheduleID
+}
+
+// WithScheduleID returns a copy of the message emitted by the schedule
+func (msg Message) WithScheduleID(scheduleID string) Message {
+	msg.ScheduleID = scheduleID
+	return msg
 }
 
-func (msg Message) GetBody() interface{} {
+func (msg Message) GetBody() []byte {
 	return msg.Body
 }
 
@@ -13,9 +96,79 @@ func (msg Message) GetAvailableAt() int {
 	return msg.AvailableAt
 }
 
-func NewMessage(body interface{}, availableAt int) Message {
+func (msg Message) GetAttributes() map[string]string {
+	return msg.Attributes
+}
+
+// WithAttribute returns a copy of the message with the attribute set, the original attributes aren't modified
+func (msg Message) WithAttribute(key string, value string) Message {
+	attributes := make(map[string]string, len(msg.Attributes)+1)
+	for k, v := range msg.Attributes {
+		attributes[k] = v
+	}
+	attributes[key] = value
+	msg.Attributes = attributes
+	return msg
+}
+
+// WithoutAttribute returns a copy of the message without the attribute, the original attributes aren't modified.
+// Attributes are dropped when the last one is removed
+func (msg Message) WithoutAttribute(key string) Message {
+	if _, has := msg.Attributes[key]; !has {
+		return msg
+	}
+	if len(msg.Attributes) == 1 {
+		msg.Attributes = nil
+		return msg
+	}
+	attributes := make(map[string]string, len(msg.Attributes))
+	for k, v := range msg.Attributes {
+		if k != key {
+			attributes[k] = v
+		}
+	}
+	msg.Attributes = attributes
+	return msg
+}
+
+func NewMessage(body []byte, availableAt int) Message {
 	return Message{
 		AvailableAt: availableAt,
 		Body:        body,
 	}
 }
+
+// NewMessageWithAttributes creates the message with source attributes except the available_at one
+func NewMessageWithAttributes(body []byte, availableAt int, attributes map[string]string) Message {
+	msg := NewMessage(body, availableAt)
+	for k, v := range attributes {
+		if k == AttributeAvailableAt {
+			continue
+		}
+		if msg.Attributes == nil {
+			msg.Attributes = make(map[string]string, len(attributes))
+		}
+		msg.Attributes[k] = v
+	}
+	return msg
+}
+
+// UnixMilli returns the time in the AvailableAt precision
+func UnixMilli(t time.Time) int {
+	return int(t.UnixNano() / int64(time.Millisecond))
+}
+
+// FromSeconds converts epoch seconds with milliseconds fraction to the AvailableAt precision
+func FromSeconds(seconds float64) int {
+	return int(math.Round(seconds * 1000))
+}
+
+// Seconds converts the AvailableAt precision to epoch seconds, milliseconds are kept as the fraction
+func Seconds(availableAt int) float64 {
+	return float64(availableAt) / 1000
+}
+
+// FormatAvailableAt formats the scheduling time as epoch seconds, milliseconds are kept as the fraction
+func FormatAvailableAt(availableAt int) string {
+	return strconv.FormatFloat(Seconds(availableAt), 'f', -1, 64)
+}
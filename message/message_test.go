@@ -1,15 +1,18 @@
 package message
 
 import (
+	"crypto/rand"
+	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestMessage_GetAvailableAt(t *testing.T) {
 	type fields struct {
 		availableAt int
-		body        interface{}
+		body        []byte
 	}
 	tests := []struct {
 		name   string
@@ -20,7 +23,7 @@ func TestMessage_GetAvailableAt(t *testing.T) {
 			name: "Zero AvailableAt",
 			fields: fields{
 				availableAt: 0,
-				body:        "foo",
+				body:        []byte("foo"),
 			},
 			want: 0,
 		},
@@ -28,7 +31,7 @@ func TestMessage_GetAvailableAt(t *testing.T) {
 			name: "Nonzero AvailableAt",
 			fields: fields{
 				availableAt: 10000,
-				body:        "foo",
+				body:        []byte("foo"),
 			},
 			want: 10000,
 		},
@@ -49,28 +52,28 @@ func TestMessage_GetAvailableAt(t *testing.T) {
 func TestMessage_GetBody(t *testing.T) {
 	type fields struct {
 		availableAt int
-		body        interface{}
+		body        []byte
 	}
 	tests := []struct {
 		name   string
 		fields fields
-		want   interface{}
+		want   []byte
 	}{
 		{
 			name: "Empty Body",
 			fields: fields{
 				availableAt: 1000,
-				body:        "",
+				body:        []byte(""),
 			},
-			want: "",
+			want: []byte(""),
 		},
 		{
 			name: "Nonempty Body",
 			fields: fields{
 				availableAt: 10000,
-				body:        "foo",
+				body:        []byte("foo"),
 			},
-			want: "foo",
+			want: []byte("foo"),
 		},
 	}
 	for _, tt := range tests {
@@ -88,7 +91,7 @@ func TestMessage_GetBody(t *testing.T) {
 
 func TestNewMessage(t *testing.T) {
 	type args struct {
-		body        interface{}
+		body        []byte
 		availableAt int
 	}
 	tests := []struct {
@@ -97,14 +100,14 @@ func TestNewMessage(t *testing.T) {
 		want Message
 	}{
 		{
-			name: "Message with string Body",
+			name: "Message with binary Body",
 			args: args{
 				availableAt: 1000,
-				body:        "foo",
+				body:        []byte("foo"),
 			},
 			want: Message{
 				AvailableAt: 1000,
-				Body:        "foo",
+				Body:        []byte("foo"),
 			},
 		},
 	}
@@ -116,3 +119,145 @@ func TestNewMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestNewMessageWithAttributes(t *testing.T) {
+	type args struct {
+		body        []byte
+		availableAt int
+		attributes  map[string]string
+	}
+	tests := []struct {
+		name string
+		args args
+		want Message
+	}{
+		{
+			name: "Message with attributes",
+			args: args{
+				availableAt: 1000,
+				body:        []byte("foo"),
+				attributes:  map[string]string{"type": "bar"},
+			},
+			want: Message{
+				AvailableAt: 1000,
+				Body:        []byte("foo"),
+				Attributes:  map[string]string{"type": "bar"},
+			},
+		},
+		{
+			name: "Message without available_at attribute",
+			args: args{
+				availableAt: 1000,
+				body:        []byte("foo"),
+				attributes:  map[string]string{"type": "bar", AttributeAvailableAt: "1000"},
+			},
+			want: Message{
+				AvailableAt: 1000,
+				Body:        []byte("foo"),
+				Attributes:  map[string]string{"type": "bar"},
+			},
+		},
+		{
+			name: "Message with available_at attribute only",
+			args: args{
+				availableAt: 1000,
+				body:        []byte("foo"),
+				attributes:  map[string]string{AttributeAvailableAt: "1000"},
+			},
+			want: NewMessage([]byte("foo"), 1000),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, NewMessageWithAttributes(tt.args.body, tt.args.availableAt, tt.args.attributes))
+		})
+	}
+}
+
+func TestMessage_WithAttribute(t *testing.T) {
+	msg := NewMessageWithAttributes([]byte("foo"), 1000, map[string]string{"type": "bar"})
+	got := msg.WithAttribute(AttributeAvailableAt, "1000")
+	assert.Equal(t, map[string]string{"type": "bar", AttributeAvailableAt: "1000"}, got.GetAttributes())
+	// original message isn't modified
+	assert.Equal(t, map[string]string{"type": "bar"}, msg.GetAttributes())
+}
+
+func randomBody(size int) []byte {
+	body := make([]byte, size)
+	_, _ = rand.Read(body)
+	return body
+}
+
+func TestMessage_JSON(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{
+			name: "Message with empty body",
+			msg:  NewMessage([]byte{}, 1000),
+		},
+		{
+			name: "Message with nil body",
+			msg:  NewMessage(nil, 1000),
+		},
+		{
+			name: "Message with invalid utf-8 body",
+			msg:  NewMessage([]byte{0xff, 0xfe, 0, '\n', '"'}, 1000),
+		},
+		{
+			name: "Message with random binary body",
+			msg:  NewMessageWithAttributes(randomBody(1024), 1000, map[string]string{"type": "bar"}),
+		},
+		{
+			name: "Message emitted by schedule",
+			msg:  NewMessage([]byte("foo"), 1000).WithID("sch1/1000").WithScheduleID("sch1"),
+		},
+		{
+			name: "Message with large random binary body",
+			msg:  NewMessage(randomBody(1024*1024), 1000),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.msg)
+			assert.NoError(t, err)
+			var got Message
+			assert.NoError(t, json.Unmarshal(data, &got))
+			assert.Equal(t, tt.msg, got)
+		})
+	}
+}
+
+func TestMessage_UnmarshalLegacyJSON(t *testing.T) {
+	var got Message
+	assert.NoError(t, json.Unmarshal([]byte(`{"AvailableAt":1000,"Body":"foo"}`), &got))
+	assert.Equal(t, NewMessage([]byte("foo"), 1000000), got)
+
+	// scheduling time in seconds is converted to milliseconds
+	got = Message{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"AvailableAt":1000,"Data":"Zm9v"}`), &got))
+	assert.Equal(t, NewMessage([]byte("foo"), 1000000), got)
+}
+
+func TestMessage_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal(NewMessage([]byte("foo"), 1000250))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"AvailableAtMs":1000250,"Data":"Zm9v","Attributes":null}`, string(data))
+}
+
+func TestUnixMilli(t *testing.T) {
+	assert.Equal(t, 1614556800250, UnixMilli(time.Date(2021, 3, 1, 0, 0, 0, 250999999, time.UTC)))
+}
+
+func TestFromSeconds(t *testing.T) {
+	assert.Equal(t, 1614556800000, FromSeconds(1614556800))
+	assert.Equal(t, 1614556800123, FromSeconds(1614556800.123))
+	assert.Equal(t, 1614556800.123, Seconds(1614556800123))
+}
+
+func TestFormatAvailableAt(t *testing.T) {
+	assert.Equal(t, "1614556800", FormatAvailableAt(1614556800000))
+	assert.Equal(t, "1614556800.25", FormatAvailableAt(1614556800250))
+	assert.Equal(t, "0.001", FormatAvailableAt(1))
+}
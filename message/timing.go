@@ -0,0 +1,77 @@
+package message
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AttributeDelaySeconds and AttributeDelay are the relative scheduling attributes, the delay is added to the publish time
+const AttributeDelaySeconds = "delay_seconds"
+const AttributeDelay = "delay"
+
+var ErrInvalidAvailableAt = errors.New("available_at must be epoch seconds or RFC 3339 time")
+var ErrInvalidDelay = errors.New("delay must be non-negative seconds, Go duration or ISO-8601 duration")
+
+var isoDuration = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:[.,]\d+)?)S)?)?$`)
+
+// ParseAvailableAt reads the scheduling time given as epoch seconds (with milliseconds fraction) or RFC 3339 time
+// and returns it in milliseconds
+func ParseAvailableAt(value string) (int, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return seconds * 1000, nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(seconds, 0) && !math.IsNaN(seconds) {
+		return FromSeconds(seconds), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return 0, ErrInvalidAvailableAt
+	}
+	return UnixMilli(t), nil
+}
+
+// ParseDelay reads the delay given as seconds, Go duration ("1h30m") or ISO-8601 duration ("PT1H30M")
+// and returns the scheduling time in milliseconds relative to from, calendar ISO-8601 components are added in UTC
+func ParseDelay(value string, from time.Time) (int, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, ErrInvalidDelay
+		}
+		return UnixMilli(from) + seconds*1000, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 {
+			return 0, ErrInvalidDelay
+		}
+		return UnixMilli(from.Add(d)), nil
+	}
+	t, err := addISODuration(value, from.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return UnixMilli(t), nil
+}
+
+func addISODuration(value string, from time.Time) (time.Time, error) {
+	parts := isoDuration.FindStringSubmatch(value)
+	// "P" and "PT" alone are not durations
+	if parts == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return time.Time{}, ErrInvalidDelay
+	}
+	n := make([]int, 6)
+	for i := range n {
+		if parts[i+1] != "" {
+			n[i], _ = strconv.Atoi(parts[i+1])
+		}
+	}
+	var seconds float64
+	if parts[7] != "" {
+		seconds, _ = strconv.ParseFloat(strings.Replace(parts[7], ",", ".", 1), 64)
+	}
+	t := from.AddDate(n[0], n[1], n[2]*7+n[3])
+	return t.Add(time.Duration(n[4])*time.Hour + time.Duration(n[5])*time.Minute + time.Duration(seconds*float64(time.Second))), nil
+}
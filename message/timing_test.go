@@ -0,0 +1,70 @@
+package message
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseAvailableAt(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{name: "Check epoch seconds", value: "1614556800", want: 1614556800000},
+		{name: "Check epoch seconds with milliseconds", value: "1614556800.25", want: 1614556800250},
+		{name: "Check RFC 3339 time", value: "2021-03-01T00:00:00Z", want: 1614556800000},
+		{name: "Check RFC 3339 time with milliseconds", value: "2021-03-01T00:00:00.123Z", want: 1614556800123},
+		{name: "Check RFC 3339 time with offset", value: "2021-03-01T02:00:00+02:00", want: 1614556800000},
+		{name: "Check invalid time", value: "tomorrow", wantErr: true},
+		{name: "Check infinite time", value: "Inf", wantErr: true},
+		{name: "Check empty time", value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAvailableAt(tt.value)
+			if tt.wantErr {
+				assert.Equal(t, ErrInvalidAvailableAt, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseDelay(t *testing.T) {
+	from := time.Date(2021, 1, 31, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "Check seconds", value: "90", want: from.Add(90 * time.Second)},
+		{name: "Check Go duration", value: "1h30m", want: from.Add(90 * time.Minute)},
+		{name: "Check Go duration with milliseconds", value: "1.25s", want: from.Add(1250 * time.Millisecond)},
+		{name: "Check ISO-8601 time duration", value: "PT1H30M", want: from.Add(90 * time.Minute)},
+		{name: "Check ISO-8601 fractional seconds", value: "PT1,5S", want: from.Add(1500 * time.Millisecond)},
+		{name: "Check ISO-8601 weeks", value: "P2W", want: from.AddDate(0, 0, 14)},
+		{name: "Check ISO-8601 calendar duration", value: "P1Y1M1DT1H", want: time.Date(2022, 3, 4, 1, 0, 0, 0, time.UTC)},
+		{name: "Check negative seconds", value: "-1", wantErr: true},
+		{name: "Check negative Go duration", value: "-1m", wantErr: true},
+		{name: "Check empty ISO-8601 duration", value: "PT", wantErr: true},
+		{name: "Check invalid ISO-8601 duration", value: "P1H", wantErr: true},
+		{name: "Check invalid delay", value: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDelay(tt.value, from)
+			if tt.wantErr {
+				assert.Equal(t, ErrInvalidDelay, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, UnixMilli(tt.want), got)
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package messagemanager
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/storage"
+	"github.com/satori/go.uuid"
+	"net/http"
+	"time"
+)
+
+type MessageManagerServer interface {
+	PushMessage(ctx echo.Context) error
+	GetMessage(ctx echo.Context) error
+	CancelMessage(ctx echo.Context) error
+	RescheduleMessage(ctx echo.Context) error
+	ListDeadLetters(ctx echo.Context) error
+	ReplayDeadLetters(ctx echo.Context) error
+}
+
+type SchedulerMessageManagerServer struct {
+	manager    MessageManager
+	httpServer *echo.Echo
+}
+
+func (m *SchedulerMessageManagerServer) BootMessageManagerServer(manager MessageManager, httpServer *echo.Echo) error {
+	m.manager = manager
+	m.httpServer = httpServer
+	m.initHttpRoutes()
+	return nil
+}
+
+func (m *SchedulerMessageManagerServer) initHttpRoutes() {
+	m.httpServer.POST("/channels/:id/messages", m.PushMessage)
+	m.httpServer.GET("/channels/:id/messages/:messageId", m.GetMessage)
+	m.httpServer.DELETE("/channels/:id/messages/:messageId", m.CancelMessage)
+	m.httpServer.PATCH("/channels/:id/messages/:messageId", m.RescheduleMessage)
+	m.httpServer.GET("/channels/:id/dead-letters", m.ListDeadLetters)
+	m.httpServer.POST("/channels/:id/dead-letters/replay", m.ReplayDeadLetters)
+}
+
+const EncodingBase64 = "base64"
+
+type MessageInput struct {
+	// ID is generated when it's not provided
+	ID   string `json:"id" form:"id" query:"id"`
+	Body string `json:"body" form:"body" query:"body" validate:"required"`
+	// Encoding of the body, binary bodies are sent base64 encoded
+	Encoding string `json:"encoding" form:"encoding" query:"encoding" validate:"omitempty,oneof=base64"`
+	// AvailableAt and Delay are seconds, the fraction keeps milliseconds
+	AvailableAt float64 `json:"available_at" form:"available_at" query:"available_at" validate:"required_without=Delay,excluded_with=Delay,min=0"`
+	// Delay is a pointer to tell a zero delay, delivered right away, from a missing one
+	Delay *float64 `json:"delay" form:"delay" query:"delay" validate:"omitempty,min=0"`
+	// Attributes are re-emitted by the destination publisher
+	Attributes map[string]string `json:"attributes" form:"attributes" query:"attributes"`
+}
+
+// availableAt resolves the delay relative to the request time, the result is in milliseconds
+func (i MessageInput) availableAt() int {
+	if i.Delay != nil {
+		return message.UnixMilli(time.Now()) + message.FromSeconds(*i.Delay)
+	}
+	return message.FromSeconds(i.AvailableAt)
+}
+
+// body decodes the message body according to the input encoding
+func (i MessageInput) body() ([]byte, error) {
+	if i.Encoding == EncodingBase64 {
+		return base64.StdEncoding.DecodeString(i.Body)
+	}
+	return []byte(i.Body), nil
+}
+
+func (m *SchedulerMessageManagerServer) PushMessage(ctx echo.Context) error {
+	channelID := ctx.Param("id")
+	msg := new(MessageInput)
+	if err := ctx.Bind(&msg); err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error binding: %s", err.Error()),
+		})
+	}
+	if err := ctx.Validate(msg); err != nil {
+		return echo.NewHTTPError(http.StatusNotAcceptable, err.Error())
+	}
+	body, err := msg.body()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotAcceptable, fmt.Sprintf("error decoding body: %s", err.Error()))
+	}
+	availableAt := msg.availableAt()
+	messageID := msg.ID
+	if messageID == "" {
+		// generated here to let the client refer to the message later
+		messageID = uuid.NewV4().String()
+	}
+	err = m.manager.PushMessage(channelID, message.NewMessageWithAttributes(body, availableAt, msg.Attributes).WithID(messageID))
+	if err == storage.ErrChannelNotFound {
+		return ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error pushing message %s: %s", ctx, err.Error()),
+		})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error pushing message %s: %s", ctx, err.Error()),
+		})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"status":      true,
+		"channelID":   channelID,
+		"messageID":   messageID,
+		"availableAt": message.Seconds(availableAt),
+	})
+}
+
+type RescheduleInput struct {
+	// AvailableAt and Delay are seconds, the fraction keeps milliseconds
+	AvailableAt float64 `json:"available_at" form:"available_at" query:"available_at" validate:"required_without=Delay,excluded_with=Delay,min=0"`
+	// Delay is a pointer to tell a zero delay, delivered right away, from a missing one
+	Delay *float64 `json:"delay" form:"delay" query:"delay" validate:"omitempty,min=0"`
+}
+
+// availableAt resolves the delay relative to the request time, the result is in milliseconds
+func (i RescheduleInput) availableAt() int {
+	if i.Delay != nil {
+		return message.UnixMilli(time.Now()) + message.FromSeconds(*i.Delay)
+	}
+	return message.FromSeconds(i.AvailableAt)
+}
+
+type MessageOutput struct {
+	ID string `json:"id"`
+	// AvailableAt is seconds, the fraction keeps milliseconds
+	AvailableAt float64           `json:"available_at"`
+	BodySize    int               `json:"body_size"`
+	Attributes  map[string]string `json:"attributes"`
+}
+
+func (m *SchedulerMessageManagerServer) GetMessage(ctx echo.Context) error {
+	channelID, messageID := ctx.Param("id"), ctx.Param("messageId")
+	msg, err := m.manager.GetMessage(channelID, messageID)
+	if err == storage.ErrChannelNotFound || err == storage.ErrMessageNotFound {
+		return ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error getting message %s: %s", ctx, err.Error()),
+		})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error getting message %s: %s", ctx, err.Error()),
+		})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"status":    true,
+		"channelID": channelID,
+		"message":   newMessageOutput(msg),
+	})
+}
+
+func newMessageOutput(msg message.Message) MessageOutput {
+	return MessageOutput{
+		ID:          msg.GetID(),
+		AvailableAt: message.Seconds(msg.GetAvailableAt()),
+		BodySize:    len(msg.GetBody()),
+		Attributes:  msg.GetAttributes(),
+	}
+}
+
+func (m *SchedulerMessageManagerServer) CancelMessage(ctx echo.Context) error {
+	channelID, messageID := ctx.Param("id"), ctx.Param("messageId")
+	err := m.manager.CancelMessage(channelID, messageID)
+	if err == storage.ErrChannelNotFound || err == storage.ErrMessageNotFound {
+		return ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error cancelling message %s: %s", ctx, err.Error()),
+		})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error cancelling message %s: %s", ctx, err.Error()),
+		})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"status":    true,
+		"channelID": channelID,
+		"messageID": messageID,
+	})
+}
+
+func (m *SchedulerMessageManagerServer) RescheduleMessage(ctx echo.Context) error {
+	channelID, messageID := ctx.Param("id"), ctx.Param("messageId")
+	input := new(RescheduleInput)
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error binding: %s", err.Error()),
+		})
+	}
+	if err := ctx.Validate(input); err != nil {
+		return echo.NewHTTPError(http.StatusNotAcceptable, err.Error())
+	}
+	msg, err := m.manager.RescheduleMessage(channelID, messageID, input.availableAt())
+	if err == storage.ErrChannelNotFound || err == storage.ErrMessageNotFound {
+		return ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error rescheduling message %s: %s", ctx, err.Error()),
+		})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error rescheduling message %s: %s", ctx, err.Error()),
+		})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"status":    true,
+		"channelID": channelID,
+		"message":   newMessageOutput(msg),
+	})
+}
+
+type DeadLetterOutput struct {
+	ID      string        `json:"id"`
+	Message MessageOutput `json:"message"`
+	Reason  string        `json:"reason"`
+	// FailedAt is seconds, the fraction keeps milliseconds
+	FailedAt float64 `json:"failed_at"`
+}
+
+func (m *SchedulerMessageManagerServer) ListDeadLetters(ctx echo.Context) error {
+	channelID := ctx.Param("id")
+	deadLetters, err := m.manager.ListDeadLetters(channelID)
+	if err == storage.ErrChannelNotFound {
+		return ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error listing dead letters %s: %s", ctx, err.Error()),
+		})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error listing dead letters %s: %s", ctx, err.Error()),
+		})
+	}
+	output := make([]DeadLetterOutput, 0, len(deadLetters))
+	for _, deadLetter := range deadLetters {
+		output = append(output, DeadLetterOutput{
+			ID:       deadLetter.ID,
+			Message:  newMessageOutput(deadLetter.Message),
+			Reason:   deadLetter.Reason,
+			FailedAt: message.Seconds(deadLetter.FailedAt),
+		})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"status":      true,
+		"channelID":   channelID,
+		"deadLetters": output,
+	})
+}
+
+type ReplayInput struct {
+	IDs []string `json:"ids" form:"ids" query:"ids" validate:"required,min=1,dive,required"`
+	// AvailableAt and Delay are seconds, the fraction keeps milliseconds, messages are replayed immediately when both are omitted
+	AvailableAt float64 `json:"available_at" form:"available_at" query:"available_at" validate:"excluded_with=Delay,min=0"`
+	Delay       float64 `json:"delay" form:"delay" query:"delay" validate:"min=0"`
+}
+
+// availableAt resolves the delay relative to the request time, the result is in milliseconds
+func (i ReplayInput) availableAt() int {
+	if i.AvailableAt > 0 {
+		return message.FromSeconds(i.AvailableAt)
+	}
+	return message.UnixMilli(time.Now()) + message.FromSeconds(i.Delay)
+}
+
+func (m *SchedulerMessageManagerServer) ReplayDeadLetters(ctx echo.Context) error {
+	channelID := ctx.Param("id")
+	input := new(ReplayInput)
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error binding: %s", err.Error()),
+		})
+	}
+	if err := ctx.Validate(input); err != nil {
+		return echo.NewHTTPError(http.StatusNotAcceptable, err.Error())
+	}
+	replayed, err := m.manager.ReplayDeadLetters(channelID, input.IDs, input.availableAt())
+	if err == storage.ErrChannelNotFound || err == storage.ErrDeadLetterNotFound {
+		return ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error replaying dead letters %s: %s", ctx, err.Error()),
+		})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error replaying dead letters %s: %s", ctx, err.Error()),
+		})
+	}
+	output := make([]MessageOutput, 0, len(replayed))
+	for _, msg := range replayed {
+		output = append(output, newMessageOutput(msg))
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"status":    true,
+		"channelID": channelID,
+		"messages":  output,
+	})
+}
@@ -0,0 +1,693 @@
+package messagemanager
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/httpvalidator"
+	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/storage"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchedulerMessageManagerServer_BootMessageManagerServer(t *testing.T) {
+	m := NewSchedulerMessageManager(nil, nil, nil)
+	httpServer := echo.New()
+
+	ms := &SchedulerMessageManagerServer{}
+	assert.NoError(t, ms.BootMessageManagerServer(m, httpServer))
+	assert.Equal(t, m, ms.manager)
+	assert.Equal(t, httpServer, ms.httpServer)
+}
+
+func TestSchedulerMessageManagerServer_PushMessage(t *testing.T) {
+	binaryBody := randomBody(1024)
+	type args struct {
+		channelId string
+		jsonInput string
+	}
+	tests := []struct {
+		name           string
+		args           args
+		channels       []channel.Channel
+		wantErr        bool
+		wantStatusCode int
+		want           []message.Message
+	}{
+		{
+			name: "Check push message API with available_at",
+			args: args{
+				channelId: "ch1",
+				jsonInput: "{\"body\":\"foo\",\"available_at\":1000}",
+			},
+			channels: []channel.Channel{
+				{ID: "ch1", Source: channel.Source{Driver: "http"}},
+			},
+			wantErr:        false,
+			wantStatusCode: http.StatusOK,
+			want:           []message.Message{message.NewMessage([]byte("foo"), 1000000)},
+		},
+		{
+			name: "Check push message API with fractional available_at",
+			args: args{
+				channelId: "ch1",
+				jsonInput: "{\"body\":\"foo\",\"available_at\":1000.25}",
+			},
+			channels: []channel.Channel{
+				{ID: "ch1", Source: channel.Source{Driver: "http"}},
+			},
+			wantErr:        false,
+			wantStatusCode: http.StatusOK,
+			want:           []message.Message{message.NewMessage([]byte("foo"), 1000250)},
+		},
+		{
+			name: "Check push message API with attributes",
+			args: args{
+				channelId: "ch1",
+				jsonInput: "{\"body\":\"foo\",\"available_at\":1000,\"attributes\":{\"type\":\"bar\",\"available_at\":\"5\"}}",
+			},
+			channels: []channel.Channel{
+				{ID: "ch1", Source: channel.Source{Driver: "http"}},
+			},
+			wantErr:        false,
+			wantStatusCode: http.StatusOK,
+			want:           []message.Message{message.NewMessageWithAttributes([]byte("foo"), 1000000, map[string]string{"type": "bar"})},
+		},
+		{
+			name: "Check push message API with base64 encoded binary body",
+			args: args{
+				channelId: "ch1",
+				jsonInput: "{\"body\":\"" + base64.StdEncoding.EncodeToString(binaryBody) + "\",\"encoding\":\"base64\",\"available_at\":1000}",
+			},
+			channels: []channel.Channel{
+				{ID: "ch1", Source: channel.Source{Driver: "http"}},
+			},
+			wantErr:        false,
+			wantStatusCode: http.StatusOK,
+			want:           []message.Message{message.NewMessage(binaryBody, 1000000)},
+		},
+		{
+			name: "Check push message API with malformed base64 body",
+			args: args{
+				channelId: "ch1",
+				jsonInput: "{\"body\":\"foo\",\"encoding\":\"base64\",\"available_at\":1000}",
+			},
+			channels: []channel.Channel{
+				{ID: "ch1", Source: channel.Source{Driver: "http"}},
+			},
+			wantErr: true,
+			want:    []message.Message{},
+		},
+		{
+			name: "Check push message API with unsupported body encoding",
+			args: args{
+				channelId: "ch1",
+				jsonInput: "{\"body\":\"foo\",\"encoding\":\"hex\",\"available_at\":1000}",
+			},
+			channels: []channel.Channel{
+				{ID: "ch1", Source: channel.Source{Driver: "http"}},
+			},
+			wantErr: true,
+			want:    []message.Message{},
+		},
+		{
+			name: "Check push message API into non-http channel",
+			args: args{
+				channelId: "ch1",
+				jsonInput: "{\"body\":\"foo\",\"available_at\":1000}",
+			},
+			channels: []channel.Channel{
+				{ID: "ch1", Source: channel.Source{Driver: "pubsub"}},
+			},
+			wantErr:        false,
+			wantStatusCode: http.StatusUnprocessableEntity,
+			want:           []message.Message{},
+		},
+		{
+			name: "Check push message API into non-existing channel",
+			args: args{
+				channelId: "ch1",
+				jsonInput: "{\"body\":\"foo\",\"available_at\":1000}",
+			},
+			channels:       []channel.Channel{},
+			wantErr:        false,
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name: "Check push message API without body",
+			args: args{
+				channelId: "ch1",
+				jsonInput: "{\"available_at\":1000}",
+			},
+			channels: []channel.Channel{
+				{ID: "ch1", Source: channel.Source{Driver: "http"}},
+			},
+			wantErr: true,
+			want:    []message.Message{},
+		},
+		{
+			name: "Check push message API without schedule",
+			args: args{
+				channelId: "ch1",
+				jsonInput: "{\"body\":\"foo\"}",
+			},
+			channels: []channel.Channel{
+				{ID: "ch1", Source: channel.Source{Driver: "http"}},
+			},
+			wantErr: true,
+			want:    []message.Message{},
+		},
+		{
+			name: "Check push message API with both available_at and delay",
+			args: args{
+				channelId: "ch1",
+				jsonInput: "{\"body\":\"foo\",\"available_at\":1000,\"delay\":60}",
+			},
+			channels: []channel.Channel{
+				{ID: "ch1", Source: channel.Source{Driver: "http"}},
+			},
+			wantErr: true,
+			want:    []message.Message{},
+		},
+		{
+			name: "Check push message API with negative delay",
+			args: args{
+				channelId: "ch1",
+				jsonInput: "{\"body\":\"foo\",\"delay\":-60}",
+			},
+			channels: []channel.Channel{
+				{ID: "ch1", Source: channel.Source{Driver: "http"}},
+			},
+			wantErr: true,
+			want:    []message.Message{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, pqStorage, p := bootLeader()
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+
+			for _, c := range tt.channels {
+				_, _ = pqStorage.AddChannel(c)
+			}
+
+			ms := &SchedulerMessageManagerServer{
+				manager:    NewSchedulerMessageManager(cluster, pqStorage, p),
+				httpServer: echo.New(),
+			}
+
+			ms.httpServer.Validator = &httpvalidator.HttpValidator{Validator: validator.New()}
+
+			// mock http request
+			req := httptest.NewRequest(http.MethodPost, "/channels/:id/messages", strings.NewReader(tt.args.jsonInput))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := ms.httpServer.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(tt.args.channelId)
+
+			r := ms.PushMessage(c)
+			if tt.wantErr {
+				assert.Error(t, r)
+			} else if assert.NoError(t, r) {
+				assert.Equal(t, tt.wantStatusCode, rec.Code)
+				assert.NotEmpty(t, rec.Body.String())
+			}
+
+			if tt.want != nil {
+				chStorage, _ := pqStorage.GetChannelStorage(tt.args.channelId)
+				got := []message.Message{}
+				for !chStorage.IsEmpty() {
+					msg := chStorage.Dequeue()
+					// message IDs are checked separately
+					assert.NotEmpty(t, msg.GetID())
+					got = append(got, msg.WithID(""))
+				}
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSchedulerMessageManagerServer_PushMessageWithDelay(t *testing.T) {
+	tests := []struct {
+		name      string
+		jsonInput string
+		delay     int
+	}{
+		{
+			name:      "Check push message API with delay",
+			jsonInput: "{\"body\":\"foo\",\"delay\":60}",
+			delay:     60000,
+		},
+		{
+			name:      "Check push message API with zero delay",
+			jsonInput: "{\"body\":\"foo\",\"delay\":0}",
+			delay:     0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, pqStorage, p := bootLeader()
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+
+			_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1", Source: channel.Source{Driver: "http"}})
+
+			ms := &SchedulerMessageManagerServer{
+				manager:    NewSchedulerMessageManager(cluster, pqStorage, p),
+				httpServer: echo.New(),
+			}
+			ms.httpServer.Validator = &httpvalidator.HttpValidator{Validator: validator.New()}
+
+			req := httptest.NewRequest(http.MethodPost, "/channels/:id/messages", strings.NewReader(tt.jsonInput))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := ms.httpServer.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues("ch1")
+
+			before := message.UnixMilli(time.Now())
+			assert.NoError(t, ms.PushMessage(c))
+			after := message.UnixMilli(time.Now())
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			var resp struct {
+				MessageID   string  `json:"messageID"`
+				AvailableAt float64 `json:"availableAt"`
+			}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			availableAt := message.FromSeconds(resp.AvailableAt)
+			assert.GreaterOrEqual(t, availableAt, before+tt.delay)
+			assert.LessOrEqual(t, availableAt, after+tt.delay)
+
+			chStorage, _ := pqStorage.GetChannelStorage("ch1")
+			assert.NotEmpty(t, resp.MessageID)
+			assert.Equal(t, message.NewMessage([]byte("foo"), availableAt).WithID(resp.MessageID), chStorage.Dequeue())
+		})
+	}
+}
+
+func randomBody(size int) []byte {
+	body := make([]byte, size)
+	_, _ = rand.Read(body)
+	return body
+}
+
+func TestSchedulerMessageManagerServer_PushMessageWithID(t *testing.T) {
+	cluster, pqStorage, p := bootLeader()
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+	_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1", Source: channel.Source{Driver: "http"}})
+
+	ms := &SchedulerMessageManagerServer{
+		manager:    NewSchedulerMessageManager(cluster, pqStorage, p),
+		httpServer: echo.New(),
+	}
+	ms.httpServer.Validator = &httpvalidator.HttpValidator{Validator: validator.New()}
+
+	req := httptest.NewRequest(http.MethodPost, "/channels/:id/messages", strings.NewReader("{\"id\":\"id1\",\"body\":\"foo\",\"available_at\":1000}"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := ms.httpServer.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("ch1")
+
+	assert.NoError(t, ms.PushMessage(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp struct {
+		MessageID string `json:"messageID"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "id1", resp.MessageID)
+
+	got, err := pqStorage.GetMessage("ch1", "id1")
+	assert.NoError(t, err)
+	assert.Equal(t, message.NewMessage([]byte("foo"), 1000000).WithID("id1"), got)
+}
+
+func TestSchedulerMessageManagerServer_GetMessage(t *testing.T) {
+	tests := []struct {
+		name           string
+		channelId      string
+		messageId      string
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			name:           "Check get message API",
+			channelId:      "ch1",
+			messageId:      "id1",
+			wantStatusCode: http.StatusOK,
+			wantBody:       "{\"channelID\":\"ch1\",\"message\":{\"id\":\"id1\",\"available_at\":1000,\"body_size\":3,\"attributes\":{\"type\":\"bar\"}},\"status\":true}",
+		},
+		{
+			name:           "Check get message API with missing message",
+			channelId:      "ch1",
+			messageId:      "id2",
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Check get message API with non-existing channel",
+			channelId:      "ch2",
+			messageId:      "id1",
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, pqStorage, p := bootLeader()
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+			_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1", Source: channel.Source{Driver: "http"}})
+
+			m := NewSchedulerMessageManager(cluster, pqStorage, p)
+			assert.NoError(t, m.PushMessage("ch1", message.NewMessageWithAttributes([]byte("foo"), 1000000, map[string]string{"type": "bar"}).WithID("id1")))
+
+			ms := &SchedulerMessageManagerServer{
+				manager:    m,
+				httpServer: echo.New(),
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/channels/:id/messages/:messageId", nil)
+			rec := httptest.NewRecorder()
+			c := ms.httpServer.NewContext(req, rec)
+			c.SetParamNames("id", "messageId")
+			c.SetParamValues(tt.channelId, tt.messageId)
+
+			assert.NoError(t, ms.GetMessage(c))
+			assert.Equal(t, tt.wantStatusCode, rec.Code)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSchedulerMessageManagerServer_CancelMessage(t *testing.T) {
+	tests := []struct {
+		name           string
+		channelId      string
+		messageId      string
+		wantStatusCode int
+		wantMessages   int
+	}{
+		{
+			name:           "Check cancel message API",
+			channelId:      "ch1",
+			messageId:      "id1",
+			wantStatusCode: http.StatusOK,
+			wantMessages:   0,
+		},
+		{
+			name:           "Check cancel message API with missing message",
+			channelId:      "ch1",
+			messageId:      "id2",
+			wantStatusCode: http.StatusNotFound,
+			wantMessages:   1,
+		},
+		{
+			name:           "Check cancel message API with non-existing channel",
+			channelId:      "ch2",
+			messageId:      "id1",
+			wantStatusCode: http.StatusNotFound,
+			wantMessages:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, pqStorage, p := bootLeader()
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+			_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1", Source: channel.Source{Driver: "http"}})
+
+			m := NewSchedulerMessageManager(cluster, pqStorage, p)
+			assert.NoError(t, m.PushMessage("ch1", message.NewMessage([]byte("foo"), 1000000).WithID("id1")))
+
+			ms := &SchedulerMessageManagerServer{
+				manager:    m,
+				httpServer: echo.New(),
+			}
+
+			req := httptest.NewRequest(http.MethodDelete, "/channels/:id/messages/:messageId", nil)
+			rec := httptest.NewRecorder()
+			c := ms.httpServer.NewContext(req, rec)
+			c.SetParamNames("id", "messageId")
+			c.SetParamValues(tt.channelId, tt.messageId)
+
+			assert.NoError(t, ms.CancelMessage(c))
+			assert.Equal(t, tt.wantStatusCode, rec.Code)
+			_, messages := pqStorage.Dump()
+			assert.Len(t, messages["ch1"], tt.wantMessages)
+		})
+	}
+}
+
+func TestSchedulerMessageManagerServer_RescheduleMessage(t *testing.T) {
+	tests := []struct {
+		name           string
+		channelId      string
+		messageId      string
+		jsonInput      string
+		wantErr        bool
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			name:           "Check reschedule message API",
+			channelId:      "ch1",
+			messageId:      "id1",
+			jsonInput:      "{\"available_at\":2000}",
+			wantStatusCode: http.StatusOK,
+			wantBody:       "{\"channelID\":\"ch1\",\"message\":{\"id\":\"id1\",\"available_at\":2000,\"body_size\":3,\"attributes\":null},\"status\":true}",
+		},
+		{
+			name:           "Check reschedule message API without time",
+			channelId:      "ch1",
+			messageId:      "id1",
+			jsonInput:      "{}",
+			wantErr:        true,
+			wantStatusCode: http.StatusNotAcceptable,
+		},
+		{
+			name:           "Check reschedule message API with zero delay",
+			channelId:      "ch1",
+			messageId:      "id1",
+			jsonInput:      "{\"delay\":0}",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Check reschedule message API with negative delay",
+			channelId:      "ch1",
+			messageId:      "id1",
+			jsonInput:      "{\"delay\":-60}",
+			wantErr:        true,
+			wantStatusCode: http.StatusNotAcceptable,
+		},
+		{
+			name:           "Check reschedule message API with missing message",
+			channelId:      "ch1",
+			messageId:      "id2",
+			jsonInput:      "{\"delay\":60}",
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Check reschedule message API with non-existing channel",
+			channelId:      "ch2",
+			messageId:      "id1",
+			jsonInput:      "{\"available_at\":2000}",
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, pqStorage, p := bootLeader()
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+			_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1", Source: channel.Source{Driver: "http"}})
+
+			m := NewSchedulerMessageManager(cluster, pqStorage, p)
+			assert.NoError(t, m.PushMessage("ch1", message.NewMessage([]byte("foo"), 1000000).WithID("id1")))
+
+			ms := &SchedulerMessageManagerServer{
+				manager:    m,
+				httpServer: echo.New(),
+			}
+			ms.httpServer.Validator = &httpvalidator.HttpValidator{Validator: validator.New()}
+
+			req := httptest.NewRequest(http.MethodPatch, "/channels/:id/messages/:messageId", strings.NewReader(tt.jsonInput))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := ms.httpServer.NewContext(req, rec)
+			c.SetParamNames("id", "messageId")
+			c.SetParamValues(tt.channelId, tt.messageId)
+
+			err := ms.RescheduleMessage(c)
+			if tt.wantErr {
+				if assert.Error(t, err) {
+					assert.Equal(t, tt.wantStatusCode, err.(*echo.HTTPError).Code)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatusCode, rec.Code)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSchedulerMessageManagerServer_ListDeadLetters(t *testing.T) {
+	tests := []struct {
+		name           string
+		channelId      string
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			name:           "Check list dead letters API",
+			channelId:      "ch1",
+			wantStatusCode: http.StatusOK,
+			wantBody:       "{\"channelID\":\"ch1\",\"deadLetters\":[{\"id\":\"dl1\",\"message\":{\"id\":\"id1\",\"available_at\":1000,\"body_size\":3,\"attributes\":{\"failure_reason\":\"unreachable\"}},\"reason\":\"unreachable\",\"failed_at\":1500.25}],\"status\":true}",
+		},
+		{
+			name:           "Check list dead letters API with non-existing channel",
+			channelId:      "ch2",
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, pqStorage, p := bootLeader()
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+			_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1", Source: channel.Source{Driver: "http"}})
+			chStorage, _ := pqStorage.GetChannelStorage("ch1")
+			chStorage.AddDeadLetter(storage.DeadLetter{
+				ID:       "dl1",
+				Message:  message.NewMessageWithAttributes([]byte("foo"), 1000000, map[string]string{message.AttributeFailureReason: "unreachable"}).WithID("id1"),
+				Reason:   "unreachable",
+				FailedAt: 1500250,
+			})
+
+			ms := &SchedulerMessageManagerServer{
+				manager:    NewSchedulerMessageManager(cluster, pqStorage, p),
+				httpServer: echo.New(),
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/channels/:id/dead-letters", nil)
+			rec := httptest.NewRecorder()
+			c := ms.httpServer.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(tt.channelId)
+
+			assert.NoError(t, ms.ListDeadLetters(c))
+			assert.Equal(t, tt.wantStatusCode, rec.Code)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSchedulerMessageManagerServer_ReplayDeadLetters(t *testing.T) {
+	tests := []struct {
+		name           string
+		channelId      string
+		jsonInput      string
+		wantErr        bool
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			name:           "Check replay dead letters API",
+			channelId:      "ch1",
+			jsonInput:      "{\"ids\":[\"dl1\"],\"available_at\":2000}",
+			wantStatusCode: http.StatusOK,
+			wantBody:       "{\"channelID\":\"ch1\",\"messages\":[{\"id\":\"id1\",\"available_at\":2000,\"body_size\":3,\"attributes\":null}],\"status\":true}",
+		},
+		{
+			name:           "Check replay dead letters API without time",
+			channelId:      "ch1",
+			jsonInput:      "{\"ids\":[\"dl1\"]}",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Check replay dead letters API without IDs",
+			channelId:      "ch1",
+			jsonInput:      "{\"available_at\":2000}",
+			wantErr:        true,
+			wantStatusCode: http.StatusNotAcceptable,
+		},
+		{
+			name:           "Check replay dead letters API with missing dead letter",
+			channelId:      "ch1",
+			jsonInput:      "{\"ids\":[\"dl1\",\"dl2\"]}",
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Check replay dead letters API with non-existing channel",
+			channelId:      "ch2",
+			jsonInput:      "{\"ids\":[\"dl1\"]}",
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, pqStorage, p := bootLeader()
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+			_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1", Source: channel.Source{Driver: "http"}})
+			chStorage, _ := pqStorage.GetChannelStorage("ch1")
+			chStorage.AddDeadLetter(storage.DeadLetter{
+				ID:      "dl1",
+				Message: message.NewMessageWithAttributes([]byte("foo"), 1000000, map[string]string{message.AttributeFailureReason: "unreachable"}).WithID("id1"),
+				Reason:  "unreachable",
+			})
+
+			ms := &SchedulerMessageManagerServer{
+				manager:    NewSchedulerMessageManager(cluster, pqStorage, p),
+				httpServer: echo.New(),
+			}
+			ms.httpServer.Validator = &httpvalidator.HttpValidator{Validator: validator.New()}
+
+			req := httptest.NewRequest(http.MethodPost, "/channels/:id/dead-letters/replay", strings.NewReader(tt.jsonInput))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := ms.httpServer.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(tt.channelId)
+
+			err := ms.ReplayDeadLetters(c)
+			if tt.wantErr {
+				if assert.Error(t, err) {
+					assert.Equal(t, tt.wantStatusCode, err.(*echo.HTTPError).Code)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatusCode, rec.Code)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package messagemanager
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/maksimru/event-scheduler/errormessages"
+	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/prioritizer"
+	"github.com/maksimru/event-scheduler/storage"
+)
+
+type MessageManager interface {
+	PushMessage(channelID string, msg message.Message) error
+	GetMessage(channelID string, messageID string) (message.Message, error)
+	CancelMessage(channelID string, messageID string) error
+	RescheduleMessage(channelID string, messageID string, availableAt int) (message.Message, error)
+	ListDeadLetters(channelID string) ([]storage.DeadLetter, error)
+	ReplayDeadLetters(channelID string, deadLetterIDs []string, availableAt int) ([]message.Message, error)
+}
+
+type SchedulerMessageManager struct {
+	cluster     *raft.Raft
+	storage     *storage.PqStorage
+	prioritizer *prioritizer.Prioritizer
+}
+
+func NewSchedulerMessageManager(cluster *raft.Raft, storage *storage.PqStorage, prioritizer *prioritizer.Prioritizer) *SchedulerMessageManager {
+	return &SchedulerMessageManager{
+		cluster:     cluster,
+		storage:     storage,
+		prioritizer: prioritizer,
+	}
+}
+
+func (m *SchedulerMessageManager) BootMessageManager(cluster *raft.Raft, storage *storage.PqStorage, prioritizer *prioritizer.Prioritizer) error {
+	m.cluster = cluster
+	m.storage = storage
+	m.prioritizer = prioritizer
+	return nil
+}
+
+// PushMessage persists the message into the channel with http source driver, it returns once the message is committed
+func (m *SchedulerMessageManager) PushMessage(channelID string, msg message.Message) error {
+	if m.cluster.State() != raft.Leader {
+		return errormessages.ErrOperationIsRestrictedOnNonLeader
+	}
+	c, err := m.storage.GetChannel(channelID)
+	if err != nil {
+		return err
+	}
+	if c.Source.Driver != "http" {
+		return errormessages.ErrChannelSourceIsNotHttp
+	}
+	return m.prioritizer.Persist(msg, c)
+}
+
+// GetMessage looks up the scheduled message, dispatched messages aren't available
+func (m *SchedulerMessageManager) GetMessage(channelID string, messageID string) (message.Message, error) {
+	if m.cluster.State() != raft.Leader {
+		return message.Message{}, errormessages.ErrOperationIsRestrictedOnNonLeader
+	}
+	return m.storage.GetMessage(channelID, messageID)
+}
+
+// CancelMessage removes scheduled messages with the ID, it returns once the cancellation is committed
+func (m *SchedulerMessageManager) CancelMessage(channelID string, messageID string) error {
+	if m.cluster.State() != raft.Leader {
+		return errormessages.ErrOperationIsRestrictedOnNonLeader
+	}
+	c, err := m.storage.GetChannel(channelID)
+	if err != nil {
+		return err
+	}
+	return m.prioritizer.Cancel(messageID, c)
+}
+
+// RescheduleMessage moves scheduled messages with the ID to the new time, it returns once the change is committed
+func (m *SchedulerMessageManager) RescheduleMessage(channelID string, messageID string, availableAt int) (message.Message, error) {
+	if m.cluster.State() != raft.Leader {
+		return message.Message{}, errormessages.ErrOperationIsRestrictedOnNonLeader
+	}
+	c, err := m.storage.GetChannel(channelID)
+	if err != nil {
+		return message.Message{}, err
+	}
+	return m.prioritizer.Reschedule(messageID, availableAt, c)
+}
+
+// ListDeadLetters returns dead letters of the channel ordered by the failure time
+func (m *SchedulerMessageManager) ListDeadLetters(channelID string) ([]storage.DeadLetter, error) {
+	if m.cluster.State() != raft.Leader {
+		return nil, errormessages.ErrOperationIsRestrictedOnNonLeader
+	}
+	return m.storage.GetDeadLetters(channelID)
+}
+
+// ReplayDeadLetters schedules messages of the dead letters to the time, it returns once the replay is committed
+func (m *SchedulerMessageManager) ReplayDeadLetters(channelID string, deadLetterIDs []string, availableAt int) ([]message.Message, error) {
+	if m.cluster.State() != raft.Leader {
+		return nil, errormessages.ErrOperationIsRestrictedOnNonLeader
+	}
+	c, err := m.storage.GetChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	return m.prioritizer.Replay(deadLetterIDs, availableAt, c)
+}
@@ -0,0 +1,403 @@
+package messagemanager
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/errormessages"
+	"github.com/maksimru/event-scheduler/fsm"
+	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/nodenameresolver"
+	"github.com/maksimru/event-scheduler/prioritizer"
+	"github.com/maksimru/event-scheduler/storage"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func inmemConfig() *raft.Config {
+	conf := raft.DefaultConfig()
+	conf.HeartbeatTimeout = 50 * time.Millisecond
+	conf.ElectionTimeout = 50 * time.Millisecond
+	conf.LeaderLeaseTimeout = 50 * time.Millisecond
+	conf.CommitTimeout = 5 * time.Millisecond
+	return conf
+}
+
+func bootStagingCluster() (*raft.Raft, *raft.InmemTransport, *storage.PqStorage) {
+	store := raft.NewInmemStore()
+	cacheStore, _ := raft.NewLogCache(128, store)
+	snapshotStore := raft.NewInmemSnapshotStore()
+	_, transport := raft.NewInmemTransport("")
+	raftconfig := inmemConfig()
+	raftconfig.LogLevel = "info"
+	raftconfig.LocalID = nodenameresolver.Resolve(string(transport.LocalAddr()))
+	raftconfig.SnapshotThreshold = 512
+	dataStorage := storage.NewPqStorage()
+	raftServer, err := raft.NewRaft(raftconfig, fsm.NewPrioritizedFSM(dataStorage), cacheStore, store, snapshotStore, transport)
+	if err != nil {
+		panic("exception during staging cluster boot: " + err.Error())
+	}
+	return raftServer, transport, dataStorage
+}
+
+// bootLeader boots single node staging cluster and waits for it to become leader
+func bootLeader() (*raft.Raft, *storage.PqStorage, *prioritizer.Prioritizer) {
+	cluster, clusterTransport, pqStorage := bootStagingCluster()
+	cluster.BootstrapCluster(raft.Configuration{Servers: []raft.Server{
+		{
+			Suffrage: raft.Voter,
+			ID:       nodenameresolver.Resolve(string(clusterTransport.LocalAddr())),
+			Address:  clusterTransport.LocalAddr(),
+		},
+	}})
+
+	// wait for election
+	time.Sleep(time.Second * 1)
+
+	p := new(prioritizer.Prioritizer)
+	_ = p.Boot(cluster)
+	return cluster, pqStorage, p
+}
+
+func TestSchedulerMessageManager_BootMessageManager(t *testing.T) {
+	cluster := &raft.Raft{}
+	pqStorage := storage.NewPqStorage()
+	p := new(prioritizer.Prioritizer)
+
+	m := &SchedulerMessageManager{}
+	assert.NoError(t, m.BootMessageManager(cluster, pqStorage, p))
+	assert.Equal(t, cluster, m.cluster)
+	assert.Equal(t, pqStorage, m.storage)
+	assert.Equal(t, p, m.prioritizer)
+}
+
+func TestSchedulerMessageManager_PushMessage(t *testing.T) {
+	type args struct {
+		channelID string
+		msg       message.Message
+	}
+	tests := []struct {
+		name     string
+		channels []channel.Channel
+		args     args
+		want     []message.Message
+		wantErr  error
+	}{
+		{
+			name: "Check push message into http channel",
+			channels: []channel.Channel{
+				{ID: "ch1", Source: channel.Source{Driver: "http"}},
+			},
+			args: args{
+				channelID: "ch1",
+				msg:       message.NewMessage([]byte("foo"), 1000),
+			},
+			want: []message.Message{message.NewMessage([]byte("foo"), 1000)},
+		},
+		{
+			name: "Check push message into channel with another source driver",
+			channels: []channel.Channel{
+				{ID: "ch1", Source: channel.Source{Driver: "pubsub"}},
+			},
+			args: args{
+				channelID: "ch1",
+				msg:       message.NewMessage([]byte("foo"), 1000),
+			},
+			want:    []message.Message{},
+			wantErr: errormessages.ErrChannelSourceIsNotHttp,
+		},
+		{
+			name:     "Check push message into non-existing channel",
+			channels: []channel.Channel{},
+			args: args{
+				channelID: "ch1",
+				msg:       message.NewMessage([]byte("foo"), 1000),
+			},
+			wantErr: storage.ErrChannelNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, pqStorage, p := bootLeader()
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+
+			for _, c := range tt.channels {
+				_, _ = pqStorage.AddChannel(c)
+			}
+
+			m := NewSchedulerMessageManager(cluster, pqStorage, p)
+			err := m.PushMessage(tt.args.channelID, tt.args.msg)
+			assert.Equal(t, tt.wantErr, err)
+
+			if tt.want != nil {
+				chStorage, _ := pqStorage.GetChannelStorage(tt.args.channelID)
+				got := []message.Message{}
+				for !chStorage.IsEmpty() {
+					msg := chStorage.Dequeue()
+					// message IDs are checked separately
+					assert.NotEmpty(t, msg.GetID())
+					got = append(got, msg.WithID(""))
+				}
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSchedulerMessageManager_PushMessageOnNonLeader(t *testing.T) {
+	cluster, _, pqStorage := bootStagingCluster()
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+
+	m := NewSchedulerMessageManager(cluster, pqStorage, new(prioritizer.Prioritizer))
+	assert.Equal(t, errormessages.ErrOperationIsRestrictedOnNonLeader, m.PushMessage("ch1", message.NewMessage([]byte("foo"), 1000)))
+}
+
+func TestSchedulerMessageManager_GetMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		channelID string
+		messageID string
+		want      message.Message
+		wantErr   error
+	}{
+		{
+			name:      "Check get scheduled message",
+			channelID: "ch1",
+			messageID: "id1",
+			want:      message.NewMessageWithAttributes([]byte("foo"), 1000, map[string]string{"type": "bar"}).WithID("id1"),
+		},
+		{
+			name:      "Check get missing message",
+			channelID: "ch1",
+			messageID: "id2",
+			wantErr:   storage.ErrMessageNotFound,
+		},
+		{
+			name:      "Check get message from non-existing channel",
+			channelID: "ch2",
+			messageID: "id1",
+			wantErr:   storage.ErrChannelNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, pqStorage, p := bootLeader()
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+			_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1", Source: channel.Source{Driver: "http"}})
+
+			m := NewSchedulerMessageManager(cluster, pqStorage, p)
+			assert.NoError(t, m.PushMessage("ch1", message.NewMessageWithAttributes([]byte("foo"), 1000, map[string]string{"type": "bar"}).WithID("id1")))
+
+			got, err := m.GetMessage(tt.channelID, tt.messageID)
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSchedulerMessageManager_GetMessageOnNonLeader(t *testing.T) {
+	cluster, _, pqStorage := bootStagingCluster()
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+
+	m := NewSchedulerMessageManager(cluster, pqStorage, new(prioritizer.Prioritizer))
+	_, err := m.GetMessage("ch1", "id1")
+	assert.Equal(t, errormessages.ErrOperationIsRestrictedOnNonLeader, err)
+}
+
+func TestSchedulerMessageManager_CancelMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		channelID string
+		messageID string
+		want      []message.Message
+		wantErr   error
+	}{
+		{
+			name:      "Check cancel scheduled message",
+			channelID: "ch1",
+			messageID: "id1",
+			want:      []message.Message{message.NewMessage([]byte("bar"), 1100).WithID("id2")},
+		},
+		{
+			name:      "Check cancel missing message",
+			channelID: "ch1",
+			messageID: "id3",
+			want: []message.Message{
+				message.NewMessage([]byte("foo"), 1000).WithID("id1"),
+				message.NewMessage([]byte("bar"), 1100).WithID("id2"),
+			},
+			wantErr: storage.ErrMessageNotFound,
+		},
+		{
+			name:      "Check cancel message in non-existing channel",
+			channelID: "ch2",
+			messageID: "id1",
+			want: []message.Message{
+				message.NewMessage([]byte("foo"), 1000).WithID("id1"),
+				message.NewMessage([]byte("bar"), 1100).WithID("id2"),
+			},
+			wantErr: storage.ErrChannelNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, pqStorage, p := bootLeader()
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+			_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1", Source: channel.Source{Driver: "http"}})
+
+			m := NewSchedulerMessageManager(cluster, pqStorage, p)
+			assert.NoError(t, m.PushMessage("ch1", message.NewMessage([]byte("foo"), 1000).WithID("id1")))
+			assert.NoError(t, m.PushMessage("ch1", message.NewMessage([]byte("bar"), 1100).WithID("id2")))
+
+			assert.Equal(t, tt.wantErr, m.CancelMessage(tt.channelID, tt.messageID))
+			_, messages := pqStorage.Dump()
+			assert.Equal(t, tt.want, messages["ch1"])
+		})
+	}
+}
+
+func TestSchedulerMessageManager_CancelMessageOnNonLeader(t *testing.T) {
+	cluster, _, pqStorage := bootStagingCluster()
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+
+	m := NewSchedulerMessageManager(cluster, pqStorage, new(prioritizer.Prioritizer))
+	assert.Equal(t, errormessages.ErrOperationIsRestrictedOnNonLeader, m.CancelMessage("ch1", "id1"))
+}
+
+func TestSchedulerMessageManager_RescheduleMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		channelID string
+		messageID string
+		want      message.Message
+		wantErr   error
+	}{
+		{
+			name:      "Check reschedule message",
+			channelID: "ch1",
+			messageID: "id1",
+			want:      message.NewMessage([]byte("foo"), 2000).WithID("id1"),
+		},
+		{
+			name:      "Check reschedule missing message",
+			channelID: "ch1",
+			messageID: "id2",
+			wantErr:   storage.ErrMessageNotFound,
+		},
+		{
+			name:      "Check reschedule message in non-existing channel",
+			channelID: "ch2",
+			messageID: "id1",
+			wantErr:   storage.ErrChannelNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, pqStorage, p := bootLeader()
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+			_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1", Source: channel.Source{Driver: "http"}})
+
+			m := NewSchedulerMessageManager(cluster, pqStorage, p)
+			assert.NoError(t, m.PushMessage("ch1", message.NewMessage([]byte("foo"), 1000).WithID("id1")))
+
+			got, err := m.RescheduleMessage(tt.channelID, tt.messageID, 2000)
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSchedulerMessageManager_ListDeadLetters(t *testing.T) {
+	cluster, pqStorage, p := bootLeader()
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+	c := channel.Channel{ID: "ch1", Source: channel.Source{Driver: "http"}}
+	_, _ = pqStorage.AddChannel(c)
+
+	m := NewSchedulerMessageManager(cluster, pqStorage, p)
+	deadLetter, err := p.DeadLetter(message.NewMessage([]byte("foo"), 1000).WithID("id1"), "unreachable", c)
+	assert.NoError(t, err)
+
+	got, err := m.ListDeadLetters("ch1")
+	assert.NoError(t, err)
+	assert.Equal(t, []storage.DeadLetter{deadLetter}, got)
+
+	_, err = m.ListDeadLetters("ch2")
+	assert.Equal(t, storage.ErrChannelNotFound, err)
+}
+
+func TestSchedulerMessageManager_ReplayDeadLetters(t *testing.T) {
+	tests := []struct {
+		name      string
+		channelID string
+		// deadLetterIDs are replaced by the ID of the stored dead letter when empty
+		deadLetterIDs []string
+		want          []message.Message
+		wantErr       error
+	}{
+		{
+			name:      "Check replay dead letter",
+			channelID: "ch1",
+			want:      []message.Message{message.NewMessage([]byte("foo"), 2000).WithID("id1")},
+		},
+		{
+			name:          "Check replay missing dead letter",
+			channelID:     "ch1",
+			deadLetterIDs: []string{"missing"},
+			wantErr:       storage.ErrDeadLetterNotFound,
+		},
+		{
+			name:      "Check replay dead letter in non-existing channel",
+			channelID: "ch2",
+			wantErr:   storage.ErrChannelNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, pqStorage, p := bootLeader()
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+			c := channel.Channel{ID: "ch1", Source: channel.Source{Driver: "http"}}
+			_, _ = pqStorage.AddChannel(c)
+
+			m := NewSchedulerMessageManager(cluster, pqStorage, p)
+			deadLetter, err := p.DeadLetter(message.NewMessage([]byte("foo"), 1000).WithID("id1"), "unreachable", c)
+			assert.NoError(t, err)
+			deadLetterIDs := tt.deadLetterIDs
+			if deadLetterIDs == nil {
+				deadLetterIDs = []string{deadLetter.ID}
+			}
+
+			got, err := m.ReplayDeadLetters(tt.channelID, deadLetterIDs, 2000)
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSchedulerMessageManager_ReplayDeadLettersOnNonLeader(t *testing.T) {
+	cluster, _, pqStorage := bootStagingCluster()
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+
+	m := NewSchedulerMessageManager(cluster, pqStorage, new(prioritizer.Prioritizer))
+	_, err := m.ReplayDeadLetters("ch1", []string{"1"}, 2000)
+	assert.Equal(t, errormessages.ErrOperationIsRestrictedOnNonLeader, err)
+}
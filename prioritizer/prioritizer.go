@@ -5,26 +5,171 @@ import (
 	"errors"
 	"github.com/hashicorp/raft"
 	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/dispatcher"
 	"github.com/maksimru/event-scheduler/fsm"
 	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/storage"
+	"github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
+	"sync"
 	"time"
 )
 
 type Prioritizer struct {
-	cluster *raft.Raft
+	cluster   *raft.Raft
+	batchSize int
+	// dispatcher forwards dead letters to the channel dead letter destination
+	dispatcher dispatcher.Dispatcher
+	// concurrent persists are grouped, they wait for the running apply and are applied by the next one
+	mutex    sync.Mutex
+	pending  []*persistRequest
+	applying bool
 }
 
+// DefaultBatchSize is the maximum number of messages pushed by one cluster command
+const DefaultBatchSize = 100
+
+type persistRequest struct {
+	message   message.Message
+	channelID string
+	// done receives the result, lead asks the waiting request to apply the pending ones
+	done chan error
+	lead chan struct{}
+}
+
+// SetBatchSize limits the number of concurrently persisted messages pushed by one cluster command, batch size 1 pushes them one by one
+func (p *Prioritizer) SetBatchSize(batchSize int) {
+	p.batchSize = batchSize
+}
+
+// SetDispatcher enables forwarding of dead letters to the channel dead letter destinations
+func (p *Prioritizer) SetDispatcher(dispatcher dispatcher.Dispatcher) {
+	p.dispatcher = dispatcher
+}
+
+// Persist pushes the message to the channel, it returns once the message is replicated
 func (p *Prioritizer) Persist(persistedMsg message.Message, channel channel.Channel) error {
-	// push through FSM
+	// ID is generated before the replication, so all nodes store the same one
+	if persistedMsg.GetID() == "" {
+		persistedMsg = persistedMsg.WithID(uuid.NewV4().String())
+	}
+	req := &persistRequest{
+		message:   persistedMsg,
+		channelID: channel.ID,
+		done:      make(chan error, 1),
+		lead:      make(chan struct{}, 1),
+	}
+	p.mutex.Lock()
+	p.pending = append(p.pending, req)
+	if !p.applying {
+		p.applying = true
+		req.lead <- struct{}{}
+	}
+	p.mutex.Unlock()
+	for {
+		select {
+		case err := <-req.done:
+			return err
+		case <-req.lead:
+			p.applyPending()
+		}
+	}
+}
+
+// applyPending pushes the pending messages by one command and hands the next ones over to the first waiting request
+func (p *Prioritizer) applyPending() {
+	p.mutex.Lock()
+	n := len(p.pending)
+	if n > p.batchSize {
+		n = p.batchSize
+	}
+	if n < 1 {
+		n = 1
+	}
+	batch := p.pending[:n]
+	p.pending = p.pending[n:]
+	p.mutex.Unlock()
+
+	errs := p.push(batch)
+	for i, req := range batch {
+		if errs[i] == storage.ErrMessageDuplicated {
+			// the message is already scheduled, so the source message is acknowledged
+			log.Trace("prioritizer duplicated message is dropped: ", req.message.GetID())
+			errs[i] = nil
+		}
+		req.done <- errs[i]
+	}
+
+	p.mutex.Lock()
+	if len(p.pending) > 0 {
+		p.pending[0].lead <- struct{}{}
+	} else {
+		p.applying = false
+	}
+	p.mutex.Unlock()
+}
+
+// push applies the batch through FSM and returns the result of every message, single message is pushed by the plain command
+func (p *Prioritizer) push(batch []*persistRequest) []error {
+	errs := make([]error, len(batch))
 	opPayload := fsm.CommandPayload{
 		Operation: fsm.OperationMessagePush,
-		Message:   persistedMsg,
-		ChannelID: channel.ID,
+		Message:   batch[0].message,
+		ChannelID: batch[0].channelID,
+		Timestamp: message.UnixMilli(time.Now()),
+	}
+	if len(batch) > 1 {
+		opPayload = fsm.CommandPayload{
+			Operation: fsm.OperationMessagePushBatch,
+			Messages:  make([]fsm.ChannelMessage, len(batch)),
+			Timestamp: opPayload.Timestamp,
+		}
+		for i, req := range batch {
+			opPayload.Messages[i] = fsm.ChannelMessage{ChannelID: req.channelID, Message: req.message}
+		}
+	}
+	fail := func(err error) []error {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
 	}
 	opPayloadData, err := json.Marshal(opPayload)
 	if err != nil {
 		log.Error("prioritizer error preparing saving data payload: ", err.Error())
+		return fail(err)
+	}
+	applyFuture := p.cluster.Apply(opPayloadData, 500*time.Millisecond)
+	if err := applyFuture.Error(); err != nil {
+		log.Error("prioritizer error persisting data in raft cluster: ", err.Error())
+		return fail(err)
+	}
+	r, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		log.Error("prioritizer error parsing apply response")
+		return fail(errors.New("fsm response failed"))
+	}
+	responses, ok := r.Data.([]fsm.ApplyResponse)
+	if !ok {
+		return fail(r.Err)
+	}
+	for i, response := range responses {
+		errs[i] = response.Err
+	}
+	return errs
+}
+
+// Cancel removes scheduled messages with the ID from the channel, it returns storage.ErrMessageNotFound if there are no such messages
+func (p *Prioritizer) Cancel(messageID string, channel channel.Channel) error {
+	// cancel through FSM
+	opPayload := fsm.CommandPayload{
+		Operation: fsm.OperationMessageCancel,
+		MessageID: messageID,
+		ChannelID: channel.ID,
+	}
+	opPayloadData, err := json.Marshal(opPayload)
+	if err != nil {
+		log.Error("prioritizer error preparing cancel data payload: ", err.Error())
 		return err
 	}
 	applyFuture := p.cluster.Apply(opPayloadData, 500*time.Millisecond)
@@ -32,15 +177,110 @@ func (p *Prioritizer) Persist(persistedMsg message.Message, channel channel.Chan
 		log.Error("prioritizer error persisting data in raft cluster: ", err.Error())
 		return err
 	}
-	_, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	r, ok := applyFuture.Response().(*fsm.ApplyResponse)
 	if !ok {
 		log.Error("prioritizer error parsing apply response")
 		return errors.New("fsm response failed")
 	}
-	return nil
+	return r.Err
+}
+
+// Reschedule moves scheduled messages with the ID to the new time, it returns storage.ErrMessageNotFound if there are no such messages
+func (p *Prioritizer) Reschedule(messageID string, availableAt int, channel channel.Channel) (message.Message, error) {
+	// reschedule through FSM
+	opPayload := fsm.CommandPayload{
+		Operation: fsm.OperationMessageReschedule,
+		MessageID: messageID,
+		Timestamp: availableAt,
+		ChannelID: channel.ID,
+	}
+	opPayloadData, err := json.Marshal(opPayload)
+	if err != nil {
+		log.Error("prioritizer error preparing reschedule data payload: ", err.Error())
+		return message.Message{}, err
+	}
+	applyFuture := p.cluster.Apply(opPayloadData, 500*time.Millisecond)
+	if err := applyFuture.Error(); err != nil {
+		log.Error("prioritizer error persisting data in raft cluster: ", err.Error())
+		return message.Message{}, err
+	}
+	r, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		log.Error("prioritizer error parsing apply response")
+		return message.Message{}, errors.New("fsm response failed")
+	}
+	if r.Err != nil {
+		return message.Message{}, r.Err
+	}
+	return r.Data.(message.Message), nil
+}
+
+// DeadLetter keeps the message which can't be scheduled in the channel dead letters, it returns once the dead letter is replicated
+func (p *Prioritizer) DeadLetter(failedMsg message.Message, reason string, channel channel.Channel) (storage.DeadLetter, error) {
+	opPayload := fsm.CommandPayload{
+		Operation: fsm.OperationDeadLetterAdd,
+		ChannelID: channel.ID,
+		Message:   failedMsg,
+		Reason:    reason,
+		Timestamp: message.UnixMilli(time.Now()),
+	}
+	opPayloadData, err := json.Marshal(opPayload)
+	if err != nil {
+		log.Error("prioritizer error preparing dead letter data payload: ", err.Error())
+		return storage.DeadLetter{}, err
+	}
+	applyFuture := p.cluster.Apply(opPayloadData, 500*time.Millisecond)
+	if err := applyFuture.Error(); err != nil {
+		log.Error("prioritizer error persisting data in raft cluster: ", err.Error())
+		return storage.DeadLetter{}, err
+	}
+	r, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		log.Error("prioritizer error parsing apply response")
+		return storage.DeadLetter{}, errors.New("fsm response failed")
+	}
+	if r.Err != nil {
+		return storage.DeadLetter{}, r.Err
+	}
+	deadLetter := r.Data.(storage.DeadLetter)
+	if p.dispatcher != nil && channel.DeadLetter != nil {
+		_ = p.dispatcher.PushDeadLetter(deadLetter.Message, channel.ID)
+	}
+	return deadLetter, nil
+}
+
+// Replay schedules messages of the dead letters to the time, it returns storage.ErrDeadLetterNotFound if any of them is missing
+func (p *Prioritizer) Replay(deadLetterIDs []string, availableAt int, channel channel.Channel) ([]message.Message, error) {
+	// replay through FSM
+	opPayload := fsm.CommandPayload{
+		Operation:     fsm.OperationDeadLetterReplay,
+		DeadLetterIDs: deadLetterIDs,
+		Timestamp:     availableAt,
+		ChannelID:     channel.ID,
+	}
+	opPayloadData, err := json.Marshal(opPayload)
+	if err != nil {
+		log.Error("prioritizer error preparing replay data payload: ", err.Error())
+		return nil, err
+	}
+	applyFuture := p.cluster.Apply(opPayloadData, 500*time.Millisecond)
+	if err := applyFuture.Error(); err != nil {
+		log.Error("prioritizer error persisting data in raft cluster: ", err.Error())
+		return nil, err
+	}
+	r, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		log.Error("prioritizer error parsing apply response")
+		return nil, errors.New("fsm response failed")
+	}
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return r.Data.([]message.Message), nil
 }
 
 func (p *Prioritizer) Boot(cluster *raft.Raft) error {
 	p.cluster = cluster
+	p.batchSize = DefaultBatchSize
 	return nil
 }
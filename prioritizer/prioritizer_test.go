@@ -2,13 +2,16 @@ package prioritizer
 
 import (
 	"context"
+	"github.com/BBVA/raft-badger"
 	"github.com/hashicorp/raft"
 	"github.com/maksimru/event-scheduler/channel"
 	"github.com/maksimru/event-scheduler/fsm"
 	"github.com/maksimru/event-scheduler/message"
 	"github.com/maksimru/event-scheduler/storage"
+	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -93,10 +96,27 @@ func TestPrioritizer_Process(t *testing.T) {
 			name:   "Check prioritizer can persist one message to the storage",
 			fields: fields{},
 			inboundMsgs: []message.Message{
-				message.NewMessage("msg1", 1000),
+				message.NewMessage([]byte("msg1"), 1000),
 			},
 			want: []message.Message{
-				message.NewMessage("msg1", 1000),
+				message.NewMessage([]byte("msg1"), 1000),
+			},
+			wantErr:         false,
+			targetChannelID: "ch1",
+			availableChannels: []channel.Channel{
+				{
+					ID: "ch1",
+				},
+			},
+		},
+		{
+			name:   "Check prioritizer keeps source message ID",
+			fields: fields{},
+			inboundMsgs: []message.Message{
+				message.NewMessage([]byte("msg1"), 1000).WithID("id1"),
+			},
+			want: []message.Message{
+				message.NewMessage([]byte("msg1"), 1000).WithID("id1"),
 			},
 			wantErr:         false,
 			targetChannelID: "ch1",
@@ -110,18 +130,18 @@ func TestPrioritizer_Process(t *testing.T) {
 			name:   "Check prioritizer can persist more than single message with right priority",
 			fields: fields{},
 			inboundMsgs: []message.Message{
-				message.NewMessage("msg1", 1000),
-				message.NewMessage("msg2", 400),
-				message.NewMessage("msg3", 600),
-				message.NewMessage("msg4", 2000),
-				message.NewMessage("msg5", 1200),
+				message.NewMessage([]byte("msg1"), 1000),
+				message.NewMessage([]byte("msg2"), 400),
+				message.NewMessage([]byte("msg3"), 600),
+				message.NewMessage([]byte("msg4"), 2000),
+				message.NewMessage([]byte("msg5"), 1200),
 			},
 			want: []message.Message{
-				message.NewMessage("msg2", 400),
-				message.NewMessage("msg3", 600),
-				message.NewMessage("msg1", 1000),
-				message.NewMessage("msg5", 1200),
-				message.NewMessage("msg4", 2000),
+				message.NewMessage([]byte("msg2"), 400),
+				message.NewMessage([]byte("msg3"), 600),
+				message.NewMessage([]byte("msg1"), 1000),
+				message.NewMessage([]byte("msg5"), 1200),
+				message.NewMessage([]byte("msg4"), 2000),
 			},
 			wantErr:         false,
 			targetChannelID: "ch1",
@@ -176,8 +196,14 @@ func TestPrioritizer_Process(t *testing.T) {
 			// validate results
 			chStorage, _ := pqStorage.GetChannelStorage(tt.targetChannelID)
 			var got []message.Message
-			for !chStorage.IsEmpty() {
-				got = append(got, chStorage.Dequeue())
+			for i := 0; !chStorage.IsEmpty(); i++ {
+				msg := chStorage.Dequeue()
+				// messages without ID get the generated one
+				if i < len(tt.want) && tt.want[i].GetID() == "" {
+					assert.NotEmpty(t, msg.GetID())
+					msg = msg.WithID("")
+				}
+				got = append(got, msg)
 			}
 
 			if !reflect.DeepEqual(got, tt.want) {
@@ -186,3 +212,110 @@ func TestPrioritizer_Process(t *testing.T) {
 		})
 	}
 }
+
+func bootLeader(nodeId string) (*raft.Raft, *storage.PqStorage) {
+	pqStorage := storage.NewPqStorage()
+	cluster, clusterAddr := bootStagingCluster(nodeId, pqStorage)
+	cluster.BootstrapCluster(raft.Configuration{Servers: []raft.Server{
+		{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(nodeId),
+			Address:  clusterAddr,
+		},
+	}})
+	// wait for election
+	time.Sleep(time.Second * 1)
+	return cluster, pqStorage
+}
+
+func TestPrioritizer_PersistConcurrently(t *testing.T) {
+	cluster, pqStorage := bootLeader("concurrent")
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+	ch1, _ := pqStorage.AddChannel(channel.Channel{ID: "ch1"})
+	ch2, _ := pqStorage.AddChannel(channel.Channel{ID: "ch2", Source: channel.Source{DeduplicationWindow: 60}})
+
+	p := new(Prioritizer)
+	_ = p.Boot(cluster)
+
+	const count = 200
+	appliedBefore := cluster.AppliedIndex()
+	errs := make(chan error, count*2)
+	for i := 0; i < count; i++ {
+		go func(i int) {
+			errs <- p.Persist(message.NewMessage([]byte("msg"), 1000+i), ch1)
+		}(i)
+		go func(i int) {
+			// every ID is sent twice, duplicates are acknowledged
+			errs <- p.Persist(message.NewMessage([]byte("msg"), 1000).WithID(strconv.Itoa(i/2)), ch2)
+		}(i)
+	}
+	for i := 0; i < count*2; i++ {
+		assert.NoError(t, <-errs)
+	}
+
+	_, messages := pqStorage.Dump()
+	assert.Len(t, messages["ch1"], count)
+	assert.Len(t, messages["ch2"], count/2)
+	// concurrent messages are grouped into fewer commands
+	assert.Less(t, int(cluster.AppliedIndex()-appliedBefore), count*2)
+}
+
+// bootDurableLeader keeps the raft log on disk like the scheduler does, so the benchmarks account for the log writes
+func bootDurableLeader(b *testing.B, nodeId string) (*raft.Raft, *storage.PqStorage) {
+	pqStorage := storage.NewPqStorage()
+	store, err := raftbadger.NewBadgerStore(b.TempDir())
+	if err != nil {
+		b.Fatal("badger store boot failed: ", err)
+	}
+	b.Cleanup(func() {
+		_ = store.Close()
+	})
+	cacheStore, _ := raft.NewLogCache(128, store)
+	raftTransportTcpAddr := raft.NewInmemAddr()
+	_, transport := raft.NewInmemTransport(raftTransportTcpAddr)
+	raftconfig := inmemConfig()
+	raftconfig.LogLevel = "warn"
+	raftconfig.LocalID = raft.ServerID(nodeId)
+	cluster, err := raft.NewRaft(raftconfig, fsm.NewPrioritizedFSM(pqStorage), cacheStore, store, raft.NewInmemSnapshotStore(), transport)
+	if err != nil {
+		b.Fatal("cluster boot failed: ", err)
+	}
+	cluster.BootstrapCluster(raft.Configuration{Servers: []raft.Server{
+		{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(nodeId),
+			Address:  raftTransportTcpAddr,
+		},
+	}})
+	// wait for election
+	time.Sleep(time.Second * 1)
+	return cluster, pqStorage
+}
+
+func BenchmarkPrioritizer_Persist(b *testing.B) {
+	log.SetLevel(log.WarnLevel)
+	for _, batchSize := range []int{1, DefaultBatchSize} {
+		b.Run("batch size "+strconv.Itoa(batchSize), func(b *testing.B) {
+			cluster, pqStorage := bootDurableLeader(b, "bench"+strconv.Itoa(batchSize))
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+			c, _ := pqStorage.AddChannel(channel.Channel{ID: "ch1"})
+			p := new(Prioritizer)
+			_ = p.Boot(cluster)
+			p.SetBatchSize(batchSize)
+
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := p.Persist(message.NewMessage([]byte("msg"), 1000), c); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
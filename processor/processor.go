@@ -3,6 +3,7 @@ package processor
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"github.com/hashicorp/raft"
 	"github.com/maksimru/event-scheduler/channel"
 	"github.com/maksimru/event-scheduler/dispatcher"
@@ -15,22 +16,47 @@ import (
 )
 
 type Processor struct {
-	publisher   publisher.Publisher
-	dispatcher  dispatcher.Dispatcher
-	dataStorage *storage.PqStorage
-	context     context.Context
-	time        CurrentTimeChecker
-	cluster     *raft.Raft
-	channel     channel.Channel
-	stopFunc    context.CancelFunc
+	publisher    publisher.Publisher
+	dispatcher   dispatcher.Dispatcher
+	dataStorage  *storage.PqStorage
+	context      context.Context
+	time         CurrentTimeChecker
+	cluster      *raft.Raft
+	channel      channel.Channel
+	stopFunc     context.CancelFunc
+	batchSize    int
+	leaseTimeout time.Duration
 }
 
+// DefaultBatchSize is the maximum number of due messages popped by one cluster command
+const DefaultBatchSize = 100
+
+// DefaultLeaseTimeout is the time given to deliver the popped message before it is dispatched once again
+const DefaultLeaseTimeout = 30 * time.Second
+
 func (p *Processor) SetTime(time CurrentTimeChecker) {
 	p.time = time
 }
 
+// SetBatchSize limits the number of due messages popped by one cluster command, batch size 1 pops them one by one
+func (p *Processor) SetBatchSize(batchSize int) {
+	p.batchSize = batchSize
+}
+
+// SetLeaseTimeout keeps popped messages in flight until the delivery is acknowledged, zero timeout removes messages on pop
+func (p *Processor) SetLeaseTimeout(leaseTimeout time.Duration) {
+	p.leaseTimeout = leaseTimeout
+}
+
 type CurrentTimeChecker interface {
 	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer fires once the duration is elapsed
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
 }
 
 type RealTime struct {
@@ -40,6 +66,22 @@ func (RealTime) Now() time.Time {
 	return time.Now()
 }
 
+func (RealTime) NewTimer(d time.Duration) Timer {
+	return realTimer{timer: time.NewTimer(d)}
+}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.timer.Stop()
+}
+
 type MockTime struct {
 	time time.Time
 }
@@ -52,6 +94,22 @@ func (m MockTime) Now() time.Time {
 	return m.time
 }
 
+// NewTimer returns the timer which never fires, mocked time doesn't pass
+func (m MockTime) NewTimer(time.Duration) Timer {
+	return mockTimer{}
+}
+
+type mockTimer struct {
+}
+
+func (mockTimer) C() <-chan time.Time {
+	return nil
+}
+
+func (mockTimer) Stop() bool {
+	return true
+}
+
 func (p *Processor) Stop() error {
 	if p.stopFunc != nil {
 		log.Info("processor stop called")
@@ -64,6 +122,16 @@ func (p *Processor) Process() error {
 	ctx, cancelListener := context.WithCancel(p.context)
 	defer cancelListener()
 	p.stopFunc = cancelListener
+	// leadership changes wake up the processor, only the leader dequeues messages
+	stateChanges := make(chan raft.Observation, 1)
+	observer := raft.NewObserver(stateChanges, false, func(o *raft.Observation) bool {
+		_, ok := o.Data.(raft.RaftState)
+		return ok
+	})
+	p.cluster.RegisterObserver(observer)
+	defer p.cluster.DeregisterObserver(observer)
+	// deliveries over the channel rate wait in the scheduled queue
+	bucket := newTokenBucket(p.channel.RateLimit, message.UnixMilli(p.time.Now()))
 	for {
 		select {
 		case <-ctx.Done():
@@ -71,47 +139,145 @@ func (p *Processor) Process() error {
 			return nil
 		default:
 		}
-		now := int(p.time.Now().Unix())
+		now := message.UnixMilli(p.time.Now())
 		chStorage, chExists := p.dataStorage.GetChannelStorage(p.channel.ID)
 		if !chExists {
 			log.Warn("channel storage is not found (channel ", p.channel.ID, ") - processor is stopped")
 			return nil
 		}
-		if p.cluster.State() == raft.Leader && chStorage.CheckScheduled(now) {
-			// dequeue through FSM
-			opPayload := fsm.CommandPayload{
-				ChannelID: p.channel.ID,
-				Operation: fsm.OperationMessagePop,
+		limit, throttledUntil := p.batchSize, 0
+		if limit < 1 {
+			limit = 1
+		}
+		if bucket != nil {
+			if available := bucket.available(now); available < limit {
+				limit = available
+			}
+			throttledUntil = bucket.nextAt(now)
+		}
+		// messages of the destination with the open breaker stay in the cluster
+		if pausedUntil, paused := p.dispatcher.PausedUntil(p.channel.ID, now); paused {
+			limit = 0
+			if pausedUntil > throttledUntil {
+				throttledUntil = pausedUntil
+			}
+		}
+		if p.cluster.State() == raft.Leader && limit > 0 && (chStorage.CheckLeases(now) || chStorage.CheckScheduled(now)) {
+			var leases []storage.Lease
+			var err error
+			// expired leases go first, their messages are overdue already
+			if chStorage.CheckLeases(now) {
+				leases, err = p.renew(now, limit)
+			} else {
+				leases, err = p.pop(now, limit)
 			}
-			opPayloadData, err := json.Marshal(opPayload)
 			if err != nil {
-				log.Error("processor error preparing saving data payload: ", err.Error())
 				continue
 			}
-			applyFuture := p.cluster.Apply(opPayloadData, 500*time.Millisecond)
-			if err := applyFuture.Error(); err != nil {
-				log.Error("processor error persisting data in raft cluster: ", err.Error())
-				continue
+			if bucket != nil {
+				bucket.take(len(leases))
 			}
-			clusterResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
-			if !ok {
-				log.Error("processor error parsing apply response")
-				continue
+			for _, lease := range leases {
+				msg := lease.Message
+				log.Trace("processor message is ready for delivery: scheduled for ", msg.GetAvailableAt(), " at ", now)
+
+				err = p.dispatcher.PushLeased(msg, p.channel.ID, lease.ID)
+				if err != nil {
+					log.Error("processor message publish exception: scheduled for ", msg.GetAvailableAt(), " at ", now, " ", err.Error())
+					return err
+				}
+				log.Trace("processor message published: scheduled for ", msg.GetAvailableAt(), " at ", now)
 			}
+		} else {
+			p.wait(ctx, chStorage, stateChanges, now, throttledUntil)
+		}
+	}
+}
 
-			msg := clusterResponse.Data.(message.Message)
+// pop dequeues up to the limit of messages scheduled up to now through FSM, messages are leased when the lease timeout is set
+func (p *Processor) pop(now int, limit int) ([]storage.Lease, error) {
+	opPayload := fsm.CommandPayload{
+		ChannelID:    p.channel.ID,
+		Operation:    fsm.OperationMessagePop,
+		Timestamp:    now,
+		LeaseTimeout: int(p.leaseTimeout / time.Millisecond),
+	}
+	if limit > 1 {
+		opPayload.Operation = fsm.OperationMessagePopBatch
+		opPayload.Limit = limit
+	}
+	return p.apply(opPayload)
+}
 
-			log.Trace("processor message is ready for delivery: scheduled for ", msg.GetAvailableAt(), " at ", now)
+// renew extends up to the limit of expired leases through FSM, their messages are delivered once again
+func (p *Processor) renew(now int, limit int) ([]storage.Lease, error) {
+	return p.apply(fsm.CommandPayload{
+		ChannelID:    p.channel.ID,
+		Operation:    fsm.OperationLeaseRenew,
+		Timestamp:    now,
+		LeaseTimeout: int(p.leaseTimeout / time.Millisecond),
+		Limit:        limit,
+	})
+}
 
-			err = p.dispatcher.Push(message.NewMessage(msg.GetBody(), msg.GetAvailableAt()), p.channel.ID)
-			if err != nil {
-				log.Error("processor message publish exception: scheduled for ", msg.GetAvailableAt(), " at ", now, " ", err.Error())
-				return err
-			}
-			log.Trace("processor message published: scheduled for ", msg.GetAvailableAt(), " at ", now)
-		} else {
-			time.Sleep(time.Second)
+func (p *Processor) apply(opPayload fsm.CommandPayload) ([]storage.Lease, error) {
+	opPayloadData, err := json.Marshal(opPayload)
+	if err != nil {
+		log.Error("processor error preparing saving data payload: ", err.Error())
+		return nil, err
+	}
+	applyFuture := p.cluster.Apply(opPayloadData, 500*time.Millisecond)
+	if err := applyFuture.Error(); err != nil {
+		log.Error("processor error persisting data in raft cluster: ", err.Error())
+		return nil, err
+	}
+	clusterResponse, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		log.Error("processor error parsing apply response")
+		return nil, errors.New("fsm response failed")
+	}
+	if clusterResponse.Err != nil {
+		// messages are cancelled after the check
+		return nil, clusterResponse.Err
+	}
+	switch data := clusterResponse.Data.(type) {
+	case []storage.Lease:
+		return data, nil
+	case storage.Lease:
+		return []storage.Lease{data}, nil
+	case []message.Message:
+		leases := make([]storage.Lease, len(data))
+		for i, msg := range data {
+			leases[i] = storage.Lease{Message: msg}
 		}
+		return leases, nil
+	default:
+		return []storage.Lease{{Message: data.(message.Message)}}, nil
+	}
+}
+
+// wait sleeps until the head of the queue is scheduled or the lease expires, but not before the rate limit lets the
+// delivery through, the new head of the queue, dropped storage or leadership change wake it up earlier, followers
+// don't wait for the scheduled time
+func (p *Processor) wait(ctx context.Context, chStorage storage.PqChannelStorage, stateChanges <-chan raft.Observation, now int, throttledUntil int) {
+	var scheduled <-chan time.Time
+	nextAt, has := chStorage.NextAvailableAt()
+	if expiresAt, leased := chStorage.NextLeaseExpiry(); leased && (!has || expiresAt < nextAt) {
+		nextAt, has = expiresAt, true
+	}
+	if has && throttledUntil > nextAt {
+		nextAt = throttledUntil
+	}
+	if has && p.cluster.State() == raft.Leader {
+		timer := p.time.NewTimer(time.Duration(nextAt-now) * time.Millisecond)
+		defer timer.Stop()
+		scheduled = timer.C()
+	}
+	select {
+	case <-ctx.Done():
+	case <-scheduled:
+	case <-chStorage.Wakeup():
+	case <-stateChanges:
 	}
 }
 
@@ -122,5 +288,7 @@ func (p *Processor) Boot(ctx context.Context, dispatcher dispatcher.Dispatcher,
 	p.time = RealTime{}
 	p.cluster = cluster
 	p.channel = channel
+	p.batchSize = DefaultBatchSize
+	p.leaseTimeout = DefaultLeaseTimeout
 	return nil
 }
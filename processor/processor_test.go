@@ -2,7 +2,8 @@ package processor
 
 import (
 	"context"
-	"github.com/enriquebris/goconcurrentqueue"
+	"encoding/json"
+	"github.com/BBVA/raft-badger"
 	"github.com/hashicorp/raft"
 	"github.com/maksimru/event-scheduler/channel"
 	"github.com/maksimru/event-scheduler/dispatcher"
@@ -10,8 +11,11 @@ import (
 	"github.com/maksimru/event-scheduler/message"
 	pubsubconfig "github.com/maksimru/event-scheduler/publisher/pubsub/config"
 	"github.com/maksimru/event-scheduler/storage"
+	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"reflect"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -31,7 +35,7 @@ func TestProcessor_Boot(t *testing.T) {
 		channel     channel.Channel
 	}
 	dataStorage := storage.NewPqStorage()
-	dispatcherProvider := dispatcher.NewDispatcher(context.Background(), goconcurrentqueue.NewFIFO(), dataStorage)
+	dispatcherProvider := dispatcher.NewDispatcher(context.Background(), dataStorage)
 	tests := []struct {
 		name    string
 		fields  fields
@@ -104,6 +108,7 @@ func TestProcessor_Process(t *testing.T) {
 		time              CurrentTimeChecker
 		channel           channel.Channel
 		availableChannels []channel.Channel
+		batchSize         int
 	}
 
 	tests := []struct {
@@ -139,20 +144,20 @@ func TestProcessor_Process(t *testing.T) {
 				},
 			},
 			storageData: []message.Message{
-				message.NewMessage("msg2", 400),
-				message.NewMessage("msg3", 600),
-				message.NewMessage("msg1", 1000),
-				message.NewMessage("msg5", 1200),
-				message.NewMessage("msg4", 2000),
+				message.NewMessage([]byte("msg2"), 400),
+				message.NewMessage([]byte("msg3"), 600),
+				message.NewMessage([]byte("msg1"), 1000),
+				message.NewMessage([]byte("msg5"), 1200),
+				message.NewMessage([]byte("msg4"), 2000),
 			},
 			node:        raft.Voter,
 			wantStorage: []message.Message{},
 			wantPublished: []message.Message{
-				message.NewMessage("msg2", 400),
-				message.NewMessage("msg3", 600),
-				message.NewMessage("msg1", 1000),
-				message.NewMessage("msg5", 1200),
-				message.NewMessage("msg4", 2000),
+				message.NewMessage([]byte("msg2"), 400),
+				message.NewMessage([]byte("msg3"), 600),
+				message.NewMessage([]byte("msg1"), 1000),
+				message.NewMessage([]byte("msg5"), 1200),
+				message.NewMessage([]byte("msg4"), 2000),
 			},
 			wantErr: false,
 		},
@@ -179,28 +184,70 @@ func TestProcessor_Process(t *testing.T) {
 				},
 			},
 			storageData: []message.Message{
-				message.NewMessage("msg2", 400),
-				message.NewMessage("msg3", 600),
-				message.NewMessage("msg1", 1000),
-				message.NewMessage("msg5", 1200),
-				message.NewMessage("msg4", 2000),
+				message.NewMessage([]byte("msg2"), 400),
+				message.NewMessage([]byte("msg3"), 600),
+				message.NewMessage([]byte("msg1"), 1000),
+				message.NewMessage([]byte("msg5"), 1200),
+				message.NewMessage([]byte("msg4"), 2000),
 			},
 			node: raft.Voter,
 			wantStorage: []message.Message{
-				message.NewMessage("msg2", 400),
-				message.NewMessage("msg3", 600),
-				message.NewMessage("msg1", 1000),
-				message.NewMessage("msg5", 1200),
-				message.NewMessage("msg4", 2000),
+				message.NewMessage([]byte("msg2"), 400),
+				message.NewMessage([]byte("msg3"), 600),
+				message.NewMessage([]byte("msg1"), 1000),
+				message.NewMessage([]byte("msg5"), 1200),
+				message.NewMessage([]byte("msg4"), 2000),
 			},
 			wantPublished: []message.Message{},
 			wantErr:       false,
 		},
+		{
+			name: "Check processor dispatches due messages in batches (as cluster leader)",
+			fields: fields{
+				dataStorage: storage.NewPqStorage(),
+				time:        NewMockTime(time.Unix(0, 1000*int64(time.Millisecond))), // simulate 1000 ms timestamp
+				channel: channel.Channel{
+					ID: "ch1",
+					Destination: channel.Destination{
+						Driver: "pubsub",
+						Config: pubsubconfig.DestinationConfig{},
+					},
+				},
+				availableChannels: []channel.Channel{
+					{
+						ID: "ch1",
+						Destination: channel.Destination{
+							Driver: "pubsub",
+							Config: pubsubconfig.DestinationConfig{},
+						},
+					},
+				},
+				batchSize: 2,
+			},
+			storageData: []message.Message{
+				message.NewMessage([]byte("msg2"), 400),
+				message.NewMessage([]byte("msg3"), 600),
+				message.NewMessage([]byte("msg1"), 1000),
+				message.NewMessage([]byte("msg5"), 1200),
+				message.NewMessage([]byte("msg4"), 2000),
+			},
+			node: raft.Voter,
+			wantStorage: []message.Message{
+				message.NewMessage([]byte("msg5"), 1200),
+				message.NewMessage([]byte("msg4"), 2000),
+			},
+			wantPublished: []message.Message{
+				message.NewMessage([]byte("msg2"), 400),
+				message.NewMessage([]byte("msg3"), 600),
+				message.NewMessage([]byte("msg1"), 1000),
+			},
+			wantErr: false,
+		},
 		{
 			name: "Check processor can partially dispatch prepared messages on time (as cluster leader)",
 			fields: fields{
 				dataStorage: storage.NewPqStorage(),
-				time:        NewMockTime(time.Unix(600, 0)), // simulate 600 timestamp
+				time:        NewMockTime(time.Unix(0, 600*int64(time.Millisecond))), // simulate 600 ms timestamp
 				channel: channel.Channel{
 					ID: "ch1",
 					Destination: channel.Destination{
@@ -219,21 +266,53 @@ func TestProcessor_Process(t *testing.T) {
 				},
 			},
 			storageData: []message.Message{
-				message.NewMessage("msg2", 400),
-				message.NewMessage("msg3", 600),
-				message.NewMessage("msg1", 1000),
-				message.NewMessage("msg5", 1200),
-				message.NewMessage("msg4", 2000),
+				message.NewMessage([]byte("msg2"), 400),
+				message.NewMessage([]byte("msg3"), 600),
+				message.NewMessage([]byte("msg1"), 1000),
+				message.NewMessage([]byte("msg5"), 1200),
+				message.NewMessage([]byte("msg4"), 2000),
 			},
 			node: raft.Voter,
 			wantStorage: []message.Message{
-				message.NewMessage("msg1", 1000),
-				message.NewMessage("msg5", 1200),
-				message.NewMessage("msg4", 2000),
+				message.NewMessage([]byte("msg1"), 1000),
+				message.NewMessage([]byte("msg5"), 1200),
+				message.NewMessage([]byte("msg4"), 2000),
+			},
+			wantPublished: []message.Message{
+				message.NewMessage([]byte("msg2"), 400),
+				message.NewMessage([]byte("msg3"), 600),
+			},
+			wantErr: false,
+		},
+		{
+			name: "Check processor keeps message attributes on dispatch (as cluster leader)",
+			fields: fields{
+				dataStorage: storage.NewPqStorage(),
+				time:        RealTime{},
+				channel: channel.Channel{
+					ID: "ch1",
+					Destination: channel.Destination{
+						Driver: "pubsub",
+						Config: pubsubconfig.DestinationConfig{},
+					},
+				},
+				availableChannels: []channel.Channel{
+					{
+						ID: "ch1",
+						Destination: channel.Destination{
+							Driver: "pubsub",
+							Config: pubsubconfig.DestinationConfig{},
+						},
+					},
+				},
 			},
+			storageData: []message.Message{
+				message.NewMessageWithAttributes([]byte("msg1"), 400, map[string]string{"type": "foo"}),
+			},
+			node:        raft.Voter,
+			wantStorage: []message.Message{},
 			wantPublished: []message.Message{
-				message.NewMessage("msg2", 400),
-				message.NewMessage("msg3", 600),
+				message.NewMessageWithAttributes([]byte("msg1"), 400, map[string]string{"type": "foo"}),
 			},
 			wantErr: false,
 		},
@@ -260,13 +339,13 @@ func TestProcessor_Process(t *testing.T) {
 				},
 			},
 			storageData: []message.Message{
-				message.NewMessage("msg2", 400),
-				message.NewMessage("msg3", 600),
+				message.NewMessage([]byte("msg2"), 400),
+				message.NewMessage([]byte("msg3"), 600),
 			},
 			node: raft.Nonvoter,
 			wantStorage: []message.Message{
-				message.NewMessage("msg2", 400),
-				message.NewMessage("msg3", 600),
+				message.NewMessage([]byte("msg2"), 400),
+				message.NewMessage([]byte("msg3"), 600),
 			},
 			wantPublished: []message.Message{},
 			wantErr:       false,
@@ -278,8 +357,7 @@ func TestProcessor_Process(t *testing.T) {
 			ctx, cancel := context.WithTimeout(ctx, time.Second*4)
 			defer cancel()
 
-			outboundQueue := goconcurrentqueue.NewFIFO()
-			dispatcherInstance := dispatcher.NewDispatcher(ctx, outboundQueue, tt.fields.dataStorage)
+			dispatcherInstance := &recordingDispatcher{}
 
 			nodeId := string(rune(testID))
 			cluster, clusterAddr := bootStagingCluster(nodeId, tt.fields.dataStorage)
@@ -294,6 +372,7 @@ func TestProcessor_Process(t *testing.T) {
 				context:     ctx,
 				cluster:     cluster,
 				channel:     tt.fields.channel,
+				batchSize:   tt.fields.batchSize,
 			}
 
 			// boot required cluster
@@ -334,15 +413,592 @@ func TestProcessor_Process(t *testing.T) {
 				assert.Equal(t, tt.wantStorage, gotStorage)
 			}
 			// validate results
-			gotPublished := []message.Message{}
-			for outboundQueue.GetLen() > 0 {
-				msg, _ := outboundQueue.Dequeue()
-				delivery := msg.(dispatcher.MessageForDelivery)
-				gotPublished = append(gotPublished, delivery.GetMessage())
-			}
+			gotPublished := append([]message.Message{}, dispatcherInstance.getPushed()...)
 			if !reflect.DeepEqual(gotPublished, tt.wantPublished) {
 				assert.Equal(t, tt.wantPublished, gotPublished)
 			}
 		})
 	}
 }
+
+// recordingDispatcher collects pushed messages instead of delivering them, its breaker is open until pausedUntil
+type recordingDispatcher struct {
+	mutex       sync.Mutex
+	pushed      []message.Message
+	pausedUntil int
+}
+
+func (r *recordingDispatcher) Push(msg message.Message, channelID string) error {
+	return r.PushLeased(msg, channelID, "")
+}
+
+func (r *recordingDispatcher) PushLeased(msg message.Message, _ string, _ string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.pushed = append(r.pushed, msg)
+	return nil
+}
+
+func (r *recordingDispatcher) PushDeadLetter(message.Message, string) error {
+	return nil
+}
+
+func (r *recordingDispatcher) PausedUntil(_ string, now int) (int, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.pausedUntil, now < r.pausedUntil
+}
+
+func (r *recordingDispatcher) GetBreakerState(string) dispatcher.BreakerState {
+	return dispatcher.BreakerState{State: dispatcher.BreakerClosed}
+}
+
+func (r *recordingDispatcher) Drain(context.Context) error {
+	return nil
+}
+
+func (r *recordingDispatcher) Dispatch() error {
+	return nil
+}
+
+func (r *recordingDispatcher) resume() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.pausedUntil = 0
+}
+
+func (r *recordingDispatcher) getPushed() []message.Message {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]message.Message{}, r.pushed...)
+}
+
+// manualTime hands the requested timers to the test, so they fire only when the test decides
+type manualTime struct {
+	now    time.Time
+	timers chan manualTimer
+}
+
+func (m manualTime) Now() time.Time {
+	return m.now
+}
+
+func (m manualTime) NewTimer(d time.Duration) Timer {
+	t := manualTimer{d: d, c: make(chan time.Time, 1)}
+	m.timers <- t
+	return t
+}
+
+type manualTimer struct {
+	d time.Duration
+	c chan time.Time
+}
+
+func (t manualTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t manualTimer) Stop() bool {
+	return true
+}
+
+func TestProcessor_wait(t *testing.T) {
+	dataStorage := storage.NewPqStorage()
+	cluster, clusterAddr := bootStagingCluster("wait", dataStorage)
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+	cluster.BootstrapCluster(raft.Configuration{Servers: []raft.Server{
+		{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID("wait"),
+			Address:  clusterAddr,
+		},
+	}})
+
+	// wait for election
+	time.Sleep(time.Second * 1)
+
+	_, _ = dataStorage.AddChannel(channel.Channel{ID: "ch1"})
+	chStorage, _ := dataStorage.GetChannelStorage("ch1")
+	clock := manualTime{now: time.Unix(1, 0), timers: make(chan manualTimer, 1)}
+	p := &Processor{cluster: cluster, dataStorage: dataStorage, time: clock}
+	stateChanges := make(chan raft.Observation, 1)
+	throttledUntil := 0
+
+	wait := func(ctx context.Context) <-chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			p.wait(ctx, chStorage, stateChanges, message.UnixMilli(clock.Now()), throttledUntil)
+			close(done)
+		}()
+		return done
+	}
+
+	// empty queue is waited without timer
+	done := wait(context.Background())
+	stateChanges <- raft.Observation{Data: raft.Leader}
+	<-done
+	assert.Len(t, clock.timers, 0)
+
+	// new head of the queue is signalled
+	chStorage.Enqueue(message.NewMessage([]byte("msg1"), 5000))
+	assert.Len(t, chStorage.Wakeup(), 1)
+	<-chStorage.Wakeup()
+
+	// processor sleeps until the head of the queue time
+	done = wait(context.Background())
+	timer := <-clock.timers
+	assert.Equal(t, 4*time.Second, timer.d)
+	chStorage.Enqueue(message.NewMessage([]byte("msg2"), 6000))
+	assert.Len(t, chStorage.Wakeup(), 0)
+	timer.c <- clock.Now().Add(timer.d)
+	<-done
+
+	// earlier message wakes up the processor before the timer
+	done = wait(context.Background())
+	timer = <-clock.timers
+	assert.Equal(t, 4*time.Second, timer.d)
+	chStorage.Enqueue(message.NewMessage([]byte("msg3"), 2000))
+	<-done
+
+	// leadership change wakes up the processor
+	done = wait(context.Background())
+	<-clock.timers
+	stateChanges <- raft.Observation{Data: raft.Follower}
+	<-done
+
+	// stopped processor doesn't wait
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done = wait(ctx)
+	<-clock.timers
+	<-done
+
+	// processor sleeps until the earliest lease expiration
+	chStorage.AddLease(storage.Lease{ID: "1-0", Message: message.NewMessage([]byte("msg0"), 1000), ExpiresAt: 1500})
+	done = wait(context.Background())
+	timer = <-clock.timers
+	assert.Equal(t, 500*time.Millisecond, timer.d)
+	timer.c <- clock.Now().Add(timer.d)
+	<-done
+
+	// rate limited processor sleeps until the next delivery is allowed
+	throttledUntil = 1800
+	done = wait(context.Background())
+	timer = <-clock.timers
+	assert.Equal(t, 800*time.Millisecond, timer.d)
+	timer.c <- clock.Now().Add(timer.d)
+	<-done
+	throttledUntil = 0
+
+	// dropped storage wakes up the processor
+	done = wait(context.Background())
+	<-clock.timers
+	_, _ = dataStorage.DeleteChannel("ch1")
+	<-done
+}
+
+func TestProcessor_ProcessWakesUpOnEnqueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dataStorage := storage.NewPqStorage()
+	c := channel.Channel{ID: "ch1", Destination: channel.Destination{Driver: "pubsub", Config: pubsubconfig.DestinationConfig{}}}
+	_, _ = dataStorage.AddChannel(c)
+	pushed := &recordingDispatcher{}
+
+	cluster, clusterAddr := bootStagingCluster("wakeup", dataStorage)
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+	cluster.BootstrapCluster(raft.Configuration{Servers: []raft.Server{
+		{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID("wakeup"),
+			Address:  clusterAddr,
+		},
+	}})
+
+	// wait for election
+	time.Sleep(time.Second * 1)
+
+	p := &Processor{
+		dispatcher:  pushed,
+		dataStorage: dataStorage,
+		// mocked time doesn't pass, so only the enqueued message can wake up the processor
+		time:    NewMockTime(time.Unix(1, 0)),
+		context: ctx,
+		cluster: cluster,
+		channel: c,
+	}
+	chStorage, _ := dataStorage.GetChannelStorage(c.ID)
+	chStorage.Enqueue(message.NewMessage([]byte("msg1"), 5000))
+
+	done := make(chan error)
+	go func() {
+		done <- p.Process()
+	}()
+
+	chStorage.Enqueue(message.NewMessage([]byte("msg2"), 500))
+	assert.Eventually(t, func() bool {
+		return len(pushed.getPushed()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, p.Stop())
+	assert.NoError(t, <-done)
+	assert.Equal(t, []message.Message{message.NewMessage([]byte("msg2"), 500)}, pushed.getPushed())
+	assert.Equal(t, []message.Message{message.NewMessage([]byte("msg1"), 5000)}, chStorage.Dump())
+}
+
+func TestProcessor_ProcessRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dataStorage := storage.NewPqStorage()
+	c := channel.Channel{ID: "ch1", RateLimit: &channel.RateLimit{Rate: 1, Burst: 2}}
+	_, _ = dataStorage.AddChannel(c)
+
+	cluster, clusterAddr := bootStagingCluster("ratelimit", dataStorage)
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+	cluster.BootstrapCluster(raft.Configuration{Servers: []raft.Server{
+		{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID("ratelimit"),
+			Address:  clusterAddr,
+		},
+	}})
+
+	// wait for election
+	time.Sleep(time.Second * 1)
+
+	pushed := &recordingDispatcher{}
+	p := &Processor{
+		dispatcher:  pushed,
+		dataStorage: dataStorage,
+		// mocked time doesn't pass, so the bucket is never refilled
+		time:         NewMockTime(time.Unix(10, 0)),
+		context:      ctx,
+		cluster:      cluster,
+		channel:      c,
+		batchSize:    DefaultBatchSize,
+		leaseTimeout: DefaultLeaseTimeout,
+	}
+	chStorage, _ := dataStorage.GetChannelStorage(c.ID)
+	for i := 1; i <= 5; i++ {
+		chStorage.Enqueue(message.NewMessage([]byte("msg"+strconv.Itoa(i)), i*1000))
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- p.Process()
+	}()
+
+	// burst is delivered at once, the rest stays scheduled
+	assert.Eventually(t, func() bool {
+		return len(pushed.getPushed()) == 2
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, p.Stop())
+	assert.NoError(t, <-done)
+	assert.Equal(t, []message.Message{
+		message.NewMessage([]byte("msg1"), 1000),
+		message.NewMessage([]byte("msg2"), 2000),
+	}, pushed.getPushed())
+	assert.Len(t, chStorage.Dump(), 3)
+	assert.Len(t, chStorage.DumpLeases(), 2)
+}
+
+func TestProcessor_ProcessPaused(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dataStorage := storage.NewPqStorage()
+	c, _ := dataStorage.AddChannel(channel.Channel{ID: "ch1"})
+
+	cluster, clusterAddr := bootStagingCluster("paused", dataStorage)
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+	cluster.BootstrapCluster(raft.Configuration{Servers: []raft.Server{
+		{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID("paused"),
+			Address:  clusterAddr,
+		},
+	}})
+
+	// wait for election
+	time.Sleep(time.Second * 1)
+
+	clock := manualTime{now: time.Unix(10, 0), timers: make(chan manualTimer, 1)}
+	pushed := &recordingDispatcher{pausedUntil: 10500}
+	p := &Processor{
+		dispatcher:   pushed,
+		dataStorage:  dataStorage,
+		time:         clock,
+		context:      ctx,
+		cluster:      cluster,
+		channel:      c,
+		batchSize:    DefaultBatchSize,
+		leaseTimeout: DefaultLeaseTimeout,
+	}
+	chStorage, _ := dataStorage.GetChannelStorage(c.ID)
+	chStorage.Enqueue(message.NewMessage([]byte("msg1"), 1000))
+	// the processor picks the message up by itself, so the wakeup doesn't end its first wait
+	<-chStorage.Wakeup()
+
+	done := make(chan error)
+	go func() {
+		done <- p.Process()
+	}()
+
+	// due message stays in the cluster until the breaker lets deliveries through
+	timer := <-clock.timers
+	assert.Equal(t, 500*time.Millisecond, timer.d)
+	assert.Empty(t, pushed.getPushed())
+	assert.Len(t, chStorage.Dump(), 1)
+
+	pushed.resume()
+	timer.c <- clock.Now().Add(timer.d)
+	assert.Eventually(t, func() bool {
+		return len(pushed.getPushed()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, chStorage.Dump())
+	assert.NoError(t, p.Stop())
+	assert.NoError(t, <-done)
+}
+
+// bootDurableLeader keeps the raft log on disk like the scheduler does, so the benchmarks account for the log writes
+func bootDurableLeader(b *testing.B, nodeId string, pqStorage *storage.PqStorage) *raft.Raft {
+	store, err := raftbadger.NewBadgerStore(b.TempDir())
+	if err != nil {
+		b.Fatal("badger store boot failed: ", err)
+	}
+	b.Cleanup(func() {
+		_ = store.Close()
+	})
+	cacheStore, _ := raft.NewLogCache(128, store)
+	raftTransportTcpAddr := raft.NewInmemAddr()
+	_, transport := raft.NewInmemTransport(raftTransportTcpAddr)
+	raftconfig := inmemConfig()
+	raftconfig.LogLevel = "warn"
+	raftconfig.LocalID = raft.ServerID(nodeId)
+	cluster, err := raft.NewRaft(raftconfig, fsm.NewPrioritizedFSM(pqStorage), cacheStore, store, raft.NewInmemSnapshotStore(), transport)
+	if err != nil {
+		b.Fatal("cluster boot failed: ", err)
+	}
+	cluster.BootstrapCluster(raft.Configuration{Servers: []raft.Server{
+		{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(nodeId),
+			Address:  raftTransportTcpAddr,
+		},
+	}})
+	// wait for election
+	time.Sleep(time.Second * 1)
+	return cluster
+}
+
+func BenchmarkProcessor_pop(b *testing.B) {
+	log.SetLevel(log.WarnLevel)
+	for _, batchSize := range []int{1, DefaultBatchSize} {
+		b.Run("batch size "+strconv.Itoa(batchSize), func(b *testing.B) {
+			dataStorage := storage.NewPqStorage()
+			cluster := bootDurableLeader(b, "bench"+strconv.Itoa(batchSize), dataStorage)
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+			c, _ := dataStorage.AddChannel(channel.Channel{ID: "ch1"})
+			p := &Processor{dataStorage: dataStorage, cluster: cluster, channel: c, batchSize: batchSize}
+
+			// whole backlog becomes due at the same time
+			chStorage, _ := dataStorage.GetChannelStorage(c.ID)
+			for i := 0; i < b.N; i++ {
+				chStorage.Enqueue(message.NewMessage([]byte("msg"), 1000))
+			}
+			b.ResetTimer()
+			for popped := 0; popped < b.N; {
+				messages, err := p.pop(1000, batchSize)
+				if err != nil {
+					b.Fatal(err)
+				}
+				popped += len(messages)
+			}
+		})
+	}
+}
+
+// recordingPublisher collects dispatched messages, the hanging one never completes the delivery until its node is stopped
+type recordingPublisher struct {
+	ctx        context.Context
+	hang       bool
+	mutex      sync.Mutex
+	dispatched []message.Message
+	calls      int
+}
+
+func (r *recordingPublisher) Dispatch(msg message.Message) error {
+	r.mutex.Lock()
+	r.calls++
+	r.mutex.Unlock()
+	if r.hang {
+		<-r.ctx.Done()
+		return r.ctx.Err()
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.dispatched = append(r.dispatched, msg)
+	return nil
+}
+
+func (r *recordingPublisher) getCalls() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.calls
+}
+
+func (r *recordingPublisher) getDispatched() []message.Message {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]message.Message{}, r.dispatched...)
+}
+
+func (r *recordingPublisher) Close() error {
+	return nil
+}
+
+func TestProcessor_LeaderFailover(t *testing.T) {
+	type node struct {
+		id          raft.ServerID
+		addr        raft.ServerAddress
+		transport   *raft.InmemTransport
+		cluster     *raft.Raft
+		dataStorage *storage.PqStorage
+		publisher   *recordingPublisher
+		stop        context.CancelFunc
+	}
+	c := channel.Channel{ID: "ch1", Destination: channel.Destination{Driver: "test"}}
+	nodes := make([]*node, 3)
+	configuration := raft.Configuration{}
+	for i := range nodes {
+		n := &node{id: raft.ServerID("failover" + strconv.Itoa(i)), dataStorage: storage.NewPqStorage()}
+		_, _ = n.dataStorage.AddChannel(c)
+		n.addr, n.transport = raft.NewInmemTransport(raft.NewInmemAddr())
+		configuration.Servers = append(configuration.Servers, raft.Server{Suffrage: raft.Voter, ID: n.id, Address: n.addr})
+		nodes[i] = n
+	}
+	for _, n := range nodes {
+		for _, peer := range nodes {
+			if peer != n {
+				n.transport.Connect(peer.addr, peer.transport)
+			}
+		}
+		store := raft.NewInmemStore()
+		raftconfig := inmemConfig()
+		raftconfig.LogLevel = "info"
+		raftconfig.LocalID = n.id
+		cluster, err := raft.NewRaft(raftconfig, fsm.NewPrioritizedFSM(n.dataStorage), store, store, raft.NewInmemSnapshotStore(), n.transport)
+		if err != nil {
+			t.Fatal("exception during staging cluster boot: ", err)
+		}
+		n.cluster = cluster
+	}
+	nodes[0].cluster.BootstrapCluster(configuration)
+
+	leader := func() *node {
+		for _, n := range nodes {
+			if n.cluster.State() == raft.Leader {
+				return n
+			}
+		}
+		return nil
+	}
+	assert.Eventually(t, func() bool {
+		return leader() != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	oldLeader := leader()
+
+	// every node runs the processor and the dispatcher, the leader hangs on the delivery
+	for _, n := range nodes {
+		ctx, cancel := context.WithCancel(context.Background())
+		n.stop = cancel
+		n.publisher = &recordingPublisher{ctx: ctx, hang: n == oldLeader}
+		d := dispatcher.NewDispatcher(ctx, n.dataStorage)
+		d.SetCluster(n.cluster)
+		d.SetPublisher(c.ID, n.publisher)
+		p := &Processor{
+			dispatcher:   d,
+			dataStorage:  n.dataStorage,
+			time:         RealTime{},
+			context:      ctx,
+			cluster:      n.cluster,
+			channel:      c,
+			batchSize:    2,
+			leaseTimeout: time.Second,
+		}
+		go func() {
+			_ = d.Dispatch()
+		}()
+		go func() {
+			_ = p.Process()
+		}()
+	}
+	defer func() {
+		for _, n := range nodes {
+			n.stop()
+			_ = n.cluster.Shutdown()
+		}
+	}()
+
+	want := make([]message.Message, 5)
+	for i := range want {
+		want[i] = message.NewMessage([]byte("msg"+strconv.Itoa(i)), message.UnixMilli(time.Now())-1000)
+		payload, _ := json.Marshal(fsm.CommandPayload{
+			Operation: fsm.OperationMessagePush,
+			ChannelID: c.ID,
+			Message:   want[i],
+			Timestamp: message.UnixMilli(time.Now()),
+		})
+		assert.NoError(t, oldLeader.cluster.Apply(payload, time.Second).Error())
+	}
+
+	// all messages are in flight, the leader is stuck in the delivery
+	assert.Eventually(t, func() bool {
+		return len(oldLeader.dataStorage.DumpLeases()[c.ID]) == len(want) && oldLeader.publisher.getCalls() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// leader crashes mid-dispatch
+	oldLeader.stop()
+	oldLeader.transport.DisconnectAll()
+	for _, n := range nodes {
+		if n != oldLeader {
+			n.transport.Disconnect(oldLeader.addr)
+		}
+	}
+	_ = oldLeader.cluster.Shutdown()
+
+	// new leader re-dispatches expired leases and acknowledges them
+	var newLeader *node
+	assert.Eventually(t, func() bool {
+		newLeader = leader()
+		return newLeader != nil && newLeader != oldLeader
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		chStorage, _ := newLeader.dataStorage.GetChannelStorage(c.ID)
+		return len(chStorage.DumpLeases()) == 0 && chStorage.IsEmpty()
+	}, 10*time.Second, 50*time.Millisecond)
+
+	delivered := []message.Message{}
+	for _, n := range nodes {
+		if n != oldLeader {
+			delivered = append(delivered, n.publisher.getDispatched()...)
+		}
+	}
+	// messages are delivered at least once, a lease may expire before its acknowledgement is committed
+	assert.Empty(t, oldLeader.publisher.getDispatched())
+	assert.Subset(t, delivered, want)
+	assert.Subset(t, want, delivered)
+}
@@ -0,0 +1,57 @@
+package processor
+
+import (
+	"github.com/maksimru/event-scheduler/channel"
+	"math"
+)
+
+// tokenBucket limits deliveries of the channel, tokens are refilled at the rate up to the burst, times are in milliseconds
+type tokenBucket struct {
+	// rate is the number of tokens refilled per millisecond
+	rate      float64
+	burst     float64
+	tokens    float64
+	updatedAt int
+}
+
+// newTokenBucket returns the full bucket of the channel rate limit, channels without the limit don't have one
+func newTokenBucket(limit *channel.RateLimit, now int) *tokenBucket {
+	if limit == nil || limit.Rate <= 0 {
+		return nil
+	}
+	burst := float64(limit.GetBurst())
+	return &tokenBucket{
+		rate:      limit.Rate / 1000,
+		burst:     burst,
+		tokens:    burst,
+		updatedAt: now,
+	}
+}
+
+func (b *tokenBucket) refill(now int) {
+	if now <= b.updatedAt {
+		return
+	}
+	b.tokens = math.Min(b.burst, b.tokens+float64(now-b.updatedAt)*b.rate)
+	b.updatedAt = now
+}
+
+// available returns the number of messages which can be delivered at the time
+func (b *tokenBucket) available(now int) int {
+	b.refill(now)
+	return int(b.tokens)
+}
+
+// take spends the tokens of the delivered messages
+func (b *tokenBucket) take(n int) {
+	b.tokens -= float64(n)
+}
+
+// nextAt returns the time when the next message can be delivered
+func (b *tokenBucket) nextAt(now int) int {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return now
+	}
+	return now + int(math.Ceil((1-b.tokens)/b.rate))
+}
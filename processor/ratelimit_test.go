@@ -0,0 +1,29 @@
+package processor
+
+import (
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_tokenBucket(t *testing.T) {
+	assert.Nil(t, newTokenBucket(nil, 0))
+	assert.Nil(t, newTokenBucket(&channel.RateLimit{}, 0))
+
+	// full bucket lets the burst through
+	b := newTokenBucket(&channel.RateLimit{Rate: 2, Burst: 3}, 1000)
+	assert.Equal(t, 3, b.available(1000))
+	assert.Equal(t, 1000, b.nextAt(1000))
+	b.take(3)
+	assert.Equal(t, 0, b.available(1000))
+
+	// tokens are refilled at the rate
+	assert.Equal(t, 1500, b.nextAt(1000))
+	assert.Equal(t, 0, b.available(1499))
+	assert.Equal(t, 1, b.available(1500))
+	b.take(1)
+	assert.Equal(t, 2000, b.nextAt(1600))
+
+	// idle bucket doesn't grow over the burst
+	assert.Equal(t, 3, b.available(60000))
+}
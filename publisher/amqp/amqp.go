@@ -0,0 +1,154 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/message"
+	amqpconfig "github.com/maksimru/event-scheduler/publisher/amqp/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"io"
+	"sync"
+)
+
+var ErrPublishNotConfirmed = errors.New("publishing is not confirmed by the broker")
+
+// Channel is the subset of amqp.Channel used by the publisher
+type Channel interface {
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+type Publisher struct {
+	config      amqpconfig.DestinationConfig
+	mutex       *sync.Mutex
+	connection  io.Closer
+	amqpChannel Channel
+	confirms    chan amqp.Confirmation
+	context     context.Context
+}
+
+func NewAmqpPublisher(ctx context.Context, config channel.DestinationConfig) *Publisher {
+	cfg := config.(amqpconfig.DestinationConfig)
+	if cfg.URL == "" {
+		panic("exception during publisher boot: url is required")
+	}
+	return &Publisher{
+		config:  cfg,
+		mutex:   &sync.Mutex{},
+		context: ctx,
+	}
+}
+
+func (p *Publisher) SetAmqpChannel(amqpChannel Channel) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.useChannel(amqpChannel)
+}
+
+func (p *Publisher) useChannel(amqpChannel Channel) error {
+	if err := amqpChannel.Confirm(false); err != nil {
+		log.Error("publisher confirm mode failure: ", err.Error())
+		return err
+	}
+	p.amqpChannel = amqpChannel
+	p.confirms = amqpChannel.NotifyPublish(make(chan amqp.Confirmation, 1))
+	return nil
+}
+
+// connect opens the broker connection lazily and after delivery failures
+func (p *Publisher) connect() error {
+	if p.amqpChannel != nil {
+		return nil
+	}
+	connection, err := amqp.Dial(p.config.URL)
+	if err != nil {
+		log.Error("publisher client connection failure: ", err.Error())
+		return err
+	}
+	amqpChannel, err := connection.Channel()
+	if err != nil {
+		_ = connection.Close()
+		log.Error("publisher client channel failure: ", err.Error())
+		return err
+	}
+	if err := p.useChannel(amqpChannel); err != nil {
+		_ = connection.Close()
+		return err
+	}
+	p.connection = connection
+	return nil
+}
+
+func (p *Publisher) disconnect() error {
+	var err error
+	if p.amqpChannel != nil {
+		err = p.amqpChannel.Close()
+	}
+	if p.connection != nil {
+		_ = p.connection.Close()
+	}
+	p.amqpChannel, p.connection, p.confirms = nil, nil, nil
+	return err
+}
+
+func (p *Publisher) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	err := p.disconnect()
+	if err != nil {
+		log.Error("publisher client termination failure: ", err.Error())
+		return err
+	}
+	return nil
+}
+
+func (p *Publisher) Dispatch(msg message.Message) error {
+	// confirmations are matched to publishings by order, so publishings are serialized
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.connect(); err != nil {
+		return err
+	}
+
+	var headers amqp.Table
+	for k, v := range msg.GetAttributes() {
+		if headers == nil {
+			headers = amqp.Table{}
+		}
+		headers[k] = v
+	}
+	err := p.amqpChannel.Publish(p.config.Exchange, p.config.RoutingKey, false, false, amqp.Publishing{
+		Headers:      headers,
+		Body:         msg.GetBody(),
+		DeliveryMode: amqp.Persistent,
+	})
+	if err == nil {
+		err = p.waitForConfirmation()
+	}
+	if err != nil {
+		log.Warn("publisher message delivery exception:", err.Error())
+		// reconnect on the next delivery
+		_ = p.disconnect()
+		return err
+	}
+	log.Trace("publisher message published to ", p.config.Exchange, " with routing key ", p.config.RoutingKey)
+
+	return nil
+}
+
+func (p *Publisher) waitForConfirmation() error {
+	select {
+	case <-p.context.Done():
+		return p.context.Err()
+	case confirmation, ok := <-p.confirms:
+		if !ok || !confirmation.Ack {
+			return ErrPublishNotConfirmed
+		}
+		return nil
+	}
+}
@@ -0,0 +1,7 @@
+package amqpconfig
+
+type DestinationConfig struct {
+	URL        string `json:"url" xml:"url" mapstructure:"url" validate:"required"`
+	Exchange   string `json:"exchange" xml:"exchange" mapstructure:"exchange"`
+	RoutingKey string `json:"routing_key" xml:"routing_key" mapstructure:"routing_key"`
+}
@@ -0,0 +1,6 @@
+package kafkaconfig
+
+type DestinationConfig struct {
+	Brokers []string `json:"brokers" xml:"brokers" mapstructure:"brokers" validate:"required,min=1"`
+	Topic   string   `json:"topic" xml:"topic" mapstructure:"topic" validate:"required"`
+}
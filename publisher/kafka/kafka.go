@@ -0,0 +1,80 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/message"
+	kafkaconfig "github.com/maksimru/event-scheduler/publisher/kafka/config"
+	"github.com/segmentio/kafka-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// Writer is the subset of kafka.Writer used by the publisher
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+type Publisher struct {
+	config  kafkaconfig.DestinationConfig
+	writer  Writer
+	context context.Context
+}
+
+func NewKafkaPublisher(ctx context.Context, config channel.DestinationConfig) *Publisher {
+	cfg := config.(kafkaconfig.DestinationConfig)
+	writer, err := makeKafkaWriter(cfg)
+	if err != nil {
+		panic("exception during publisher boot: " + err.Error())
+	}
+	return &Publisher{
+		config:  cfg,
+		writer:  writer,
+		context: ctx,
+	}
+}
+
+func (p *Publisher) SetKafkaWriter(writer Writer) {
+	p.writer = writer
+}
+
+func makeKafkaWriter(config kafkaconfig.DestinationConfig) (Writer, error) {
+	if len(config.Brokers) == 0 || config.Topic == "" {
+		err := errors.New("brokers and topic are required")
+		log.Error("publisher client boot failure: ", err.Error())
+		return nil, err
+	}
+	return &kafka.Writer{
+		Addr:     kafka.TCP(config.Brokers...),
+		Topic:    config.Topic,
+		Balancer: &kafka.LeastBytes{},
+	}, nil
+}
+
+func (p *Publisher) Close() error {
+	err := p.writer.Close()
+	if err != nil {
+		log.Error("publisher client termination failure: ", err.Error())
+		return err
+	}
+	return nil
+}
+
+func (p *Publisher) Dispatch(msg message.Message) error {
+	var headers []kafka.Header
+	for k, v := range msg.GetAttributes() {
+		headers = append(headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+	err := p.writer.WriteMessages(p.context, kafka.Message{
+		Value:   msg.GetBody(),
+		Headers: headers,
+	})
+	if err != nil {
+		log.Warn("publisher message delivery exception:", err.Error())
+		return err
+	}
+	log.Trace("publisher message published to ", p.config.Topic)
+
+	return nil
+}
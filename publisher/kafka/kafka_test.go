@@ -0,0 +1,148 @@
+package kafka
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"github.com/maksimru/event-scheduler/message"
+	kafkaconfig "github.com/maksimru/event-scheduler/publisher/kafka/config"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type mockWriter struct {
+	broken  bool
+	written []kafka.Message
+	closed  bool
+}
+
+func (w *mockWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	if w.broken {
+		return errors.New("write failure")
+	}
+	w.written = append(w.written, msgs...)
+	return nil
+}
+
+func (w *mockWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestNewKafkaPublisher(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    kafkaconfig.DestinationConfig
+		wantPanic bool
+	}{
+		{
+			name: "Checks kafka publisher constructor",
+			config: kafkaconfig.DestinationConfig{
+				Brokers: []string{"localhost:9092"},
+				Topic:   "topic",
+			},
+			wantPanic: false,
+		},
+		{
+			name: "Checks kafka publisher constructor without topic",
+			config: kafkaconfig.DestinationConfig{
+				Brokers: []string{"localhost:9092"},
+			},
+			wantPanic: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantPanic {
+				assert.Panics(t, func() {
+					NewKafkaPublisher(context.Background(), tt.config)
+				})
+				return
+			}
+			p := NewKafkaPublisher(context.Background(), tt.config)
+			assert.Equal(t, tt.config, p.config)
+			assert.IsType(t, &kafka.Writer{}, p.writer)
+			assert.NoError(t, p.Close())
+		})
+	}
+}
+
+func TestKafkaPublisher_Dispatch(t *testing.T) {
+	binaryBody := randomBody(1024)
+	tests := []struct {
+		name    string
+		broken  bool
+		wantErr bool
+		publish []message.Message
+		want    []kafka.Message
+	}{
+		{
+			name:    "Check kafka publisher can publish single message",
+			publish: []message.Message{message.NewMessage([]byte("foo"), 1000)},
+			wantErr: false,
+			want:    []kafka.Message{{Value: []byte("foo")}},
+		},
+		{
+			name:    "Check kafka publisher can publish binary message",
+			publish: []message.Message{message.NewMessage(binaryBody, 1000)},
+			wantErr: false,
+			want:    []kafka.Message{{Value: binaryBody}},
+		},
+		{
+			name: "Check kafka publisher can publish multiple message",
+			publish: []message.Message{
+				message.NewMessage([]byte("msg1"), 1000),
+				message.NewMessage([]byte("msg2"), 1100),
+				message.NewMessage([]byte("msg3"), 1200),
+			},
+			wantErr: false,
+			want: []kafka.Message{
+				{Value: []byte("msg1")},
+				{Value: []byte("msg2")},
+				{Value: []byte("msg3")},
+			},
+		},
+		{
+			name:    "Check kafka publisher publishes attributes as headers",
+			publish: []message.Message{message.NewMessageWithAttributes([]byte("foo"), 1000, map[string]string{"type": "bar"})},
+			wantErr: false,
+			want:    []kafka.Message{{Value: []byte("foo"), Headers: []kafka.Header{{Key: "type", Value: []byte("bar")}}}},
+		},
+		{
+			name:    "Check kafka publisher returns delivery errors",
+			broken:  true,
+			publish: []message.Message{message.NewMessage([]byte("foo"), 1000)},
+			wantErr: true,
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := &mockWriter{broken: tt.broken}
+			p := &Publisher{
+				config:  kafkaconfig.DestinationConfig{Topic: "topic"},
+				context: context.Background(),
+			}
+			p.SetKafkaWriter(writer)
+
+			for _, msg := range tt.publish {
+				if !tt.wantErr {
+					assert.NoError(t, p.Dispatch(msg))
+				} else {
+					assert.Error(t, p.Dispatch(msg))
+				}
+			}
+
+			assert.Equal(t, tt.want, writer.written)
+			assert.NoError(t, p.Close())
+			assert.True(t, writer.closed)
+		})
+	}
+}
+
+func randomBody(size int) []byte {
+	body := make([]byte, size)
+	_, _ = rand.Read(body)
+	return body
+}
@@ -0,0 +1,7 @@
+package natsconfig
+
+type DestinationConfig struct {
+	URL     string            `json:"url" xml:"url" mapstructure:"url" validate:"required"`
+	Subject string            `json:"subject" xml:"subject" mapstructure:"subject" validate:"required"`
+	Headers map[string]string `json:"headers" xml:"headers" mapstructure:"headers"`
+}
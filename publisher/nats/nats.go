@@ -0,0 +1,90 @@
+package nats
+
+import (
+	"context"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/message"
+	natsconfig "github.com/maksimru/event-scheduler/publisher/nats/config"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+	"sync"
+)
+
+type Publisher struct {
+	config     natsconfig.DestinationConfig
+	mutex      *sync.Mutex
+	connection *nats.Conn
+	jetStream  nats.JetStreamContext
+	context    context.Context
+}
+
+func NewNatsPublisher(ctx context.Context, config channel.DestinationConfig) *Publisher {
+	cfg := config.(natsconfig.DestinationConfig)
+	if cfg.URL == "" || cfg.Subject == "" {
+		panic("exception during publisher boot: url and subject are required")
+	}
+	return &Publisher{
+		config:  cfg,
+		mutex:   &sync.Mutex{},
+		context: ctx,
+	}
+}
+
+// connect opens the server connection lazily and after the connection is closed
+func (p *Publisher) connect() error {
+	if p.connection != nil && !p.connection.IsClosed() {
+		return nil
+	}
+	connection, err := nats.Connect(p.config.URL)
+	if err != nil {
+		log.Error("publisher client connection failure: ", err.Error())
+		return err
+	}
+	js, err := connection.JetStream()
+	if err != nil {
+		connection.Close()
+		log.Error("publisher client jetstream failure: ", err.Error())
+		return err
+	}
+	p.connection, p.jetStream = connection, js
+	return nil
+}
+
+func (p *Publisher) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.connection != nil {
+		p.connection.Close()
+	}
+	p.connection, p.jetStream = nil, nil
+	return nil
+}
+
+func (p *Publisher) Dispatch(msg message.Message) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.connect(); err != nil {
+		return err
+	}
+
+	natsMsg := nats.NewMsg(p.config.Subject)
+	natsMsg.Data = msg.GetBody()
+	for k, v := range p.config.Headers {
+		natsMsg.Header.Set(k, v)
+	}
+	// message attributes are more specific than the channel headers
+	for k, v := range msg.GetAttributes() {
+		natsMsg.Header[k] = []string{v}
+	}
+
+	// stream acknowledgement confirms the message is stored
+	ack, err := p.jetStream.PublishMsg(natsMsg, nats.Context(p.context))
+	if err != nil {
+		log.Warn("publisher message delivery exception:", err.Error())
+		return err
+	}
+	log.Trace("publisher message published to stream ", ack.Stream, " with sequence ", ack.Sequence)
+
+	return nil
+}
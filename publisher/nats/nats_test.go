@@ -0,0 +1,178 @@
+package nats
+
+import (
+	"context"
+	"crypto/rand"
+	"github.com/maksimru/event-scheduler/message"
+	natsconfig "github.com/maksimru/event-scheduler/publisher/nats/config"
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// runJetStreamServer starts embedded nats server with the stream capturing the subject
+func runJetStreamServer(t *testing.T, stream string, subject string) (*server.Server, func()) {
+	dir, err := ioutil.TempDir("", "nats-publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = dir
+	s := natsserver.RunServer(&opts)
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	js, _ := nc.JetStream()
+	if _, err := js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{subject}}); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, func() {
+		s.Shutdown()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestNewNatsPublisher(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    natsconfig.DestinationConfig
+		wantPanic bool
+	}{
+		{
+			name: "Checks nats publisher constructor",
+			config: natsconfig.DestinationConfig{
+				URL:     "nats://localhost:4222",
+				Subject: "released",
+			},
+			wantPanic: false,
+		},
+		{
+			name:      "Checks nats publisher constructor without subject",
+			config:    natsconfig.DestinationConfig{URL: "nats://localhost:4222"},
+			wantPanic: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantPanic {
+				assert.Panics(t, func() {
+					NewNatsPublisher(context.Background(), tt.config)
+				})
+				return
+			}
+			p := NewNatsPublisher(context.Background(), tt.config)
+			assert.Equal(t, tt.config, p.config)
+			assert.NoError(t, p.Close())
+		})
+	}
+}
+
+func TestNatsPublisher_Dispatch(t *testing.T) {
+	binaryBody := randomBody(1024)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		publish []message.Message
+		want    []*nats.Msg
+	}{
+		{
+			name:    "Check nats publisher can publish single message",
+			publish: []message.Message{message.NewMessage([]byte("foo"), 1000)},
+			want:    []*nats.Msg{{Data: []byte("foo")}},
+		},
+		{
+			name:    "Check nats publisher can publish binary message",
+			publish: []message.Message{message.NewMessage(binaryBody, 1000)},
+			want:    []*nats.Msg{{Data: binaryBody}},
+		},
+		{
+			name:    "Check nats publisher can publish multiple messages with headers",
+			headers: map[string]string{"event_type": "scheduled"},
+			publish: []message.Message{
+				message.NewMessage([]byte("msg1"), 1000),
+				message.NewMessage([]byte("msg2"), 1100),
+			},
+			want: []*nats.Msg{
+				{Data: []byte("msg1"), Header: nats.Header{"event_type": []string{"scheduled"}}},
+				{Data: []byte("msg2"), Header: nats.Header{"event_type": []string{"scheduled"}}},
+			},
+		},
+		{
+			name:    "Check nats publisher publishes attributes as headers",
+			headers: map[string]string{"event_type": "scheduled", "type": "default"},
+			publish: []message.Message{
+				message.NewMessageWithAttributes([]byte("foo"), 1000, map[string]string{"type": "bar"}),
+			},
+			want: []*nats.Msg{
+				{Data: []byte("foo"), Header: nats.Header{"event_type": []string{"scheduled"}, "type": []string{"bar"}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			natsServer, shutdown := runJetStreamServer(t, "RELEASED", "released")
+			defer shutdown()
+
+			p := NewNatsPublisher(context.Background(), natsconfig.DestinationConfig{
+				URL:     natsServer.ClientURL(),
+				Subject: "released",
+				Headers: tt.headers,
+			})
+			for _, msg := range tt.publish {
+				assert.NoError(t, p.Dispatch(msg))
+			}
+			assert.NoError(t, p.Close())
+
+			nc, err := nats.Connect(natsServer.ClientURL())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer nc.Close()
+			js, _ := nc.JetStream()
+			sub, err := js.SubscribeSync("released")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				got, err := sub.NextMsg(time.Second)
+				if assert.NoError(t, err) {
+					assert.Equal(t, want.Data, got.Data)
+					for k := range want.Header {
+						assert.Equal(t, want.Header.Get(k), got.Header.Get(k))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNatsPublisher_DispatchWithoutStream(t *testing.T) {
+	natsServer, shutdown := runJetStreamServer(t, "RELEASED", "released")
+	defer shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	p := NewNatsPublisher(ctx, natsconfig.DestinationConfig{
+		URL:     natsServer.ClientURL(),
+		Subject: "unknown",
+	})
+	assert.Error(t, p.Dispatch(message.NewMessage([]byte("foo"), 1000)))
+	assert.NoError(t, p.Close())
+}
+
+func randomBody(size int) []byte {
+	body := make([]byte, size)
+	_, _ = rand.Read(body)
+	return body
+}
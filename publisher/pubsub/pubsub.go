@@ -54,7 +54,8 @@ func (p *Publisher) Close() error {
 func (p *Publisher) Dispatch(msg message.Message) error {
 	t := p.client.Topic(p.config.TopicID)
 	result := t.Publish(p.context, &pubsub.Message{
-		Data: []byte(msg.GetBody().(string)),
+		Data:       msg.GetBody(),
+		Attributes: msg.GetAttributes(),
 	})
 
 	id, err := result.Get(p.context)
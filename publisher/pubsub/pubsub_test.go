@@ -4,6 +4,7 @@ import (
 	"cloud.google.com/go/pubsub"
 	"cloud.google.com/go/pubsub/pstest"
 	"context"
+	"crypto/rand"
 	"github.com/maksimru/event-scheduler/channel"
 	"github.com/maksimru/event-scheduler/message"
 	pubsubconfig "github.com/maksimru/event-scheduler/publisher/pubsub/config"
@@ -115,6 +116,7 @@ func mockPubsubClient(ctx context.Context, t *testing.T, pubsubServerConn *grpc.
 }
 
 func TestPubsubPublisher_Dispatch(t *testing.T) {
+	binaryBody := randomBody(1024)
 	tests := []struct {
 		name    string
 		wantErr bool
@@ -123,18 +125,26 @@ func TestPubsubPublisher_Dispatch(t *testing.T) {
 	}{
 		{
 			name:    "Check pubsub publisher can publish single message",
-			publish: []message.Message{message.NewMessage("foo", 1000)},
+			publish: []message.Message{message.NewMessage([]byte("foo"), 1000)},
 			wantErr: false,
 			want: []pubsub.Message{{
 				Data: []byte("foo"),
 			}},
 		},
+		{
+			name:    "Check pubsub publisher can publish binary message",
+			publish: []message.Message{message.NewMessage(binaryBody, 1000)},
+			wantErr: false,
+			want: []pubsub.Message{{
+				Data: binaryBody,
+			}},
+		},
 		{
 			name: "Check pubsub publisher can publish multiple message",
 			publish: []message.Message{
-				message.NewMessage("msg1", 1000),
-				message.NewMessage("msg2", 1100),
-				message.NewMessage("msg3", 1200),
+				message.NewMessage([]byte("msg1"), 1000),
+				message.NewMessage([]byte("msg2"), 1100),
+				message.NewMessage([]byte("msg3"), 1200),
 			},
 			wantErr: false,
 			want: []pubsub.Message{{
@@ -145,6 +155,15 @@ func TestPubsubPublisher_Dispatch(t *testing.T) {
 				Data: []byte("msg3"),
 			}},
 		},
+		{
+			name:    "Check pubsub publisher publishes message attributes",
+			publish: []message.Message{message.NewMessageWithAttributes([]byte("foo"), 1000, map[string]string{"type": "bar"})},
+			wantErr: false,
+			want: []pubsub.Message{{
+				Data:       []byte("foo"),
+				Attributes: map[string]string{"type": "bar"},
+			}},
+		},
 	}
 	for testID, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -241,3 +260,9 @@ func TestPublisher_Close(t *testing.T) {
 		})
 	}
 }
+
+func randomBody(size int) []byte {
+	body := make([]byte, size)
+	_, _ = rand.Read(body)
+	return body
+}
@@ -0,0 +1,10 @@
+package redisconfig
+
+type DestinationConfig struct {
+	Addr         string `json:"addr" xml:"addr" mapstructure:"addr" validate:"required"`
+	Password     string `json:"password" xml:"password" mapstructure:"password"`
+	DB           int    `json:"db" xml:"db" mapstructure:"db" validate:"min=0"`
+	Stream       string `json:"stream" xml:"stream" mapstructure:"stream" validate:"required"`
+	MaxLen       int64  `json:"max_len" xml:"max_len" mapstructure:"max_len" validate:"min=0"`
+	MaxLenApprox bool   `json:"max_len_approx" xml:"max_len_approx" mapstructure:"max_len_approx"`
+}
@@ -0,0 +1,71 @@
+package redis
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/message"
+	redisconfig "github.com/maksimru/event-scheduler/publisher/redis/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const FieldBody = "body"
+
+type Publisher struct {
+	config  redisconfig.DestinationConfig
+	client  *redis.Client
+	context context.Context
+}
+
+func NewRedisPublisher(ctx context.Context, config channel.DestinationConfig) *Publisher {
+	cfg := config.(redisconfig.DestinationConfig)
+	if cfg.Addr == "" || cfg.Stream == "" {
+		panic("exception during publisher boot: addr and stream are required")
+	}
+	// client connects lazily
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+	return &Publisher{
+		config:  cfg,
+		client:  client,
+		context: ctx,
+	}
+}
+
+func (p *Publisher) Close() error {
+	err := p.client.Close()
+	if err != nil {
+		log.Error("publisher client termination failure: ", err.Error())
+		return err
+	}
+	return nil
+}
+
+func (p *Publisher) Dispatch(msg message.Message) error {
+	values := make(map[string]interface{}, len(msg.GetAttributes())+1)
+	for k, v := range msg.GetAttributes() {
+		values[k] = v
+	}
+	// body field can't be overridden by the attribute
+	values[FieldBody] = msg.GetBody()
+	args := &redis.XAddArgs{
+		Stream: p.config.Stream,
+		Values: values,
+	}
+	if p.config.MaxLenApprox {
+		args.MaxLenApprox = p.config.MaxLen
+	} else {
+		args.MaxLen = p.config.MaxLen
+	}
+	id, err := p.client.XAdd(p.context, args).Result()
+	if err != nil {
+		log.Warn("publisher message delivery exception:", err.Error())
+		return err
+	}
+	log.Trace("publisher message published: ", id)
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/maksimru/event-scheduler/message"
+	redisconfig "github.com/maksimru/event-scheduler/publisher/redis/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewRedisPublisher(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    redisconfig.DestinationConfig
+		wantPanic bool
+	}{
+		{
+			name: "Checks redis publisher constructor",
+			config: redisconfig.DestinationConfig{
+				Addr:   "localhost:6379",
+				Stream: "released",
+			},
+			wantPanic: false,
+		},
+		{
+			name:      "Checks redis publisher constructor without stream",
+			config:    redisconfig.DestinationConfig{Addr: "localhost:6379"},
+			wantPanic: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantPanic {
+				assert.Panics(t, func() {
+					NewRedisPublisher(context.Background(), tt.config)
+				})
+				return
+			}
+			p := NewRedisPublisher(context.Background(), tt.config)
+			assert.Equal(t, tt.config, p.config)
+			assert.NoError(t, p.Close())
+		})
+	}
+}
+
+func TestRedisPublisher_Dispatch(t *testing.T) {
+	binaryBody := randomBody(1024)
+	tests := []struct {
+		name    string
+		config  redisconfig.DestinationConfig
+		publish []message.Message
+		want    []string
+	}{
+		{
+			name:    "Check redis publisher can publish single message",
+			config:  redisconfig.DestinationConfig{Stream: "released"},
+			publish: []message.Message{message.NewMessage([]byte("foo"), 1000)},
+			want:    []string{"foo"},
+		},
+		{
+			name:    "Check redis publisher can publish binary message",
+			config:  redisconfig.DestinationConfig{Stream: "released"},
+			publish: []message.Message{message.NewMessage(binaryBody, 1000)},
+			want:    []string{string(binaryBody)},
+		},
+		{
+			name:   "Check redis publisher can publish multiple messages",
+			config: redisconfig.DestinationConfig{Stream: "released"},
+			publish: []message.Message{
+				message.NewMessage([]byte("msg1"), 1000),
+				message.NewMessage([]byte("msg2"), 1100),
+				message.NewMessage([]byte("msg3"), 1200),
+			},
+			want: []string{"msg1", "msg2", "msg3"},
+		},
+		{
+			name:   "Check redis publisher trims stream",
+			config: redisconfig.DestinationConfig{Stream: "released", MaxLen: 2},
+			publish: []message.Message{
+				message.NewMessage([]byte("msg1"), 1000),
+				message.NewMessage([]byte("msg2"), 1100),
+				message.NewMessage([]byte("msg3"), 1200),
+			},
+			want: []string{"msg2", "msg3"},
+		},
+		{
+			name:   "Check redis publisher trims stream approximately",
+			config: redisconfig.DestinationConfig{Stream: "released", MaxLen: 2, MaxLenApprox: true},
+			publish: []message.Message{
+				message.NewMessage([]byte("msg1"), 1000),
+				message.NewMessage([]byte("msg2"), 1100),
+				message.NewMessage([]byte("msg3"), 1200),
+			},
+			// miniredis trims approximate length exactly
+			want: []string{"msg2", "msg3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redisServer, err := miniredis.Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer redisServer.Close()
+
+			cfg := tt.config
+			cfg.Addr = redisServer.Addr()
+			p := NewRedisPublisher(context.Background(), cfg)
+			for _, msg := range tt.publish {
+				assert.NoError(t, p.Dispatch(msg))
+			}
+			assert.NoError(t, p.Close())
+
+			entries, err := redisServer.Stream(cfg.Stream)
+			assert.NoError(t, err)
+			got := []string{}
+			for _, entry := range entries {
+				assert.Equal(t, FieldBody, entry.Values[0])
+				got = append(got, entry.Values[1])
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRedisPublisher_DispatchAttributes(t *testing.T) {
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redisServer.Close()
+
+	p := NewRedisPublisher(context.Background(), redisconfig.DestinationConfig{Addr: redisServer.Addr(), Stream: "released"})
+	msg := message.NewMessageWithAttributes([]byte("foo"), 1000, map[string]string{"type": "bar", FieldBody: "baz"})
+	assert.NoError(t, p.Dispatch(msg))
+	assert.NoError(t, p.Close())
+
+	entries, err := redisServer.Stream("released")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	got := map[string]string{}
+	for i := 0; i+1 < len(entries[0].Values); i += 2 {
+		got[entries[0].Values[i]] = entries[0].Values[i+1]
+	}
+	// attribute can't override the body field
+	assert.Equal(t, map[string]string{FieldBody: "foo", "type": "bar"}, got)
+}
+
+func TestRedisPublisher_DispatchWithoutServer(t *testing.T) {
+	p := NewRedisPublisher(context.Background(), redisconfig.DestinationConfig{
+		Addr:   "127.0.0.1:1",
+		Stream: "released",
+	})
+	assert.Error(t, p.Dispatch(message.NewMessage([]byte("foo"), 1000)))
+	assert.NoError(t, p.Close())
+}
+
+func randomBody(size int) []byte {
+	body := make([]byte, size)
+	_, _ = rand.Read(body)
+	return body
+}
@@ -3,10 +3,13 @@ package test
 import (
 	"errors"
 	"github.com/maksimru/event-scheduler/message"
+	"sync"
 )
 
 type Publisher struct {
-	broken     bool
+	broken bool
+	// mutex guards dispatched messages, dispatcher workers publish concurrently
+	mutex      sync.Mutex
 	dispatched []message.Message
 }
 
@@ -28,6 +31,8 @@ func (p *Publisher) Dispatch(msg message.Message) error {
 	if p.broken {
 		return errors.New("publisher dispatch exception")
 	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	if p.dispatched == nil {
 		p.dispatched = make([]message.Message, 0)
 	}
@@ -36,7 +41,12 @@ func (p *Publisher) Dispatch(msg message.Message) error {
 }
 
 func (p *Publisher) GetDispatched() []message.Message {
-	return p.dispatched
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.dispatched == nil {
+		return nil
+	}
+	return append([]message.Message{}, p.dispatched...)
 }
 
 func (p *Publisher) Close() error {
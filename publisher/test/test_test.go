@@ -21,13 +21,13 @@ func TestPublisher_Push(t *testing.T) {
 			args: args{
 				msg: message.Message{
 					AvailableAt: 0,
-					Body:        "test",
+					Body:        []byte("test"),
 				},
 			},
 			want: []message.Message{
 				{
 					AvailableAt: 0,
-					Body:        "test",
+					Body:        []byte("test"),
 				},
 			},
 			wantErr: false,
@@ -0,0 +1,10 @@
+package webhookconfig
+
+type DestinationConfig struct {
+	URL                string            `json:"url" xml:"url" mapstructure:"url" validate:"required,url"`
+	Method             string            `json:"method" xml:"method" mapstructure:"method" validate:"omitempty,oneof=POST PUT PATCH"`
+	Headers            map[string]string `json:"headers" xml:"headers" mapstructure:"headers"`
+	TimeoutMs          int               `json:"timeout_ms" xml:"timeout_ms" mapstructure:"timeout_ms" validate:"min=0"`
+	SuccessStatusCodes []int             `json:"success_status_codes" xml:"success_status_codes" mapstructure:"success_status_codes" validate:"dive,min=100,max=599"`
+	SigningSecret      string            `json:"signing_secret" xml:"signing_secret" mapstructure:"signing_secret"`
+}
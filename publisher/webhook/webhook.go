@@ -0,0 +1,137 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/message"
+	webhookconfig "github.com/maksimru/event-scheduler/publisher/webhook/config"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMethod  = http.MethodPost
+	defaultTimeout = 10 * time.Second
+)
+
+const (
+	HeaderAvailableAt = "X-Event-Scheduler-Available-At"
+	HeaderTimestamp   = "X-Event-Scheduler-Timestamp"
+	HeaderSignature   = "X-Event-Scheduler-Signature"
+	// HeaderAttributePrefix is followed by the attribute name, attributes can't override the configured headers
+	HeaderAttributePrefix = "X-Event-Scheduler-Attribute-"
+)
+
+type Publisher struct {
+	config  webhookconfig.DestinationConfig
+	client  *http.Client
+	context context.Context
+}
+
+func NewWebhookPublisher(ctx context.Context, config channel.DestinationConfig) *Publisher {
+	cfg := config.(webhookconfig.DestinationConfig)
+	if cfg.URL == "" {
+		panic("exception during publisher boot: url is required")
+	}
+	timeout := defaultTimeout
+	if cfg.TimeoutMs > 0 {
+		timeout = time.Duration(cfg.TimeoutMs) * time.Millisecond
+	}
+	return &Publisher{
+		config:  cfg,
+		client:  &http.Client{Timeout: timeout},
+		context: ctx,
+	}
+}
+
+func (p *Publisher) SetHttpClient(client *http.Client) {
+	p.client = client
+}
+
+func (p *Publisher) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
+
+func (p *Publisher) Dispatch(msg message.Message) error {
+	req, err := p.makeRequest(msg)
+	if err != nil {
+		log.Error("publisher request preparation exception: ", err.Error())
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		log.Warn("publisher message delivery exception:", err.Error())
+		return err
+	}
+	defer func() {
+		// drain the body to let the connection be reused
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	if !p.isSuccessful(resp.StatusCode) {
+		err := fmt.Errorf("webhook responded with unexpected status code %d", resp.StatusCode)
+		log.Warn("publisher message delivery exception:", err.Error())
+		return err
+	}
+	log.Trace("publisher message published: ", resp.StatusCode)
+
+	return nil
+}
+
+func (p *Publisher) makeRequest(msg message.Message) (*http.Request, error) {
+	body := msg.GetBody()
+	method := p.config.Method
+	if method == "" {
+		method = defaultMethod
+	}
+	req, err := http.NewRequestWithContext(p.context, method, p.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range msg.GetAttributes() {
+		req.Header.Set(HeaderAttributePrefix+k, v)
+	}
+	for k, v := range p.config.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set(HeaderAvailableAt, message.FormatAvailableAt(msg.GetAvailableAt()))
+	if p.config.SigningSecret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(HeaderTimestamp, timestamp)
+		req.Header.Set(HeaderSignature, "sha256="+Sign(p.config.SigningSecret, timestamp, body))
+	}
+	return req, nil
+}
+
+func (p *Publisher) isSuccessful(statusCode int) bool {
+	if len(p.config.SuccessStatusCodes) == 0 {
+		return statusCode >= 200 && statusCode < 300
+	}
+	for _, code := range p.config.SuccessStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign calculates hex encoded HMAC-SHA256 of the "timestamp.body" string,
+// receivers should compare it with the signature header and reject stale timestamps
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
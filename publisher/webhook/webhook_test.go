@@ -0,0 +1,225 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"github.com/maksimru/event-scheduler/message"
+	webhookconfig "github.com/maksimru/event-scheduler/publisher/webhook/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receivedRequest struct {
+	method string
+	header http.Header
+	body   []byte
+}
+
+type mockReceiver struct {
+	mutex      sync.Mutex
+	statusCode int
+	delay      time.Duration
+	received   []receivedRequest
+}
+
+func (r *mockReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.mutex.Lock()
+	r.received = append(r.received, receivedRequest{method: req.Method, header: req.Header, body: body})
+	r.mutex.Unlock()
+	time.Sleep(r.delay)
+	w.WriteHeader(r.statusCode)
+}
+
+func TestNewWebhookPublisher(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      webhookconfig.DestinationConfig
+		wantTimeout time.Duration
+		wantPanic   bool
+	}{
+		{
+			name:        "Checks webhook publisher constructor with default timeout",
+			config:      webhookconfig.DestinationConfig{URL: "http://localhost/hook"},
+			wantTimeout: defaultTimeout,
+		},
+		{
+			name:        "Checks webhook publisher constructor with custom timeout",
+			config:      webhookconfig.DestinationConfig{URL: "http://localhost/hook", TimeoutMs: 1500},
+			wantTimeout: 1500 * time.Millisecond,
+		},
+		{
+			name:      "Checks webhook publisher constructor without url",
+			config:    webhookconfig.DestinationConfig{},
+			wantPanic: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantPanic {
+				assert.Panics(t, func() {
+					NewWebhookPublisher(context.Background(), tt.config)
+				})
+				return
+			}
+			p := NewWebhookPublisher(context.Background(), tt.config)
+			assert.Equal(t, tt.config, p.config)
+			assert.Equal(t, tt.wantTimeout, p.client.Timeout)
+			assert.NoError(t, p.Close())
+		})
+	}
+}
+
+func TestWebhookPublisher_Dispatch(t *testing.T) {
+	binaryBody := randomBody(1024)
+	tests := []struct {
+		name       string
+		config     webhookconfig.DestinationConfig
+		statusCode int
+		delay      time.Duration
+		publish    message.Message
+		wantErr    bool
+		wantMethod string
+		wantHeader map[string]string
+	}{
+		{
+			name:       "Check webhook publisher posts message body with metadata",
+			config:     webhookconfig.DestinationConfig{Headers: map[string]string{"Content-Type": "application/json"}},
+			statusCode: http.StatusAccepted,
+			publish:    message.NewMessage([]byte("{\"foo\":1}"), 1000000),
+			wantErr:    false,
+			wantMethod: http.MethodPost,
+			wantHeader: map[string]string{
+				"Content-Type":    "application/json",
+				HeaderAvailableAt: "1000",
+			},
+		},
+		{
+			name:       "Check webhook publisher sends attributes as prefixed headers",
+			config:     webhookconfig.DestinationConfig{Headers: map[string]string{HeaderAttributePrefix + "Source": "config"}},
+			statusCode: http.StatusOK,
+			publish:    message.NewMessageWithAttributes([]byte("foo"), 1000, map[string]string{"type": "bar", "source": "message"}),
+			wantErr:    false,
+			wantMethod: http.MethodPost,
+			wantHeader: map[string]string{
+				HeaderAttributePrefix + "Type":   "bar",
+				HeaderAttributePrefix + "Source": "config",
+			},
+		},
+		{
+			name:       "Check webhook publisher posts binary message body",
+			config:     webhookconfig.DestinationConfig{Headers: map[string]string{"Content-Type": "application/octet-stream"}},
+			statusCode: http.StatusOK,
+			publish:    message.NewMessage(binaryBody, 1000),
+			wantErr:    false,
+			wantMethod: http.MethodPost,
+		},
+		{
+			name:       "Check webhook publisher uses configured method",
+			config:     webhookconfig.DestinationConfig{Method: http.MethodPut},
+			statusCode: http.StatusOK,
+			publish:    message.NewMessage([]byte("foo"), 1000),
+			wantErr:    false,
+			wantMethod: http.MethodPut,
+		},
+		{
+			name:       "Check webhook publisher fails on non-2xx response",
+			config:     webhookconfig.DestinationConfig{},
+			statusCode: http.StatusServiceUnavailable,
+			publish:    message.NewMessage([]byte("foo"), 1000),
+			wantErr:    true,
+			wantMethod: http.MethodPost,
+		},
+		{
+			name:       "Check webhook publisher accepts configured status codes only",
+			config:     webhookconfig.DestinationConfig{SuccessStatusCodes: []int{http.StatusCreated}},
+			statusCode: http.StatusOK,
+			publish:    message.NewMessage([]byte("foo"), 1000),
+			wantErr:    true,
+			wantMethod: http.MethodPost,
+		},
+		{
+			name:       "Check webhook publisher accepts configured non-2xx status code",
+			config:     webhookconfig.DestinationConfig{SuccessStatusCodes: []int{http.StatusConflict}},
+			statusCode: http.StatusConflict,
+			publish:    message.NewMessage([]byte("foo"), 1000),
+			wantErr:    false,
+			wantMethod: http.MethodPost,
+		},
+		{
+			name:       "Check webhook publisher fails on timeout",
+			config:     webhookconfig.DestinationConfig{TimeoutMs: 50},
+			statusCode: http.StatusOK,
+			delay:      200 * time.Millisecond,
+			publish:    message.NewMessage([]byte("foo"), 1000),
+			wantErr:    true,
+			wantMethod: http.MethodPost,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := &mockReceiver{statusCode: tt.statusCode, delay: tt.delay}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+
+			cfg := tt.config
+			cfg.URL = server.URL
+			p := NewWebhookPublisher(context.Background(), cfg)
+
+			if !tt.wantErr {
+				assert.NoError(t, p.Dispatch(tt.publish))
+			} else {
+				assert.Error(t, p.Dispatch(tt.publish))
+			}
+
+			receiver.mutex.Lock()
+			defer receiver.mutex.Unlock()
+			if assert.Equal(t, 1, len(receiver.received)) {
+				assert.Equal(t, tt.wantMethod, receiver.received[0].method)
+				assert.Equal(t, tt.publish.GetBody(), receiver.received[0].body)
+				for k, v := range tt.wantHeader {
+					assert.Equal(t, v, receiver.received[0].header.Get(k))
+				}
+				assert.Empty(t, receiver.received[0].header.Get(HeaderSignature))
+			}
+		})
+	}
+}
+
+func TestWebhookPublisher_DispatchSigned(t *testing.T) {
+	receiver := &mockReceiver{statusCode: http.StatusOK}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	p := NewWebhookPublisher(context.Background(), webhookconfig.DestinationConfig{
+		URL:           server.URL,
+		SigningSecret: "secret",
+	})
+	assert.NoError(t, p.Dispatch(message.NewMessage([]byte("foo"), 1000)))
+
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	if assert.Equal(t, 1, len(receiver.received)) {
+		req := receiver.received[0]
+		timestamp := req.header.Get(HeaderTimestamp)
+		assert.NotEmpty(t, timestamp)
+		assert.Equal(t, "sha256="+Sign("secret", timestamp, []byte("foo")), req.header.Get(HeaderSignature))
+		assert.NotEqual(t, "sha256="+Sign("other", timestamp, []byte("foo")), req.header.Get(HeaderSignature))
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n "1614556800.foo" | openssl dgst -sha256 -hmac secret
+	assert.Equal(t, "6f51ad0af92ae8ce53f9b4d69c26a076006e0a91698e0ca4c2476fb7c1962978", Sign("secret", "1614556800", []byte("foo")))
+}
+
+func randomBody(size int) []byte {
+	body := make([]byte, size)
+	_, _ = rand.Read(body)
+	return body
+}
@@ -0,0 +1,103 @@
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/maksimru/event-scheduler/message"
+	"github.com/robfig/cron/v3"
+	"time"
+	// timezone database is embedded, so all nodes resolve the same fire times
+	_ "time/tzdata"
+)
+
+var ErrNoFireTime = errors.New("schedule has no next fire time")
+
+// Schedule emits the message to the channel at every fire time of the cron expression
+type Schedule struct {
+	ID string
+	// Cron is the standard 5 fields expression or the descriptor (@daily, @every 1h)
+	Cron string
+	// Timezone is the IANA timezone name the expression is evaluated in, UTC by default
+	Timezone   string
+	Body       []byte
+	Attributes map[string]string
+	Paused     bool
+	// NextAt is the fire time of the scheduled occurrence in Unix milliseconds, it's zero for paused schedules
+	NextAt int `json:"NextAtMs"`
+}
+
+// UnmarshalJSON converts the fire time of schedules encoded before millisecond precision
+func (s *Schedule) UnmarshalJSON(data []byte) error {
+	type schedule Schedule
+	var decoded struct {
+		schedule
+		LegacyNextAt int `json:"NextAt"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*s = Schedule(decoded.schedule)
+	if s.NextAt == 0 {
+		s.NextAt = decoded.LegacyNextAt * 1000
+	}
+	return nil
+}
+
+func (s Schedule) parse() (cron.Schedule, *time.Location, error) {
+	spec, err := cron.ParseStandard(s.Cron)
+	if err != nil {
+		return nil, nil, err
+	}
+	location, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, nil, err
+	}
+	return spec, location, nil
+}
+
+// Validate checks the cron expression and the timezone
+func (s Schedule) Validate() error {
+	_, _, err := s.parse()
+	return err
+}
+
+// Next returns the first fire time after the timestamp, both are Unix milliseconds
+func (s Schedule) Next(after int) (int, error) {
+	fireTimes, err := s.Preview(after, 1)
+	if err != nil {
+		return 0, err
+	}
+	return fireTimes[0], nil
+}
+
+// Preview returns the fire times after the timestamp in Unix milliseconds
+func (s Schedule) Preview(after int, count int) ([]int, error) {
+	spec, location, err := s.parse()
+	if err != nil {
+		return nil, err
+	}
+	fireTimes := make([]int, 0, count)
+	t := time.Unix(0, int64(after)*int64(time.Millisecond)).In(location)
+	for len(fireTimes) < count {
+		t = spec.Next(t)
+		if t.IsZero() {
+			if len(fireTimes) == 0 {
+				return nil, ErrNoFireTime
+			}
+			break
+		}
+		fireTimes = append(fireTimes, message.UnixMilli(t))
+	}
+	return fireTimes, nil
+}
+
+// OccurrenceID identifies the message emitted at the fire time, fire times are whole seconds so the ID keeps seconds
+func (s Schedule) OccurrenceID(fireAt int) string {
+	return fmt.Sprintf("%s/%d", s.ID, fireAt/1000)
+}
+
+// Occurrence builds the message emitted at the fire time
+func (s Schedule) Occurrence(fireAt int) message.Message {
+	return message.NewMessageWithAttributes(s.Body, fireAt, s.Attributes).WithID(s.OccurrenceID(fireAt)).WithScheduleID(s.ID)
+}
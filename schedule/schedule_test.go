@@ -0,0 +1,121 @@
+package schedule
+
+import (
+	"encoding/json"
+	"github.com/maksimru/event-scheduler/message"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSchedule_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       Schedule
+		wantErr bool
+	}{
+		{
+			name: "Check standard expression",
+			s:    Schedule{Cron: "0 9 * * *"},
+		},
+		{
+			name: "Check descriptor with timezone",
+			s:    Schedule{Cron: "@daily", Timezone: "America/New_York"},
+		},
+		{
+			name:    "Check invalid expression",
+			s:       Schedule{Cron: "0 9 * *"},
+			wantErr: true,
+		},
+		{
+			name:    "Check unknown timezone",
+			s:       Schedule{Cron: "0 9 * * *", Timezone: "Mars/Olympus_Mons"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr {
+				assert.Error(t, tt.s.Validate())
+			} else {
+				assert.NoError(t, tt.s.Validate())
+			}
+		})
+	}
+}
+
+func TestSchedule_Preview(t *testing.T) {
+	after := message.UnixMilli(time.Date(2021, 3, 13, 12, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name  string
+		s     Schedule
+		count int
+		want  []int
+	}{
+		{
+			name:  "Check fire times in UTC",
+			s:     Schedule{Cron: "0 9 * * *"},
+			count: 2,
+			want: []int{
+				message.UnixMilli(time.Date(2021, 3, 14, 9, 0, 0, 0, time.UTC)),
+				message.UnixMilli(time.Date(2021, 3, 15, 9, 0, 0, 0, time.UTC)),
+			},
+		},
+		{
+			name:  "Check fire times follow daylight saving time of the timezone",
+			s:     Schedule{Cron: "0 9 * * *", Timezone: "America/New_York"},
+			count: 2,
+			want: []int{
+				// EST
+				message.UnixMilli(time.Date(2021, 3, 13, 14, 0, 0, 0, time.UTC)),
+				// EDT
+				message.UnixMilli(time.Date(2021, 3, 14, 13, 0, 0, 0, time.UTC)),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.s.Preview(after, tt.count)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	s := Schedule{Cron: "*/5 * * * *"}
+	got, err := s.Next(1000000)
+	assert.NoError(t, err)
+	assert.Equal(t, 1200000, got)
+
+	// fire time is never the timestamp itself
+	got, err = s.Next(1200000)
+	assert.NoError(t, err)
+	assert.Equal(t, 1500000, got)
+	got, err = s.Next(1199999)
+	assert.NoError(t, err)
+	assert.Equal(t, 1200000, got)
+
+	// February 30 never comes
+	_, err = Schedule{Cron: "0 0 30 2 *"}.Next(1000000)
+	assert.Equal(t, ErrNoFireTime, err)
+}
+
+func TestSchedule_Occurrence(t *testing.T) {
+	s := Schedule{ID: "sch1", Cron: "@hourly", Body: []byte("foo"), Attributes: map[string]string{"type": "bar"}}
+	assert.Equal(t, message.NewMessageWithAttributes([]byte("foo"), 3600000, map[string]string{"type": "bar"}).WithID("sch1/3600").WithScheduleID("sch1"), s.Occurrence(3600000))
+}
+
+func TestSchedule_JSON(t *testing.T) {
+	s := Schedule{ID: "sch1", Cron: "@hourly", Body: []byte("foo"), NextAt: 3600000}
+	data, err := json.Marshal(s)
+	assert.NoError(t, err)
+	var got Schedule
+	assert.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, s, got)
+
+	// fire time in seconds is converted to milliseconds
+	got = Schedule{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"ID":"sch1","Cron":"@hourly","Body":"Zm9v","NextAt":3600}`), &got))
+	assert.Equal(t, s, got)
+}
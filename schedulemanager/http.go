@@ -0,0 +1,281 @@
+package schedulemanager
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/maksimru/event-scheduler/schedule"
+	"github.com/maksimru/event-scheduler/storage"
+	"net/http"
+)
+
+type ScheduleManagerServer interface {
+	ListSchedules(ctx echo.Context) error
+	GetSchedule(ctx echo.Context) error
+	AddSchedule(ctx echo.Context) error
+	UpdateSchedule(ctx echo.Context) error
+	DeleteSchedule(ctx echo.Context) error
+	PauseSchedule(ctx echo.Context) error
+	ResumeSchedule(ctx echo.Context) error
+	PreviewSchedule(ctx echo.Context) error
+}
+
+type SchedulerScheduleManagerServer struct {
+	manager    ScheduleManager
+	httpServer *echo.Echo
+}
+
+func (m *SchedulerScheduleManagerServer) BootScheduleManagerServer(manager ScheduleManager, httpServer *echo.Echo) error {
+	m.manager = manager
+	m.httpServer = httpServer
+	m.initHttpRoutes()
+	return nil
+}
+
+func (m *SchedulerScheduleManagerServer) initHttpRoutes() {
+	m.httpServer.GET("/channels/:id/schedules", m.ListSchedules)
+	m.httpServer.POST("/channels/:id/schedules", m.AddSchedule)
+	m.httpServer.GET("/channels/:id/schedules/:scheduleId", m.GetSchedule)
+	m.httpServer.PATCH("/channels/:id/schedules/:scheduleId", m.UpdateSchedule)
+	m.httpServer.DELETE("/channels/:id/schedules/:scheduleId", m.DeleteSchedule)
+	m.httpServer.POST("/channels/:id/schedules/:scheduleId/pause", m.PauseSchedule)
+	m.httpServer.POST("/channels/:id/schedules/:scheduleId/resume", m.ResumeSchedule)
+	m.httpServer.GET("/channels/:id/schedules/:scheduleId/preview", m.PreviewSchedule)
+}
+
+const EncodingBase64 = "base64"
+
+const defaultPreviewCount = 5
+
+type ScheduleInput struct {
+	// ID is generated when it's not provided
+	ID       string `json:"id" form:"id" query:"id"`
+	Cron     string `json:"cron" form:"cron" query:"cron" validate:"required"`
+	Timezone string `json:"timezone" form:"timezone" query:"timezone"`
+	Body     string `json:"body" form:"body" query:"body" validate:"required"`
+	// Encoding of the body, binary bodies are sent base64 encoded
+	Encoding   string            `json:"encoding" form:"encoding" query:"encoding" validate:"omitempty,oneof=base64"`
+	Attributes map[string]string `json:"attributes" form:"attributes" query:"attributes"`
+	Paused     bool              `json:"paused" form:"paused" query:"paused"`
+}
+
+// schedule decodes the input, the cron expression and the timezone are checked as well
+func (i ScheduleInput) schedule() (schedule.Schedule, error) {
+	body := []byte(i.Body)
+	if i.Encoding == EncodingBase64 {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(i.Body); err != nil {
+			return schedule.Schedule{}, fmt.Errorf("error decoding body: %s", err.Error())
+		}
+	}
+	sch := schedule.Schedule{
+		ID:         i.ID,
+		Cron:       i.Cron,
+		Timezone:   i.Timezone,
+		Body:       body,
+		Attributes: i.Attributes,
+		Paused:     i.Paused,
+	}
+	if err := sch.Validate(); err != nil {
+		return schedule.Schedule{}, fmt.Errorf("invalid schedule: %s", err.Error())
+	}
+	return sch, nil
+}
+
+type ScheduleOutput struct {
+	ID         string            `json:"id"`
+	Cron       string            `json:"cron"`
+	Timezone   string            `json:"timezone"`
+	BodySize   int               `json:"body_size"`
+	Attributes map[string]string `json:"attributes"`
+	Paused     bool              `json:"paused"`
+	NextAt     int               `json:"next_at"`
+}
+
+func newScheduleOutput(sch schedule.Schedule) ScheduleOutput {
+	return ScheduleOutput{
+		ID:         sch.ID,
+		Cron:       sch.Cron,
+		Timezone:   sch.Timezone,
+		BodySize:   len(sch.Body),
+		Attributes: sch.Attributes,
+		Paused:     sch.Paused,
+		NextAt:     sch.NextAt / 1000,
+	}
+}
+
+func isNotFound(err error) bool {
+	return err == storage.ErrChannelNotFound || err == storage.ErrScheduleNotFound
+}
+
+func (m *SchedulerScheduleManagerServer) ListSchedules(ctx echo.Context) error {
+	channelID := ctx.Param("id")
+	schedules, err := m.manager.GetSchedules(channelID)
+	if err == storage.ErrChannelNotFound {
+		return ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error listing schedules %s: %s", ctx, err.Error()),
+		})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error listing schedules %s: %s", ctx, err.Error()),
+		})
+	}
+	data := make([]ScheduleOutput, len(schedules))
+	for i, sch := range schedules {
+		data[i] = newScheduleOutput(sch)
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"status":    true,
+		"channelID": channelID,
+		"data":      data,
+	})
+}
+
+func (m *SchedulerScheduleManagerServer) GetSchedule(ctx echo.Context) error {
+	channelID, scheduleID := ctx.Param("id"), ctx.Param("scheduleId")
+	sch, err := m.manager.GetSchedule(channelID, scheduleID)
+	return m.scheduleResponse(ctx, "getting", channelID, sch, err)
+}
+
+func (m *SchedulerScheduleManagerServer) AddSchedule(ctx echo.Context) error {
+	channelID := ctx.Param("id")
+	input := new(ScheduleInput)
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error binding: %s", err.Error()),
+		})
+	}
+	if err := ctx.Validate(input); err != nil {
+		return echo.NewHTTPError(http.StatusNotAcceptable, err.Error())
+	}
+	sch, err := input.schedule()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotAcceptable, err.Error())
+	}
+	sch, err = m.manager.AddSchedule(channelID, sch)
+	return m.scheduleResponse(ctx, "adding", channelID, sch, err)
+}
+
+func (m *SchedulerScheduleManagerServer) UpdateSchedule(ctx echo.Context) error {
+	channelID, scheduleID := ctx.Param("id"), ctx.Param("scheduleId")
+	input := new(ScheduleInput)
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error binding: %s", err.Error()),
+		})
+	}
+	if err := ctx.Validate(input); err != nil {
+		return echo.NewHTTPError(http.StatusNotAcceptable, err.Error())
+	}
+	sch, err := input.schedule()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotAcceptable, err.Error())
+	}
+	sch, err = m.manager.UpdateSchedule(channelID, scheduleID, sch)
+	return m.scheduleResponse(ctx, "updating", channelID, sch, err)
+}
+
+func (m *SchedulerScheduleManagerServer) DeleteSchedule(ctx echo.Context) error {
+	channelID, scheduleID := ctx.Param("id"), ctx.Param("scheduleId")
+	err := m.manager.DeleteSchedule(channelID, scheduleID)
+	if isNotFound(err) {
+		return ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error removing schedule %s: %s", ctx, err.Error()),
+		})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error removing schedule %s: %s", ctx, err.Error()),
+		})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"status":     true,
+		"channelID":  channelID,
+		"scheduleID": scheduleID,
+	})
+}
+
+func (m *SchedulerScheduleManagerServer) PauseSchedule(ctx echo.Context) error {
+	channelID, scheduleID := ctx.Param("id"), ctx.Param("scheduleId")
+	sch, err := m.manager.PauseSchedule(channelID, scheduleID)
+	return m.scheduleResponse(ctx, "pausing", channelID, sch, err)
+}
+
+func (m *SchedulerScheduleManagerServer) ResumeSchedule(ctx echo.Context) error {
+	channelID, scheduleID := ctx.Param("id"), ctx.Param("scheduleId")
+	sch, err := m.manager.ResumeSchedule(channelID, scheduleID)
+	return m.scheduleResponse(ctx, "resuming", channelID, sch, err)
+}
+
+type PreviewInput struct {
+	// Count of the fire times, 5 by default
+	Count int `json:"count" form:"count" query:"count" validate:"min=0,max=100"`
+}
+
+func (m *SchedulerScheduleManagerServer) PreviewSchedule(ctx echo.Context) error {
+	channelID, scheduleID := ctx.Param("id"), ctx.Param("scheduleId")
+	input := new(PreviewInput)
+	if err := ctx.Bind(input); err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error binding: %s", err.Error()),
+		})
+	}
+	if err := ctx.Validate(input); err != nil {
+		return echo.NewHTTPError(http.StatusNotAcceptable, err.Error())
+	}
+	count := input.Count
+	if count == 0 {
+		count = defaultPreviewCount
+	}
+	fireTimes, err := m.manager.PreviewSchedule(channelID, scheduleID, count)
+	if isNotFound(err) {
+		return ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error previewing schedule %s: %s", ctx, err.Error()),
+		})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error previewing schedule %s: %s", ctx, err.Error()),
+		})
+	}
+	// cron fires on whole seconds, the API reports epoch seconds
+	for i := range fireTimes {
+		fireTimes[i] /= 1000
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"status":     true,
+		"channelID":  channelID,
+		"scheduleID": scheduleID,
+		"data":       fireTimes,
+	})
+}
+
+func (m *SchedulerScheduleManagerServer) scheduleResponse(ctx echo.Context, action string, channelID string, sch schedule.Schedule, err error) error {
+	if isNotFound(err) {
+		return ctx.JSON(http.StatusNotFound, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error %s schedule %s: %s", action, ctx, err.Error()),
+		})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": false,
+			"error":  fmt.Sprintf("error %s schedule %s: %s", action, ctx, err.Error()),
+		})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"status":    true,
+		"channelID": channelID,
+		"schedule":  newScheduleOutput(sch),
+	})
+}
@@ -0,0 +1,336 @@
+package schedulemanager
+
+import (
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/httpvalidator"
+	"github.com/maksimru/event-scheduler/schedule"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSchedulerScheduleManagerServer_BootScheduleManagerServer(t *testing.T) {
+	m := NewSchedulerScheduleManager(nil, nil)
+	httpServer := echo.New()
+
+	ms := &SchedulerScheduleManagerServer{}
+	assert.NoError(t, ms.BootScheduleManagerServer(m, httpServer))
+	assert.Equal(t, m, ms.manager)
+	assert.Equal(t, httpServer, ms.httpServer)
+}
+
+func TestSchedulerScheduleManagerServer_AddSchedule(t *testing.T) {
+	tests := []struct {
+		name           string
+		channelId      string
+		jsonInput      string
+		wantErr        bool
+		wantStatusCode int
+		wantSchedules  int
+	}{
+		{
+			name:           "Check add schedule API",
+			channelId:      "ch1",
+			jsonInput:      "{\"id\":\"sch1\",\"cron\":\"0 9 * * 1-5\",\"timezone\":\"Europe/Berlin\",\"body\":\"foo\",\"attributes\":{\"k\":\"v\"}}",
+			wantStatusCode: http.StatusOK,
+			wantSchedules:  1,
+		},
+		{
+			name:           "Check add schedule API with base64 body",
+			channelId:      "ch1",
+			jsonInput:      "{\"cron\":\"@hourly\",\"body\":\"Zm9v\",\"encoding\":\"base64\"}",
+			wantStatusCode: http.StatusOK,
+			wantSchedules:  1,
+		},
+		{
+			name:           "Check add schedule API with invalid cron",
+			channelId:      "ch1",
+			jsonInput:      "{\"cron\":\"61 * * * *\",\"body\":\"foo\"}",
+			wantErr:        true,
+			wantStatusCode: http.StatusNotAcceptable,
+		},
+		{
+			name:           "Check add schedule API with unknown timezone",
+			channelId:      "ch1",
+			jsonInput:      "{\"cron\":\"@hourly\",\"timezone\":\"Mars/Olympus\",\"body\":\"foo\"}",
+			wantErr:        true,
+			wantStatusCode: http.StatusNotAcceptable,
+		},
+		{
+			name:           "Check add schedule API without cron",
+			channelId:      "ch1",
+			jsonInput:      "{\"body\":\"foo\"}",
+			wantErr:        true,
+			wantStatusCode: http.StatusNotAcceptable,
+		},
+		{
+			name:           "Check add schedule API with non-existing channel",
+			channelId:      "ch2",
+			jsonInput:      "{\"cron\":\"@hourly\",\"body\":\"foo\"}",
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, pqStorage := bootLeader()
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+			_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1"})
+
+			ms := &SchedulerScheduleManagerServer{
+				manager:    NewSchedulerScheduleManager(cluster, pqStorage),
+				httpServer: echo.New(),
+			}
+			ms.httpServer.Validator = &httpvalidator.HttpValidator{Validator: validator.New()}
+
+			req := httptest.NewRequest(http.MethodPost, "/channels/:id/schedules", strings.NewReader(tt.jsonInput))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := ms.httpServer.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(tt.channelId)
+
+			err := ms.AddSchedule(c)
+			if tt.wantErr {
+				if assert.Error(t, err) {
+					assert.Equal(t, tt.wantStatusCode, err.(*echo.HTTPError).Code)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatusCode, rec.Code)
+			schedules, _ := pqStorage.GetSchedules("ch1")
+			assert.Len(t, schedules, tt.wantSchedules)
+			if tt.wantSchedules > 0 {
+				assert.Equal(t, []byte("foo"), schedules[0].Body)
+			}
+		})
+	}
+}
+
+func TestSchedulerScheduleManagerServer_Schedule(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		channelId      string
+		scheduleId     string
+		jsonInput      string
+		handler        func(ms *SchedulerScheduleManagerServer) echo.HandlerFunc
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			name:           "Check list schedules API",
+			method:         http.MethodGet,
+			path:           "/channels/:id/schedules",
+			channelId:      "ch1",
+			handler:        func(ms *SchedulerScheduleManagerServer) echo.HandlerFunc { return ms.ListSchedules },
+			wantStatusCode: http.StatusOK,
+			wantBody:       "{\"channelID\":\"ch1\",\"data\":[{\"id\":\"sch1\",\"cron\":\"0 0 * * *\",\"timezone\":\"UTC\",\"body_size\":3,\"attributes\":null,\"paused\":true,\"next_at\":0}],\"status\":true}",
+		},
+		{
+			name:           "Check list schedules API with non-existing channel",
+			method:         http.MethodGet,
+			path:           "/channels/:id/schedules",
+			channelId:      "ch2",
+			handler:        func(ms *SchedulerScheduleManagerServer) echo.HandlerFunc { return ms.ListSchedules },
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Check get schedule API",
+			method:         http.MethodGet,
+			path:           "/channels/:id/schedules/:scheduleId",
+			channelId:      "ch1",
+			scheduleId:     "sch1",
+			handler:        func(ms *SchedulerScheduleManagerServer) echo.HandlerFunc { return ms.GetSchedule },
+			wantStatusCode: http.StatusOK,
+			wantBody:       "{\"channelID\":\"ch1\",\"schedule\":{\"id\":\"sch1\",\"cron\":\"0 0 * * *\",\"timezone\":\"UTC\",\"body_size\":3,\"attributes\":null,\"paused\":true,\"next_at\":0},\"status\":true}",
+		},
+		{
+			name:           "Check get schedule API with missing schedule",
+			method:         http.MethodGet,
+			path:           "/channels/:id/schedules/:scheduleId",
+			channelId:      "ch1",
+			scheduleId:     "sch2",
+			handler:        func(ms *SchedulerScheduleManagerServer) echo.HandlerFunc { return ms.GetSchedule },
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Check update schedule API",
+			method:         http.MethodPatch,
+			path:           "/channels/:id/schedules/:scheduleId",
+			channelId:      "ch1",
+			scheduleId:     "sch1",
+			jsonInput:      "{\"cron\":\"@hourly\",\"body\":\"foobar\",\"paused\":true}",
+			handler:        func(ms *SchedulerScheduleManagerServer) echo.HandlerFunc { return ms.UpdateSchedule },
+			wantStatusCode: http.StatusOK,
+			wantBody:       "{\"channelID\":\"ch1\",\"schedule\":{\"id\":\"sch1\",\"cron\":\"@hourly\",\"timezone\":\"\",\"body_size\":6,\"attributes\":null,\"paused\":true,\"next_at\":0},\"status\":true}",
+		},
+		{
+			name:           "Check update schedule API with missing schedule",
+			method:         http.MethodPatch,
+			path:           "/channels/:id/schedules/:scheduleId",
+			channelId:      "ch1",
+			scheduleId:     "sch2",
+			jsonInput:      "{\"cron\":\"@hourly\",\"body\":\"foobar\"}",
+			handler:        func(ms *SchedulerScheduleManagerServer) echo.HandlerFunc { return ms.UpdateSchedule },
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Check delete schedule API",
+			method:         http.MethodDelete,
+			path:           "/channels/:id/schedules/:scheduleId",
+			channelId:      "ch1",
+			scheduleId:     "sch1",
+			handler:        func(ms *SchedulerScheduleManagerServer) echo.HandlerFunc { return ms.DeleteSchedule },
+			wantStatusCode: http.StatusOK,
+			wantBody:       "{\"channelID\":\"ch1\",\"scheduleID\":\"sch1\",\"status\":true}",
+		},
+		{
+			name:           "Check delete schedule API with missing schedule",
+			method:         http.MethodDelete,
+			path:           "/channels/:id/schedules/:scheduleId",
+			channelId:      "ch1",
+			scheduleId:     "sch2",
+			handler:        func(ms *SchedulerScheduleManagerServer) echo.HandlerFunc { return ms.DeleteSchedule },
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Check pause schedule API",
+			method:         http.MethodPost,
+			path:           "/channels/:id/schedules/:scheduleId/pause",
+			channelId:      "ch1",
+			scheduleId:     "sch1",
+			handler:        func(ms *SchedulerScheduleManagerServer) echo.HandlerFunc { return ms.PauseSchedule },
+			wantStatusCode: http.StatusOK,
+			wantBody:       "{\"channelID\":\"ch1\",\"schedule\":{\"id\":\"sch1\",\"cron\":\"0 0 * * *\",\"timezone\":\"UTC\",\"body_size\":3,\"attributes\":null,\"paused\":true,\"next_at\":0},\"status\":true}",
+		},
+		{
+			name:           "Check resume schedule API with missing schedule",
+			method:         http.MethodPost,
+			path:           "/channels/:id/schedules/:scheduleId/resume",
+			channelId:      "ch1",
+			scheduleId:     "sch2",
+			handler:        func(ms *SchedulerScheduleManagerServer) echo.HandlerFunc { return ms.ResumeSchedule },
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Check preview schedule API with missing schedule",
+			method:         http.MethodGet,
+			path:           "/channels/:id/schedules/:scheduleId/preview",
+			channelId:      "ch1",
+			scheduleId:     "sch2",
+			handler:        func(ms *SchedulerScheduleManagerServer) echo.HandlerFunc { return ms.PreviewSchedule },
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, pqStorage := bootLeader()
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+			_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1"})
+			m := NewSchedulerScheduleManager(cluster, pqStorage)
+			// paused schedule has stable output
+			_, err := m.AddSchedule("ch1", schedule.Schedule{ID: "sch1", Cron: "0 0 * * *", Timezone: "UTC", Body: []byte("foo"), Paused: true})
+			assert.NoError(t, err)
+
+			ms := &SchedulerScheduleManagerServer{
+				manager:    m,
+				httpServer: echo.New(),
+			}
+			ms.httpServer.Validator = &httpvalidator.HttpValidator{Validator: validator.New()}
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.jsonInput))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := ms.httpServer.NewContext(req, rec)
+			c.SetParamNames("id", "scheduleId")
+			c.SetParamValues(tt.channelId, tt.scheduleId)
+
+			assert.NoError(t, tt.handler(ms)(c))
+			assert.Equal(t, tt.wantStatusCode, rec.Code)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSchedulerScheduleManagerServer_PreviewSchedule(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		wantErr        bool
+		wantStatusCode int
+		wantFireTimes  int
+	}{
+		{
+			name:           "Check preview schedule API",
+			wantStatusCode: http.StatusOK,
+			wantFireTimes:  defaultPreviewCount,
+		},
+		{
+			name:           "Check preview schedule API with count",
+			query:          "?count=2",
+			wantStatusCode: http.StatusOK,
+			wantFireTimes:  2,
+		},
+		{
+			name:           "Check preview schedule API with too large count",
+			query:          "?count=1000",
+			wantErr:        true,
+			wantStatusCode: http.StatusNotAcceptable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, pqStorage := bootLeader()
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+			_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1"})
+			m := NewSchedulerScheduleManager(cluster, pqStorage)
+			_, err := m.AddSchedule("ch1", schedule.Schedule{ID: "sch1", Cron: "@daily", Body: []byte("foo")})
+			assert.NoError(t, err)
+
+			ms := &SchedulerScheduleManagerServer{
+				manager:    m,
+				httpServer: echo.New(),
+			}
+			ms.httpServer.Validator = &httpvalidator.HttpValidator{Validator: validator.New()}
+
+			req := httptest.NewRequest(http.MethodGet, "/channels/:id/schedules/:scheduleId/preview"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			c := ms.httpServer.NewContext(req, rec)
+			c.SetParamNames("id", "scheduleId")
+			c.SetParamValues("ch1", "sch1")
+
+			err = ms.PreviewSchedule(c)
+			if tt.wantErr {
+				if assert.Error(t, err) {
+					assert.Equal(t, tt.wantStatusCode, err.(*echo.HTTPError).Code)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatusCode, rec.Code)
+			var body struct {
+				Data []int `json:"data"`
+			}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			if assert.Len(t, body.Data, tt.wantFireTimes) {
+				// fire times are reported in epoch seconds
+				assert.Equal(t, 86400, body.Data[1]-body.Data[0])
+			}
+		})
+	}
+}
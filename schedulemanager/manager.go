@@ -0,0 +1,173 @@
+package schedulemanager
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hashicorp/raft"
+	"github.com/maksimru/event-scheduler/errormessages"
+	"github.com/maksimru/event-scheduler/fsm"
+	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/schedule"
+	"github.com/maksimru/event-scheduler/storage"
+	"github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+var ErrScheduleAlreadyExists = errors.New("schedule is already exists")
+
+type ScheduleManager interface {
+	GetSchedules(channelID string) ([]schedule.Schedule, error)
+	GetSchedule(channelID string, scheduleID string) (schedule.Schedule, error)
+	AddSchedule(channelID string, sch schedule.Schedule) (schedule.Schedule, error)
+	UpdateSchedule(channelID string, scheduleID string, sch schedule.Schedule) (schedule.Schedule, error)
+	DeleteSchedule(channelID string, scheduleID string) error
+	PauseSchedule(channelID string, scheduleID string) (schedule.Schedule, error)
+	ResumeSchedule(channelID string, scheduleID string) (schedule.Schedule, error)
+	PreviewSchedule(channelID string, scheduleID string, count int) ([]int, error)
+}
+
+type SchedulerScheduleManager struct {
+	cluster *raft.Raft
+	storage *storage.PqStorage
+}
+
+func NewSchedulerScheduleManager(cluster *raft.Raft, storage *storage.PqStorage) *SchedulerScheduleManager {
+	return &SchedulerScheduleManager{
+		cluster: cluster,
+		storage: storage,
+	}
+}
+
+func (m *SchedulerScheduleManager) BootScheduleManager(cluster *raft.Raft, storage *storage.PqStorage) error {
+	m.cluster = cluster
+	m.storage = storage
+	return nil
+}
+
+func (m *SchedulerScheduleManager) GetSchedules(channelID string) ([]schedule.Schedule, error) {
+	if m.cluster.State() != raft.Leader {
+		return nil, errormessages.ErrOperationIsRestrictedOnNonLeader
+	}
+	return m.storage.GetSchedules(channelID)
+}
+
+func (m *SchedulerScheduleManager) GetSchedule(channelID string, scheduleID string) (schedule.Schedule, error) {
+	if m.cluster.State() != raft.Leader {
+		return schedule.Schedule{}, errormessages.ErrOperationIsRestrictedOnNonLeader
+	}
+	return m.storage.GetSchedule(channelID, scheduleID)
+}
+
+// AddSchedule creates the schedule and its first occurrence, the ID is generated when it's not provided
+func (m *SchedulerScheduleManager) AddSchedule(channelID string, sch schedule.Schedule) (schedule.Schedule, error) {
+	if m.cluster.State() != raft.Leader {
+		return schedule.Schedule{}, errormessages.ErrOperationIsRestrictedOnNonLeader
+	}
+	if sch.ID == "" {
+		sch.ID = uuid.NewV4().String()
+	} else if _, err := m.storage.GetSchedule(channelID, sch.ID); err == nil {
+		return schedule.Schedule{}, ErrScheduleAlreadyExists
+	}
+	return m.save(channelID, sch)
+}
+
+// UpdateSchedule replaces the schedule, its occurrence is moved to the next fire time
+func (m *SchedulerScheduleManager) UpdateSchedule(channelID string, scheduleID string, sch schedule.Schedule) (schedule.Schedule, error) {
+	if m.cluster.State() != raft.Leader {
+		return schedule.Schedule{}, errormessages.ErrOperationIsRestrictedOnNonLeader
+	}
+	if _, err := m.storage.GetSchedule(channelID, scheduleID); err != nil {
+		return schedule.Schedule{}, err
+	}
+	// do not let override schedule id
+	sch.ID = scheduleID
+	return m.save(channelID, sch)
+}
+
+func (m *SchedulerScheduleManager) DeleteSchedule(channelID string, scheduleID string) error {
+	if m.cluster.State() != raft.Leader {
+		return errormessages.ErrOperationIsRestrictedOnNonLeader
+	}
+	_, err := m.apply(fsm.CommandPayload{
+		Operation: fsm.OperationScheduleDelete,
+		ChannelID: channelID,
+		Schedule:  &schedule.Schedule{ID: scheduleID},
+	})
+	return err
+}
+
+// PauseSchedule cancels the scheduled occurrence until the schedule is resumed
+func (m *SchedulerScheduleManager) PauseSchedule(channelID string, scheduleID string) (schedule.Schedule, error) {
+	return m.setPaused(channelID, scheduleID, true)
+}
+
+// ResumeSchedule schedules the occurrence at the next fire time, occurrences missed during the pause aren't emitted
+func (m *SchedulerScheduleManager) ResumeSchedule(channelID string, scheduleID string) (schedule.Schedule, error) {
+	return m.setPaused(channelID, scheduleID, false)
+}
+
+// PreviewSchedule returns the next fire times of the schedule
+func (m *SchedulerScheduleManager) PreviewSchedule(channelID string, scheduleID string, count int) ([]int, error) {
+	if m.cluster.State() != raft.Leader {
+		return nil, errormessages.ErrOperationIsRestrictedOnNonLeader
+	}
+	sch, err := m.storage.GetSchedule(channelID, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	return sch.Preview(message.UnixMilli(time.Now()), count)
+}
+
+func (m *SchedulerScheduleManager) setPaused(channelID string, scheduleID string, paused bool) (schedule.Schedule, error) {
+	if m.cluster.State() != raft.Leader {
+		return schedule.Schedule{}, errormessages.ErrOperationIsRestrictedOnNonLeader
+	}
+	sch, err := m.storage.GetSchedule(channelID, scheduleID)
+	if err != nil {
+		return schedule.Schedule{}, err
+	}
+	if sch.Paused == paused {
+		return sch, nil
+	}
+	sch.Paused = paused
+	return m.save(channelID, sch)
+}
+
+func (m *SchedulerScheduleManager) save(channelID string, sch schedule.Schedule) (schedule.Schedule, error) {
+	if err := sch.Validate(); err != nil {
+		return schedule.Schedule{}, err
+	}
+	r, err := m.apply(fsm.CommandPayload{
+		Operation: fsm.OperationScheduleSave,
+		ChannelID: channelID,
+		Schedule:  &sch,
+		Timestamp: message.UnixMilli(time.Now()),
+	})
+	if err != nil {
+		return schedule.Schedule{}, err
+	}
+	return r.Data.(schedule.Schedule), nil
+}
+
+func (m *SchedulerScheduleManager) apply(opPayload fsm.CommandPayload) (*fsm.ApplyResponse, error) {
+	opPayloadData, err := json.Marshal(opPayload)
+	if err != nil {
+		log.Error("schedulemanager error preparing data payload: ", err.Error())
+		return nil, err
+	}
+	applyFuture := m.cluster.Apply(opPayloadData, 500*time.Millisecond)
+	if err := applyFuture.Error(); err != nil {
+		log.Error("schedulemanager error persisting data in raft cluster: ", err.Error())
+		return nil, err
+	}
+	r, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		log.Error("schedulemanager error parsing apply response")
+		return nil, errors.New("fsm response failed")
+	}
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return r, nil
+}
@@ -0,0 +1,239 @@
+package schedulemanager
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/maksimru/event-scheduler/channel"
+	"github.com/maksimru/event-scheduler/errormessages"
+	"github.com/maksimru/event-scheduler/fsm"
+	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/nodenameresolver"
+	"github.com/maksimru/event-scheduler/schedule"
+	"github.com/maksimru/event-scheduler/storage"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func inmemConfig() *raft.Config {
+	conf := raft.DefaultConfig()
+	conf.HeartbeatTimeout = 50 * time.Millisecond
+	conf.ElectionTimeout = 50 * time.Millisecond
+	conf.LeaderLeaseTimeout = 50 * time.Millisecond
+	conf.CommitTimeout = 5 * time.Millisecond
+	return conf
+}
+
+func bootStagingCluster() (*raft.Raft, *raft.InmemTransport, *storage.PqStorage) {
+	store := raft.NewInmemStore()
+	cacheStore, _ := raft.NewLogCache(128, store)
+	snapshotStore := raft.NewInmemSnapshotStore()
+	_, transport := raft.NewInmemTransport("")
+	raftconfig := inmemConfig()
+	raftconfig.LogLevel = "info"
+	raftconfig.LocalID = nodenameresolver.Resolve(string(transport.LocalAddr()))
+	raftconfig.SnapshotThreshold = 512
+	dataStorage := storage.NewPqStorage()
+	raftServer, err := raft.NewRaft(raftconfig, fsm.NewPrioritizedFSM(dataStorage), cacheStore, store, snapshotStore, transport)
+	if err != nil {
+		panic("exception during staging cluster boot: " + err.Error())
+	}
+	return raftServer, transport, dataStorage
+}
+
+// bootLeader boots single node staging cluster and waits for it to become leader
+func bootLeader() (*raft.Raft, *storage.PqStorage) {
+	cluster, clusterTransport, pqStorage := bootStagingCluster()
+	cluster.BootstrapCluster(raft.Configuration{Servers: []raft.Server{
+		{
+			Suffrage: raft.Voter,
+			ID:       nodenameresolver.Resolve(string(clusterTransport.LocalAddr())),
+			Address:  clusterTransport.LocalAddr(),
+		},
+	}})
+
+	// wait for election
+	time.Sleep(time.Second * 1)
+
+	return cluster, pqStorage
+}
+
+func TestSchedulerScheduleManager_BootScheduleManager(t *testing.T) {
+	cluster := &raft.Raft{}
+	pqStorage := storage.NewPqStorage()
+
+	m := &SchedulerScheduleManager{}
+	assert.NoError(t, m.BootScheduleManager(cluster, pqStorage))
+	assert.Equal(t, cluster, m.cluster)
+	assert.Equal(t, pqStorage, m.storage)
+}
+
+func TestSchedulerScheduleManager_AddSchedule(t *testing.T) {
+	tests := []struct {
+		name      string
+		channelID string
+		sch       schedule.Schedule
+		wantErr   bool
+	}{
+		{
+			name:      "Check add schedule",
+			channelID: "ch1",
+			sch:       schedule.Schedule{ID: "sch2", Cron: "@hourly", Body: []byte("foo")},
+		},
+		{
+			name:      "Check add schedule without ID",
+			channelID: "ch1",
+			sch:       schedule.Schedule{Cron: "@hourly", Body: []byte("foo")},
+		},
+		{
+			name:      "Check add schedule with existing ID",
+			channelID: "ch1",
+			sch:       schedule.Schedule{ID: "sch1", Cron: "@hourly", Body: []byte("foo")},
+			wantErr:   true,
+		},
+		{
+			name:      "Check add schedule with invalid cron",
+			channelID: "ch1",
+			sch:       schedule.Schedule{ID: "sch2", Cron: "every minute", Body: []byte("foo")},
+			wantErr:   true,
+		},
+		{
+			name:      "Check add schedule into non-existing channel",
+			channelID: "ch2",
+			sch:       schedule.Schedule{ID: "sch2", Cron: "@hourly", Body: []byte("foo")},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, pqStorage := bootLeader()
+			defer func() {
+				_ = cluster.Shutdown()
+			}()
+			_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1"})
+			m := NewSchedulerScheduleManager(cluster, pqStorage)
+			_, err := m.AddSchedule("ch1", schedule.Schedule{ID: "sch1", Cron: "@hourly", Body: []byte("foo")})
+			assert.NoError(t, err)
+
+			got, err := m.AddSchedule(tt.channelID, tt.sch)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotEmpty(t, got.ID)
+			assert.Greater(t, got.NextAt, message.UnixMilli(time.Now()))
+			stored, err := m.GetSchedule(tt.channelID, got.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, got, stored)
+			_, messages := pqStorage.Dump()
+			assert.Len(t, messages["ch1"], 2)
+		})
+	}
+}
+
+func TestSchedulerScheduleManager_UpdateSchedule(t *testing.T) {
+	cluster, pqStorage := bootLeader()
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+	_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1"})
+	m := NewSchedulerScheduleManager(cluster, pqStorage)
+	_, err := m.AddSchedule("ch1", schedule.Schedule{ID: "sch1", Cron: "@hourly", Body: []byte("foo")})
+	assert.NoError(t, err)
+
+	got, err := m.UpdateSchedule("ch1", "sch1", schedule.Schedule{ID: "sch2", Cron: "@daily", Body: []byte("bar")})
+	assert.NoError(t, err)
+	assert.Equal(t, "sch1", got.ID)
+	assert.Equal(t, "@daily", got.Cron)
+	_, messages := pqStorage.Dump()
+	if assert.Len(t, messages["ch1"], 1) {
+		assert.Equal(t, []byte("bar"), messages["ch1"][0].GetBody())
+	}
+
+	_, err = m.UpdateSchedule("ch1", "sch2", schedule.Schedule{Cron: "@daily"})
+	assert.Equal(t, storage.ErrScheduleNotFound, err)
+}
+
+func TestSchedulerScheduleManager_PauseResumeSchedule(t *testing.T) {
+	cluster, pqStorage := bootLeader()
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+	_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1"})
+	m := NewSchedulerScheduleManager(cluster, pqStorage)
+	_, err := m.AddSchedule("ch1", schedule.Schedule{ID: "sch1", Cron: "@hourly", Body: []byte("foo")})
+	assert.NoError(t, err)
+
+	got, err := m.PauseSchedule("ch1", "sch1")
+	assert.NoError(t, err)
+	assert.True(t, got.Paused)
+	assert.Equal(t, 0, got.NextAt)
+	_, messages := pqStorage.Dump()
+	assert.Empty(t, messages["ch1"])
+
+	got, err = m.ResumeSchedule("ch1", "sch1")
+	assert.NoError(t, err)
+	assert.False(t, got.Paused)
+	assert.NotZero(t, got.NextAt)
+	_, messages = pqStorage.Dump()
+	assert.Len(t, messages["ch1"], 1)
+
+	_, err = m.PauseSchedule("ch1", "sch2")
+	assert.Equal(t, storage.ErrScheduleNotFound, err)
+}
+
+func TestSchedulerScheduleManager_DeleteSchedule(t *testing.T) {
+	cluster, pqStorage := bootLeader()
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+	_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1"})
+	m := NewSchedulerScheduleManager(cluster, pqStorage)
+	_, err := m.AddSchedule("ch1", schedule.Schedule{ID: "sch1", Cron: "@hourly", Body: []byte("foo")})
+	assert.NoError(t, err)
+
+	assert.NoError(t, m.DeleteSchedule("ch1", "sch1"))
+	assert.Equal(t, storage.ErrScheduleNotFound, m.DeleteSchedule("ch1", "sch1"))
+	assert.Equal(t, storage.ErrChannelNotFound, m.DeleteSchedule("ch2", "sch1"))
+	schedules, err := m.GetSchedules("ch1")
+	assert.NoError(t, err)
+	assert.Empty(t, schedules)
+	_, messages := pqStorage.Dump()
+	assert.Empty(t, messages["ch1"])
+}
+
+func TestSchedulerScheduleManager_PreviewSchedule(t *testing.T) {
+	cluster, pqStorage := bootLeader()
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+	_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1"})
+	m := NewSchedulerScheduleManager(cluster, pqStorage)
+	_, err := m.AddSchedule("ch1", schedule.Schedule{ID: "sch1", Cron: "@hourly", Body: []byte("foo")})
+	assert.NoError(t, err)
+
+	fireTimes, err := m.PreviewSchedule("ch1", "sch1", 3)
+	assert.NoError(t, err)
+	if assert.Len(t, fireTimes, 3) {
+		assert.Equal(t, 3600000, fireTimes[1]-fireTimes[0])
+		assert.Equal(t, 3600000, fireTimes[2]-fireTimes[1])
+	}
+	_, err = m.PreviewSchedule("ch1", "sch2", 3)
+	assert.Equal(t, storage.ErrScheduleNotFound, err)
+}
+
+func TestSchedulerScheduleManager_OnNonLeader(t *testing.T) {
+	cluster, _, pqStorage := bootStagingCluster()
+	defer func() {
+		_ = cluster.Shutdown()
+	}()
+
+	m := NewSchedulerScheduleManager(cluster, pqStorage)
+	_, err := m.GetSchedules("ch1")
+	assert.Equal(t, errormessages.ErrOperationIsRestrictedOnNonLeader, err)
+	_, err = m.AddSchedule("ch1", schedule.Schedule{Cron: "@hourly"})
+	assert.Equal(t, errormessages.ErrOperationIsRestrictedOnNonLeader, err)
+	_, err = m.PauseSchedule("ch1", "sch1")
+	assert.Equal(t, errormessages.ErrOperationIsRestrictedOnNonLeader, err)
+	assert.Equal(t, errormessages.ErrOperationIsRestrictedOnNonLeader, m.DeleteSchedule("ch1", "sch1"))
+}
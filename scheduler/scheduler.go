@@ -3,7 +3,6 @@ package scheduler
 import (
 	"context"
 	"github.com/BBVA/raft-badger"
-	"github.com/enriquebris/goconcurrentqueue"
 	"github.com/go-playground/validator/v10"
 	"github.com/hashicorp/raft"
 	"github.com/labstack/echo/v4"
@@ -17,16 +16,23 @@ import (
 	"github.com/maksimru/event-scheduler/fsm"
 	"github.com/maksimru/event-scheduler/httpvalidator"
 	"github.com/maksimru/event-scheduler/listener"
+	listeneramqp "github.com/maksimru/event-scheduler/listener/amqp"
+	listenerhttp "github.com/maksimru/event-scheduler/listener/http"
+	listenerkafka "github.com/maksimru/event-scheduler/listener/kafka"
+	listenernats "github.com/maksimru/event-scheduler/listener/nats"
 	listenerpubsub "github.com/maksimru/event-scheduler/listener/pubsub"
 	pubsublistenerconfig "github.com/maksimru/event-scheduler/listener/pubsub/config"
+	listenerredis "github.com/maksimru/event-scheduler/listener/redis"
 	listenertest "github.com/maksimru/event-scheduler/listener/test"
 	testlistenerconfig "github.com/maksimru/event-scheduler/listener/test/config"
+	"github.com/maksimru/event-scheduler/messagemanager"
 	"github.com/maksimru/event-scheduler/middleware"
 	"github.com/maksimru/event-scheduler/nodenameresolver"
 	"github.com/maksimru/event-scheduler/prioritizer"
 	"github.com/maksimru/event-scheduler/processor"
 	pubsubpublisherconfig "github.com/maksimru/event-scheduler/publisher/pubsub/config"
 	testpublisherconfig "github.com/maksimru/event-scheduler/publisher/test/config"
+	"github.com/maksimru/event-scheduler/schedulemanager"
 	"github.com/maksimru/event-scheduler/storage"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
@@ -34,6 +40,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -49,8 +56,13 @@ const (
 	defaultChannelName = "default"
 )
 
+const (
+	defaultShutdownTimeout = 30 * time.Second
+)
+
 type StartableScheduler interface {
 	Run(ctx context.Context) error
+	Shutdown(ctx context.Context) error
 }
 
 type InitiableScheduler interface {
@@ -61,6 +73,8 @@ type InitiableScheduler interface {
 	BootListener(ctx context.Context, c channel.Channel) error
 	BootRaftManager(ctx context.Context)
 	BootChannelManager(ctx context.Context)
+	BootMessageManager(ctx context.Context)
+	BootScheduleManager(ctx context.Context)
 }
 
 type Scheduler struct {
@@ -72,27 +86,43 @@ type Scheduler struct {
 	dispatcher       dispatcher.Dispatcher
 	prioritizer      *prioritizer.Prioritizer
 	dataStorage      *storage.PqStorage
-	outboundPool     *goconcurrentqueue.FIFO
 	raftCluster      *raft.Raft
 	raftManager      clustermanager.ClusterManager
 	channelManager   channelmanager.ChannelManager
+	messageManager   messagemanager.MessageManager
+	scheduleManager  schedulemanager.ScheduleManager
 	httpServer       *echo.Echo
 	channelHandler   *channel.EventHandler
+	// stopServices terminates the components bound to the scheduler, it's called once the shutdown is finished
+	stopServices  context.CancelFunc
+	shutdownMutex sync.Mutex
+	shuttingDown  bool
+	running       sync.WaitGroup
 }
 
 func NewScheduler(ctx context.Context, config config.Config) *Scheduler {
 	scheduler := new(Scheduler)
+	// components are detached from the caller context, which is usually shared with Run, so they outlive it
+	// until the shutdown drains them
+	ctx, scheduler.stopServices = context.WithCancel(context.Background())
 	scheduler.config = config
-	scheduler.outboundPool = goconcurrentqueue.NewFIFO()
 	scheduler.dataStorage = storage.NewPqStorage()
-	scheduler.dispatcher = dispatcher.NewDispatcher(ctx, scheduler.outboundPool, scheduler.dataStorage)
+	messageDispatcher := dispatcher.NewDispatcher(ctx, scheduler.dataStorage)
+	messageDispatcher.SetQueueSize(config.DispatcherQueueSize)
+	scheduler.dispatcher = messageDispatcher
 	scheduler.channelHandler = channel.NewEventHandler(scheduler.channelUpdated(ctx), scheduler.channelDeleted(ctx), scheduler.channelAdded(ctx))
+	// updated destinations take effect on the next delivery
+	messageDispatcher.Subscribe(scheduler.channelHandler)
 	scheduler.BootCluster(ctx)
+	// delivered messages are acknowledged through the cluster
+	messageDispatcher.SetCluster(scheduler.raftCluster)
 	// web server should be booted after the cluster
 	scheduler.BootHttpServer(ctx)
 	scheduler.BootPrioritizer(ctx)
 	scheduler.BootRaftManager(ctx)
 	scheduler.BootChannelManager(ctx)
+	scheduler.BootMessageManager(ctx)
+	scheduler.BootScheduleManager(ctx)
 	scheduler.listenerRunning = make(map[string]bool)
 	scheduler.listeners = make(map[string]listener.Listener)
 	scheduler.processorRunning = make(map[string]bool)
@@ -125,7 +155,7 @@ func (s *Scheduler) Run(ctx context.Context) error {
 		return err
 	})
 
-	// publisher dispatches prepared jobs from the outboundPool to the destination queue
+	// publisher dispatches prepared jobs from the channel outbound queues to the destination queue
 	g.Go(func() error {
 		err := s.dispatcher.Dispatch()
 		if err != nil {
@@ -134,34 +164,165 @@ func (s *Scheduler) Run(ctx context.Context) error {
 		return err
 	})
 
-	// stop cluster on context termination
+	// graceful shutdown on context termination
 	g.Go(func() error {
-		for {
-			select {
-			case <-ctx.Done():
-				log.Warn("context is done, stopping the cluster")
-				s.raftCluster.Shutdown()
-				return nil
-			}
+		<-ctx.Done()
+		log.Warn("context is done, shutting down the scheduler")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout())
+		defer cancel()
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			log.Warn("scheduler shutdown isn't graceful: ", err.Error())
 		}
+		return nil
 	})
 
-	// stop cluster on context termination
-	g.Go(func() error {
-		for {
-			select {
-			case <-ctx.Done():
-				log.Warn("context is done, stopping the webserver")
-				err := s.httpServer.Shutdown(ctx)
-				if err != nil && err != context.DeadlineExceeded {
-					log.Error("http server stop failure: ", err.Error())
-				}
-				return nil
+	return g.Wait()
+}
+
+func (s *Scheduler) shutdownTimeout() time.Duration {
+	if s.config.ShutdownTimeoutMs > 0 {
+		return time.Duration(s.config.ShutdownTimeoutMs) * time.Millisecond
+	}
+	return defaultShutdownTimeout
+}
+
+// Shutdown stops listeners and processors, drains the dispatcher and hands the leadership over to another voter
+// before the cluster is stopped, leases of the undelivered messages are released once the drain time is over
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	s.shutdownMutex.Lock()
+	s.shuttingDown = true
+	s.shutdownMutex.Unlock()
+	drainCtx, cancel := drainContext(ctx)
+	defer cancel()
+
+	log.Info("listener stopping")
+	s.stopListeners()
+	log.Info("processor stopping")
+	s.stopProcessors()
+	stopped := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-drainCtx.Done():
+		log.Warn("listeners and processors aren't stopped in time")
+	}
+
+	err := s.dispatcher.Drain(drainCtx)
+	s.transferLeadership(ctx)
+
+	log.Warn("stopping the cluster")
+	if shutdownErr := s.raftCluster.Shutdown().Error(); shutdownErr != nil {
+		log.Error("cluster stop failure: ", shutdownErr.Error())
+	}
+	log.Warn("stopping the webserver")
+	if shutdownErr := s.httpServer.Shutdown(ctx); shutdownErr != nil {
+		log.Error("http server stop failure: ", shutdownErr.Error())
+		_ = s.httpServer.Close()
+	}
+	if s.stopServices != nil {
+		s.stopServices()
+	}
+	return err
+}
+
+// drainContext keeps a quarter of the shutdown time for the leadership transfer
+func drainContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, has := ctx.Deadline()
+	if !has {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, deadline.Add(-time.Until(deadline)/4))
+}
+
+// transferLeadership hands the leadership over to another voter, so the cluster doesn't wait for the election
+func (s *Scheduler) transferLeadership(ctx context.Context) {
+	if s.raftCluster.State() != raft.Leader {
+		return
+	}
+	configurationFuture := s.raftCluster.GetConfiguration()
+	if err := configurationFuture.Error(); err != nil {
+		log.Error("cluster configuration failure: ", err.Error())
+		return
+	}
+	localID := nodenameresolver.Resolve(s.config.ClusterNodeHost + ":" + s.config.ClusterNodePort)
+	hasVoters := false
+	for _, server := range configurationFuture.Configuration().Servers {
+		if server.Suffrage == raft.Voter && server.ID != localID {
+			hasVoters = true
+		}
+	}
+	if !hasVoters {
+		return
+	}
+	log.Info("transferring the cluster leadership")
+	transferred := make(chan error, 1)
+	go func() {
+		transferred <- s.raftCluster.LeadershipTransfer().Error()
+	}()
+	select {
+	case err := <-transferred:
+		if err != nil {
+			log.Error("leadership transfer failure: ", err.Error())
+			return
+		}
+	case <-ctx.Done():
+		log.Warn("leadership isn't transferred in time")
+		return
+	}
+	// the node votes for the new leader, so it's kept in the cluster until the election is over
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if leader := s.raftCluster.Leader(); leader != "" && s.raftCluster.State() != raft.Leader {
+			log.Info("cluster leadership is transferred to ", leader)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			log.Warn("new cluster leader isn't elected in time")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// startService runs the channel listener or processor, nothing is started once the shutdown begins
+func (s *Scheduler) startService(service func()) {
+	s.shutdownMutex.Lock()
+	defer s.shutdownMutex.Unlock()
+	if s.shuttingDown {
+		return
+	}
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		service()
+	}()
+}
+
+func (s *Scheduler) stopListeners() {
+	for channelID, l := range s.listeners {
+		if s.isListenerRunning(channelID) {
+			err := l.Stop()
+			if err != nil {
+				log.Error("listener stop failure: ", err.Error(), ", channel ", channelID)
 			}
 		}
-	})
+	}
+}
 
-	return g.Wait()
+func (s *Scheduler) stopProcessors() {
+	for channelID, p := range s.processors {
+		if s.isProcessorRunning(channelID) {
+			err := p.Stop()
+			if err != nil {
+				log.Error("processor stop failure: ", err.Error(), ", channel ", channelID)
+			}
+		}
+	}
 }
 
 func (s *Scheduler) BootHttpServer(ctx context.Context) {
@@ -287,26 +448,12 @@ func (s *Scheduler) clusterLeaderChangeCallback(ctx context.Context, isLeader bo
 		// listeners
 		go func(s *Scheduler) {
 			log.Info("listener stopping")
-			for channelID, l := range s.listeners {
-				if s.isListenerRunning(channelID) {
-					err := l.Stop()
-					if err != nil {
-						log.Error("listener stop failure: ", err.Error(), ", channel ", channelID)
-					}
-				}
-			}
+			s.stopListeners()
 		}(s)
 		// processors
 		go func(s *Scheduler) {
 			log.Info("processor stopping")
-			for channelID, p := range s.processors {
-				if s.isProcessorRunning(channelID) {
-					err := p.Stop()
-					if err != nil {
-						log.Error("processor stop failure: ", err.Error(), ", channel ", channelID)
-					}
-				}
-			}
+			s.stopProcessors()
 		}(s)
 	} else if isLeader {
 		s.createDefaultChannel()
@@ -320,7 +467,7 @@ func (s *Scheduler) clusterLeaderChangeCallback(ctx context.Context, isLeader bo
 
 func (s *Scheduler) ensureChannelStart(ctx context.Context, c channel.Channel) {
 	// listener
-	go func(s *Scheduler) {
+	s.startService(func() {
 		if s.isListenerRunning(c.ID) {
 			return
 		}
@@ -337,9 +484,9 @@ func (s *Scheduler) ensureChannelStart(ctx context.Context, c channel.Channel) {
 		if err != nil {
 			log.Error("listener failure: ", err.Error(), ", channel ", c.ID)
 		}
-	}(s)
+	})
 	// processor
-	go func(s *Scheduler) {
+	s.startService(func() {
 		for _, c := range s.dataStorage.GetChannels() {
 			if s.isProcessorRunning(c.ID) {
 				return
@@ -353,11 +500,11 @@ func (s *Scheduler) ensureChannelStart(ctx context.Context, c channel.Channel) {
 				log.Error("processor failure: ", err.Error(), ", channel ", c.ID)
 			}
 		}
-	}(s)
+	})
 }
 
 func (s *Scheduler) ensureChannelRestart(ctx context.Context, c channel.Channel) {
-	go func(s *Scheduler) {
+	s.startService(func() {
 		if s.isListenerRunning(c.ID) {
 			l := s.listeners[c.ID]
 			err := l.Stop()
@@ -376,9 +523,9 @@ func (s *Scheduler) ensureChannelRestart(ctx context.Context, c channel.Channel)
 		if err != nil {
 			log.Error("listener failure: ", err.Error(), ", channel ", c.ID)
 		}
-	}(s)
+	})
 	// processor
-	go func(s *Scheduler) {
+	s.startService(func() {
 		if s.isProcessorRunning(c.ID) {
 			p := s.processors[c.ID]
 			err := p.Stop()
@@ -392,7 +539,7 @@ func (s *Scheduler) ensureChannelRestart(ctx context.Context, c channel.Channel)
 				log.Error("processor failure: ", err.Error(), ", channel ", c.ID)
 			}
 		}
-	}(s)
+	})
 }
 
 func (s *Scheduler) ensureChannelStop(ctx context.Context, c channel.Channel) {
@@ -451,6 +598,11 @@ func (s *Scheduler) BootProcessor(ctx context.Context, channel channel.Channel)
 	if err != nil {
 		panic("exception during processor boot: " + err.Error() + ", channel " + channel.ID)
 	}
+	if s.config.ProcessorBatchSize > 0 {
+		processorInstance.SetBatchSize(s.config.ProcessorBatchSize)
+	}
+	// zero lease timeout removes messages on pop
+	processorInstance.SetLeaseTimeout(time.Duration(s.config.ProcessorLeaseTimeoutMs) * time.Millisecond)
 	s.processors[channel.ID] = processorInstance
 	log.Info("processor boot is finished, channel ", channel.ID)
 }
@@ -461,6 +613,12 @@ func (s *Scheduler) BootPrioritizer(ctx context.Context) {
 	if err != nil {
 		panic("exception during prioritizer boot: " + err.Error())
 	}
+	if s.config.PrioritizerBatchSize > 0 {
+		prioritizerInstance.SetBatchSize(s.config.PrioritizerBatchSize)
+	}
+	if s.dispatcher != nil {
+		prioritizerInstance.SetDispatcher(s.dispatcher)
+	}
 	s.prioritizer = prioritizerInstance
 	log.Info("prioritizer boot is finished")
 }
@@ -480,6 +638,7 @@ func (s *Scheduler) BootChannelManager(ctx context.Context) {
 	if err := manager.BootChannelManager(s.raftCluster, s.dataStorage, s.channelHandler); err != nil {
 		panic("exception during channel manager boot: " + err.Error())
 	}
+	manager.SetDispatcher(s.dispatcher)
 	log.Info("channel manager boot is finished")
 	s.channelManager = manager
 	server := new(channelmanager.SchedulerChannelManagerServer)
@@ -489,6 +648,34 @@ func (s *Scheduler) BootChannelManager(ctx context.Context) {
 	log.Info("channel manager server boot is finished")
 }
 
+func (s *Scheduler) BootMessageManager(ctx context.Context) {
+	manager := new(messagemanager.SchedulerMessageManager)
+	if err := manager.BootMessageManager(s.raftCluster, s.dataStorage, s.prioritizer); err != nil {
+		panic("exception during message manager boot: " + err.Error())
+	}
+	log.Info("message manager boot is finished")
+	s.messageManager = manager
+	server := new(messagemanager.SchedulerMessageManagerServer)
+	if err := server.BootMessageManagerServer(manager, s.httpServer); err != nil {
+		panic("exception during message manager server boot: " + err.Error())
+	}
+	log.Info("message manager server boot is finished")
+}
+
+func (s *Scheduler) BootScheduleManager(ctx context.Context) {
+	manager := new(schedulemanager.SchedulerScheduleManager)
+	if err := manager.BootScheduleManager(s.raftCluster, s.dataStorage); err != nil {
+		panic("exception during schedule manager boot: " + err.Error())
+	}
+	log.Info("schedule manager boot is finished")
+	s.scheduleManager = manager
+	server := new(schedulemanager.SchedulerScheduleManagerServer)
+	if err := server.BootScheduleManagerServer(manager, s.httpServer); err != nil {
+		panic("exception during schedule manager server boot: " + err.Error())
+	}
+	log.Info("schedule manager server boot is finished")
+}
+
 func (s *Scheduler) BootListener(ctx context.Context, channel channel.Channel) error {
 	switch channel.Source.Driver {
 	case "pubsub":
@@ -498,6 +685,41 @@ func (s *Scheduler) BootListener(ctx context.Context, channel channel.Channel) e
 			panic("exception during listener boot: " + err.Error() + ", channel " + channel.ID)
 		}
 		s.listeners[channel.ID] = listenerInstance
+	case "kafka":
+		listenerInstance := new(listenerkafka.Listener)
+		err := listenerInstance.Boot(ctx, channel, s.prioritizer)
+		if err != nil {
+			panic("exception during listener boot: " + err.Error() + ", channel " + channel.ID)
+		}
+		s.listeners[channel.ID] = listenerInstance
+	case "amqp":
+		listenerInstance := new(listeneramqp.Listener)
+		err := listenerInstance.Boot(ctx, channel, s.prioritizer)
+		if err != nil {
+			panic("exception during listener boot: " + err.Error() + ", channel " + channel.ID)
+		}
+		s.listeners[channel.ID] = listenerInstance
+	case "http":
+		listenerInstance := new(listenerhttp.Listener)
+		err := listenerInstance.Boot(ctx, channel, s.prioritizer)
+		if err != nil {
+			panic("exception during listener boot: " + err.Error() + ", channel " + channel.ID)
+		}
+		s.listeners[channel.ID] = listenerInstance
+	case "nats":
+		listenerInstance := new(listenernats.Listener)
+		err := listenerInstance.Boot(ctx, channel, s.prioritizer)
+		if err != nil {
+			panic("exception during listener boot: " + err.Error() + ", channel " + channel.ID)
+		}
+		s.listeners[channel.ID] = listenerInstance
+	case "redis":
+		listenerInstance := new(listenerredis.Listener)
+		err := listenerInstance.Boot(ctx, channel, s.prioritizer)
+		if err != nil {
+			panic("exception during listener boot: " + err.Error() + ", channel " + channel.ID)
+		}
+		s.listeners[channel.ID] = listenerInstance
 	case "test":
 		listenerInstance := new(listenertest.Listener)
 		err := listenerInstance.Boot(ctx, channel, s.prioritizer)
@@ -517,10 +739,6 @@ func (s *Scheduler) GetDataStorage() *storage.PqStorage {
 	return s.dataStorage
 }
 
-func (s *Scheduler) GetOutboundPool() *goconcurrentqueue.FIFO {
-	return s.outboundPool
-}
-
 func (s *Scheduler) GetDispatcher() *dispatcher.Dispatcher {
 	return &s.dispatcher
 }
@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"github.com/maksimru/event-scheduler/message"
+	"sort"
+)
+
+// DeadLetter keeps the message which couldn't be scheduled or delivered until it's replayed
+type DeadLetter struct {
+	ID      string
+	Message message.Message
+	// Reason describes the failure, the message carries it in the failure_reason attribute
+	Reason string
+	// FailedAt is the leader time of the failure in Unix milliseconds
+	FailedAt int `json:"FailedAtMs"`
+}
+
+// sortDeadLetters orders dead letters by the failure time
+func sortDeadLetters(deadLetters []DeadLetter) {
+	sort.Slice(deadLetters, func(i, j int) bool {
+		if deadLetters[i].FailedAt != deadLetters[j].FailedAt {
+			return deadLetters[i].FailedAt < deadLetters[j].FailedAt
+		}
+		return deadLetters[i].ID < deadLetters[j].ID
+	})
+}
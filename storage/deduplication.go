@@ -0,0 +1,81 @@
+package storage
+
+import "encoding/json"
+
+// DeduplicationEntry remembers the key of the message seen at the time (Unix milliseconds)
+type DeduplicationEntry struct {
+	Key    string
+	SeenAt int `json:"SeenAtMs"`
+}
+
+// UnmarshalJSON converts the time of entries encoded before millisecond precision
+func (e *DeduplicationEntry) UnmarshalJSON(data []byte) error {
+	type entry DeduplicationEntry
+	var decoded struct {
+		entry
+		LegacySeenAt int `json:"SeenAt"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*e = DeduplicationEntry(decoded.entry)
+	if e.SeenAt == 0 {
+		e.SeenAt = decoded.LegacySeenAt * 1000
+	}
+	return nil
+}
+
+// DeduplicationTable keeps the keys in the order they were seen, so expired keys are pruned from the head
+type DeduplicationTable struct {
+	seen    map[string]int
+	entries []DeduplicationEntry
+}
+
+func NewDeduplicationTable() *DeduplicationTable {
+	return &DeduplicationTable{
+		seen:    make(map[string]int),
+		entries: make([]DeduplicationEntry, 0),
+	}
+}
+
+// Check prunes keys expired at the time and records the key, it returns true if the key was seen within the window
+func (t *DeduplicationTable) Check(key string, timestamp int, window int) bool {
+	t.prune(timestamp, window)
+	if seenAt, has := t.seen[key]; has && seenAt+window > timestamp {
+		return true
+	}
+	t.Add(DeduplicationEntry{Key: key, SeenAt: timestamp})
+	return false
+}
+
+func (t *DeduplicationTable) Add(entry DeduplicationEntry) {
+	t.seen[entry.Key] = entry.SeenAt
+	t.entries = append(t.entries, entry)
+}
+
+func (t *DeduplicationTable) prune(timestamp int, window int) {
+	i := 0
+	for ; i < len(t.entries) && t.entries[i].SeenAt+window <= timestamp; i++ {
+		// the key could be seen once again after this entry
+		if t.seen[t.entries[i].Key] == t.entries[i].SeenAt {
+			delete(t.seen, t.entries[i].Key)
+		}
+	}
+	t.entries = t.entries[i:]
+}
+
+// Dump returns the keys which are still tracked
+func (t *DeduplicationTable) Dump() []DeduplicationEntry {
+	entries := make([]DeduplicationEntry, 0, len(t.seen))
+	for _, entry := range t.entries {
+		if t.seen[entry.Key] == entry.SeenAt {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (t *DeduplicationTable) Flush() {
+	t.seen = make(map[string]int)
+	t.entries = make([]DeduplicationEntry, 0)
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDeduplicationTable_Check(t *testing.T) {
+	table := NewDeduplicationTable()
+
+	assert.False(t, table.Check("k1", 100, 60))
+	assert.False(t, table.Check("k2", 120, 60))
+	assert.True(t, table.Check("k1", 159, 60))
+	assert.Equal(t, []DeduplicationEntry{{Key: "k1", SeenAt: 100}, {Key: "k2", SeenAt: 120}}, table.Dump())
+
+	// expired key is pruned and seen once again
+	assert.False(t, table.Check("k1", 160, 60))
+	assert.Equal(t, []DeduplicationEntry{{Key: "k2", SeenAt: 120}, {Key: "k1", SeenAt: 160}}, table.Dump())
+
+	assert.False(t, table.Check("k3", 300, 60))
+	assert.Equal(t, []DeduplicationEntry{{Key: "k3", SeenAt: 300}}, table.Dump())
+
+	table.Flush()
+	assert.Empty(t, table.Dump())
+}
+
+func TestPqChannelStorage_Deduplicate(t *testing.T) {
+	storage := NewPqChannelStorage()
+
+	assert.False(t, storage.Deduplicate("k1", 100, 60))
+	assert.True(t, storage.Deduplicate("k1", 110, 60))
+	// messages without key aren't deduplicated
+	assert.False(t, storage.Deduplicate("", 100, 60))
+	assert.False(t, storage.Deduplicate("", 110, 60))
+	assert.Equal(t, []DeduplicationEntry{{Key: "k1", SeenAt: 100}}, storage.DumpDeduplication())
+
+	storage.Flush()
+	assert.Empty(t, storage.DumpDeduplication())
+	storage.RestoreDeduplication(DeduplicationEntry{Key: "k1", SeenAt: 100})
+	assert.True(t, storage.Deduplicate("k1", 110, 60))
+}
+
+func TestDeduplicationEntry_JSON(t *testing.T) {
+	entry := DeduplicationEntry{Key: "k1", SeenAt: 100250}
+	data, err := json.Marshal(entry)
+	assert.NoError(t, err)
+	var got DeduplicationEntry
+	assert.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, entry, got)
+
+	// time in seconds is converted to milliseconds
+	got = DeduplicationEntry{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"Key":"k1","SeenAt":100}`), &got))
+	assert.Equal(t, DeduplicationEntry{Key: "k1", SeenAt: 100000}, got)
+}
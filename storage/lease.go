@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"github.com/maksimru/event-scheduler/message"
+	"sort"
+)
+
+// Lease keeps the popped message in flight until the delivery is acknowledged,
+// the message is dispatched once again when the lease expires (Unix milliseconds)
+type Lease struct {
+	ID        string
+	Message   message.Message
+	ExpiresAt int `json:"ExpiresAtMs"`
+}
+
+// sortLeases orders leases by expiration, so all nodes renew the same ones
+func sortLeases(leases []Lease) {
+	sort.Slice(leases, func(i, j int) bool {
+		if leases[i].ExpiresAt != leases[j].ExpiresAt {
+			return leases[i].ExpiresAt < leases[j].ExpiresAt
+		}
+		return leases[i].ID < leases[j].ID
+	})
+}
@@ -0,0 +1,14 @@
+package storage
+
+// ChannelStats describes the backlog of the channel at the time
+type ChannelStats struct {
+	// Scheduled is the number of messages waiting in the queue
+	Scheduled int
+	// InFlight is the number of popped messages waiting for the acknowledgement
+	InFlight int
+	// DeadLetters is the number of messages kept for the replay
+	DeadLetters int
+	// Lag is how far behind the schedule the channel is running in milliseconds,
+	// it's the age of the earliest due message or zero if nothing is due
+	Lag int `json:"LagMs"`
+}
@@ -4,16 +4,23 @@ import (
 	"errors"
 	"github.com/maksimru/event-scheduler/channel"
 	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/schedule"
 	"github.com/maksimru/go-hpds/doublylinkedlist"
 	"github.com/maksimru/go-hpds/priorityqueue"
 	"github.com/maksimru/go-hpds/utils/arraylist"
 	"github.com/maksimru/go-hpds/utils/comparator"
 	"github.com/satori/go.uuid"
+	"sort"
 	"sync"
 )
 
 var (
 	ErrChannelNotFound = errors.New("channel is not found")
+	ErrMessageNotFound = errors.New("message is not found")
+	// ErrMessageDuplicated is returned when the message key was seen within the channel deduplication window
+	ErrMessageDuplicated  = errors.New("message is duplicated")
+	ErrScheduleNotFound   = errors.New("schedule is not found")
+	ErrDeadLetterNotFound = errors.New("dead letter is not found")
 )
 
 type PrioritizedNodePointer struct {
@@ -102,6 +109,10 @@ func NewPqStorage() *PqStorage {
 
 func (p *PqStorage) Flush() {
 	p.mutex.Lock()
+	// processors waiting on the dropped storages look the channels up again
+	for _, s := range p.data {
+		s.notify()
+	}
 	p.channels = make(map[string]channel.Channel)
 	p.data = make(map[string]PqChannelStorage)
 	p.mutex.Unlock()
@@ -124,6 +135,105 @@ func (p *PqStorage) Dump() ([]channel.Channel, map[string][]message.Message) {
 	return channels, m
 }
 
+// DumpDeduplication returns deduplication keys of every channel
+func (p *PqStorage) DumpDeduplication() map[string][]DeduplicationEntry {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	m := make(map[string][]DeduplicationEntry, len(p.channels))
+	for _, c := range p.channels {
+		pq := p.data[c.ID]
+		m[c.ID] = pq.DumpDeduplication()
+	}
+
+	return m
+}
+
+// DumpDeadLetters returns dead letters of every channel
+func (p *PqStorage) DumpDeadLetters() map[string][]DeadLetter {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	m := make(map[string][]DeadLetter)
+	for _, c := range p.channels {
+		pq := p.data[c.ID]
+		m[c.ID] = pq.GetDeadLetters()
+	}
+	return m
+}
+
+func (p *PqStorage) GetDeadLetters(channelID string) ([]DeadLetter, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s, has := p.GetChannelStorage(channelID)
+	if !has {
+		return nil, ErrChannelNotFound
+	}
+	return s.GetDeadLetters(), nil
+}
+
+// ReplayDeadLetters schedules messages of the dead letters to the time, nothing is replayed if any of them is not found
+func (p *PqStorage) ReplayDeadLetters(channelID string, ids []string, availableAt int) ([]message.Message, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s, has := p.GetChannelStorage(channelID)
+	if !has {
+		return nil, ErrChannelNotFound
+	}
+	if len(ids) == 0 || !s.HasDeadLetters(ids) {
+		return nil, ErrDeadLetterNotFound
+	}
+	replayed := make([]message.Message, 0, len(ids))
+	for _, id := range ids {
+		deadLetter, has := s.RemoveDeadLetter(id)
+		if !has {
+			// the ID is repeated
+			continue
+		}
+		msg := deadLetter.Message.WithoutAttribute(message.AttributeFailureReason)
+		msg.AvailableAt = availableAt
+		s.Enqueue(msg)
+		replayed = append(replayed, msg)
+	}
+	return replayed, nil
+}
+
+// GetChannelStats returns the backlog of the channel at the timestamp
+func (p *PqStorage) GetChannelStats(channelID string, timestamp int) (ChannelStats, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s, has := p.GetChannelStorage(channelID)
+	if !has {
+		return ChannelStats{}, ErrChannelNotFound
+	}
+	return s.Stats(timestamp), nil
+}
+
+// DumpLeases returns in-flight messages of every channel
+func (p *PqStorage) DumpLeases() map[string][]Lease {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	m := make(map[string][]Lease)
+	for _, c := range p.channels {
+		pq := p.data[c.ID]
+		m[c.ID] = pq.DumpLeases()
+	}
+	return m
+}
+
+// DumpSchedules returns schedules of every channel
+func (p *PqStorage) DumpSchedules() map[string][]schedule.Schedule {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	m := make(map[string][]schedule.Schedule, len(p.channels))
+	for _, c := range p.channels {
+		pq := p.data[c.ID]
+		m[c.ID] = pq.GetSchedules()
+	}
+
+	return m
+}
+
 func (p *PqStorage) GetChannel(channelID string) (channel.Channel, error) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
@@ -200,10 +310,104 @@ func (p *PqStorage) DeleteChannel(channelID string) (channel.Channel, error) {
 		s.mutex.Lock()
 		defer s.mutex.Unlock()
 		delete(p.data, channelID)
+		s.notify()
 	}
 	return c, nil
 }
 
+func (p *PqStorage) GetMessage(channelID string, messageID string) (message.Message, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s, has := p.GetChannelStorage(channelID)
+	if !has {
+		return message.Message{}, ErrChannelNotFound
+	}
+	msg, has := s.Get(messageID)
+	if !has {
+		return message.Message{}, ErrMessageNotFound
+	}
+	return msg, nil
+}
+
+// RemoveMessage cancels all scheduled messages with the ID
+func (p *PqStorage) RemoveMessage(channelID string, messageID string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s, has := p.GetChannelStorage(channelID)
+	if !has {
+		return ErrChannelNotFound
+	}
+	if !s.Remove(messageID) {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+// RescheduleMessage moves all scheduled messages with the ID to the new time
+func (p *PqStorage) RescheduleMessage(channelID string, messageID string, availableAt int) (message.Message, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s, has := p.GetChannelStorage(channelID)
+	if !has {
+		return message.Message{}, ErrChannelNotFound
+	}
+	msg, has := s.Reschedule(messageID, availableAt)
+	if !has {
+		return message.Message{}, ErrMessageNotFound
+	}
+	return msg, nil
+}
+
+func (p *PqStorage) GetSchedules(channelID string) ([]schedule.Schedule, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s, has := p.GetChannelStorage(channelID)
+	if !has {
+		return nil, ErrChannelNotFound
+	}
+	return s.GetSchedules(), nil
+}
+
+func (p *PqStorage) GetSchedule(channelID string, scheduleID string) (schedule.Schedule, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s, has := p.GetChannelStorage(channelID)
+	if !has {
+		return schedule.Schedule{}, ErrChannelNotFound
+	}
+	sch, has := s.GetSchedule(scheduleID)
+	if !has {
+		return schedule.Schedule{}, ErrScheduleNotFound
+	}
+	return sch, nil
+}
+
+// SaveSchedule creates or replaces the schedule, its occurrence is scheduled to the first fire time after the timestamp
+func (p *PqStorage) SaveSchedule(channelID string, sch schedule.Schedule, timestamp int) (schedule.Schedule, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s, has := p.GetChannelStorage(channelID)
+	if !has {
+		return schedule.Schedule{}, ErrChannelNotFound
+	}
+	return s.SaveSchedule(sch, timestamp)
+}
+
+// DeleteSchedule removes the schedule with its scheduled occurrence
+func (p *PqStorage) DeleteSchedule(channelID string, scheduleID string) (schedule.Schedule, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s, has := p.GetChannelStorage(channelID)
+	if !has {
+		return schedule.Schedule{}, ErrChannelNotFound
+	}
+	sch, has := s.DeleteSchedule(scheduleID)
+	if !has {
+		return schedule.Schedule{}, ErrScheduleNotFound
+	}
+	return sch, nil
+}
+
 func (p *PqStorage) GetChannelStorage(channelID string) (PqChannelStorage, bool) {
 	storage, has := p.data[channelID]
 	return storage, has
@@ -213,6 +417,21 @@ type PqChannelStorage struct {
 	mutex       *sync.Mutex
 	dataStorage *priorityqueue.PriorityQueue
 	iterator    *doublylinkedlist.DoublyLinkedList
+	// index refers to the list nodes of every message ID, the latest one is the last
+	index map[string][]*doublylinkedlist.Node
+	// removed keeps the nodes of cancelled messages which are still in the priority queue,
+	// they are dropped once they reach the top, so the top always refers to the scheduled message
+	removed map[*doublylinkedlist.Node]struct{}
+	// deduplication keeps the keys of the recently received messages
+	deduplication *DeduplicationTable
+	// schedules emit their next occurrence once the previous one is dequeued
+	schedules map[string]schedule.Schedule
+	// wakeup is signalled when the head of the queue is replaced by an earlier message
+	wakeup chan struct{}
+	// leases keep the popped messages until their delivery is acknowledged
+	leases map[string]Lease
+	// deadLetters keep the messages which couldn't be scheduled or delivered
+	deadLetters map[string]DeadLetter
 }
 
 func NewPqChannelStorage() PqChannelStorage {
@@ -222,7 +441,14 @@ func NewPqChannelStorage() PqChannelStorage {
 			NewPrioritizedNodePointerValueList(make([]PrioritizedNodePointer, 0)),
 			NewPrioritizedNodePointerMinPriorityComparator(),
 		),
-		iterator: doublylinkedlist.NewDoublyLinkedList(),
+		iterator:      doublylinkedlist.NewDoublyLinkedList(),
+		index:         make(map[string][]*doublylinkedlist.Node),
+		removed:       make(map[*doublylinkedlist.Node]struct{}),
+		deduplication: NewDeduplicationTable(),
+		schedules:     make(map[string]schedule.Schedule),
+		wakeup:        make(chan struct{}, 1),
+		leases:        make(map[string]Lease),
+		deadLetters:   make(map[string]DeadLetter),
 	}
 }
 
@@ -232,43 +458,431 @@ func (p *PqChannelStorage) Dequeue() message.Message {
 	msgPtr := priorityData.GetValue().(*doublylinkedlist.Node)
 	msgData := msgPtr.GetValue().(message.Message)
 	msgPtr.Remove()
+	p.unindex(msgData.GetID(), msgPtr)
+	p.dropRemoved()
 	p.mutex.Unlock()
 	return msgData
 }
 
 func (p *PqChannelStorage) Enqueue(value message.Message) {
 	p.mutex.Lock()
+	p.enqueue(value)
+	p.mutex.Unlock()
+}
+
+func (p *PqChannelStorage) enqueue(value message.Message) {
 	node := p.iterator.Append(value)
 	p.dataStorage.Enqueue(NewPrioritizedNodePointer(node, value.GetAvailableAt()))
+	if value.GetID() != "" {
+		p.index[value.GetID()] = append(p.index[value.GetID()], node)
+	}
+	if p.dataStorage.Top().GetValue() == node {
+		p.notify()
+	}
+}
+
+// Wakeup is signalled when the enqueued message becomes the head of the queue, the lease is released or the storage is dropped,
+// signals are coalesced, so the receiver has to check the queue once again
+func (p *PqChannelStorage) Wakeup() <-chan struct{} {
+	return p.wakeup
+}
+
+func (p *PqChannelStorage) notify() {
+	select {
+	case p.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Replace schedules the message instead of the messages with the same ID
+func (p *PqChannelStorage) Replace(value message.Message) {
+	p.mutex.Lock()
+	p.remove(value.GetID())
+	p.enqueue(value)
 	p.mutex.Unlock()
 }
 
+func (p *PqChannelStorage) Get(messageID string) (message.Message, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	nodes, has := p.index[messageID]
+	if !has {
+		return message.Message{}, false
+	}
+	return nodes[len(nodes)-1].GetValue().(message.Message), true
+}
+
+// Remove cancels all messages with the ID, it returns false if there are no such messages
+func (p *PqChannelStorage) Remove(messageID string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	messages := p.remove(messageID)
+	for _, msg := range messages {
+		// cancelled occurrence is skipped, the schedule goes on
+		p.scheduleNext(msg, 0)
+	}
+	return len(messages) > 0
+}
+
+// Reschedule moves all messages with the ID to the new time, it returns the latest one
+func (p *PqChannelStorage) Reschedule(messageID string, availableAt int) (message.Message, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	messages := p.remove(messageID)
+	if len(messages) == 0 {
+		return message.Message{}, false
+	}
+	// priority queue can't update the priority in place, so messages are scheduled once again
+	for _, msg := range messages {
+		msg.AvailableAt = availableAt
+		p.enqueue(msg)
+	}
+	msg := messages[len(messages)-1]
+	msg.AvailableAt = availableAt
+	return msg, true
+}
+
+// remove takes all messages with the ID out of the storage and returns them
+func (p *PqChannelStorage) remove(messageID string) []message.Message {
+	nodes := p.index[messageID]
+	messages := make([]message.Message, len(nodes))
+	for i, node := range nodes {
+		messages[i] = node.GetValue().(message.Message)
+		node.Remove()
+		p.removed[node] = struct{}{}
+	}
+	delete(p.index, messageID)
+	p.dropRemoved()
+	return messages
+}
+
+func (p *PqChannelStorage) unindex(messageID string, node *doublylinkedlist.Node) {
+	nodes := p.index[messageID]
+	for i, n := range nodes {
+		if n == node {
+			nodes = append(nodes[:i], nodes[i+1:]...)
+			break
+		}
+	}
+	if len(nodes) == 0 {
+		delete(p.index, messageID)
+	} else {
+		p.index[messageID] = nodes
+	}
+}
+
+// dropRemoved dequeues cancelled messages from the top of the priority queue
+func (p *PqChannelStorage) dropRemoved() {
+	for len(p.removed) > 0 {
+		top := p.dataStorage.Top()
+		if top == nil {
+			return
+		}
+		node := top.GetValue().(*doublylinkedlist.Node)
+		if _, has := p.removed[node]; !has {
+			return
+		}
+		p.dataStorage.Dequeue()
+		delete(p.removed, node)
+	}
+}
+
+// Deduplicate records the message key seen at the time, it returns true if the key was seen within the window
+func (p *PqChannelStorage) Deduplicate(key string, timestamp int, window int) bool {
+	if key == "" {
+		return false
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.deduplication.Check(key, timestamp, window)
+}
+
+// RestoreDeduplication records the key seen before the snapshot
+func (p *PqChannelStorage) RestoreDeduplication(entry DeduplicationEntry) {
+	p.mutex.Lock()
+	p.deduplication.Add(entry)
+	p.mutex.Unlock()
+}
+
+func (p *PqChannelStorage) DumpDeduplication() []DeduplicationEntry {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.deduplication.Dump()
+}
+
+// ScheduleNext enqueues the occurrence which follows the dequeued one, missed fire times up to the timestamp are skipped
+func (p *PqChannelStorage) ScheduleNext(msg message.Message, timestamp int) {
+	p.mutex.Lock()
+	p.scheduleNext(msg, timestamp)
+	p.mutex.Unlock()
+}
+
+func (p *PqChannelStorage) scheduleNext(msg message.Message, timestamp int) {
+	sch, has := p.schedules[msg.GetScheduleID()]
+	// occurrences of replaced or paused schedules are ignored
+	if !has || sch.NextAt == 0 || msg.GetID() != sch.OccurrenceID(sch.NextAt) {
+		return
+	}
+	after := sch.NextAt
+	if timestamp > after {
+		after = timestamp
+	}
+	nextAt, err := sch.Next(after)
+	if err != nil {
+		// schedule is exhausted
+		sch.NextAt = 0
+	} else {
+		sch.NextAt = nextAt
+		p.enqueue(sch.Occurrence(nextAt))
+	}
+	p.schedules[sch.ID] = sch
+}
+
+func (p *PqChannelStorage) SaveSchedule(sch schedule.Schedule, timestamp int) (schedule.Schedule, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	sch.NextAt = 0
+	if !sch.Paused {
+		nextAt, err := sch.Next(timestamp)
+		if err != nil {
+			return schedule.Schedule{}, err
+		}
+		sch.NextAt = nextAt
+	}
+	if previous, has := p.schedules[sch.ID]; has && previous.NextAt > 0 {
+		p.remove(previous.OccurrenceID(previous.NextAt))
+	}
+	if sch.NextAt > 0 {
+		p.enqueue(sch.Occurrence(sch.NextAt))
+	}
+	p.schedules[sch.ID] = sch
+	return sch, nil
+}
+
+func (p *PqChannelStorage) DeleteSchedule(scheduleID string) (schedule.Schedule, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	sch, has := p.schedules[scheduleID]
+	if !has {
+		return schedule.Schedule{}, false
+	}
+	if sch.NextAt > 0 {
+		p.remove(sch.OccurrenceID(sch.NextAt))
+	}
+	delete(p.schedules, scheduleID)
+	return sch, true
+}
+
+// RestoreSchedule keeps the schedule from the snapshot, its occurrence is restored with the messages
+func (p *PqChannelStorage) RestoreSchedule(sch schedule.Schedule) {
+	p.mutex.Lock()
+	p.schedules[sch.ID] = sch
+	p.mutex.Unlock()
+}
+
+func (p *PqChannelStorage) GetSchedule(scheduleID string) (schedule.Schedule, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	sch, has := p.schedules[scheduleID]
+	return sch, has
+}
+
+// GetSchedules returns schedules ordered by ID
+func (p *PqChannelStorage) GetSchedules() []schedule.Schedule {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	schedules := make([]schedule.Schedule, 0, len(p.schedules))
+	for _, sch := range p.schedules {
+		schedules = append(schedules, sch)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].ID < schedules[j].ID
+	})
+	return schedules
+}
+
 func (p *PqChannelStorage) IsEmpty() bool {
 	return p.dataStorage.IsEmpty()
 }
 
 func (p *PqChannelStorage) CheckScheduled(nowTimestamp int) bool {
+	earliestScheduledTimestamp, has := p.NextAvailableAt()
+	return has && earliestScheduledTimestamp <= nowTimestamp
+}
+
+// NextAvailableAt returns the scheduling time of the head of the queue, it returns false if the queue is empty
+func (p *PqChannelStorage) NextAvailableAt() (int, bool) {
 	p.mutex.Lock()
 	top := p.dataStorage.Top()
 	p.mutex.Unlock()
 	if top == nil {
+		return 0, false
+	}
+	return top.GetPriority(), true
+}
+
+// AddLease keeps the message in flight until the lease is acknowledged
+func (p *PqChannelStorage) AddLease(lease Lease) {
+	p.mutex.Lock()
+	p.leases[lease.ID] = lease
+	p.mutex.Unlock()
+}
+
+// Ack completes the delivery of the leased message, it returns false if there is no such lease
+func (p *PqChannelStorage) Ack(leaseID string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	_, has := p.leases[leaseID]
+	delete(p.leases, leaseID)
+	return has
+}
+
+// Release expires the lease at the timestamp, so its message is delivered once again right away,
+// it returns false if there is no such lease or it expires earlier
+func (p *PqChannelStorage) Release(leaseID string, timestamp int) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	lease, has := p.leases[leaseID]
+	if !has || lease.ExpiresAt <= timestamp {
 		return false
 	}
-	earliestScheduledTimestamp := top.GetPriority()
-	match := earliestScheduledTimestamp <= nowTimestamp
-	return match
+	lease.ExpiresAt = timestamp
+	p.leases[leaseID] = lease
+	// processor waits for the former lease expiration
+	p.notify()
+	return true
+}
+
+// NextLeaseExpiry returns the earliest lease expiration time, it returns false if there are no messages in flight
+func (p *PqChannelStorage) NextLeaseExpiry() (int, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	expiresAt, has := 0, false
+	for _, lease := range p.leases {
+		if !has || lease.ExpiresAt < expiresAt {
+			expiresAt, has = lease.ExpiresAt, true
+		}
+	}
+	return expiresAt, has
+}
+
+func (p *PqChannelStorage) CheckLeases(nowTimestamp int) bool {
+	expiresAt, has := p.NextLeaseExpiry()
+	return has && expiresAt <= nowTimestamp
+}
+
+// RenewExpired extends up to the limit of leases expired at the timestamp and returns them
+func (p *PqChannelStorage) RenewExpired(timestamp int, expiresAt int, limit int) []Lease {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	expired := make([]Lease, 0)
+	for _, lease := range p.leases {
+		if lease.ExpiresAt <= timestamp {
+			expired = append(expired, lease)
+		}
+	}
+	sortLeases(expired)
+	if len(expired) > limit {
+		expired = expired[:limit]
+	}
+	for i := range expired {
+		expired[i].ExpiresAt = expiresAt
+		p.leases[expired[i].ID] = expired[i]
+	}
+	return expired
+}
+
+// DumpLeases returns in-flight messages ordered by expiration
+func (p *PqChannelStorage) DumpLeases() []Lease {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	leases := make([]Lease, 0, len(p.leases))
+	for _, lease := range p.leases {
+		leases = append(leases, lease)
+	}
+	sortLeases(leases)
+	return leases
+}
+
+// Stats returns the backlog of the channel at the timestamp
+func (p *PqChannelStorage) Stats(timestamp int) ChannelStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	stats := ChannelStats{
+		Scheduled:   p.iterator.GetLength(),
+		InFlight:    len(p.leases),
+		DeadLetters: len(p.deadLetters),
+	}
+	if top := p.dataStorage.Top(); top != nil && top.GetPriority() < timestamp {
+		stats.Lag = timestamp - top.GetPriority()
+	}
+	return stats
+}
+
+func (p *PqChannelStorage) AddDeadLetter(deadLetter DeadLetter) {
+	p.mutex.Lock()
+	p.deadLetters[deadLetter.ID] = deadLetter
+	p.mutex.Unlock()
+}
+
+// GetDeadLetters returns dead letters ordered by the failure time
+func (p *PqChannelStorage) GetDeadLetters() []DeadLetter {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	deadLetters := make([]DeadLetter, 0, len(p.deadLetters))
+	for _, deadLetter := range p.deadLetters {
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	sortDeadLetters(deadLetters)
+	return deadLetters
+}
+
+// HasDeadLetters reports whether all dead letters with the IDs are kept
+func (p *PqChannelStorage) HasDeadLetters(ids []string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, id := range ids {
+		if _, has := p.deadLetters[id]; !has {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *PqChannelStorage) RemoveDeadLetter(id string) (DeadLetter, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	deadLetter, has := p.deadLetters[id]
+	delete(p.deadLetters, id)
+	return deadLetter, has
 }
 
 func (p *PqChannelStorage) Flush() {
 	p.mutex.Lock()
 	p.iterator.Purge()
 	p.dataStorage.Clean()
+	for id := range p.index {
+		delete(p.index, id)
+	}
+	for node := range p.removed {
+		delete(p.removed, node)
+	}
+	p.deduplication.Flush()
+	for id := range p.schedules {
+		delete(p.schedules, id)
+	}
+	for id := range p.leases {
+		delete(p.leases, id)
+	}
+	for id := range p.deadLetters {
+		delete(p.deadLetters, id)
+	}
 	p.mutex.Unlock()
 }
 
 func (p *PqChannelStorage) Dump() []message.Message {
 	p.mutex.Lock()
-	clone := make([]message.Message, p.dataStorage.GetLength())
+	clone := make([]message.Message, p.iterator.GetLength())
 	node := p.iterator.GetHead()
 	i := 0
 	for node != nil {
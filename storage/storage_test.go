@@ -3,6 +3,7 @@ package storage
 import (
 	"github.com/maksimru/event-scheduler/channel"
 	"github.com/maksimru/event-scheduler/message"
+	"github.com/maksimru/event-scheduler/schedule"
 	"github.com/maksimru/go-hpds/doublylinkedlist"
 	"github.com/maksimru/go-hpds/priorityqueue"
 	"github.com/stretchr/testify/assert"
@@ -24,13 +25,23 @@ func TestNewPqChannelStorage(t *testing.T) {
 					NewPrioritizedNodePointerValueList(make([]PrioritizedNodePointer, 0)),
 					NewPrioritizedNodePointerMinPriorityComparator(),
 				),
-				iterator: doublylinkedlist.NewDoublyLinkedList(),
+				iterator:      doublylinkedlist.NewDoublyLinkedList(),
+				index:         make(map[string][]*doublylinkedlist.Node),
+				removed:       make(map[*doublylinkedlist.Node]struct{}),
+				deduplication: NewDeduplicationTable(),
+				schedules:     make(map[string]schedule.Schedule),
+				leases:        make(map[string]Lease),
+				deadLetters:   make(map[string]DeadLetter),
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := NewPqChannelStorage(); !reflect.DeepEqual(got, tt.want) {
+			got := NewPqChannelStorage()
+			// channels are compared by identity
+			assert.Equal(t, 1, cap(got.wakeup))
+			got.wakeup = nil
+			if !reflect.DeepEqual(got, tt.want) {
 				assert.Equal(t, tt.want, got)
 			}
 		})
@@ -64,9 +75,9 @@ func TestPqChannelStorage_CheckScheduled(t *testing.T) {
 		nowTimestamp int
 	}
 	storage := NewPqChannelStorage()
-	storage.Enqueue(message.NewMessage("msg1", 1000))
-	storage.Enqueue(message.NewMessage("msg2", 1001))
-	storage.Enqueue(message.NewMessage("msg3", 1002))
+	storage.Enqueue(message.NewMessage([]byte("msg1"), 1000))
+	storage.Enqueue(message.NewMessage([]byte("msg2"), 1001))
+	storage.Enqueue(message.NewMessage([]byte("msg3"), 1002))
 	emptyStorage := NewPqChannelStorage()
 	tests := []struct {
 		name   string
@@ -132,14 +143,73 @@ func TestPqChannelStorage_CheckScheduled(t *testing.T) {
 	}
 }
 
+func TestPqChannelStorage_NextAvailableAt(t *testing.T) {
+	storage := NewPqChannelStorage()
+	_, has := storage.NextAvailableAt()
+	assert.False(t, has)
+
+	storage.Enqueue(message.NewMessage([]byte("msg1"), 1250).WithID("id1"))
+	storage.Enqueue(message.NewMessage([]byte("msg2"), 1001))
+	got, has := storage.NextAvailableAt()
+	assert.True(t, has)
+	assert.Equal(t, 1001, got)
+
+	storage.Dequeue()
+	got, has = storage.NextAvailableAt()
+	assert.True(t, has)
+	assert.Equal(t, 1250, got)
+
+	// cancelled message doesn't hold the queue
+	storage.Remove("id1")
+	_, has = storage.NextAvailableAt()
+	assert.False(t, has)
+}
+
+func TestPqChannelStorage_Wakeup(t *testing.T) {
+	storage := NewPqChannelStorage()
+	assert.Len(t, storage.Wakeup(), 0)
+
+	// new head of the queue is signalled
+	storage.Enqueue(message.NewMessage([]byte("msg1"), 2000).WithID("id1"))
+	assert.Len(t, storage.Wakeup(), 1)
+
+	// signals are coalesced
+	storage.Enqueue(message.NewMessage([]byte("msg2"), 1000).WithID("id2"))
+	assert.Len(t, storage.Wakeup(), 1)
+	<-storage.Wakeup()
+
+	// later message doesn't change the head
+	storage.Enqueue(message.NewMessage([]byte("msg3"), 3000))
+	assert.Len(t, storage.Wakeup(), 0)
+
+	// rescheduled message becomes the head
+	storage.Reschedule("id1", 500)
+	assert.Len(t, storage.Wakeup(), 1)
+	<-storage.Wakeup()
+
+	// released lease is signalled
+	storage.AddLease(Lease{ID: "l1", Message: message.NewMessage([]byte("msg4"), 1000), ExpiresAt: 5000})
+	assert.Len(t, storage.Wakeup(), 0)
+	storage.Release("l1", 1000)
+	assert.Len(t, storage.Wakeup(), 1)
+	<-storage.Wakeup()
+
+	// dropped storage is signalled
+	pqStorage := NewPqStorage()
+	_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1"})
+	chStorage, _ := pqStorage.GetChannelStorage("ch1")
+	_, _ = pqStorage.DeleteChannel("ch1")
+	assert.Len(t, chStorage.Wakeup(), 1)
+}
+
 func TestPqChannelStorage_Dequeue(t *testing.T) {
 	type fields struct {
 		mutex       *sync.Mutex
 		dataStorage *priorityqueue.PriorityQueue
 	}
 	storage := NewPqChannelStorage()
-	storage.Enqueue(message.NewMessage("msg1", 1000))
-	storage.Enqueue(message.NewMessage("msg2", 1100))
+	storage.Enqueue(message.NewMessage([]byte("msg1"), 1000))
+	storage.Enqueue(message.NewMessage([]byte("msg2"), 1100))
 	tests := []struct {
 		name   string
 		fields fields
@@ -151,7 +221,7 @@ func TestPqChannelStorage_Dequeue(t *testing.T) {
 				mutex:       storage.mutex,
 				dataStorage: storage.dataStorage,
 			},
-			want: message.NewMessage("msg1", 1000),
+			want: message.NewMessage([]byte("msg1"), 1000),
 		},
 	}
 	for _, tt := range tests {
@@ -190,7 +260,7 @@ func TestPqChannelStorage_Enqueue(t *testing.T) {
 				iterator:    doublylinkedlist.NewDoublyLinkedList(),
 			},
 			args: args{
-				message.NewMessage("msg1", 1000),
+				message.NewMessage([]byte("msg1"), 1000),
 			},
 		},
 	}
@@ -305,8 +375,8 @@ func TestPqChannelStorage_Dump(t *testing.T) {
 				),
 				iterator: doublylinkedlist.NewDoublyLinkedList(),
 			},
-			msgs:    []message.Message{message.NewMessage("msg1", 2000), message.NewMessage("msg2", 1000), message.NewMessage("msg3", 2500)},
-			want:    []message.Message{message.NewMessage("msg1", 2000), message.NewMessage("msg2", 1000), message.NewMessage("msg3", 2500)},
+			msgs:    []message.Message{message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg3"), 2500)},
+			want:    []message.Message{message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg3"), 2500)},
 			dequeue: 0,
 		},
 		{
@@ -319,8 +389,8 @@ func TestPqChannelStorage_Dump(t *testing.T) {
 				),
 				iterator: doublylinkedlist.NewDoublyLinkedList(),
 			},
-			msgs:    []message.Message{message.NewMessage("msg1", 2000), message.NewMessage("msg2", 1000), message.NewMessage("msg3", 2500)},
-			want:    []message.Message{message.NewMessage("msg1", 2000), message.NewMessage("msg3", 2500)},
+			msgs:    []message.Message{message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg3"), 2500)},
+			want:    []message.Message{message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg3"), 2500)},
 			dequeue: 1,
 		},
 		{
@@ -333,8 +403,8 @@ func TestPqChannelStorage_Dump(t *testing.T) {
 				),
 				iterator: doublylinkedlist.NewDoublyLinkedList(),
 			},
-			msgs:    []message.Message{message.NewMessage("msg1", 2000), message.NewMessage("msg2", 1000), message.NewMessage("msg3", 2500)},
-			want:    []message.Message{message.NewMessage("msg3", 2500)},
+			msgs:    []message.Message{message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg3"), 2500)},
+			want:    []message.Message{message.NewMessage([]byte("msg3"), 2500)},
 			dequeue: 2,
 		},
 		{
@@ -347,7 +417,7 @@ func TestPqChannelStorage_Dump(t *testing.T) {
 				),
 				iterator: doublylinkedlist.NewDoublyLinkedList(),
 			},
-			msgs:    []message.Message{message.NewMessage("msg1", 2000), message.NewMessage("msg2", 1000), message.NewMessage("msg3", 2500)},
+			msgs:    []message.Message{message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg3"), 2500)},
 			want:    []message.Message{},
 			dequeue: 3,
 		},
@@ -395,16 +465,18 @@ func TestPqChannelStorage_Flush(t *testing.T) {
 				),
 				iterator: doublylinkedlist.NewDoublyLinkedList(),
 			},
-			msgs: []message.Message{message.NewMessage("msg1", 2000), message.NewMessage("msg2", 1000), message.NewMessage("msg3", 2500)},
+			msgs: []message.Message{message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg3"), 2500)},
 			want: []message.Message{},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			p := &PqChannelStorage{
-				mutex:       tt.fields.mutex,
-				dataStorage: tt.fields.dataStorage,
-				iterator:    tt.fields.iterator,
+				mutex:         tt.fields.mutex,
+				dataStorage:   tt.fields.dataStorage,
+				iterator:      tt.fields.iterator,
+				deduplication: NewDeduplicationTable(),
+				schedules:     make(map[string]schedule.Schedule),
 			}
 			for _, msg := range tt.msgs {
 				p.Enqueue(msg)
@@ -455,7 +527,7 @@ func TestPqChannelStorage_IsEmpty(t *testing.T) {
 			want: false,
 			data: []message.Message{{
 				AvailableAt: 0,
-				Body:        "msg",
+				Body:        []byte("msg"),
 			}},
 		},
 	}
@@ -621,7 +693,7 @@ func TestPqStorage_DeleteChannel(t *testing.T) {
 					"ch1": {
 						message.Message{
 							AvailableAt: 1000,
-							Body:        "msg1",
+							Body:        []byte("msg1"),
 						},
 					},
 				},
@@ -699,7 +771,7 @@ func TestPqStorage_Dump(t *testing.T) {
 				data:     map[string]PqChannelStorage{"ch1": NewPqChannelStorage()},
 			},
 			msgs: map[string][]message.Message{
-				"ch1": {message.NewMessage("msg1", 2000), message.NewMessage("msg2", 1000), message.NewMessage("msg3", 2500)},
+				"ch1": {message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg3"), 2500)},
 			},
 			wantChannels: []channel.Channel{
 				{
@@ -707,7 +779,7 @@ func TestPqStorage_Dump(t *testing.T) {
 				},
 			},
 			wantMsgs: map[string][]message.Message{
-				"ch1": {message.NewMessage("msg1", 2000), message.NewMessage("msg2", 1000), message.NewMessage("msg3", 2500)},
+				"ch1": {message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg3"), 2500)},
 			},
 		},
 		{
@@ -718,8 +790,8 @@ func TestPqStorage_Dump(t *testing.T) {
 				data:     map[string]PqChannelStorage{"ch1": NewPqChannelStorage(), "ch2": NewPqChannelStorage()},
 			},
 			msgs: map[string][]message.Message{
-				"ch1": {message.NewMessage("msg1", 2000), message.NewMessage("msg2", 1000), message.NewMessage("msg3", 2500)},
-				"ch2": {message.NewMessage("msg4", 3000), message.NewMessage("msg5", 3000), message.NewMessage("msg6", 3500)},
+				"ch1": {message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg3"), 2500)},
+				"ch2": {message.NewMessage([]byte("msg4"), 3000), message.NewMessage([]byte("msg5"), 3000), message.NewMessage([]byte("msg6"), 3500)},
 			},
 			wantChannels: []channel.Channel{
 				{
@@ -730,8 +802,8 @@ func TestPqStorage_Dump(t *testing.T) {
 				},
 			},
 			wantMsgs: map[string][]message.Message{
-				"ch1": {message.NewMessage("msg1", 2000), message.NewMessage("msg2", 1000), message.NewMessage("msg3", 2500)},
-				"ch2": {message.NewMessage("msg4", 3000), message.NewMessage("msg5", 3000), message.NewMessage("msg6", 3500)},
+				"ch1": {message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg3"), 2500)},
+				"ch2": {message.NewMessage([]byte("msg4"), 3000), message.NewMessage([]byte("msg5"), 3000), message.NewMessage([]byte("msg6"), 3500)},
 			},
 		},
 		{
@@ -742,8 +814,8 @@ func TestPqStorage_Dump(t *testing.T) {
 				data:     map[string]PqChannelStorage{"ch1": NewPqChannelStorage(), "ch2": NewPqChannelStorage()},
 			},
 			msgs: map[string][]message.Message{
-				"ch1": {message.NewMessage("msg1", 2000), message.NewMessage("msg2", 1000), message.NewMessage("msg3", 2500)},
-				"ch2": {message.NewMessage("msg4", 3000), message.NewMessage("msg5", 3000), message.NewMessage("msg6", 3500)},
+				"ch1": {message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg3"), 2500)},
+				"ch2": {message.NewMessage([]byte("msg4"), 3000), message.NewMessage([]byte("msg5"), 3000), message.NewMessage([]byte("msg6"), 3500)},
 			},
 			wantChannels: []channel.Channel{
 				{
@@ -754,8 +826,8 @@ func TestPqStorage_Dump(t *testing.T) {
 				},
 			},
 			wantMsgs: map[string][]message.Message{
-				"ch2": {message.NewMessage("msg4", 3000), message.NewMessage("msg5", 3000), message.NewMessage("msg6", 3500)},
-				"ch1": {message.NewMessage("msg1", 2000), message.NewMessage("msg2", 1000), message.NewMessage("msg3", 2500)},
+				"ch2": {message.NewMessage([]byte("msg4"), 3000), message.NewMessage([]byte("msg5"), 3000), message.NewMessage([]byte("msg6"), 3500)},
+				"ch1": {message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg3"), 2500)},
 			},
 		},
 	}
@@ -803,7 +875,7 @@ func TestPqStorage_Flush(t *testing.T) {
 				data:     map[string]PqChannelStorage{"ch1": NewPqChannelStorage()},
 			},
 			msgs: map[string][]message.Message{
-				"ch1": {message.NewMessage("msg1", 2000), message.NewMessage("msg2", 1000), message.NewMessage("msg3", 2500)},
+				"ch1": {message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg3"), 2500)},
 			},
 			wantChannels: []channel.Channel{},
 			wantMsgs:     map[string][]message.Message{},
@@ -816,8 +888,8 @@ func TestPqStorage_Flush(t *testing.T) {
 				data:     map[string]PqChannelStorage{"ch1": NewPqChannelStorage(), "ch2": NewPqChannelStorage()},
 			},
 			msgs: map[string][]message.Message{
-				"ch1": {message.NewMessage("msg1", 2000), message.NewMessage("msg2", 1000), message.NewMessage("msg3", 2500)},
-				"ch2": {message.NewMessage("msg4", 100), message.NewMessage("msg5", 200), message.NewMessage("msg6", 300)},
+				"ch1": {message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg3"), 2500)},
+				"ch2": {message.NewMessage([]byte("msg4"), 100), message.NewMessage([]byte("msg5"), 200), message.NewMessage([]byte("msg6"), 300)},
 			},
 			wantChannels: []channel.Channel{},
 			wantMsgs:     map[string][]message.Message{},
@@ -869,14 +941,14 @@ func TestPqStorage_GetChannelStorage(t *testing.T) {
 				data:     map[string]PqChannelStorage{"ch1": NewPqChannelStorage(), "ch2": NewPqChannelStorage()},
 			},
 			msgs: map[string][]message.Message{
-				"ch1": {message.NewMessage("msg1", 2000), message.NewMessage("msg2", 1000), message.NewMessage("msg3", 2500)},
-				"ch2": {message.NewMessage("msg4", 3200), message.NewMessage("msg5", 3000), message.NewMessage("msg6", 3500)},
+				"ch1": {message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg3"), 2500)},
+				"ch2": {message.NewMessage([]byte("msg4"), 3200), message.NewMessage([]byte("msg5"), 3000), message.NewMessage([]byte("msg6"), 3500)},
 			},
 			args: args{
 				channelID: "ch2",
 			},
 			wantAvailable: true,
-			want:          []message.Message{message.NewMessage("msg5", 3000), message.NewMessage("msg4", 3200), message.NewMessage("msg6", 3500)},
+			want:          []message.Message{message.NewMessage([]byte("msg5"), 3000), message.NewMessage([]byte("msg4"), 3200), message.NewMessage([]byte("msg6"), 3500)},
 		},
 		{
 			name: "Check channel storage with multiple channels, another channel",
@@ -886,14 +958,14 @@ func TestPqStorage_GetChannelStorage(t *testing.T) {
 				data:     map[string]PqChannelStorage{"ch1": NewPqChannelStorage(), "ch2": NewPqChannelStorage()},
 			},
 			msgs: map[string][]message.Message{
-				"ch1": {message.NewMessage("msg1", 2000), message.NewMessage("msg2", 1000), message.NewMessage("msg3", 2500)},
-				"ch2": {message.NewMessage("msg4", 3200), message.NewMessage("msg5", 3000), message.NewMessage("msg6", 3500)},
+				"ch1": {message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg3"), 2500)},
+				"ch2": {message.NewMessage([]byte("msg4"), 3200), message.NewMessage([]byte("msg5"), 3000), message.NewMessage([]byte("msg6"), 3500)},
 			},
 			args: args{
 				channelID: "ch1",
 			},
 			wantAvailable: true,
-			want:          []message.Message{message.NewMessage("msg2", 1000), message.NewMessage("msg1", 2000), message.NewMessage("msg3", 2500)},
+			want:          []message.Message{message.NewMessage([]byte("msg2"), 1000), message.NewMessage([]byte("msg1"), 2000), message.NewMessage([]byte("msg3"), 2500)},
 		},
 		{
 			name: "Check channel storage with multiple channels, non existing channel",
@@ -1145,3 +1217,344 @@ func TestPqStorage_UpdateChannel(t *testing.T) {
 		})
 	}
 }
+
+func TestPqChannelStorage_Get(t *testing.T) {
+	storage := NewPqChannelStorage()
+	storage.Enqueue(message.NewMessage([]byte("msg1"), 1000).WithID("id1"))
+	storage.Enqueue(message.NewMessage([]byte("msg2"), 1100).WithID("id2"))
+	storage.Enqueue(message.NewMessage([]byte("msg3"), 1200))
+
+	got, has := storage.Get("id2")
+	assert.True(t, has)
+	assert.Equal(t, message.NewMessage([]byte("msg2"), 1100).WithID("id2"), got)
+
+	// dequeued messages are removed from the index
+	storage.Dequeue()
+	_, has = storage.Get("id1")
+	assert.False(t, has)
+
+	// index points to the latest message with the same ID
+	storage.Enqueue(message.NewMessage([]byte("msg4"), 900).WithID("id2"))
+	got, _ = storage.Get("id2")
+	assert.Equal(t, message.NewMessage([]byte("msg4"), 900).WithID("id2"), got)
+	// the previous message with the same ID is still scheduled
+	storage.Dequeue()
+	got, has = storage.Get("id2")
+	assert.True(t, has)
+	assert.Equal(t, message.NewMessage([]byte("msg2"), 1100).WithID("id2"), got)
+
+	storage.Enqueue(message.NewMessage([]byte("msg5"), 1300).WithID("id5"))
+	storage.Flush()
+	_, has = storage.Get("id5")
+	assert.False(t, has)
+}
+
+func TestPqStorage_GetMessage(t *testing.T) {
+	p := NewPqStorage()
+	_, _ = p.AddChannel(channel.Channel{ID: "ch1"})
+	s, _ := p.GetChannelStorage("ch1")
+	s.Enqueue(message.NewMessage([]byte("msg1"), 1000).WithID("id1"))
+
+	got, err := p.GetMessage("ch1", "id1")
+	assert.NoError(t, err)
+	assert.Equal(t, message.NewMessage([]byte("msg1"), 1000).WithID("id1"), got)
+
+	_, err = p.GetMessage("ch1", "id2")
+	assert.Equal(t, ErrMessageNotFound, err)
+
+	_, err = p.GetMessage("ch2", "id1")
+	assert.Equal(t, ErrChannelNotFound, err)
+}
+
+func TestPqChannelStorage_Remove(t *testing.T) {
+	storage := NewPqChannelStorage()
+	storage.Enqueue(message.NewMessage([]byte("msg1"), 1000).WithID("id1"))
+	storage.Enqueue(message.NewMessage([]byte("msg2"), 1100).WithID("id2"))
+	storage.Enqueue(message.NewMessage([]byte("msg3"), 1200).WithID("id3"))
+	storage.Enqueue(message.NewMessage([]byte("msg4"), 1300).WithID("id2"))
+
+	assert.True(t, storage.Remove("id2"))
+	assert.False(t, storage.Remove("id2"))
+	assert.False(t, storage.Remove("id4"))
+	_, has := storage.Get("id2")
+	assert.False(t, has)
+	assert.Equal(t, []message.Message{
+		message.NewMessage([]byte("msg1"), 1000).WithID("id1"),
+		message.NewMessage([]byte("msg3"), 1200).WithID("id3"),
+	}, storage.Dump())
+
+	// cancelled message on top of the queue doesn't hold back the rest
+	assert.True(t, storage.Remove("id1"))
+	assert.False(t, storage.CheckScheduled(1100))
+	assert.True(t, storage.CheckScheduled(1200))
+	assert.Equal(t, message.NewMessage([]byte("msg3"), 1200).WithID("id3"), storage.Dequeue())
+	assert.True(t, storage.IsEmpty())
+
+	// last message cancellation leaves the queue empty
+	storage.Enqueue(message.NewMessage([]byte("msg5"), 1400).WithID("id5"))
+	assert.True(t, storage.Remove("id5"))
+	assert.True(t, storage.IsEmpty())
+	assert.Empty(t, storage.Dump())
+}
+
+func TestPqStorage_RemoveMessage(t *testing.T) {
+	p := NewPqStorage()
+	_, _ = p.AddChannel(channel.Channel{ID: "ch1"})
+	s, _ := p.GetChannelStorage("ch1")
+	s.Enqueue(message.NewMessage([]byte("msg1"), 1000).WithID("id1"))
+
+	assert.NoError(t, p.RemoveMessage("ch1", "id1"))
+	assert.Equal(t, ErrMessageNotFound, p.RemoveMessage("ch1", "id1"))
+	assert.Equal(t, ErrChannelNotFound, p.RemoveMessage("ch2", "id1"))
+	assert.True(t, s.IsEmpty())
+}
+
+func TestPqChannelStorage_Reschedule(t *testing.T) {
+	storage := NewPqChannelStorage()
+	storage.Enqueue(message.NewMessage([]byte("msg1"), 1000).WithID("id1"))
+	storage.Enqueue(message.NewMessage([]byte("msg2"), 1100).WithID("id2"))
+
+	got, has := storage.Reschedule("id1", 1200)
+	assert.True(t, has)
+	assert.Equal(t, message.NewMessage([]byte("msg1"), 1200).WithID("id1"), got)
+	_, has = storage.Reschedule("id3", 1200)
+	assert.False(t, has)
+
+	assert.False(t, storage.CheckScheduled(1000))
+	assert.Equal(t, message.NewMessage([]byte("msg2"), 1100).WithID("id2"), storage.Dequeue())
+	assert.Equal(t, message.NewMessage([]byte("msg1"), 1200).WithID("id1"), storage.Dequeue())
+	assert.True(t, storage.IsEmpty())
+}
+
+func TestPqChannelStorage_Replace(t *testing.T) {
+	storage := NewPqChannelStorage()
+	storage.Enqueue(message.NewMessage([]byte("msg1"), 1000).WithID("id1"))
+	storage.Enqueue(message.NewMessage([]byte("msg2"), 1100).WithID("id2"))
+
+	storage.Replace(message.NewMessage([]byte("msg3"), 1200).WithID("id1"))
+	storage.Replace(message.NewMessage([]byte("msg4"), 1300).WithID("id4"))
+	assert.Equal(t, []message.Message{
+		message.NewMessage([]byte("msg2"), 1100).WithID("id2"),
+		message.NewMessage([]byte("msg3"), 1200).WithID("id1"),
+		message.NewMessage([]byte("msg4"), 1300).WithID("id4"),
+	}, storage.Dump())
+}
+
+func TestPqStorage_RescheduleMessage(t *testing.T) {
+	p := NewPqStorage()
+	_, _ = p.AddChannel(channel.Channel{ID: "ch1"})
+	s, _ := p.GetChannelStorage("ch1")
+	s.Enqueue(message.NewMessage([]byte("msg1"), 1000).WithID("id1"))
+
+	got, err := p.RescheduleMessage("ch1", "id1", 1200)
+	assert.NoError(t, err)
+	assert.Equal(t, message.NewMessage([]byte("msg1"), 1200).WithID("id1"), got)
+	_, err = p.RescheduleMessage("ch1", "id2", 1200)
+	assert.Equal(t, ErrMessageNotFound, err)
+	_, err = p.RescheduleMessage("ch2", "id1", 1200)
+	assert.Equal(t, ErrChannelNotFound, err)
+}
+
+func TestPqChannelStorage_Schedules(t *testing.T) {
+	storage := NewPqChannelStorage()
+	sch := schedule.Schedule{ID: "sch1", Cron: "*/5 * * * *", Body: []byte("foo")}
+
+	saved, err := storage.SaveSchedule(sch, 1000000)
+	assert.NoError(t, err)
+	assert.Equal(t, 1200000, saved.NextAt)
+	got, has := storage.GetSchedule("sch1")
+	assert.True(t, has)
+	assert.Equal(t, saved, got)
+	assert.Equal(t, []message.Message{sch.Occurrence(1200000)}, storage.Dump())
+
+	// next occurrence follows the dequeued one
+	msg := storage.Dequeue()
+	storage.ScheduleNext(msg, 1200000)
+	assert.Equal(t, []message.Message{sch.Occurrence(1500000)}, storage.Dump())
+
+	// missed fire times are skipped
+	msg = storage.Dequeue()
+	storage.ScheduleNext(msg, 2000000)
+	assert.Equal(t, []message.Message{sch.Occurrence(2100000)}, storage.Dump())
+
+	// cancelled occurrence is skipped
+	assert.True(t, storage.Remove(sch.OccurrenceID(2100000)))
+	assert.Equal(t, []message.Message{sch.Occurrence(2400000)}, storage.Dump())
+
+	// paused schedule has no occurrence
+	sch.Paused = true
+	saved, err = storage.SaveSchedule(sch, 2200000)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, saved.NextAt)
+	assert.Empty(t, storage.Dump())
+
+	sch.Paused = false
+	_, err = storage.SaveSchedule(sch, 3000000)
+	assert.NoError(t, err)
+	assert.Equal(t, []message.Message{sch.Occurrence(3300000)}, storage.Dump())
+
+	_, err = storage.SaveSchedule(schedule.Schedule{ID: "sch2", Cron: "0 0 30 2 *"}, 3000000)
+	assert.Equal(t, schedule.ErrNoFireTime, err)
+	assert.Len(t, storage.GetSchedules(), 1)
+
+	deleted, has := storage.DeleteSchedule("sch1")
+	assert.True(t, has)
+	assert.Equal(t, "sch1", deleted.ID)
+	_, has = storage.DeleteSchedule("sch1")
+	assert.False(t, has)
+	assert.Empty(t, storage.Dump())
+	assert.Empty(t, storage.GetSchedules())
+}
+
+func TestPqStorage_Schedules(t *testing.T) {
+	p := NewPqStorage()
+	_, _ = p.AddChannel(channel.Channel{ID: "ch1"})
+
+	_, err := p.SaveSchedule("ch1", schedule.Schedule{ID: "sch2", Cron: "@hourly"}, 0)
+	assert.NoError(t, err)
+	_, err = p.SaveSchedule("ch1", schedule.Schedule{ID: "sch1", Cron: "@hourly"}, 0)
+	assert.NoError(t, err)
+	_, err = p.SaveSchedule("ch2", schedule.Schedule{ID: "sch1", Cron: "@hourly"}, 0)
+	assert.Equal(t, ErrChannelNotFound, err)
+
+	schedules, err := p.GetSchedules("ch1")
+	assert.NoError(t, err)
+	assert.Equal(t, []schedule.Schedule{
+		{ID: "sch1", Cron: "@hourly", NextAt: 3600000},
+		{ID: "sch2", Cron: "@hourly", NextAt: 3600000},
+	}, schedules)
+	assert.Equal(t, map[string][]schedule.Schedule{"ch1": schedules}, p.DumpSchedules())
+	_, err = p.GetSchedules("ch2")
+	assert.Equal(t, ErrChannelNotFound, err)
+
+	_, err = p.GetSchedule("ch1", "sch3")
+	assert.Equal(t, ErrScheduleNotFound, err)
+	_, err = p.DeleteSchedule("ch1", "sch3")
+	assert.Equal(t, ErrScheduleNotFound, err)
+	_, err = p.DeleteSchedule("ch1", "sch1")
+	assert.NoError(t, err)
+	_, err = p.GetSchedule("ch1", "sch1")
+	assert.Equal(t, ErrScheduleNotFound, err)
+}
+
+func TestPqChannelStorage_Leases(t *testing.T) {
+	storage := NewPqChannelStorage()
+	_, has := storage.NextLeaseExpiry()
+	assert.False(t, has)
+
+	storage.AddLease(Lease{ID: "l2", Message: message.NewMessage([]byte("msg2"), 1000), ExpiresAt: 2000})
+	storage.AddLease(Lease{ID: "l1", Message: message.NewMessage([]byte("msg1"), 1000), ExpiresAt: 2000})
+	storage.AddLease(Lease{ID: "l3", Message: message.NewMessage([]byte("msg3"), 1000), ExpiresAt: 3000})
+	got, has := storage.NextLeaseExpiry()
+	assert.True(t, has)
+	assert.Equal(t, 2000, got)
+	assert.False(t, storage.CheckLeases(1999))
+	assert.True(t, storage.CheckLeases(2000))
+
+	// expired leases are renewed in the order of expiration
+	assert.Equal(t, []Lease{
+		{ID: "l1", Message: message.NewMessage([]byte("msg1"), 1000), ExpiresAt: 5000},
+	}, storage.RenewExpired(2500, 5000, 1))
+	assert.Equal(t, []Lease{
+		{ID: "l2", Message: message.NewMessage([]byte("msg2"), 1000), ExpiresAt: 5000},
+	}, storage.RenewExpired(2500, 5000, 10))
+	assert.Empty(t, storage.RenewExpired(2500, 5000, 10))
+
+	// released lease expires at once
+	assert.True(t, storage.Release("l3", 2500))
+	assert.False(t, storage.Release("l3", 2600))
+	assert.False(t, storage.Release("l4", 2500))
+	assert.True(t, storage.CheckLeases(2500))
+
+	// acknowledged lease is dropped
+	assert.True(t, storage.Ack("l3"))
+	assert.False(t, storage.Ack("l3"))
+	assert.Equal(t, []Lease{
+		{ID: "l1", Message: message.NewMessage([]byte("msg1"), 1000), ExpiresAt: 5000},
+		{ID: "l2", Message: message.NewMessage([]byte("msg2"), 1000), ExpiresAt: 5000},
+	}, storage.DumpLeases())
+
+	storage.Flush()
+	assert.Empty(t, storage.DumpLeases())
+}
+
+func TestPqStorage_DumpLeases(t *testing.T) {
+	pqStorage := NewPqStorage()
+	_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1"})
+	_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch2"})
+	chStorage, _ := pqStorage.GetChannelStorage("ch1")
+	chStorage.AddLease(Lease{ID: "l1", Message: message.NewMessage([]byte("msg1"), 1000), ExpiresAt: 2000})
+
+	assert.Equal(t, map[string][]Lease{
+		"ch1": {{ID: "l1", Message: message.NewMessage([]byte("msg1"), 1000), ExpiresAt: 2000}},
+		"ch2": {},
+	}, pqStorage.DumpLeases())
+}
+
+func TestPqStorage_DeadLetters(t *testing.T) {
+	pqStorage := NewPqStorage()
+	_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1"})
+	_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch2"})
+	chStorage, _ := pqStorage.GetChannelStorage("ch1")
+	failed := message.NewMessage([]byte("msg1"), 1000).WithID("id1").WithAttribute(message.AttributeFailureReason, "timeout")
+	chStorage.AddDeadLetter(DeadLetter{ID: "d2", Message: message.NewMessage([]byte("msg2"), 0), Reason: "invalid available_at", FailedAt: 3000})
+	chStorage.AddDeadLetter(DeadLetter{ID: "d1", Message: failed, Reason: "timeout", FailedAt: 2000})
+
+	deadLetters, err := pqStorage.GetDeadLetters("ch1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d1", "d2"}, []string{deadLetters[0].ID, deadLetters[1].ID})
+	_, err = pqStorage.GetDeadLetters("ch3")
+	assert.Equal(t, ErrChannelNotFound, err)
+	assert.Equal(t, map[string][]DeadLetter{"ch1": deadLetters, "ch2": {}}, pqStorage.DumpDeadLetters())
+
+	// unknown dead letter fails the whole replay
+	_, err = pqStorage.ReplayDeadLetters("ch1", []string{"d1", "d3"}, 5000)
+	assert.Equal(t, ErrDeadLetterNotFound, err)
+	_, err = pqStorage.ReplayDeadLetters("ch1", nil, 5000)
+	assert.Equal(t, ErrDeadLetterNotFound, err)
+	_, err = pqStorage.ReplayDeadLetters("ch3", []string{"d1"}, 5000)
+	assert.Equal(t, ErrChannelNotFound, err)
+	assert.Len(t, chStorage.GetDeadLetters(), 2)
+
+	// replayed message is scheduled without the failure reason
+	replayed, err := pqStorage.ReplayDeadLetters("ch1", []string{"d1", "d1"}, 5000)
+	assert.NoError(t, err)
+	want := message.NewMessage([]byte("msg1"), 5000).WithID("id1")
+	assert.Equal(t, []message.Message{want}, replayed)
+	assert.Equal(t, []message.Message{want}, chStorage.Dump())
+	assert.Equal(t, []DeadLetter{deadLetters[1]}, chStorage.GetDeadLetters())
+
+	chStorage.Flush()
+	assert.Empty(t, chStorage.GetDeadLetters())
+}
+
+func TestPqStorage_GetChannelStats(t *testing.T) {
+	pqStorage := NewPqStorage()
+	_, _ = pqStorage.AddChannel(channel.Channel{ID: "ch1"})
+	stats, err := pqStorage.GetChannelStats("ch1", 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, ChannelStats{}, stats)
+	_, err = pqStorage.GetChannelStats("ch2", 1000)
+	assert.Equal(t, ErrChannelNotFound, err)
+
+	chStorage, _ := pqStorage.GetChannelStorage("ch1")
+	chStorage.Enqueue(message.NewMessage([]byte("msg1"), 1500))
+	chStorage.Enqueue(message.NewMessage([]byte("msg2"), 3000))
+	chStorage.Enqueue(message.NewMessage([]byte("msg3"), 2000).WithID("id3"))
+	chStorage.AddLease(Lease{ID: "l1", Message: message.NewMessage([]byte("msg4"), 500), ExpiresAt: 5000})
+	chStorage.AddDeadLetter(DeadLetter{ID: "d1", Message: message.NewMessage([]byte("msg5"), 0), FailedAt: 500})
+
+	// nothing is due yet
+	stats, _ = pqStorage.GetChannelStats("ch1", 1000)
+	assert.Equal(t, ChannelStats{Scheduled: 3, InFlight: 1, DeadLetters: 1}, stats)
+
+	// lag is the age of the earliest due message
+	stats, _ = pqStorage.GetChannelStats("ch1", 2500)
+	assert.Equal(t, ChannelStats{Scheduled: 3, InFlight: 1, DeadLetters: 1, Lag: 1000}, stats)
+	chStorage.Dequeue()
+	stats, _ = pqStorage.GetChannelStats("ch1", 2500)
+	assert.Equal(t, ChannelStats{Scheduled: 2, InFlight: 1, DeadLetters: 1, Lag: 500}, stats)
+	chStorage.Remove("id3")
+	stats, _ = pqStorage.GetChannelStats("ch1", 2500)
+	assert.Equal(t, ChannelStats{Scheduled: 1, InFlight: 1, DeadLetters: 1}, stats)
+}